@@ -0,0 +1,10 @@
+//go:build (darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (netbsd && amd64) || (openbsd && (amd64 || arm64)) || (windows && (amd64 || arm64))
+
+// Package sqlite registers the "sqlite" database/sql driver.
+//
+// The driver (modernc.org/sqlite) is pure-go, but it only supports some
+// platforms. On the others, a driver which returns an error when opening a
+// database is registered instead, so the packages using it still build.
+package sqlite
+
+import _ "modernc.org/sqlite"
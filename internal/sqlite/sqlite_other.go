@@ -0,0 +1,20 @@
+//go:build !((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (netbsd && amd64) || (openbsd && (amd64 || arm64)) || (windows && (amd64 || arm64)))
+
+package sqlite
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"runtime"
+)
+
+func init() {
+	sql.Register("sqlite", unsupported{})
+}
+
+type unsupported struct{}
+
+func (unsupported) Open(string) (driver.Conn, error) {
+	return nil, errors.New("sqlite: not supported on " + runtime.GOOS + "/" + runtime.GOARCH)
+}
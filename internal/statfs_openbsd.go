@@ -0,0 +1,12 @@
+package internal
+
+import "golang.org/x/sys/unix"
+
+// Statfs returns the total and free space on the filesystem containing path.
+func Statfs(path string) (total, free uint64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.F_blocks * uint64(st.F_bsize), uint64(st.F_bavail) * uint64(st.F_bsize), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !windows
+
+package internal
+
+import "errors"
+
+// Statfs returns the total and free space on the filesystem containing path.
+func Statfs(path string) (total, free uint64, err error) {
+	return 0, 0, errors.New("not supported")
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package internal
+
+import "golang.org/x/sys/unix"
+
+// Statfs returns the total and free space on the filesystem containing path.
+func Statfs(path string) (total, free uint64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Blocks) * uint64(st.Bsize), uint64(st.Bavail) * uint64(st.Bsize), nil
+}
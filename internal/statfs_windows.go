@@ -0,0 +1,15 @@
+package internal
+
+import "golang.org/x/sys/windows"
+
+// Statfs returns the total and free space on the filesystem containing path.
+func Statfs(path string) (total, free uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, nil); err != nil {
+		return 0, 0, err
+	}
+	return total, free, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/annotations"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/spf13/pflag"
+)
+
+func main() {
+	format := pflag.StringP("format", "f", "markdown", "output format (markdown, json, csv)")
+	output := pflag.StringP("output", "o", "", "output file (- for stdout), or directory with --per-book (default: - or the current directory)")
+	perBook := pflag.Bool("per-book", false, "write a file for each book instead of a single file for the whole library")
+	book := pflag.StringP("book", "b", "", "only export annotations for the book with this ContentID")
+	cursor := pflag.String("cursor", "", "only export annotations which are new or modified since the cursor in this file, then update it")
+	help := pflag.BoolP("help", "h", false, "show this help text")
+	pflag.Parse()
+
+	if *help || pflag.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "usage: kobo-annotations [options] [kobo_path]\n")
+		fmt.Fprintf(os.Stderr, "\nversion: %s\n\noptions:\n", internal.VersionName())
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nIf kobo_path is not specified, kobo-annotations will attempt to look for a kobo device.\n")
+		fmt.Fprintf(os.Stderr, "\nHighlight colors are only exported for devices with color displays. The cursor file is created if it doesn't exist, and is only updated after the annotations have been written. With --per-book, only the files for books with new or modified annotations are written, but they contain all of the book's annotations.\n")
+		os.Exit(2)
+	}
+
+	if *cursor != "" && *book != "" {
+		fmt.Fprintf(os.Stderr, "Error: --cursor cannot be used with --book, since the cursor is for the whole library\n")
+		os.Exit(2)
+	}
+
+	f, err := annotations.ParseFormat(*format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --format: %v\n", err)
+		os.Exit(2)
+	}
+	if *output == "" {
+		if *perBook {
+			*output = "."
+		} else {
+			*output = "-"
+		}
+	} else if *perBook && *output == "-" {
+		fmt.Fprintf(os.Stderr, "Error: --output: cannot write to stdout with --per-book\n")
+		os.Exit(2)
+	}
+
+	var kpath string
+	if pflag.NArg() == 1 {
+		kpath = pflag.Arg(0)
+	} else {
+		kobos, err := kobo.Find()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not look for a kobo: %v\n", err)
+			os.Exit(1)
+		} else if len(kobos) < 1 {
+			fmt.Fprintf(os.Stderr, "Error: could not find a kobo\n")
+			os.Exit(1)
+		}
+		kpath = kobos[0]
+	}
+
+	opts := &annotations.Options{
+		Book: *book,
+	}
+
+	if _, _, id, err := kobo.ParseKoboVersion(kpath); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not read device version, not exporting colors: %v\n", err)
+	} else if dev, ok := kobo.DeviceByID(id); ok {
+		opts.Colors = dev.ColorDisplay()
+	}
+
+	if *cursor != "" {
+		buf, err := os.ReadFile(*cursor)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Error: could not read cursor: %v\n", err)
+			os.Exit(1)
+		} else if err == nil {
+			var c annotations.Cursor
+			if err := json.Unmarshal(buf, &c); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: could not read cursor: %v\n", err)
+				os.Exit(1)
+			}
+			opts.Since = &c
+		}
+	}
+
+	d, err := db.Open(kpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	books, cur, err := annotations.Read(context.Background(), d, opts)
+	if err != nil {
+		d.Close()
+		fmt.Fprintf(os.Stderr, "Error: could not read annotations: %v\n", err)
+		os.Exit(1)
+	}
+
+	// per-book files always contain all annotations for the book, so only the
+	// cursor is used to choose which ones to write
+	all := books
+	if *perBook && opts.Since != nil {
+		if all, _, err = annotations.Read(context.Background(), d, &annotations.Options{
+			Book:   opts.Book,
+			Colors: opts.Colors,
+		}); err != nil {
+			d.Close()
+			fmt.Fprintf(os.Stderr, "Error: could not read annotations: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	d.Close()
+
+	var n int
+	for _, b := range books {
+		n += len(b.Annotations)
+	}
+
+	if *perBook {
+		if err := os.MkdirAll(*output, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not create output directory: %v\n", err)
+			os.Exit(1)
+		}
+		changed := map[string]bool{}
+		for _, b := range books {
+			changed[b.ContentID] = true
+		}
+		names := map[string]int{}
+		for _, b := range all {
+			names[filename(b.Title)]++
+		}
+		for _, b := range all {
+			if !changed[b.ContentID] {
+				continue
+			}
+			name := filename(b.Title)
+			if names[name] > 1 {
+				// based on the book rather than the order, so the file for a
+				// book doesn't change between exports
+				h := sha1.Sum([]byte(b.ContentID))
+				name += " (" + hex.EncodeToString(h[:4]) + ")"
+			}
+			fn := filepath.Join(*output, name+f.Ext())
+
+			var buf bytes.Buffer
+			if err := annotations.Write(&buf, f, []annotations.Book{b}); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: could not write annotations: %v\n", err)
+				os.Exit(1)
+			}
+			if err := os.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: could not write annotations: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d annotations from %d books to %s\n", n, len(books), *output)
+	} else {
+		var buf bytes.Buffer
+		if err := annotations.Write(&buf, f, books); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not write annotations: %v\n", err)
+			os.Exit(1)
+		}
+		if *output == "-" {
+			_, err = os.Stdout.Write(buf.Bytes())
+		} else {
+			err = os.WriteFile(*output, buf.Bytes(), 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not write annotations: %v\n", err)
+			os.Exit(1)
+		}
+		if *output != "-" {
+			fmt.Fprintf(os.Stderr, "Exported %d annotations from %d books to %s\n", n, len(books), *output)
+		}
+	}
+
+	if *cursor != "" {
+		buf, err := json.MarshalIndent(cur, "", "    ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not encode cursor: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(*cursor, append(buf, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not save cursor: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// filename makes a title safe to use as a file name.
+func filename(title string) string {
+	s := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}, title)
+	s = strings.Trim(strings.Join(strings.Fields(s), " "), ". ")
+	if len(s) > 100 {
+		s = strings.TrimSpace(strings.ToValidUTF8(s[:100], ""))
+	}
+	if s == "" {
+		s = "Untitled"
+	}
+	return s
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/autoshelf"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/spf13/pflag"
+)
+
+func main() {
+	sources := pflag.StringArrayP("source", "s", nil, "generate shelves from folder, series, or subject (can be specified multiple times)")
+	root := pflag.StringP("root", "r", "", "only scan this folder on the kobo, and name folder shelves relative to it")
+	prefix := pflag.StringP("prefix", "p", "", "prefix for shelf names")
+	minBooks := pflag.IntP("min-books", "m", 1, "minimum number of books for a shelf to be created")
+	clean := pflag.Bool("clean", false, "remove all shelves created by kobo-autoshelf")
+	dryRun := pflag.BoolP("dry-run", "n", false, "show the changes without making them")
+	backupDir := pflag.String("backup-dir", "", "directory to write the database backup to (default: next to the database)")
+	help := pflag.BoolP("help", "h", false, "show this help text")
+	pflag.Parse()
+
+	if *help || pflag.NArg() > 1 || (len(*sources) == 0 && !*clean) || (len(*sources) != 0 && *clean) {
+		fmt.Fprintf(os.Stderr, "usage: kobo-autoshelf [options] [kobo_path]\n")
+		fmt.Fprintf(os.Stderr, "\nversion: %s\n\noptions:\n", internal.VersionName())
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nIf kobo_path is not specified, kobo-autoshelf will attempt to look for a kobo device.\n")
+		fmt.Fprintf(os.Stderr, "\nEither --source or --clean must be specified. Shelves created by kobo-autoshelf are updated to match the books on the kobo each time it is run, and shelves which are no longer needed are removed. Shelves created on the device are never modified. Only books which have already been imported by the device are added to shelves.\n")
+		os.Exit(2)
+	}
+
+	opts := &autoshelf.Options{
+		Root:     *root,
+		Prefix:   *prefix,
+		MinBooks: *minBooks,
+	}
+	for _, s := range *sources {
+		src, err := autoshelf.ParseSource(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --source: %v\n", err)
+			os.Exit(2)
+		}
+		opts.Sources = append(opts.Sources, src)
+	}
+
+	var kpath string
+	if pflag.NArg() == 1 {
+		kpath = pflag.Arg(0)
+	} else {
+		kobos, err := kobo.Find()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not look for a kobo: %v\n", err)
+			os.Exit(1)
+		} else if len(kobos) < 1 {
+			fmt.Fprintf(os.Stderr, "Error: could not find a kobo\n")
+			os.Exit(1)
+		}
+		kpath = kobos[0]
+	}
+
+	plan := func(ctx context.Context, d *db.DB) (*autoshelf.Changes, error) {
+		if err := d.CheckSchema(nil); errors.Is(err, db.ErrUnknownVersion) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		p := &autoshelf.Plan{}
+		if !*clean {
+			var err error
+			if p, err = autoshelf.Scan(ctx, kpath, d, opts); err != nil {
+				return nil, err
+			}
+			for _, e := range p.Errors {
+				fmt.Fprintf(os.Stderr, "Warning: %s\n", e)
+			}
+		}
+		return autoshelf.Diff(ctx, d, p)
+	}
+
+	if *dryRun {
+		d, err := db.Open(kpath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		c, err := plan(context.Background(), d)
+		d.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		printChanges(c)
+		return
+	}
+
+	var c *autoshelf.Changes
+	backup, err := db.Update(context.Background(), kpath, &db.UpdateOptions{
+		Tool:      "kobo-autoshelf",
+		BackupDir: *backupDir,
+	}, func(ctx context.Context, tx *db.Tx) error {
+		var err error
+		if c, err = plan(ctx, tx.DB); err != nil {
+			return err
+		}
+		return autoshelf.Apply(ctx, tx, c, time.Now())
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if backup != "" {
+			fmt.Fprintf(os.Stderr, "Backup: %s\n", backup)
+		}
+		os.Exit(1)
+	}
+	printChanges(c)
+	if c.Empty() {
+		os.Remove(backup)
+	} else {
+		fmt.Printf("Backup: %s\n", backup)
+	}
+}
+
+func printChanges(c *autoshelf.Changes) {
+	for _, name := range c.Conflicts {
+		fmt.Fprintf(os.Stderr, "Warning: not creating shelf %q since a shelf with the same name was created (or deleted) on the device\n", name)
+	}
+	if c.Empty() {
+		fmt.Printf("No changes.\n")
+		return
+	}
+	for _, name := range c.Create {
+		fmt.Printf("+ %s (%d books)\n", name, len(c.Add[name]))
+	}
+	for _, s := range c.Restore {
+		fmt.Printf("+ %s (%d books, restored)\n", s.Name, len(c.Add[s.Name]))
+	}
+	created := map[string]bool{}
+	for _, name := range c.Create {
+		created[name] = true
+	}
+	for _, s := range c.Restore {
+		created[s.Name] = true
+	}
+	var updated []string
+	for name := range c.Add {
+		if !created[name] {
+			updated = append(updated, name)
+		}
+	}
+	for name := range c.Remove {
+		if _, ok := c.Add[name]; !ok {
+			updated = append(updated, name)
+		}
+	}
+	sort.Strings(updated)
+	for _, name := range updated {
+		fmt.Printf("~ %s (+%d -%d books)\n", name, len(c.Add[name]), len(c.Remove[name]))
+	}
+	for _, s := range c.Delete {
+		fmt.Printf("- %s\n", s.Name)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/check"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/spf13/pflag"
+)
+
+func main() {
+	fix := pflag.Bool("fix", false, "remove missing books, orphaned rows, and orphaned covers")
+	fixDuplicates := pflag.Bool("fix-duplicates", false, "also delete the files for duplicate books")
+	backupDir := pflag.String("backup-dir", "", "directory to write the database backup to (default: next to the database)")
+	help := pflag.BoolP("help", "h", false, "show this help text")
+	pflag.Parse()
+
+	if *help || pflag.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "usage: kobo-check [options] [kobo_path]\n")
+		fmt.Fprintf(os.Stderr, "\nversion: %s\n\noptions:\n", internal.VersionName())
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nIf kobo_path is not specified, kobo-check will attempt to look for a kobo device.\n")
+		fmt.Fprintf(os.Stderr, "\nkobo-check finds books in the library whose files no longer exist, rows for books which no longer exist, duplicate copies of sideloaded books (with the same title, author, size, and format), and cached covers for books which no longer exist. Annotations are kept when books are removed, in case they are added again.\n")
+		fmt.Fprintf(os.Stderr, "\nDuplicates are only removed with --fix-duplicates, since this deletes the book files. The most recently read copy of each book is kept.\n")
+		os.Exit(2)
+	}
+	if *fixDuplicates {
+		*fix = true
+	}
+
+	var kpath string
+	if pflag.NArg() == 1 {
+		kpath = pflag.Arg(0)
+	} else {
+		kobos, err := kobo.Find()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not look for a kobo: %v\n", err)
+			os.Exit(1)
+		} else if len(kobos) < 1 {
+			fmt.Fprintf(os.Stderr, "Error: could not find a kobo\n")
+			os.Exit(1)
+		}
+		kpath = kobos[0]
+	}
+
+	// only fix the selected problems, but report all of them
+	fixable := func(p check.Problem) bool {
+		return *fix && (p.Kind != check.KindDuplicate || *fixDuplicates)
+	}
+
+	if !*fix {
+		d, err := db.Open(kpath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		ps, err := check.Check(context.Background(), kpath, d)
+		d.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		printProblems(ps, fixable)
+		return
+	}
+
+	var ps, fixed []check.Problem
+	backup, err := db.Update(context.Background(), kpath, &db.UpdateOptions{
+		Tool:      "kobo-check",
+		BackupDir: *backupDir,
+	}, func(ctx context.Context, tx *db.Tx) error {
+		if err := tx.CheckSchema(nil); errors.Is(err, db.ErrUnknownVersion) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		var err error
+		if ps, err = check.Check(ctx, kpath, tx.DB); err != nil {
+			return err
+		}
+		for _, p := range ps {
+			if fixable(p) {
+				fixed = append(fixed, p)
+			}
+		}
+		return check.Fix(ctx, tx, fixed, time.Now())
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if backup != "" {
+			fmt.Fprintf(os.Stderr, "Backup: %s\n", backup)
+		}
+		os.Exit(1)
+	}
+	if err := check.RemoveFiles(kpath, fixed); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not remove files: %v\n", err)
+	}
+	printProblems(ps, fixable)
+	if len(fixed) == 0 {
+		os.Remove(backup)
+	} else {
+		fmt.Printf("Backup: %s\n", backup)
+	}
+}
+
+func printProblems(ps []check.Problem, fixed func(check.Problem) bool) {
+	if len(ps) == 0 {
+		fmt.Printf("No problems found.\n")
+		return
+	}
+	counts := map[check.Kind]int{}
+	for _, p := range ps {
+		counts[p.Kind]++
+		if fixed(p) {
+			fmt.Printf("%s (fixed)\n", p)
+		} else {
+			fmt.Printf("%s\n", p)
+		}
+	}
+	fmt.Printf("\n")
+	for _, k := range check.Kinds {
+		if counts[k] != 0 {
+			fmt.Printf("%s: %d\n", k, counts[k])
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/diag"
+	"github.com/spf13/pflag"
+)
+
+func main() {
+	output := pflag.StringP("output", "o", "kobo-diag.zip", "output file (- for stdout)")
+	json := pflag.BoolP("json", "j", false, "only write the json report instead of a zip bundle")
+	redact := pflag.StringArray("redact", nil, "additional fields to redact as [FILE:][SECTION/]KEY (can be specified multiple times)")
+	hash := pflag.StringArray("hash", nil, "additional fields to hash as [FILE:][SECTION/]KEY (can be specified multiple times)")
+	keep := pflag.StringArray("keep", nil, "fields to keep as [FILE:][SECTION/]KEY, overriding the default rules (can be specified multiple times)")
+	noDefaultRules := pflag.Bool("no-default-rules", false, "don't use the default redaction rules")
+	salt := pflag.String("salt", "", "secret salt for hashed values (reuse it to correlate bundles from the same device) (default: random)")
+	reproducible := pflag.Bool("reproducible", false, "omit the creation time so the bundle is byte-for-byte reproducible (requires --salt)")
+	noStorage := pflag.Bool("no-storage", false, "don't collect storage usage")
+	help := pflag.BoolP("help", "h", false, "show this help text")
+	pflag.Parse()
+
+	if *help || pflag.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "usage: kobo-diag [options] [kobo_path]\n")
+		fmt.Fprintf(os.Stderr, "\nversion: %s\n\noptions:\n", internal.VersionName())
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nIf kobo_path is not specified, kobo-diag will attempt to look for a kobo device.\n")
+		fmt.Fprintf(os.Stderr, "\nThe bundle contains the device info, settings, database version, storage usage, and installed add-ons, with serial numbers, account information, and credentials redacted or hashed. Review it before sharing.\n")
+		os.Exit(2)
+	}
+
+	if *reproducible && *salt == "" {
+		fmt.Fprintf(os.Stderr, "Error: --reproducible requires --salt, since hashed values use a random salt by default\n")
+		os.Exit(2)
+	}
+
+	var rules []diag.Rule
+	for _, x := range []struct {
+		Patterns []string
+		Action   diag.Action
+	}{
+		{*keep, diag.ActionKeep},
+		{*redact, diag.ActionRedact},
+		{*hash, diag.ActionHash},
+	} {
+		for _, p := range x.Patterns {
+			r, err := diag.ParseRule(p, x.Action)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: --%s: %v\n", x.Action, err)
+				os.Exit(2)
+			}
+			rules = append(rules, r)
+		}
+	}
+	if !*noDefaultRules {
+		rules = append(rules, diag.DefaultRules()...)
+	} else if rules == nil {
+		rules = []diag.Rule{}
+	}
+
+	var kpath string
+	if pflag.NArg() == 1 {
+		kpath = pflag.Arg(0)
+	} else {
+		kobos, err := kobo.Find()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not look for a kobo: %v\n", err)
+			os.Exit(1)
+		} else if len(kobos) < 1 {
+			fmt.Fprintf(os.Stderr, "Error: could not find a kobo\n")
+			os.Exit(1)
+		}
+		kpath = kobos[0]
+	}
+
+	opts := &diag.Options{
+		Rules:     rules,
+		Salt:      *salt,
+		NoStorage: *noStorage,
+	}
+	if !*reproducible {
+		opts.Time = time.Now().UTC()
+	}
+
+	b, err := diag.Collect(kpath, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, e := range b.Errors {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", e)
+	}
+
+	var buf bytes.Buffer
+	if *json {
+		err = b.WriteJSON(&buf)
+	} else {
+		err = b.WriteZip(&buf)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not write bundle: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *output == "-" {
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not write bundle: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := os.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not write bundle: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s (%d fields redacted)\n", *output, len(b.Redacted))
+}
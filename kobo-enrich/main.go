@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/enrich"
+	"github.com/spf13/pflag"
+)
+
+func main() {
+	var fields []string
+	for _, f := range enrich.Fields {
+		fields = append(fields, string(f))
+	}
+	field := pflag.StringArrayP("field", "f", nil, "only update this field ("+strings.Join(fields, ", ")+") (can be specified multiple times)")
+	dryRun := pflag.BoolP("dry-run", "n", false, "show the changes without making them")
+	backupDir := pflag.String("backup-dir", "", "directory to write the database backup to (default: next to the database)")
+	watch := pflag.BoolP("watch", "w", false, "keep running, and update the metadata each time a kobo is connected")
+	interval := pflag.Duration("interval", time.Second*5, "how often to check for kobos with --watch")
+	help := pflag.BoolP("help", "h", false, "show this help text")
+	pflag.Parse()
+
+	if *help || pflag.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "usage: kobo-enrich [options] [kobo_path]\n")
+		fmt.Fprintf(os.Stderr, "\nversion: %s\n\noptions:\n", internal.VersionName())
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nIf kobo_path is not specified, kobo-enrich will attempt to look for a kobo device.\n")
+		fmt.Fprintf(os.Stderr, "\nkobo-enrich copies the series (from calibre or EPUB3 metadata), subtitle, and publisher of sideloaded EPUBs and kepubs into the library database. Fields are only set, never cleared. Only books which have already been imported by the device are updated.\n")
+		fmt.Fprintf(os.Stderr, "\nNickel resets the metadata when it re-imports a book (e.g., after it is replaced), which happens after the device is ejected. With --watch, kobo-enrich runs each time the device is connected, so the metadata is re-applied to re-imported books. Books which still have the metadata are not changed.\n")
+		os.Exit(2)
+	}
+
+	opts := &enrich.Options{}
+	for _, s := range *field {
+		f, err := enrich.ParseField(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --field: %v\n", err)
+			os.Exit(2)
+		}
+		opts.Fields = append(opts.Fields, f)
+	}
+
+	if *watch {
+		if *dryRun {
+			fmt.Fprintf(os.Stderr, "Error: --watch cannot be used with --dry-run\n")
+			os.Exit(2)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		watchKobos(ctx, pflag.Arg(0), *interval, func(kpath string) {
+			fmt.Printf("Connected: %s\n", kpath)
+			if err := enrichKobo(kpath, opts, false, *backupDir); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", kpath, err)
+			}
+		})
+		return
+	}
+
+	var kpath string
+	if pflag.NArg() == 1 {
+		kpath = pflag.Arg(0)
+	} else {
+		kobos, err := kobo.Find()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not look for a kobo: %v\n", err)
+			os.Exit(1)
+		} else if len(kobos) < 1 {
+			fmt.Fprintf(os.Stderr, "Error: could not find a kobo\n")
+			os.Exit(1)
+		}
+		kpath = kobos[0]
+	}
+
+	if err := enrichKobo(kpath, opts, *dryRun, *backupDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// enrichKobo updates (or shows the changes for, if dryRun is true) the
+// metadata of the books on the Kobo at kpath.
+func enrichKobo(kpath string, opts *enrich.Options, dryRun bool, backupDir string) error {
+	plan := func(ctx context.Context, d *db.DB) (*enrich.Result, error) {
+		if err := d.CheckSchema(nil); errors.Is(err, db.ErrUnknownVersion) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		r, err := enrich.Plan(ctx, kpath, d, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range r.Errors {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", e)
+		}
+		return r, nil
+	}
+
+	if dryRun {
+		d, err := db.Open(kpath)
+		if err != nil {
+			return err
+		}
+		r, err := plan(context.Background(), d)
+		d.Close()
+		if err != nil {
+			return err
+		}
+		printChanges(r.Changes)
+		return nil
+	}
+
+	var r *enrich.Result
+	backup, err := db.Update(context.Background(), kpath, &db.UpdateOptions{
+		Tool:      "kobo-enrich",
+		BackupDir: backupDir,
+	}, func(ctx context.Context, tx *db.Tx) error {
+		var err error
+		if r, err = plan(ctx, tx.DB); err != nil {
+			return err
+		}
+		return enrich.Apply(ctx, tx, r.Changes)
+	})
+	if err != nil {
+		if backup != "" {
+			return fmt.Errorf("%w (backup: %s)", err, backup)
+		}
+		return err
+	}
+	printChanges(r.Changes)
+	if len(r.Changes) == 0 {
+		os.Remove(backup)
+	} else {
+		fmt.Printf("Backup: %s\n", backup)
+	}
+	return nil
+}
+
+// watchKobos calls fn each time a Kobo is connected (including ones which are
+// already connected) until ctx is cancelled. If kpath is not empty, only it is
+// watched.
+func watchKobos(ctx context.Context, kpath string, interval time.Duration, fn func(kpath string)) {
+	connected := map[string]bool{}
+	for {
+		var (
+			kobos []string
+			err   error
+		)
+		if kpath == "" {
+			kobos, err = kobo.Find()
+		} else if kobo.IsKobo(kpath) {
+			kobos = []string{kpath}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not look for a kobo: %v\n", err)
+		} else {
+			now := map[string]bool{}
+			for _, k := range kobos {
+				now[k] = true
+				if !connected[k] {
+					fn(k)
+				}
+			}
+			connected = now
+		}
+
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func printChanges(cs []enrich.Change) {
+	if len(cs) == 0 {
+		fmt.Printf("No changes.\n")
+		return
+	}
+	for _, c := range cs {
+		fmt.Printf("~ %s (%s)\n", c.Path, c.Title)
+		for _, l := range c.Diff() {
+			fmt.Printf("    %s\n", l)
+		}
+	}
+}
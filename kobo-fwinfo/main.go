@@ -7,11 +7,10 @@ import (
 	"os"
 
 	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo/firmware"
 	"github.com/spf13/pflag"
 )
 
-// TODO: extract other files into a package
-
 func main() {
 	help := pflag.BoolP("help", "h", false, "show this help text")
 	tempDir := pflag.StringP("temp-dir", "t", "", "override the temp dir for extracting large firmware files to")
@@ -28,8 +27,8 @@ func main() {
 	var errs int
 	for _, fw := range pflag.Args() {
 		var ok bool
-		p, err := func() (Package, error) {
-			var p Package
+		p, err := func() (firmware.Package, error) {
+			var p firmware.Package
 
 			fi, err := os.Stat(fw)
 			if err != nil {
@@ -49,7 +48,7 @@ func main() {
 			}
 
 			ok = true
-			return Parse(fsys, ReadTempDirAuto(*tempDir))
+			return firmware.Parse(fsys, firmware.ReadTempDirAuto(*tempDir))
 		}()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: error: %v\n", fw, err)
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/fwwatch"
+	"github.com/spf13/pflag"
+)
+
+func main() {
+	devices := pflag.StringSliceP("device", "d", nil, "device to watch (ID, full ID string, or name) (default: all)")
+	affiliates := pflag.StringSliceP("affiliate", "a", []string{"Kobo"}, "affiliate to watch")
+	allAffiliates := pflag.BoolP("all-affiliates", "A", false, "watch every known affiliate")
+	state := pflag.StringP("state", "s", "fwwatch.json", "file to persist the last-seen versions in")
+	interval := pflag.DurationP("interval", "i", time.Minute*30, "time between polls")
+	jitter := pflag.Duration("jitter", time.Minute*5, "maximum random delay to add to the interval")
+	jobs := pflag.IntP("jobs", "j", 4, "maximum number of concurrent requests")
+	timeout := pflag.DurationP("timeout", "t", time.Second*10, "timeout for each request")
+	initial := pflag.Bool("initial", false, "emit events for targets without a last-seen version")
+	execHook := pflag.StringP("exec", "e", "", "command to run for each event (the event is passed as json on stdin and as FWWATCH_* env vars)")
+	webhook := pflag.StringP("webhook", "w", "", "url to POST each event to as json")
+	quiet := pflag.BoolP("quiet", "q", false, "don't write events to stdout")
+	once := pflag.Bool("once", false, "poll once, then exit")
+	apiURL := pflag.String("api-url", "", "override the Kobo API base URL")
+	help := pflag.BoolP("help", "h", false, "show this help text")
+	pflag.Parse()
+
+	if *help || pflag.NArg() != 0 || *jobs < 1 || *interval <= 0 {
+		fmt.Fprintf(os.Stderr, "usage: kobo-fwwatch [options]\n")
+		fmt.Fprintf(os.Stderr, "\nversion: %s\n\noptions:\n", internal.VersionName())
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nEvents are written to stdout as json lines when the latest version for a device/affiliate changes.\n")
+		os.Exit(2)
+	}
+
+	ds := kobo.Devices()
+	if len(*devices) != 0 {
+		ds = nil
+		for _, x := range *devices {
+			d, ok := kobo.ParseDevice(x)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Error: unknown device %q\n", x)
+				os.Exit(2)
+			}
+			ds = append(ds, d)
+		}
+	}
+
+	if *allAffiliates {
+		*affiliates = nil
+		for _, a := range kobo.Affiliates() {
+			*affiliates = append(*affiliates, a.Name)
+		}
+	}
+	for _, a := range *affiliates {
+		if err := kobo.ValidateAffiliate(a); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	w := &fwwatch.Watcher{
+		Client: &kobo.APIClient{
+			BaseURL:    *apiURL,
+			HTTPClient: &http.Client{Timeout: *timeout},
+		},
+		Targets:       fwwatch.Targets(ds, *affiliates),
+		Interval:      *interval,
+		Jitter:        *jitter,
+		Concurrency:   *jobs,
+		StatePath:     *state,
+		NotifyInitial: *initial,
+		Logf: func(format string, a ...interface{}) {
+			fmt.Fprintf(os.Stderr, "kobo-fwwatch: "+format+"\n", a...)
+		},
+	}
+	if !*quiet {
+		w.Notifiers = append(w.Notifiers, &fwwatch.JSONLines{W: os.Stdout})
+	}
+	if *execHook != "" {
+		n := &fwwatch.Exec{
+			Name:   "sh",
+			Args:   []string{"-c", *execHook},
+			Stdout: os.Stderr,
+			Stderr: os.Stderr,
+		}
+		if runtime.GOOS == "windows" {
+			n.Name, n.Args = "cmd", []string{"/C", *execHook}
+		}
+		w.Notifiers = append(w.Notifiers, n)
+	}
+	if *webhook != "" {
+		w.Notifiers = append(w.Notifiers, &fwwatch.Webhook{
+			URL:        *webhook,
+			HTTPClient: &http.Client{Timeout: *timeout},
+		})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		if _, err := w.Poll(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := w.Run(ctx); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
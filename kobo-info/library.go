@@ -0,0 +1,53 @@
+//go:build (darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (netbsd && amd64) || (openbsd && (amd64 || arm64)) || (windows && (amd64 || arm64))
+
+package main
+
+import (
+	"context"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+)
+
+// readLibrary reads the library counts from the nickel database. The build
+// constraint matches the platforms supported by internal/sqlite.
+func readLibrary(kpath string) (*libraryInfo, error) {
+	d, err := db.Open(kpath)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	ctx := context.Background()
+	l := libraryInfo{DBVersion: d.Version()}
+
+	books, err := d.Books(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range books {
+		l.Books++
+		if b.Sideloaded() {
+			l.Sideloaded++
+		} else {
+			l.Store++
+		}
+	}
+
+	shelves, err := d.Shelves(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range shelves {
+		if !s.Deleted {
+			l.Shelves++
+		}
+	}
+
+	bookmarks, err := d.Bookmarks(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	l.Annotations = len(bookmarks)
+
+	return &l, nil
+}
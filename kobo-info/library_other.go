@@ -0,0 +1,14 @@
+//go:build !((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (netbsd && amd64) || (openbsd && (amd64 || arm64)) || (windows && (amd64 || arm64)))
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// readLibrary reads the library counts from the nickel database. The sqlite
+// driver used by kobo/db doesn't support this platform, so it isn't linked.
+func readLibrary(kpath string) (*libraryInfo, error) {
+	return nil, errors.New("not supported on " + runtime.GOOS + "/" + runtime.GOARCH)
+}
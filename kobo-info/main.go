@@ -1,33 +1,72 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"runtime"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/pgaskin/koboutils/v2/internal"
 	"github.com/pgaskin/koboutils/v2/kobo"
 	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
 )
 
-var jsono = false
+var pause bool
 
 func main() {
-	json := pflag.BoolP("json", "j", false, "output as json")
+	format := pflag.StringP("format", "f", "text", "output format (text, json, yaml)")
+	jsono := pflag.BoolP("json", "j", false, "output as json (same as --format json)")
+	checkUpdate := pflag.BoolP("check-update", "u", false, "also check the Kobo API for a firmware update (requires an internet connection)")
+	apiURL := pflag.String("api-url", "", "override the Kobo API base URL for --check-update")
+	pflag.BoolVar(&pause, "pause", false, "wait for enter to be pressed before exiting (for when run outside a terminal)")
 	help := pflag.BoolP("help", "h", false, "show this help text")
 	pflag.Parse()
 
+	if *jsono {
+		*format = "json"
+	}
+
 	if *help || pflag.NArg() > 1 {
 		fmt.Fprintf(os.Stderr, "Usage: kobo-info [OPTIONS] [KOBO_PATH]\n")
 		fmt.Fprintf(os.Stderr, "\nVersion: %s\n\nOptions:\n", internal.VersionName())
 		pflag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nIf KOBO_PATH is not specified, kobo-info will attempt to look for a kobo device.\n")
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "\nThe json and yaml output is an object with the fields:\n")
+		fmt.Fprintf(os.Stderr, "  path                   the kobo path\n")
+		fmt.Fprintf(os.Stderr, "  device                 known, name, id, family, codenames (class, family,\n")
+		fmt.Fprintf(os.Stderr, "                         secondary), hardware, storage_gb, display_ppi, serial,\n")
+		fmt.Fprintf(os.Stderr, "                         model_number (only known, id, and serial are set for\n")
+		fmt.Fprintf(os.Stderr, "                         unknown devices)\n")
+		fmt.Fprintf(os.Stderr, "  version                serial, kernel, firmware, field3, field4, id (from\n")
+		fmt.Fprintf(os.Stderr, "                         .kobo/version)\n")
+		fmt.Fprintf(os.Stderr, "  affiliate              the affiliate (optional)\n")
+		fmt.Fprintf(os.Stderr, "  storage                total, free, used in bytes (optional)\n")
+		fmt.Fprintf(os.Stderr, "  update                 pending, files (the update files in .kobo), and if\n")
+		fmt.Fprintf(os.Stderr, "                         --check-update is specified, available (type,\n")
+		fmt.Fprintf(os.Stderr, "                         version, url)\n")
+		fmt.Fprintf(os.Stderr, "  library                db_version, books, sideloaded, store, shelves,\n")
+		fmt.Fprintf(os.Stderr, "                         annotations (optional)\n")
+		fmt.Fprintf(os.Stderr, "  warnings, errors       problems encountered (optional fields which could not\n")
+		fmt.Fprintf(os.Stderr, "                         be determined are omitted and added to errors)\n")
+		exit(2)
 	}
 
-	jsono = *json
+	var write func(io.Writer, *report) error
+	switch *format {
+	case "text":
+		write = writeText
+	case "json":
+		write = writeJSON
+	case "yaml":
+		write = writeYAML
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q\n", *format)
+		exit(2)
+	}
 
 	var kpath string
 	if pflag.NArg() == 1 {
@@ -36,74 +75,143 @@ func main() {
 		kobos, err := kobo.Find()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: could not look for a kobo: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		} else if len(kobos) < 1 {
 			fmt.Fprintf(os.Stderr, "Error: could not find a kobo\n")
-			os.Exit(1)
+			exit(1)
 		}
 		kpath = kobos[0]
 	}
 
 	if !kobo.IsKobo(kpath) {
 		fmt.Fprintf(os.Stderr, "Error: not a valid kobo: %s\n", kpath)
-		os.Exit(1)
+		exit(1)
 	}
 
-	serial, version, id, err := kobo.ParseKoboVersion(kpath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: could not parse kobo version: %v\n", err)
-		os.Exit(1)
+	var c *kobo.APIClient
+	if *checkUpdate {
+		c = &kobo.APIClient{BaseURL: *apiURL}
 	}
 
-	if device, ok := kobo.DeviceByID(id); ok {
-		printkv("Device", device.Name())
-		printkv("Device ID", id)
-		printkv("Device Family", fmt.Sprintf("%s (%s)", device.Family(), device.CodeNames().Family()))
-		printkv("Codenames", device.CodeNames().String())
-		printkv("Hardware", device.Hardware().String())
-	} else {
-		printkv("Device", "unknown")
-		printkv("Device ID", id)
+	r, err := newReport(kpath, c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not get kobo info: %v\n", err)
+		exit(1)
 	}
 
-	println()
-	printkv("Serial", serial)
-	println()
-	printkv("Current FW", version)
+	if *format == "text" {
+		for _, w := range r.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+		for _, e := range r.Errors {
+			fmt.Fprintf(os.Stderr, "Warning: could not %s\n", e)
+		}
+	}
 
-	if affiliate, err := kobo.ParseKoboAffiliate(kpath); err == nil {
-		printkv("Affiliate", affiliate)
-	} else {
-		printkv("Affiliate", "unknown")
+	if err := write(os.Stdout, r); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not write output: %v\n", err)
+		exit(1)
 	}
+	exit(0)
+}
 
-	if jsono {
-		fmt.Print("\n}\n")
+// exit waits for enter if --pause was specified, then exits.
+func exit(code int) {
+	if pause {
+		fmt.Fprintf(os.Stderr, "\nPress enter to exit.\n")
+		bufio.NewReader(os.Stdin).ReadString('\n')
 	}
+	os.Exit(code)
+}
 
-	if runtime.GOOS == "windows" {
-		time.Sleep(time.Second * 5)
+func writeJSON(w io.Writer, r *report) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "    ")
+	return e.Encode(r)
+}
+
+func writeYAML(w io.Writer, r *report) error {
+	e := yaml.NewEncoder(w)
+	e.SetIndent(4)
+	if err := e.Encode(r); err != nil {
+		return err
 	}
+	return e.Close()
 }
 
-var jsons = true
+func writeText(w io.Writer, r *report) error {
+	bw := bufio.NewWriter(w)
+	kv := func(key, value string) {
+		fmt.Fprintf(bw, "%15s: %s\n", key, value)
+	}
 
-func printkv(key, value string) {
-	if jsono {
-		if jsons {
-			fmt.Print("{\n")
-			jsons = false
+	if d, ok := kobo.DeviceByID(r.Device.ID); ok {
+		kv("Device", r.Device.Name)
+		kv("Device ID", r.Device.ID)
+		kv("Device Family", fmt.Sprintf("%s (%s)", r.Device.Family, d.CodeNames().Family()))
+		kv("Codenames", d.CodeNames().String())
+		kv("Hardware", r.Device.Hardware)
+		kv("Storage", fmt.Sprintf("%d GB", r.Device.StorageGB))
+		kv("Display", fmt.Sprintf("%d PPI", r.Device.DisplayPPI))
+	} else {
+		kv("Device", "unknown")
+		kv("Device ID", r.Device.ID)
+	}
+
+	fmt.Fprintln(bw)
+	kv("Serial", r.Device.Serial)
+	if r.Device.ModelNumber != "" {
+		kv("Model Number", r.Device.ModelNumber)
+	}
+
+	fmt.Fprintln(bw)
+	kv("Current FW", r.Version.Firmware)
+	kv("Kernel", r.Version.Kernel)
+	if r.Affiliate != "" {
+		kv("Affiliate", r.Affiliate)
+	} else {
+		kv("Affiliate", "unknown")
+	}
+	if r.Update.Pending {
+		kv("Update", "pending installation")
+	} else {
+		kv("Update", "none")
+	}
+	if a := r.Update.Available; a != nil {
+		if a.Version != "" {
+			kv("Latest FW", fmt.Sprintf("%s (%s)", a.Version, strings.ToLower(a.Type)))
 		} else {
-			fmt.Print(",\n")
+			kv("Latest FW", "up to date")
 		}
-		fmt.Printf(`    "%s": "%s"`, strings.Replace(strings.ToLower(key), " ", "_", -1), value)
-	} else {
-		fmt.Printf("%15s: %s\n", key, value)
 	}
+
+	if s := r.Storage; s != nil {
+		fmt.Fprintln(bw)
+		kv("Total Space", formatSize(s.Total))
+		kv("Used Space", formatSize(s.Used))
+		kv("Free Space", formatSize(s.Free))
+	}
+
+	if l := r.Library; l != nil {
+		fmt.Fprintln(bw)
+		kv("DB Version", strconv.Itoa(l.DBVersion))
+		kv("Books", fmt.Sprintf("%d (%d sideloaded, %d store)", l.Books, l.Sideloaded, l.Store))
+		kv("Shelves", strconv.Itoa(l.Shelves))
+		kv("Annotations", strconv.Itoa(l.Annotations))
+	}
+
+	return bw.Flush()
 }
 
-func println() {
-	if !jsono {
-		fmt.Print("\n")
+func formatSize(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for x := n / unit; x >= unit; x /= unit {
+		div *= unit
+		exp++
 	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/float64(div)), ".0") + " " + string("KMGTPE"[exp]) + "iB"
 }
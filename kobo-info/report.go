@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+// report is the output of kobo-info. The json and yaml field names are the
+// documented schema. Optional fields are omitted if they could not be
+// determined, and the reason is added to errors.
+type report struct {
+	Path      string       `json:"path" yaml:"path"`
+	Device    deviceInfo   `json:"device" yaml:"device"`
+	Version   versionInfo  `json:"version" yaml:"version"`
+	Affiliate string       `json:"affiliate,omitempty" yaml:"affiliate,omitempty"`
+	Storage   *storageInfo `json:"storage,omitempty" yaml:"storage,omitempty"`
+	Update    updateInfo   `json:"update" yaml:"update"`
+	Library   *libraryInfo `json:"library,omitempty" yaml:"library,omitempty"`
+	Warnings  []string     `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+	Errors    []string     `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+// deviceInfo contains the device specs. Only id, serial, and known are set for
+// unknown devices.
+type deviceInfo struct {
+	Known       bool           `json:"known" yaml:"known"`
+	Name        string         `json:"name,omitempty" yaml:"name,omitempty"`
+	ID          string         `json:"id" yaml:"id"`
+	Family      string         `json:"family,omitempty" yaml:"family,omitempty"`
+	CodeNames   *codeNamesInfo `json:"codenames,omitempty" yaml:"codenames,omitempty"`
+	Hardware    string         `json:"hardware,omitempty" yaml:"hardware,omitempty"`
+	StorageGB   int            `json:"storage_gb,omitempty" yaml:"storage_gb,omitempty"` // advertised
+	DisplayPPI  int            `json:"display_ppi,omitempty" yaml:"display_ppi,omitempty"`
+	Serial      string         `json:"serial" yaml:"serial"`
+	ModelNumber string         `json:"model_number,omitempty" yaml:"model_number,omitempty"`
+}
+
+type codeNamesInfo struct {
+	Class     string `json:"class" yaml:"class"`
+	Family    string `json:"family" yaml:"family"`
+	Secondary string `json:"secondary,omitempty" yaml:"secondary,omitempty"`
+}
+
+// versionInfo contains every field of .kobo/version.
+type versionInfo struct {
+	Serial   string `json:"serial" yaml:"serial"`
+	Kernel   string `json:"kernel" yaml:"kernel"`
+	Firmware string `json:"firmware" yaml:"firmware"`
+	Field3   string `json:"field3" yaml:"field3"`
+	Field4   string `json:"field4" yaml:"field4"`
+	ID       string `json:"id" yaml:"id"`
+}
+
+// storageInfo contains the filesystem usage in bytes.
+type storageInfo struct {
+	Total uint64 `json:"total" yaml:"total"`
+	Free  uint64 `json:"free" yaml:"free"`
+	Used  uint64 `json:"used" yaml:"used"`
+}
+
+// updateInfo contains the firmware update status.
+type updateInfo struct {
+	Pending   bool           `json:"pending" yaml:"pending"`                         // an update will be installed on the next reboot
+	Files     []string       `json:"files,omitempty" yaml:"files,omitempty"`         // the update files in .kobo
+	Available *availableInfo `json:"available,omitempty" yaml:"available,omitempty"` // only if checked online
+}
+
+// availableInfo contains the result of an online update check. The version
+// and url are empty if there isn't an update.
+type availableInfo struct {
+	Type    string `json:"type" yaml:"type"`
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	URL     string `json:"url,omitempty" yaml:"url,omitempty"`
+}
+
+// libraryInfo contains counts from the nickel database. Deleted shelves are
+// not counted.
+type libraryInfo struct {
+	DBVersion   int `json:"db_version" yaml:"db_version"`
+	Books       int `json:"books" yaml:"books"`
+	Sideloaded  int `json:"sideloaded" yaml:"sideloaded"`
+	Store       int `json:"store" yaml:"store"`
+	Shelves     int `json:"shelves" yaml:"shelves"`
+	Annotations int `json:"annotations" yaml:"annotations"`
+}
+
+// updateFiles are the files in .kobo which nickel installs on the next reboot.
+var updateFiles = []string{"KoboRoot.tgz", "manifest.md5sum", "upgrade"}
+
+// newReport collects the report for the kobo at kpath. If c is not nil, it is
+// used to check for a firmware update.
+func newReport(kpath string, c *kobo.APIClient) (*report, error) {
+	r := &report{Path: kpath}
+
+	buf, err := os.ReadFile(filepath.Join(kpath, ".kobo", "version"))
+	if err != nil {
+		return nil, fmt.Errorf("read version: %w", err)
+	}
+	spl := strings.Split(strings.TrimSpace(string(buf)), ",")
+	if len(spl) != 6 {
+		return nil, fmt.Errorf("parse version: expected 6 fields, got %d", len(spl))
+	}
+	r.Version = versionInfo{spl[0], spl[1], spl[2], spl[3], spl[4], spl[5]}
+
+	r.Device.ID = r.Version.ID
+	r.Device.Serial = r.Version.Serial
+	if d, ok := kobo.DeviceByID(r.Version.ID); ok {
+		cn := d.CodeNames()
+		r.Device.Known = true
+		r.Device.Name = d.Name()
+		r.Device.Family = d.Family()
+		r.Device.CodeNames = &codeNamesInfo{cn.Class().String(), cn.Family().String(), cn.Secondary().String()}
+		r.Device.Hardware = d.Hardware().String()
+		r.Device.StorageGB = d.StorageGB()
+		r.Device.DisplayPPI = d.DisplayPPI()
+	}
+	if s, err := kobo.CheckSerial(r.Version.Serial, r.Version.ID); err == nil || errors.Is(err, kobo.ErrSerialMismatch) {
+		r.Device.ModelNumber = s.Model
+		if err != nil {
+			r.Warnings = append(r.Warnings, err.Error())
+		}
+	}
+
+	if affiliate, err := kobo.ParseKoboAffiliate(kpath); err == nil {
+		r.Affiliate = affiliate
+	} else {
+		r.Errors = append(r.Errors, fmt.Sprintf("read affiliate: %v", err))
+	}
+
+	if total, free, err := internal.Statfs(kpath); err == nil {
+		r.Storage = &storageInfo{Total: total, Free: free, Used: total - free}
+	} else {
+		r.Errors = append(r.Errors, fmt.Sprintf("get storage: %v", err))
+	}
+
+	for _, fn := range updateFiles {
+		if _, err := os.Stat(filepath.Join(kpath, ".kobo", fn)); err == nil {
+			r.Update.Files = append(r.Update.Files, fn)
+			if fn == "KoboRoot.tgz" {
+				r.Update.Pending = true
+			}
+		}
+	}
+
+	if c != nil {
+		affiliate := r.Affiliate
+		if affiliate == "" {
+			affiliate = "Kobo"
+		}
+		if res, err := c.CheckUpgrade(context.Background(), r.Version.ID, affiliate, r.Version.Firmware, r.Version.Serial); err == nil {
+			r.Update.Available = &availableInfo{Type: res.UpgradeType.String()}
+			if res.UpgradeType.IsUpdate() {
+				r.Update.Available.Version = res.ParseVersion()
+				r.Update.Available.URL = res.UpgradeURL
+			}
+		} else {
+			r.Errors = append(r.Errors, fmt.Sprintf("check for update: %v", err))
+		}
+	}
+
+	if l, err := readLibrary(kpath); err == nil {
+		r.Library = l
+	} else {
+		r.Errors = append(r.Errors, fmt.Sprintf("read library: %v", err))
+	}
+
+	return r, nil
+}
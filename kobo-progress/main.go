@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/progress"
+	"github.com/spf13/pflag"
+)
+
+func main() {
+	export := pflag.StringP("export", "e", "", "export the reading progress to a file (- for stdout)")
+	imprt := pflag.StringP("import", "i", "", "import the reading progress from a file (- for stdin)")
+	force := pflag.Bool("force", false, "import progress even for books which were read more recently on the kobo")
+	dryRun := pflag.BoolP("dry-run", "n", false, "show the changes without making them")
+	backupDir := pflag.String("backup-dir", "", "directory to write the database backup to (default: next to the database)")
+	help := pflag.BoolP("help", "h", false, "show this help text")
+	pflag.Parse()
+
+	if *help || pflag.NArg() > 1 || (*export == "") == (*imprt == "") {
+		fmt.Fprintf(os.Stderr, "usage: kobo-progress (--export file|--import file) [options] [kobo_path]\n")
+		fmt.Fprintf(os.Stderr, "\nversion: %s\n\noptions:\n", internal.VersionName())
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nIf kobo_path is not specified, kobo-progress will attempt to look for a kobo device.\n")
+		fmt.Fprintf(os.Stderr, "\nBooks are matched by their path on the kobo, then by file name (so books in different folders, or converted to or from kepub, still match), then by ISBN, then by title and author. The position in the book is only imported if it has the same format on both devices; otherwise only the read status and percentage are imported.\n")
+		os.Exit(2)
+	}
+
+	var kpath string
+	if pflag.NArg() == 1 {
+		kpath = pflag.Arg(0)
+	} else {
+		kobos, err := kobo.Find()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not look for a kobo: %v\n", err)
+			os.Exit(1)
+		} else if len(kobos) < 1 {
+			fmt.Fprintf(os.Stderr, "Error: could not find a kobo\n")
+			os.Exit(1)
+		}
+		kpath = kobos[0]
+	}
+
+	if *export != "" {
+		serial, _, _, err := kobo.ParseKoboVersion(kpath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not read device info: %v\n", err)
+			os.Exit(1)
+		}
+
+		d, err := db.Open(kpath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		es, err := progress.Read(context.Background(), d)
+		d.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not read progress: %v\n", err)
+			os.Exit(1)
+		}
+
+		var buf bytes.Buffer
+		if err := progress.WriteJSON(&buf, &progress.Export{
+			Serial:   serial,
+			Exported: time.Now().UTC(),
+			Books:    es,
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not write progress: %v\n", err)
+			os.Exit(1)
+		}
+		if *export == "-" {
+			_, err = os.Stdout.Write(buf.Bytes())
+		} else {
+			err = os.WriteFile(*export, buf.Bytes(), 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not write progress: %v\n", err)
+			os.Exit(1)
+		}
+		if *export != "-" {
+			fmt.Printf("Exported progress for %d books.\n", len(es))
+		}
+		return
+	}
+
+	f := os.Stdin
+	if *imprt != "-" {
+		var err error
+		if f, err = os.Open(*imprt); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not read progress: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	x, err := progress.ReadJSON(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not read progress: %v\n", err)
+		os.Exit(1)
+	}
+
+	opts := &progress.Options{
+		Force: *force,
+	}
+	plan := func(ctx context.Context, d *db.DB) ([]progress.Change, error) {
+		if err := d.CheckSchema(nil); errors.Is(err, db.ErrUnknownVersion) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		return progress.Plan(ctx, d, x.Books, opts)
+	}
+
+	if *dryRun {
+		d, err := db.Open(kpath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cs, err := plan(context.Background(), d)
+		d.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		printChanges(cs)
+		return
+	}
+
+	var cs []progress.Change
+	backup, err := db.Update(context.Background(), kpath, &db.UpdateOptions{
+		Tool:      "kobo-progress",
+		BackupDir: *backupDir,
+	}, func(ctx context.Context, tx *db.Tx) error {
+		var err error
+		if cs, err = plan(ctx, tx.DB); err != nil {
+			return err
+		}
+		return progress.Apply(ctx, tx, cs)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if backup != "" {
+			fmt.Fprintf(os.Stderr, "Backup: %s\n", backup)
+		}
+		os.Exit(1)
+	}
+	if printChanges(cs) == 0 {
+		os.Remove(backup)
+	} else {
+		fmt.Printf("Backup: %s\n", backup)
+	}
+}
+
+// printChanges prints the changes and returns the number of updated books.
+func printChanges(cs []progress.Change) int {
+	progress.Sort(cs)
+	var n int
+	for _, c := range cs {
+		switch c.Action {
+		case progress.ActionUpdate:
+			fmt.Printf("~ %s\n", c)
+			n++
+		case progress.ActionUnchanged:
+			fmt.Printf("= %s\n", c)
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", c)
+		}
+	}
+	if n == 0 {
+		fmt.Printf("No changes.\n")
+	}
+	return n
+}
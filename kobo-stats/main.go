@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/stats"
+	"github.com/spf13/pflag"
+)
+
+func main() {
+	format := pflag.StringP("format", "f", "json", "output format (json, csv)")
+	table := pflag.StringP("table", "t", "books", "table to write as csv ("+strings.Join(stats.Tables, ", ")+")")
+	output := pflag.StringP("output", "o", "-", "output file (- for stdout)")
+	merge := pflag.StringArrayP("merge", "m", nil, "merge the statistics from a previous json output (can be specified multiple times)")
+	tz := pflag.String("tz", "", "time zone for days (default: local)")
+	help := pflag.BoolP("help", "h", false, "show this help text")
+	pflag.Parse()
+
+	if *help {
+		fmt.Fprintf(os.Stderr, "usage: kobo-stats [options] [kobo_path...]\n")
+		fmt.Fprintf(os.Stderr, "\nversion: %s\n\noptions:\n", internal.VersionName())
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nIf no kobo_path or --merge is specified, kobo-stats will attempt to look for kobo devices.\n")
+		fmt.Fprintf(os.Stderr, "\nStatistics from multiple devices are combined, and devices are identified by their serial number. To merge statistics from devices which aren't connected at the same time, save the json output for each one and pass it to --merge. If the same device is included more than once, the most recent book statistics for it are used, and the reading sessions from all of them are combined.\n")
+		fmt.Fprintf(os.Stderr, "\nThe per-day statistics and streaks only include reading sessions which nickel hasn't uploaded and removed yet, so merge regularly to keep a complete history.\n")
+		os.Exit(2)
+	}
+
+	switch *format {
+	case "json":
+	case "csv":
+		var ok bool
+		for _, t := range stats.Tables {
+			ok = ok || t == *table
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: --table: unknown table %q\n", *table)
+			os.Exit(2)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: --format: unknown format %q\n", *format)
+		os.Exit(2)
+	}
+
+	loc := time.Local
+	if *tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(*tz); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --tz: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	var srcs []stats.Source
+	for _, fn := range *merge {
+		f, err := os.Open(fn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not read statistics: %v\n", err)
+			os.Exit(1)
+		}
+		st, err := stats.ReadJSON(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not read statistics from %s: %v\n", fn, err)
+			os.Exit(1)
+		}
+		srcs = append(srcs, st.Sources...)
+	}
+
+	kpaths := pflag.Args()
+	if len(kpaths) == 0 && len(*merge) == 0 {
+		kobos, err := kobo.Find()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not look for a kobo: %v\n", err)
+			os.Exit(1)
+		} else if len(kobos) < 1 {
+			fmt.Fprintf(os.Stderr, "Error: could not find a kobo\n")
+			os.Exit(1)
+		}
+		kpaths = kobos
+	}
+
+	now := time.Now()
+	for _, kpath := range kpaths {
+		serial, _, id, err := kobo.ParseKoboVersion(kpath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not read device info for %s: %v\n", kpath, err)
+			os.Exit(1)
+		}
+		var name string
+		if dev, ok := kobo.DeviceByID(id); ok {
+			name = dev.Name()
+		}
+
+		d, err := db.Open(kpath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		src, err := stats.Collect(context.Background(), d, serial, name, now)
+		d.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not read statistics from %s: %v\n", kpath, err)
+			os.Exit(1)
+		}
+		srcs = append(srcs, *src)
+	}
+
+	st := stats.Aggregate(srcs, &stats.Options{
+		Location: loc,
+		Now:      now,
+	})
+
+	var buf bytes.Buffer
+	var err error
+	if *format == "csv" {
+		err = stats.WriteCSV(&buf, st, *table)
+	} else {
+		err = stats.WriteJSON(&buf, st)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not write statistics: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *output == "-" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = os.WriteFile(*output, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not write statistics: %v\n", err)
+		os.Exit(1)
+	}
+}
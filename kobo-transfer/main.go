@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/spf13/pflag"
+)
+
+func main() {
+	addr := pflag.StringP("addr", "a", ":8080", "address to listen on")
+	help := pflag.BoolP("help", "h", false, "show this help text")
+	pflag.Parse()
+
+	if *help || pflag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: kobo-transfer [options] books_dir\n")
+		fmt.Fprintf(os.Stderr, "\nversion: %s\n\noptions:\n", internal.VersionName())
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nOpen the printed address in the experimental web browser on the kobo (which must be on the same network) to download books.\n")
+		os.Exit(2)
+	}
+
+	dir := pflag.Arg(0)
+	if fi, err := os.Stat(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	} else if !fi.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: not a directory: %s\n", dir)
+		os.Exit(1)
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	la := l.Addr().(*net.TCPAddr)
+	if ips := localIPs(); la.IP.IsUnspecified() && len(ips) != 0 {
+		for _, ip := range ips {
+			fmt.Printf("Serving %s at http://%s/\n", dir, net.JoinHostPort(ip.String(), strconv.Itoa(la.Port)))
+		}
+	} else {
+		fmt.Printf("Serving %s at http://%s/\n", dir, la)
+	}
+
+	srv := &http.Server{
+		Handler:           newServer(os.DirFS(dir)),
+		ReadHeaderTimeout: time.Second * 30,
+	}
+	if err := srv.Serve(l); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// localIPs returns the non-loopback IPv4 addresses of the machine.
+func localIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			ips = append(ips, n.IP)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// recorded user agents
+const (
+	uaClaraHD = "Mozilla/5.0 (Linux; U; Android 2.0; en-us;) AppleWebKit/538.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/538.1 (Kobo Touch 0376/4.38.21908)"
+	uaAuraH2O = "Mozilla/5.0 (Linux; U; Android 2.0; en-us;) AppleWebKit/538.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/538.1 (Kobo Touch 0370/4.20.14622)"
+	uaFirefox = "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"
+)
+
+func testServer() *httptest.Server {
+	mt := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return httptest.NewServer(newServer(fstest.MapFS{
+		"Book One.epub":             {Data: []byte("epub1"), ModTime: mt},
+		"Book One.kepub.epub":       {Data: []byte("kepub1"), ModTime: mt},
+		"Book Two.epub":             {Data: []byte("epub2"), ModTime: mt},
+		"Manual.pdf":                {Data: []byte("pdf"), ModTime: mt},
+		"notes.docx":                {Data: []byte("docx"), ModTime: mt},
+		".hidden.epub":              {Data: []byte("hidden"), ModTime: mt},
+		"Series/Book Three.cbz":     {Data: []byte("cbz"), ModTime: mt},
+		"Series/Book <Four>.epub":   {Data: []byte("epub4"), ModTime: mt},
+		"Series/Book #5?.epub":      {Data: []byte("epub5"), ModTime: mt},
+		"Series/.kobo/KoboReader.x": {Data: []byte("x"), ModTime: mt},
+		".git/Book Six.epub":        {Data: []byte("epub6"), ModTime: mt},
+	}))
+}
+
+func get(t *testing.T, s *httptest.Server, path, ua string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, s.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", ua)
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(buf)
+}
+
+func TestBrowse(t *testing.T) {
+	s := testServer()
+	defer s.Close()
+
+	for _, tc := range []struct {
+		name     string
+		ua       string
+		contains []string
+		excludes []string
+	}{
+		{
+			"ClaraHD", uaClaraHD,
+			[]string{"Kobo Clara HD (4.38.21908)", `href="/download/Book%20One.kepub.epub"`, `href="/download/Book%20Two.epub"`, `href="/download/Manual.pdf"`, `href="/Series/"`},
+			[]string{`href="/download/Book%20One.epub"`, "notes.docx", ".hidden"},
+		},
+		{
+			"AuraH2O", uaAuraH2O,
+			[]string{"Kobo Aura H2O (4.20.14622)", `href="/download/Book%20One.kepub.epub"`},
+			[]string{`href="/download/Book%20One.epub"`},
+		},
+		{
+			"Firefox", uaFirefox,
+			[]string{`href="/download/Book%20One.epub"`, `href="/download/Book%20Two.epub"`},
+			[]string{`href="/download/Book%20One.kepub.epub"`, `class="device"`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := get(t, s, "/", tc.ua)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status %d", resp.StatusCode)
+			}
+			for _, x := range tc.contains {
+				if !strings.Contains(body, x) {
+					t.Errorf("expected body to contain %q", x)
+				}
+			}
+			for _, x := range tc.excludes {
+				if strings.Contains(body, x) {
+					t.Errorf("expected body not to contain %q", x)
+				}
+			}
+		})
+	}
+
+	resp, body := get(t, s, "/Series/", uaClaraHD)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	for _, x := range []string{`href="/"`, `href="/download/Series/Book%20Three.cbz"`, "Book &lt;Four&gt;", `href="/download/Series/Book%20%235%3F.epub"`} {
+		if !strings.Contains(body, x) {
+			t.Errorf("expected subdirectory body to contain %q", x)
+		}
+	}
+	if strings.Contains(body, ".kobo") {
+		t.Errorf("expected hidden directory to be excluded")
+	}
+
+	if resp, _ := get(t, s, "/Missing/", uaClaraHD); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found for missing directory, got %d", resp.StatusCode)
+	}
+	if resp, _ := get(t, s, "/Series/.kobo/", uaClaraHD); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found for hidden directory, got %d", resp.StatusCode)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	s := testServer()
+	defer s.Close()
+
+	for _, tc := range []struct {
+		path   string
+		status int
+		ctype  string
+		body   string
+		fn     string
+	}{
+		{"/download/Book%20One.kepub.epub", http.StatusOK, "application/epub+zip", "kepub1", "Book One.kepub.epub"},
+		{"/download/Book%20One.epub", http.StatusOK, "application/epub+zip", "epub1", "Book One.epub"},
+		{"/download/Manual.pdf", http.StatusOK, "application/pdf", "pdf", "Manual.pdf"},
+		{"/download/Series/Book%20Three.cbz", http.StatusOK, "application/x-cbz", "cbz", "Book Three.cbz"},
+		{"/download/Series/Book%20%235%3F.epub", http.StatusOK, "application/epub+zip", "epub5", "Book #5?.epub"},
+		{"/download/notes.docx", http.StatusNotFound, "", "", ""},
+		{"/download/.hidden.epub", http.StatusNotFound, "", "", ""},
+		{"/download/Missing.epub", http.StatusNotFound, "", "", ""},
+		{"/download/..%2f..%2fetc%2fpasswd.txt", http.StatusNotFound, "", "", ""},
+		{"/download/.git/Book%20Six.epub", http.StatusNotFound, "", "", ""},
+		{"/download/Series", http.StatusNotFound, "", "", ""},
+	} {
+		resp, body := get(t, s, tc.path, uaClaraHD)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, resp.StatusCode)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != tc.ctype {
+			t.Errorf("%s: expected content type %q, got %q", tc.path, tc.ctype, ct)
+		}
+		if cd := resp.Header.Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") || !strings.Contains(cd, tc.fn) {
+			t.Errorf("%s: unexpected content disposition %q", tc.path, cd)
+		}
+		if body != tc.body {
+			t.Errorf("%s: expected body %q, got %q", tc.path, tc.body, body)
+		}
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	for _, fn := range []string{
+		filepath.Join(root, "Books", "Book One.epub"),
+		filepath.Join(outside, "Secret.epub"),
+		filepath.Join(outside, "Dir", "Secret.epub"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(fn), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte("epub"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"Secret.epub":      filepath.Join(outside, "Secret.epub"),
+		"Dir":              filepath.Join(outside, "Dir"),
+		"Books/Alias.epub": "Book One.epub",
+		"Books/Linked Dir": ".",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			t.Skipf("create symlink: %v", err)
+		}
+	}
+
+	s := httptest.NewServer(newServer(os.DirFS(root)))
+	defer s.Close()
+
+	for _, x := range []struct {
+		path   string
+		status int
+	}{
+		{"/download/Books/Book%20One.epub", http.StatusOK},
+		{"/download/Secret.epub", http.StatusNotFound},
+		{"/download/Dir/Secret.epub", http.StatusNotFound},
+		{"/download/Books/Alias.epub", http.StatusNotFound},
+		{"/download/Books/Linked%20Dir/Book%20One.epub", http.StatusNotFound},
+		{"/Books/", http.StatusOK},
+		{"/Dir/", http.StatusNotFound},
+		{"/Books/Linked%20Dir/", http.StatusNotFound},
+	} {
+		if resp, _ := get(t, s, x.path, uaClaraHD); resp.StatusCode != x.status {
+			t.Errorf("%s: expected status %d, got %d", x.path, x.status, resp.StatusCode)
+		}
+	}
+}
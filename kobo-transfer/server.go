@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pgaskin/koboutils/v2/kobo/kobohttp"
+)
+
+// formats maps the lowercase extensions of the formats supported by nickel to
+// the content type to serve them with. Nickel identifies kepubs by the
+// .kepub.epub filename, so they are served as regular epubs.
+var formats = map[string]string{
+	".kepub.epub": "application/epub+zip",
+	".epub":       "application/epub+zip",
+	".pdf":        "application/pdf",
+	".mobi":       "application/x-mobipocket-ebook",
+	".cbz":        "application/x-cbz",
+	".cbr":        "application/x-cbr",
+	".txt":        "text/plain; charset=utf-8",
+	".html":       "text/html; charset=utf-8",
+	".htm":        "text/html; charset=utf-8",
+	".rtf":        "application/rtf",
+}
+
+// format returns the extension and content type of a supported book.
+func format(name string) (ext, ctype string, ok bool) {
+	lname := strings.ToLower(name)
+	if strings.HasSuffix(lname, ".kepub.epub") {
+		return name[len(name)-len(".kepub.epub"):], formats[".kepub.epub"], true
+	}
+	ext = path.Ext(name)
+	ctype, ok = formats[strings.ToLower(ext)]
+	return ext, ctype, ok
+}
+
+// server serves books from a filesystem.
+type server struct {
+	fsys fs.FS
+}
+
+// newServer returns a handler serving the books in fsys.
+func newServer(fsys fs.FS) http.Handler {
+	s := &server{fsys}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleBrowse)
+	mux.HandleFunc("/download/", s.handleDownload)
+	return kobohttp.Middleware(mux)
+}
+
+type entry struct {
+	Name string
+	Href string
+}
+
+type book struct {
+	Title  string
+	Href   string
+	Format string
+}
+
+var browseTmpl = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 1.2em; margin: 0; padding: 0.5em; }
+h1 { font-size: 1.3em; }
+p.device { color: #555; }
+ul { list-style: none; padding: 0; margin: 0; }
+li { border-bottom: 1px solid #999; }
+li a { display: block; padding: 0.8em 0.3em; color: #000; text-decoration: none; }
+span.format { float: right; color: #555; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Device}}<p class="device">{{.Device}}</p>{{end}}
+<ul>
+{{if .Parent}}<li><a href="{{.Parent}}">&larr; Back</a></li>{{end}}
+{{range .Dirs}}<li><a href="{{.Href}}">{{.Name}}/</a></li>
+{{end}}{{range .Books}}<li><a href="{{.Href}}">{{.Title}}<span class="format">{{.Format}}</span></a></li>
+{{end}}</ul>
+{{if not (or .Dirs .Books)}}<p>No books.</p>{{end}}
+</body>
+</html>
+`))
+
+func (s *server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	dir := strings.Trim(r.URL.Path, "/")
+	if dir == "" {
+		dir = "."
+	}
+	if !fs.ValidPath(dir) || !s.visible(dir, true) {
+		http.NotFound(w, r)
+		return
+	}
+
+	des, err := fs.ReadDir(s.fsys, dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, "Failed to read directory.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var data struct {
+		Title  string
+		Device string
+		Parent string
+		Dirs   []entry
+		Books  []book
+	}
+
+	data.Title = "Books"
+	if dir != "." {
+		data.Title = path.Base(dir)
+		data.Parent = "/" + path.Dir(dir) + "/"
+		if data.Parent == "/./" {
+			data.Parent = "/"
+		}
+	}
+
+	if c, ok := kobohttp.FromRequest(r); ok {
+		if c.Device != 0 {
+			data.Device = c.Device.Name() + " (" + c.Version.String() + ")"
+		} else {
+			data.Device = "Kobo " + c.UserAgent.IDString() + " (" + c.Version.String() + ")"
+		}
+	}
+
+	variants := map[string][]string{}
+	for _, de := range des {
+		name := de.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if de.IsDir() {
+			data.Dirs = append(data.Dirs, entry{name, href("/" + path.Join(dir, name) + "/")})
+			continue
+		}
+		if !de.Type().IsRegular() {
+			continue
+		}
+		ext, _, ok := format(name)
+		if !ok {
+			continue
+		}
+		title := strings.TrimSuffix(name, ext)
+		if strings.EqualFold(ext, ".epub") || strings.EqualFold(ext, ".kepub.epub") {
+			title += "\x00epub" // group epub variants only
+		} else {
+			title += "\x00" + strings.ToLower(ext)
+		}
+		variants[title] = append(variants[title], name)
+	}
+
+	for key, vs := range variants {
+		name := kobohttp.ChooseVariant(r, vs...)
+		ext, _, _ := format(name)
+		title, _, _ := strings.Cut(key, "\x00")
+		data.Books = append(data.Books, book{
+			Title:  title,
+			Href:   href("/download/" + path.Join(dir, name)),
+			Format: strings.ToUpper(strings.TrimPrefix(ext, ".")),
+		})
+	}
+	sort.Slice(data.Books, func(i, j int) bool {
+		if a, b := strings.ToLower(data.Books[i].Title), strings.ToLower(data.Books[j].Title); a != b {
+			return a < b
+		}
+		return data.Books[i].Format < data.Books[j].Format
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	browseTmpl.Execute(w, data)
+}
+
+// visible checks whether name would be reachable from the listing, i.e., every
+// path component is a non-hidden directory, and the last one is a directory if
+// dir is true or a regular file otherwise. Each component is checked using the
+// entries of its parent directory, which aren't resolved like Open and Stat
+// are, so symlinks (which os.DirFS would follow outside the root) are rejected.
+func (s *server) visible(name string, dir bool) bool {
+	if name == "." {
+		return dir
+	}
+	parent := "."
+	cs := strings.Split(name, "/")
+	for i, c := range cs {
+		if strings.HasPrefix(c, ".") {
+			return false
+		}
+		des, err := fs.ReadDir(s.fsys, parent)
+		if err != nil {
+			return false
+		}
+		j := sort.Search(len(des), func(j int) bool {
+			return des[j].Name() >= c
+		})
+		if j == len(des) || des[j].Name() != c {
+			return false
+		}
+		if t := des[j].Type(); i != len(cs)-1 || dir {
+			if !t.IsDir() {
+				return false
+			}
+		} else if !t.IsRegular() {
+			return false
+		}
+		parent = path.Join(parent, c)
+	}
+	return true
+}
+
+// href escapes a path for use in a link.
+func href(p string) string {
+	return (&url.URL{Path: p}).String()
+}
+
+func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/download/")
+	if !fs.ValidPath(name) || !s.visible(name, false) {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, ctype, ok := format(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", fi.ModTime(), rs)
+		return
+	}
+	http.Error(w, "File is not seekable.", http.StatusInternalServerError)
+}
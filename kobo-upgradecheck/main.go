@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/relnotes"
+	"github.com/spf13/pflag"
+)
+
+func main() {
+	device := pflag.StringP("device", "d", "", "check for the specified device (ID, full ID string, or name) instead of a connected one")
+	affiliates := pflag.StringSliceP("affiliate", "a", nil, "affiliate to check (default: from the device, or Kobo)")
+	version := pflag.StringP("version", "v", "", "current firmware version to check from (default: from the device, or 0.0.0)")
+	serial := pflag.StringP("serial", "s", "", "serial number to send (default: from the device, or N0)")
+	matrix := pflag.BoolP("matrix", "m", false, "check every known device for each affiliate")
+	allAffiliates := pflag.BoolP("all-affiliates", "A", false, "check every known affiliate")
+	jobs := pflag.IntP("jobs", "j", 4, "maximum number of concurrent requests")
+	timeout := pflag.DurationP("timeout", "t", time.Second*10, "timeout for each request")
+	apiURL := pflag.String("api-url", "", "override the Kobo API base URL")
+	storeAPIURL := pflag.String("store-api-url", "", "override the Kobo store API base URL used by --discover")
+	discover := pflag.Bool("discover", false, "use the upgrade check endpoint from the store initialization resources")
+	jsono := pflag.Bool("json", false, "output as json")
+	notes := pflag.String("release-notes", "", "also fetch the release notes for updates (text, markdown, or html)")
+	cacheDir := pflag.String("cache-dir", "", "cache release notes in the specified directory")
+	help := pflag.BoolP("help", "h", false, "show this help text")
+	pflag.Lookup("release-notes").NoOptDefVal = string(relnotes.FormatText)
+	pflag.Parse()
+
+	var notesFormat relnotes.Format
+	if *notes != "" {
+		f, err := relnotes.ParseFormat(*notes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		notesFormat = f
+	}
+
+	if *help || pflag.NArg() > 1 || (pflag.NArg() != 0 && (*device != "" || *matrix)) || (*device != "" && *matrix) || *jobs < 1 {
+		fmt.Fprintf(os.Stderr, "usage: kobo-upgradecheck [options] [kobo_path]\n")
+		fmt.Fprintf(os.Stderr, "\nversion: %s\n\noptions:\n", internal.VersionName())
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nIf neither --device nor --matrix is specified, the device at kobo_path (or the first one found) is checked.\n")
+		os.Exit(2)
+	}
+
+	if *allAffiliates {
+		if len(*affiliates) != 0 {
+			fmt.Fprintf(os.Stderr, "Error: --affiliate and --all-affiliates are mutually exclusive\n")
+			os.Exit(2)
+		}
+		for _, a := range kobo.Affiliates() {
+			*affiliates = append(*affiliates, a.Name)
+		}
+	}
+	for _, a := range *affiliates {
+		if err := kobo.ValidateAffiliate(a); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	var checks []check
+	switch {
+	case *matrix:
+		for _, d := range kobo.Devices() {
+			for _, a := range orDefaults(*affiliates, "Kobo") {
+				checks = append(checks, check{
+					Device:    d,
+					DeviceID:  d.IDString(),
+					Affiliate: a,
+					Version:   orDefault(*version, "0.0.0"),
+					Serial:    orDefault(*serial, "N0"),
+				})
+			}
+		}
+	case *device != "":
+		d, ok := kobo.ParseDevice(*device)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: unknown device %q\n", *device)
+			os.Exit(2)
+		}
+		for _, a := range orDefaults(*affiliates, "Kobo") {
+			checks = append(checks, check{
+				Device:    d,
+				DeviceID:  d.IDString(),
+				Affiliate: a,
+				Version:   orDefault(*version, "0.0.0"),
+				Serial:    orDefault(*serial, "N0"),
+			})
+		}
+	default:
+		var kpath string
+		if pflag.NArg() == 1 {
+			kpath = pflag.Arg(0)
+		} else {
+			kobos, err := kobo.Find()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: could not look for a kobo: %v\n", err)
+				os.Exit(1)
+			} else if len(kobos) < 1 {
+				fmt.Fprintf(os.Stderr, "Error: could not find a kobo\n")
+				os.Exit(1)
+			}
+			kpath = kobos[0]
+		}
+
+		if !kobo.IsKobo(kpath) {
+			fmt.Fprintf(os.Stderr, "Error: not a valid kobo: %s\n", kpath)
+			os.Exit(1)
+		}
+
+		dserial, dversion, id, err := kobo.ParseKoboVersion(kpath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not parse kobo version: %v\n", err)
+			os.Exit(1)
+		}
+
+		daffiliate, err := kobo.ParseKoboAffiliate(kpath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not parse kobo affiliate: %v\n", err)
+			os.Exit(1)
+		}
+		if err := kobo.ValidateAffiliate(daffiliate); err != nil && len(*affiliates) == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+
+		d, _ := kobo.DeviceByID(id)
+		for _, a := range orDefaults(*affiliates, daffiliate) {
+			checks = append(checks, check{
+				Device:    d,
+				DeviceID:  id,
+				Affiliate: a,
+				Version:   orDefault(*version, dversion),
+				Serial:    orDefault(*serial, dserial),
+			})
+		}
+	}
+
+	c := &kobo.APIClient{
+		BaseURL:      *apiURL,
+		StoreBaseURL: *storeAPIURL,
+		HTTPClient:   &http.Client{Timeout: *timeout},
+	}
+	if *discover {
+		res, err := c.Initialization(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not get initialization resources: %v\n", err)
+			os.Exit(1)
+		}
+		c.Resources = res
+	}
+	run(context.Background(), c, checks, *jobs)
+	if notesFormat != "" {
+		fetchNotes(context.Background(), &relnotes.Fetcher{Client: c, CacheDir: *cacheDir}, notesFormat, checks)
+	}
+
+	if *jsono {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(checks); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "DEVICE\tAFFILIATE\tCURRENT\tUPGRADE\tVERSION\tURL\n")
+		for _, c := range checks {
+			name := c.DeviceID
+			if c.Device != 0 {
+				name = c.Device.Name()
+			}
+			if c.Error != "" {
+				fmt.Fprintf(tw, "%s\t%s\t%s\terror\t-\t%s\n", name, c.Affiliate, c.Version, c.Error)
+			} else {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", name, c.Affiliate, c.Version, c.UpgradeType, orDefault(c.UpgradeVersion, "-"), orDefault(c.UpgradeURL, "-"))
+			}
+		}
+		tw.Flush()
+
+		seen := map[string]bool{}
+		for _, c := range checks {
+			if c.ReleaseNotes == "" || seen[c.UpgradeVersion] {
+				continue
+			}
+			seen[c.UpgradeVersion] = true
+			fmt.Printf("\n==> Release notes for %s <==\n\n%s", c.UpgradeVersion, c.ReleaseNotes)
+			if !strings.HasSuffix(c.ReleaseNotes, "\n") {
+				fmt.Println()
+			}
+		}
+	}
+
+	for _, c := range checks {
+		if c.Error != "" {
+			os.Exit(1)
+		}
+	}
+}
+
+// check is an upgrade check and its result.
+type check struct {
+	Device         kobo.Device `json:"-"`
+	DeviceID       string      `json:"device_id"`
+	DeviceName     string      `json:"device_name,omitempty"`
+	Affiliate      string      `json:"affiliate"`
+	Version        string      `json:"version"`
+	Serial         string      `json:"-"`
+	UpgradeType    string      `json:"upgrade_type,omitempty"`
+	UpgradeVersion string      `json:"upgrade_version,omitempty"`
+	UpgradeURL     string      `json:"upgrade_url,omitempty"`
+	ReleaseNoteURL string      `json:"release_note_url,omitempty"`
+	ReleaseNotes   string      `json:"release_notes,omitempty"`
+	Error          string      `json:"error,omitempty"`
+}
+
+// run performs the checks, with at most jobs concurrent requests.
+func run(ctx context.Context, c *kobo.APIClient, checks []check, jobs int) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, jobs)
+	for i := range checks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(x *check) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if x.Device != 0 {
+				x.DeviceName = x.Device.Name()
+			}
+
+			res, err := c.CheckUpgrade(ctx, x.DeviceID, x.Affiliate, x.Version, x.Serial)
+			if err != nil {
+				x.Error = err.Error()
+				return
+			}
+			x.UpgradeType = res.UpgradeType.String()
+			x.UpgradeURL = res.UpgradeURL
+			x.ReleaseNoteURL = res.ReleaseNoteURL
+			if res.UpgradeType.IsUpdate() {
+				x.UpgradeVersion = res.ParseVersion()
+			}
+		}(&checks[i])
+	}
+	wg.Wait()
+}
+
+// fetchNotes fetches and renders the release notes for each update, fetching
+// the notes for each version only once.
+func fetchNotes(ctx context.Context, f *relnotes.Fetcher, format relnotes.Format, checks []check) {
+	type result struct {
+		notes string
+		err   error
+	}
+	done := map[string]result{}
+	for i := range checks {
+		x := &checks[i]
+		if x.Error != "" || x.ReleaseNoteURL == "" || x.UpgradeVersion == "" {
+			continue
+		}
+		r, ok := done[x.UpgradeVersion]
+		if !ok {
+			buf, err := f.Fetch(ctx, x.UpgradeVersion, x.ReleaseNoteURL)
+			if err == nil {
+				r.notes, r.err = relnotes.Render(buf, format)
+			} else if buf == nil {
+				r.err = err
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: release notes for %s: %v\n", x.UpgradeVersion, err)
+				r.notes, r.err = relnotes.Render(buf, format)
+			}
+			done[x.UpgradeVersion] = r
+		}
+		if r.err != nil {
+			x.Error = "release notes: " + r.err.Error()
+		} else {
+			x.ReleaseNotes = r.notes
+		}
+	}
+}
+
+func orDefaults(s []string, d string) []string {
+	if len(s) == 0 {
+		return []string{d}
+	}
+	return s
+}
+
+func orDefault(s, d string) string {
+	if s == "" {
+		return d
+	}
+	return s
+}
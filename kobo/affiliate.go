@@ -0,0 +1,272 @@
+package kobo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Affiliate contains information about a retailer which sells Kobo devices.
+// The affiliate is written to .kobo/affiliate.conf, and affects the store,
+// the firmware updates offered, and some branding.
+type Affiliate struct {
+	Name   string // as written in affiliate.conf
+	Store  string // human-readable retailer name
+	Region string // primary market
+}
+
+// ErrUnknownAffiliate is returned by ValidateAffiliate for affiliates which are
+// not known.
+var ErrUnknownAffiliate = errors.New("unknown affiliate")
+
+// Affiliates returns the known affiliates. This list is not exhaustive, since
+// retailer partnerships come and go over time.
+func Affiliates() []Affiliate {
+	return []Affiliate{
+		{"Kobo", "Rakuten Kobo", "Global"},
+		{"Indigo", "Indigo Books & Music", "Canada"},
+		{"BestBuy", "Best Buy", "North America"},
+		{"Walmart", "Walmart", "United States"},
+		{"Fnac", "Fnac", "France"},
+		{"Bol", "bol.com", "Netherlands and Belgium"},
+		{"Mondadori", "Mondadori Store", "Italy"},
+		{"WHSmith", "WHSmith", "United Kingdom"},
+		{"Rakuten", "Rakuten Books", "Japan"},
+	}
+}
+
+// AffiliateByName gets a known affiliate by its name (case-insensitive).
+func AffiliateByName(name string) (Affiliate, bool) {
+	for _, a := range Affiliates() {
+		if strings.EqualFold(a.Name, name) {
+			return a, true
+		}
+	}
+	return Affiliate{}, false
+}
+
+// ValidateAffiliate checks if an affiliate name is known, returning an error
+// wrapping ErrUnknownAffiliate if not.
+func ValidateAffiliate(name string) error {
+	if name == "" {
+		return errors.New("empty affiliate")
+	}
+	if a, ok := AffiliateByName(name); !ok {
+		return fmt.Errorf("%w %q", ErrUnknownAffiliate, name)
+	} else if a.Name != name {
+		return fmt.Errorf("affiliate %q should be written as %q", name, a.Name)
+	}
+	return nil
+}
+
+func (a Affiliate) String() string {
+	return a.Name
+}
+
+// AffiliateConf is an affiliate.conf file. It is a QSettings INI file, and
+// everything other than the modified values (including unknown sections and
+// keys, comments, and ordering) is preserved when it is written.
+type AffiliateConf struct {
+	lines []string
+	crlf  bool
+}
+
+// ReadAffiliateConf reads the .kobo/affiliate.conf file. If it doesn't exist,
+// an error wrapping fs.ErrNotExist is returned.
+func ReadAffiliateConf(kpath string) (*AffiliateConf, error) {
+	f, err := os.Open(filepath.Join(kpath, ".kobo", "affiliate.conf"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseAffiliateConf(f)
+}
+
+// WriteAffiliateConf replaces the .kobo/affiliate.conf file.
+func WriteAffiliateConf(kpath string, c *AffiliateConf) error {
+	fn := filepath.Join(kpath, ".kobo", "affiliate.conf")
+	tmp := fn + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if _, err := c.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// NewAffiliateConf creates an affiliate.conf for the specified affiliate.
+func NewAffiliateConf(affiliate string) *AffiliateConf {
+	c := &AffiliateConf{}
+	c.SetAffiliate(affiliate)
+	return c
+}
+
+// ParseAffiliateConf parses an affiliate.conf file.
+func ParseAffiliateConf(r io.Reader) (*AffiliateConf, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c := &AffiliateConf{
+		crlf: bytes.Contains(buf, []byte("\r\n")),
+	}
+	sc := bufio.NewScanner(bytes.NewReader(buf))
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if t := strings.TrimSpace(line); strings.HasPrefix(t, "[") && !strings.HasSuffix(t, "]") {
+			return nil, fmt.Errorf("line %d: invalid section header %q", len(c.lines)+1, line)
+		}
+		c.lines = append(c.lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Affiliate gets the affiliate from the General section.
+func (c *AffiliateConf) Affiliate() string {
+	v, _ := c.Get("General", "affiliate")
+	return v
+}
+
+// SetAffiliate sets the affiliate in the General section.
+func (c *AffiliateConf) SetAffiliate(affiliate string) {
+	c.Set("General", "affiliate", affiliate)
+}
+
+// Get gets the value of a key.
+func (c *AffiliateConf) Get(section, key string) (string, bool) {
+	for _, i := range c.find(section, key) {
+		_, v, _ := confKV(c.lines[i])
+		return v, true
+	}
+	return "", false
+}
+
+// Keys returns the keys in a section, in order.
+func (c *AffiliateConf) Keys(section string) []string {
+	var keys []string
+	var cur string
+	for _, line := range c.lines {
+		if s, ok := confSection(line); ok {
+			cur = s
+			continue
+		}
+		if k, _, ok := confKV(line); ok && cur == section {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Sections returns the sections, in order.
+func (c *AffiliateConf) Sections() []string {
+	var sections []string
+	for _, line := range c.lines {
+		if s, ok := confSection(line); ok {
+			sections = append(sections, s)
+		}
+	}
+	return sections
+}
+
+// Set sets the value of a key, adding it to the end of the section (which is
+// created if necessary) if it doesn't already exist.
+func (c *AffiliateConf) Set(section, key, value string) {
+	if idx := c.find(section, key); len(idx) != 0 {
+		for _, i := range idx {
+			c.lines[i] = key + "=" + value
+		}
+		return
+	}
+	var cur string
+	end := -1
+	for i, line := range c.lines {
+		if s, ok := confSection(line); ok {
+			cur = s
+			if s == section {
+				end = i + 1
+			}
+			continue
+		}
+		if cur == section && strings.TrimSpace(line) != "" {
+			end = i + 1
+		}
+	}
+	if end == -1 {
+		if n := len(c.lines); n != 0 && strings.TrimSpace(c.lines[n-1]) != "" {
+			c.lines = append(c.lines, "")
+		}
+		c.lines = append(c.lines, "["+section+"]", key+"="+value)
+		return
+	}
+	c.lines = append(c.lines[:end], append([]string{key + "=" + value}, c.lines[end:]...)...)
+}
+
+// WriteTo writes the file.
+func (c *AffiliateConf) WriteTo(w io.Writer) (int64, error) {
+	nl := "\n"
+	if c.crlf {
+		nl = "\r\n"
+	}
+	var b strings.Builder
+	for _, line := range c.lines {
+		b.WriteString(line)
+		b.WriteString(nl)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// find returns the line indexes of the key in a section.
+func (c *AffiliateConf) find(section, key string) []int {
+	var idx []int
+	var cur string
+	for i, line := range c.lines {
+		if s, ok := confSection(line); ok {
+			cur = s
+			continue
+		}
+		if k, _, ok := confKV(line); ok && cur == section && k == key {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func confSection(line string) (string, bool) {
+	t := strings.TrimSpace(line)
+	if strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+		return t[1 : len(t)-1], true
+	}
+	return "", false
+}
+
+func confKV(line string) (key, value string, ok bool) {
+	t := strings.TrimSpace(line)
+	if t == "" || t[0] == ';' || t[0] == '#' {
+		return "", "", false
+	}
+	k, v, ok := strings.Cut(t, "=")
+	if !ok {
+		return "", "", false
+	}
+	return strings.TrimSpace(k), strings.TrimSpace(v), true
+}
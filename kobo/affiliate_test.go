@@ -0,0 +1,118 @@
+package kobo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAffiliateConf(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		fn   func(c *AffiliateConf)
+		out  string
+	}{
+		{
+			"RoundTrip",
+			"[General]\naffiliate=Kobo\n; comment\nunknown = value\n\n[Other]\nx=y\n",
+			func(c *AffiliateConf) {},
+			"[General]\naffiliate=Kobo\n; comment\nunknown = value\n\n[Other]\nx=y\n",
+		},
+		{
+			"SetExisting",
+			"[General]\naffiliate = Kobo\nunknown=value\n",
+			func(c *AffiliateConf) { c.SetAffiliate("Indigo") },
+			"[General]\naffiliate=Indigo\nunknown=value\n",
+		},
+		{
+			"SetNewKey",
+			"[General]\naffiliate=Kobo\n\n[Other]\nx=y\n",
+			func(c *AffiliateConf) { c.Set("General", "key", "value") },
+			"[General]\naffiliate=Kobo\nkey=value\n\n[Other]\nx=y\n",
+		},
+		{
+			"SetNewSection",
+			"[General]\naffiliate=Kobo",
+			func(c *AffiliateConf) { c.Set("Other", "x", "y") },
+			"[General]\naffiliate=Kobo\n\n[Other]\nx=y\n",
+		},
+		{
+			"CRLF",
+			"[General]\r\naffiliate=Kobo\r\n",
+			func(c *AffiliateConf) { c.SetAffiliate("Fnac") },
+			"[General]\r\naffiliate=Fnac\r\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := ParseAffiliateConf(strings.NewReader(tc.in))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			tc.fn(c)
+			var b strings.Builder
+			if _, err := c.WriteTo(&b); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if b.String() != tc.out {
+				t.Errorf("expected %q, got %q", tc.out, b.String())
+			}
+		})
+	}
+
+	c, err := ParseAffiliateConf(strings.NewReader("[General]\naffiliate=Kobo\nunknown=value\n\n[Other]\nx=y\n"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if a := c.Affiliate(); a != "Kobo" {
+		t.Errorf("expected affiliate Kobo, got %q", a)
+	}
+	if v, ok := c.Get("Other", "x"); !ok || v != "y" {
+		t.Errorf("expected Other/x to be y, got %q", v)
+	}
+	if k := c.Keys("General"); len(k) != 2 || k[1] != "unknown" {
+		t.Errorf("unexpected keys %q", k)
+	}
+	if s := c.Sections(); len(s) != 2 || s[1] != "Other" {
+		t.Errorf("unexpected sections %q", s)
+	}
+
+	if _, err := ParseAffiliateConf(strings.NewReader("[General\naffiliate=Kobo\n")); err == nil {
+		t.Errorf("expected error for invalid section header")
+	}
+}
+
+func TestAffiliateConfFile(t *testing.T) {
+	if err := fakekobo(func(kpath string) {
+		c, err := ReadAffiliateConf(kpath)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		c.SetAffiliate("Indigo")
+		if err := WriteAffiliateConf(kpath, c); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if aff, err := ParseKoboAffiliate(kpath); err != nil || aff != "Indigo" {
+			t.Errorf("expected Indigo, got %q (err: %v)", aff, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateAffiliate(t *testing.T) {
+	for _, a := range Affiliates() {
+		if err := ValidateAffiliate(a.Name); err != nil {
+			t.Errorf("%s: unexpected error: %v", a, err)
+		}
+	}
+	if err := ValidateAffiliate("kobo"); err == nil || errors.Is(err, ErrUnknownAffiliate) {
+		t.Errorf("expected case error, got %v", err)
+	}
+	if err := ValidateAffiliate("NotARealAffiliate"); !errors.Is(err, ErrUnknownAffiliate) {
+		t.Errorf("expected unknown affiliate error, got %v", err)
+	}
+	if a, ok := AffiliateByName("indigo"); !ok || a.Region != "Canada" {
+		t.Errorf("unexpected affiliate %#v", a)
+	}
+}
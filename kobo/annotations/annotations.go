@@ -0,0 +1,244 @@
+// Package annotations exports highlights, notes, and bookmarks from the nickel
+// database.
+package annotations
+
+import (
+	"context"
+	"errors"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+)
+
+// Book is a book with annotations.
+type Book struct {
+	ContentID   string       `json:"content_id"`
+	Title       string       `json:"title"`
+	Author      string       `json:"author,omitempty"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+// Annotation is a highlight, note, or bookmark.
+type Annotation struct {
+	ID              string          `json:"id"`
+	Type            db.BookmarkType `json:"type"`
+	Text            string          `json:"text,omitempty"` // the highlighted text
+	Note            string          `json:"note,omitempty"`
+	Chapter         string          `json:"chapter,omitempty"`
+	Color           string          `json:"color,omitempty"`    // only set if Options.Colors is true
+	Progress        float64         `json:"progress,omitempty"` // estimated position in the book (0-100), zero if unknown
+	ChapterProgress float64         `json:"chapter_progress"`   // position in the chapter (0-1)
+	Created         time.Time       `json:"created"`
+	Modified        time.Time       `json:"modified"` // same as Created if never modified
+}
+
+// Cursor is the position of an incremental export.
+type Cursor struct {
+	Time time.Time `json:"time"`          // the latest modification time exported
+	IDs  []string  `json:"ids,omitempty"` // the annotations exported at Time
+}
+
+// includes returns true if the annotation is new or has been modified since
+// the cursor.
+func (c *Cursor) includes(a Annotation) bool {
+	if c == nil || a.Modified.After(c.Time) {
+		return true
+	}
+	if a.Modified.Equal(c.Time) {
+		for _, id := range c.IDs {
+			if id == a.ID {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// advance moves the cursor past the annotation.
+func (c *Cursor) advance(a Annotation) {
+	switch {
+	case a.Modified.After(c.Time):
+		c.Time = a.Modified
+		c.IDs = []string{a.ID}
+	case a.Modified.Equal(c.Time):
+		c.IDs = append(c.IDs, a.ID)
+		sort.Strings(c.IDs)
+	}
+}
+
+// Options configures which annotations are read.
+type Options struct {
+	// Book, if not empty, is the ContentID of the book to read annotations
+	// for.
+	Book string
+
+	// Since, if not nil, restricts annotations to ones which are new or were
+	// modified since the cursor.
+	Since *Cursor
+
+	// Colors includes highlight colors. It should only be set for devices
+	// with color displays, since others store a default color.
+	Colors bool
+}
+
+// Read reads the annotations from the database, grouped by book (sorted by
+// title), and sorted by position in the book. Hidden annotations are not
+// included. The returned cursor is Since advanced past the annotations
+// returned.
+func Read(ctx context.Context, d *db.DB, opts *Options) ([]Book, Cursor, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	var cur Cursor
+	if opts.Since != nil {
+		cur.Time = opts.Since.Time
+		cur.IDs = append([]string(nil), opts.Since.IDs...)
+	}
+
+	bms, err := d.Bookmarks(ctx, opts.Book)
+	if err != nil {
+		return nil, cur, err
+	}
+
+	var books []Book
+	idx := map[string]int{}
+	chapters := map[string][]db.Chapter{}
+	for _, bm := range bms {
+		if bm.Hidden {
+			continue
+		}
+
+		i, ok := idx[bm.VolumeID]
+		if !ok {
+			b := Book{
+				ContentID: bm.VolumeID,
+				Title:     titleFromContentID(bm.VolumeID),
+			}
+			if x, err := d.Book(ctx, bm.VolumeID); err == nil {
+				b.Title = x.Title
+				b.Author = x.Attribution
+			} else if !errors.Is(err, db.ErrNotFound) {
+				return nil, cur, err
+			}
+			cs, err := d.Chapters(ctx, bm.VolumeID)
+			if err != nil {
+				return nil, cur, err
+			}
+			chapters[bm.VolumeID] = cs
+			i = len(books)
+			idx[bm.VolumeID] = i
+			books = append(books, b)
+		}
+
+		a := Annotation{
+			ID:              bm.ID,
+			Type:            bm.Type,
+			Text:            strings.TrimSpace(bm.Text),
+			Note:            strings.TrimSpace(bm.Annotation),
+			ChapterProgress: bm.ChapterProgress,
+			Created:         bm.Created,
+			Modified:        bm.Modified,
+		}
+		if a.Modified.IsZero() || a.Modified.Before(a.Created) {
+			a.Modified = a.Created
+		}
+		if opts.Colors && bm.Color != db.BookmarkColorNone {
+			a.Color = bm.Color.String()
+		}
+		if c, total, ok := findChapter(chapters[bm.VolumeID], bm); ok {
+			a.Chapter = strings.TrimSpace(c.Title)
+			if total > 0 {
+				a.Progress = (float64(c.FileOffset) + bm.ChapterProgress*float64(c.FileSize)) / float64(total) * 100
+				a.Progress = float64(int(a.Progress*100+0.5)) / 100
+				a.Progress = min(max(a.Progress, 0), 100)
+			}
+		}
+
+		if !opts.Since.includes(a) {
+			continue
+		}
+		books[i].Annotations = append(books[i].Annotations, a)
+	}
+
+	res := books[:0]
+	for _, b := range books {
+		if len(b.Annotations) == 0 {
+			continue
+		}
+		sort.SliceStable(b.Annotations, func(i, j int) bool {
+			x, y := b.Annotations[i], b.Annotations[j]
+			if x.Progress != y.Progress {
+				return x.Progress < y.Progress
+			}
+			return x.Created.Before(y.Created)
+		})
+		for _, a := range b.Annotations {
+			cur.advance(a)
+		}
+		res = append(res, b)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return strings.ToLower(res[i].Title) < strings.ToLower(res[j].Title)
+	})
+	return res, cur, nil
+}
+
+// findChapter finds the chapter containing a bookmark, and returns it along
+// with the total size of the book in the same units as the chapter's offset.
+// The bookmark's ContentID is usually the path of the chapter's file in the
+// book, but is sometimes the chapter's ContentID.
+func findChapter(cs []db.Chapter, bm db.Bookmark) (db.Chapter, int64, bool) {
+	var total int64
+	for _, c := range cs {
+		total = max(total, c.FileOffset+c.FileSize)
+	}
+	for _, c := range cs {
+		if c.ContentID == bm.ContentID {
+			return c, total, true
+		}
+	}
+	p := chapterPath(bm.ContentID, bm.VolumeID)
+	for _, c := range cs {
+		if chapterPath(c.ContentID, c.BookID) == p {
+			return c, total, true
+		}
+	}
+	return db.Chapter{}, 0, false
+}
+
+var chapterSuffixRe = regexp.MustCompile(`-[0-9]+$`)
+
+// chapterPath gets the path of a chapter's file in the book from a chapter or
+// bookmark ContentID. Kepub chapters are like BOOK!DIR!FILE-N, and epub
+// chapters are like BOOK#(N)DIR/FILE.
+func chapterPath(contentID, bookID string) string {
+	s := strings.TrimPrefix(contentID, bookID)
+	if strings.HasPrefix(s, "#(") {
+		if _, x, ok := strings.Cut(s, ")"); ok {
+			s = x
+		}
+	}
+	s = strings.ReplaceAll(s, "!", "/")
+	s, _, _ = strings.Cut(s, "#")
+	s = chapterSuffixRe.ReplaceAllString(s, "")
+	return strings.TrimLeft(s, "/")
+}
+
+// titleFromContentID makes a title for a book which isn't in the database.
+func titleFromContentID(contentID string) string {
+	if !strings.HasPrefix(contentID, "file://") {
+		return contentID
+	}
+	name := path.Base(contentID)
+	for _, ext := range []string{".kepub.epub", ".epub", ".pdf"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
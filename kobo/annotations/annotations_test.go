@@ -0,0 +1,264 @@
+package annotations
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/db/dbtest"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestRead(t *testing.T) {
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent,
+		`INSERT INTO Bookmark (BookmarkID, VolumeID, ContentID, StartContainerPath, StartContainerChildIndex, StartOffset, EndContainerPath, EndContainerChildIndex, EndOffset, Text, DateCreated, ChapterProgress, Hidden, Type) VALUES ('bm-hidden', 'file:///mnt/onboard/Books/Emma.epub', 'OEBPS/ch1.xhtml', '', 0, 0, '', 0, 0, 'hidden', '2023-12-22T10:00:00.000', 0.5, 'true', 'highlight')`,
+		`INSERT INTO Bookmark (BookmarkID, VolumeID, ContentID, StartContainerPath, StartContainerChildIndex, StartOffset, EndContainerPath, EndContainerChildIndex, EndOffset, Text, DateCreated, ChapterProgress, Hidden, Type) VALUES ('bm-deleted', 'file:///mnt/onboard/Books/Deleted Book.kepub.epub', 'OEBPS/ch1.xhtml', '', 0, 0, '', 0, 0, 'orphan', '2023-11-01T10:00:00.000', 0.5, 'false', 'highlight')`,
+	))
+
+	books, cur, err := Read(context.Background(), d, &Options{Colors: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp := []Book{
+		{
+			ContentID: "file:///mnt/onboard/Books/Deleted Book.kepub.epub",
+			Title:     "Deleted Book",
+			Annotations: []Annotation{
+				{ID: "bm-deleted", Type: db.BookmarkTypeHighlight, Text: "orphan", ChapterProgress: 0.5, Created: date("2023-11-01T10:00:00Z"), Modified: date("2023-11-01T10:00:00Z")},
+			},
+		},
+		{
+			ContentID: "file:///mnt/onboard/Books/Dune.kepub.epub",
+			Title:     "Dune",
+			Author:    "Frank Herbert",
+			Annotations: []Annotation{
+				{ID: "bm-1", Type: db.BookmarkTypeHighlight, Text: "Fear is the mind-killer.", Chapter: "Chapter 1", Color: "blue", Progress: 12.5, ChapterProgress: 0.25, Created: date("2024-02-01T10:00:00Z"), Modified: date("2024-02-01T10:00:00Z")},
+				{ID: "bm-2", Type: db.BookmarkTypeNote, Text: "spice", Note: "Melange", Chapter: "Chapter 2", Color: "yellow", Progress: 75, ChapterProgress: 0.5, Created: date("2024-02-02T10:00:00Z"), Modified: date("2024-02-02T11:00:00Z")},
+			},
+		},
+		{
+			ContentID: "file:///mnt/onboard/Books/Emma.epub",
+			Title:     "Emma",
+			Author:    "Jane Austen",
+			Annotations: []Annotation{
+				{ID: "bm-3", Type: db.BookmarkTypeDogEar, Chapter: "Volume I", Progress: 10, ChapterProgress: 0.1, Created: date("2023-12-21T10:00:00Z"), Modified: date("2023-12-21T10:00:00Z")},
+			},
+		},
+	}
+	if !reflect.DeepEqual(books, exp) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", exp, books)
+	}
+	if exp := (Cursor{Time: date("2024-02-02T11:00:00Z"), IDs: []string{"bm-2"}}); !reflect.DeepEqual(cur, exp) {
+		t.Errorf("expected cursor %+v, got %+v", exp, cur)
+	}
+
+	if books, _, err := Read(context.Background(), d, &Options{Book: "file:///mnt/onboard/Books/Emma.epub"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if len(books) != 1 || len(books[0].Annotations) != 1 || books[0].Annotations[0].Color != "" {
+		t.Errorf("unexpected books %+v", books)
+	}
+}
+
+func TestReadIncremental(t *testing.T) {
+	fn := dbtest.NewFile(t, dbtest.Recent)
+	read := func(since *Cursor) ([]string, Cursor) {
+		t.Helper()
+		d, err := db.OpenFile(fn)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer d.Close()
+		books, cur, err := Read(context.Background(), d, &Options{Since: since})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var ids []string
+		for _, b := range books {
+			for _, a := range b.Annotations {
+				ids = append(ids, a.ID)
+			}
+		}
+		return ids, cur
+	}
+
+	ids, cur := read(nil)
+	if len(ids) != 3 {
+		t.Errorf("expected all annotations, got %q", ids)
+	}
+
+	if ids, cur2 := read(&cur); len(ids) != 0 || !reflect.DeepEqual(cur, cur2) {
+		t.Errorf("expected nothing new, got %q %+v", ids, cur2)
+	}
+
+	dbtest.Exec(t, fn,
+		`INSERT INTO Bookmark (BookmarkID, VolumeID, ContentID, StartContainerPath, StartContainerChildIndex, StartOffset, EndContainerPath, EndContainerChildIndex, EndOffset, Text, DateCreated, ChapterProgress, Type) VALUES ('bm-4', 'file:///mnt/onboard/Books/Dune.kepub.epub', 'OEBPS/ch2.xhtml', '', 0, 0, '', 0, 0, 'same time', '2024-02-02T11:00:00.000', 0.9, 'highlight')`,
+		`INSERT INTO Bookmark (BookmarkID, VolumeID, ContentID, StartContainerPath, StartContainerChildIndex, StartOffset, EndContainerPath, EndContainerChildIndex, EndOffset, Text, DateCreated, ChapterProgress, Type) VALUES ('bm-5', 'file:///mnt/onboard/Books/Emma.epub', 'OEBPS/ch1.xhtml', '', 0, 0, '', 0, 0, 'later', '2024-03-01T00:00:00.000', 0.9, 'highlight')`,
+		`UPDATE Bookmark SET Annotation = 'edited', DateModified = '2024-03-02T00:00:00.000' WHERE BookmarkID = 'bm-1'`,
+	)
+
+	ids, cur = read(&cur)
+	if exp := []string{"bm-1", "bm-4", "bm-5"}; !reflect.DeepEqual(ids, exp) {
+		t.Errorf("expected new and modified annotations %q, got %q", exp, ids)
+	}
+	if exp := (Cursor{Time: date("2024-03-02T00:00:00Z"), IDs: []string{"bm-1"}}); !reflect.DeepEqual(cur, exp) {
+		t.Errorf("expected cursor %+v, got %+v", exp, cur)
+	}
+}
+
+func TestReadLegacy(t *testing.T) {
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Legacy))
+	books, _, err := Read(context.Background(), d, &Options{Colors: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(books) != 1 || len(books[0].Annotations) != 3 {
+		t.Fatalf("unexpected books %+v", books)
+	}
+	for _, a := range books[0].Annotations {
+		if a.Chapter != "One" || a.Color != "" {
+			t.Errorf("unexpected annotation %+v", a)
+		}
+	}
+}
+
+func TestChapterPath(t *testing.T) {
+	for _, c := range []struct {
+		ContentID, BookID, Out string
+	}{
+		{"file:///mnt/onboard/a.kepub.epub!OEBPS!ch1.xhtml-1", "file:///mnt/onboard/a.kepub.epub", "OEBPS/ch1.xhtml"},
+		{"file:///mnt/onboard/a.kepub.epub!!OEBPS!Text!ch1.xhtml-12", "file:///mnt/onboard/a.kepub.epub", "OEBPS/Text/ch1.xhtml"},
+		{"file:///mnt/onboard/a.epub#(3)OEBPS/ch1.xhtml", "file:///mnt/onboard/a.epub", "OEBPS/ch1.xhtml"},
+		{"file:///mnt/onboard/a.epub#(3)OEBPS/ch1.xhtml#sec2", "file:///mnt/onboard/a.epub", "OEBPS/ch1.xhtml"},
+		{"OEBPS/ch1.xhtml", "file:///mnt/onboard/a.epub", "OEBPS/ch1.xhtml"},
+		{"OEBPS/chapter-2.xhtml", "file:///mnt/onboard/a.epub", "OEBPS/chapter-2.xhtml"},
+	} {
+		if p := chapterPath(c.ContentID, c.BookID); p != c.Out {
+			t.Errorf("%q: expected %q, got %q", c.ContentID, c.Out, p)
+		}
+	}
+}
+
+var testBooks = []Book{
+	{
+		ContentID: "file:///mnt/onboard/Dune.kepub.epub",
+		Title:     "Dune",
+		Author:    "Frank Herbert",
+		Annotations: []Annotation{
+			{ID: "1", Type: db.BookmarkTypeHighlight, Text: "Fear is the mind-killer.\nFear is the little-death.", Chapter: "Chapter 1", Color: "blue", Progress: 12.5, ChapterProgress: 0.25, Created: date("2024-02-01T10:00:00Z"), Modified: date("2024-02-01T10:00:00Z")},
+			{ID: "2", Type: db.BookmarkTypeNote, Text: "spice", Note: "Melange, \"the spice\"", Chapter: "Chapter 1", Progress: 20, ChapterProgress: 0.5, Created: date("2024-02-02T10:00:00Z"), Modified: date("2024-02-02T11:00:00Z")},
+			{ID: "3", Type: db.BookmarkTypeDogEar, Chapter: "Chapter 2", ChapterProgress: 0.1, Created: date("2024-02-03T10:00:00Z"), Modified: date("2024-02-03T10:00:00Z")},
+		},
+	},
+	{
+		ContentID: "file:///mnt/onboard/Emma.epub",
+		Title:     "Emma",
+		Annotations: []Annotation{
+			{ID: "4", Type: db.BookmarkTypeHighlight, Text: "Emma Woodhouse", Created: date("2024-01-01T00:00:00Z"), Modified: date("2024-01-01T00:00:00Z")},
+		},
+	},
+}
+
+func TestMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatMarkdown, testBooks); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := "# Dune\n" +
+		"\n" +
+		"*Frank Herbert*\n" +
+		"\n" +
+		"## Chapter 1\n" +
+		"\n" +
+		"> Fear is the mind-killer.\n" +
+		"> Fear is the little-death.\n" +
+		"\n" +
+		"<sub>highlight · blue · 12.5% · 2024-02-01 10:00</sub>\n" +
+		"\n" +
+		"> spice\n" +
+		"\n" +
+		"Melange, \"the spice\"\n" +
+		"\n" +
+		"<sub>note · 20% · 2024-02-02 10:00</sub>\n" +
+		"\n" +
+		"## Chapter 2\n" +
+		"\n" +
+		"*Bookmark*\n" +
+		"\n" +
+		"<sub>dogear · 2024-02-03 10:00</sub>\n" +
+		"\n" +
+		"# Emma\n" +
+		"\n" +
+		"> Emma Woodhouse\n" +
+		"\n" +
+		"<sub>highlight · 2024-01-01 00:00</sub>\n"
+	if buf.String() != exp {
+		t.Errorf("expected:\n%s\ngot:\n%s", exp, buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatJSON, testBooks); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var books []Book
+	if err := json.Unmarshal(buf.Bytes(), &books); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(books, testBooks) {
+		t.Errorf("json round-trip failed:\n%s", buf.Bytes())
+	}
+
+	buf.Reset()
+	if err := Write(&buf, FormatJSON, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if buf.String() != "[]\n" {
+		t.Errorf("expected empty array, got %q", buf.String())
+	}
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatCSV, testBooks); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 5 || !reflect.DeepEqual(rows[0], CSVHeader) {
+		t.Fatalf("unexpected rows %q", rows)
+	}
+	if exp := []string{"file:///mnt/onboard/Dune.kepub.epub", "Dune", "Frank Herbert", "2", "note", "Chapter 1", "spice", "Melange, \"the spice\"", "", "20", "0.5", "2024-02-02T10:00:00Z", "2024-02-02T11:00:00Z"}; !reflect.DeepEqual(rows[2], exp) {
+		t.Errorf("expected row %q, got %q", exp, rows[2])
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for in, exp := range map[string]Format{
+		"markdown": FormatMarkdown,
+		"md":       FormatMarkdown,
+		"JSON":     FormatJSON,
+		"csv":      FormatCSV,
+	} {
+		if f, err := ParseFormat(in); err != nil || f != exp {
+			t.Errorf("%q: expected %q, got %q %v", in, exp, f, err)
+		}
+	}
+	if _, err := ParseFormat("html"); err == nil {
+		t.Errorf("expected error")
+	}
+}
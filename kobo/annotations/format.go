@@ -0,0 +1,175 @@
+package annotations
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+)
+
+// Format is an output format for annotations.
+type Format string
+
+// Formats.
+const (
+	FormatMarkdown Format = "markdown"
+	FormatJSON     Format = "json"
+	FormatCSV      Format = "csv"
+)
+
+// ParseFormat parses a format name.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatMarkdown, FormatJSON, FormatCSV:
+		return f, nil
+	case "md":
+		return FormatMarkdown, nil
+	}
+	return "", fmt.Errorf("unknown annotations format %q", s)
+}
+
+// Ext returns the file extension for the format.
+func (f Format) Ext() string {
+	switch f {
+	case FormatMarkdown:
+		return ".md"
+	case FormatJSON:
+		return ".json"
+	case FormatCSV:
+		return ".csv"
+	}
+	return ""
+}
+
+// Write writes books in the specified format.
+func Write(w io.Writer, f Format, books []Book) error {
+	switch f {
+	case FormatMarkdown:
+		return WriteMarkdown(w, books)
+	case FormatJSON:
+		return WriteJSON(w, books)
+	case FormatCSV:
+		return WriteCSV(w, books)
+	}
+	return fmt.Errorf("unknown annotations format %q", f)
+}
+
+// WriteJSON writes books as a JSON array.
+func WriteJSON(w io.Writer, books []Book) error {
+	if books == nil {
+		books = []Book{}
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "    ")
+	return e.Encode(books)
+}
+
+// CSVHeader is the header row written by WriteCSV.
+var CSVHeader = []string{
+	"book_id", "book_title", "book_author",
+	"id", "type", "chapter", "text", "note", "color",
+	"progress", "chapter_progress", "created", "modified",
+}
+
+// WriteCSV writes books as CSV with a row for each annotation.
+func WriteCSV(w io.Writer, books []Book) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+	for _, b := range books {
+		for _, a := range b.Annotations {
+			if err := cw.Write([]string{
+				b.ContentID, b.Title, b.Author,
+				a.ID, string(a.Type), a.Chapter, a.Text, a.Note, a.Color,
+				strconv.FormatFloat(a.Progress, 'f', -1, 64),
+				strconv.FormatFloat(a.ChapterProgress, 'f', -1, 64),
+				a.Created.Format(time.RFC3339),
+				a.Modified.Format(time.RFC3339),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteMarkdown writes books as Markdown, with a top-level heading for each
+// book and a second-level one for each chapter.
+func WriteMarkdown(w io.Writer, books []Book) error {
+	bw := bufio.NewWriter(w)
+	for i, b := range books {
+		if i != 0 {
+			bw.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "# %s\n", oneLine(b.Title))
+		if b.Author != "" {
+			fmt.Fprintf(bw, "\n*%s*\n", oneLine(b.Author))
+		}
+
+		var chapter string
+		for _, a := range b.Annotations {
+			if a.Chapter != "" && a.Chapter != chapter {
+				fmt.Fprintf(bw, "\n## %s\n", oneLine(a.Chapter))
+				chapter = a.Chapter
+			}
+			bw.WriteString("\n")
+			switch {
+			case a.Text != "":
+				bw.WriteString(quote(a.Text))
+			case a.Type == db.BookmarkTypeDogEar:
+				bw.WriteString("*Bookmark*\n")
+			case a.Type == db.BookmarkTypeMarkup:
+				bw.WriteString("*Handwritten annotation*\n")
+			}
+			if a.Note != "" {
+				if a.Text != "" {
+					bw.WriteString("\n")
+				}
+				bw.WriteString(a.Note)
+				bw.WriteString("\n")
+			}
+			bw.WriteString("\n")
+			bw.WriteString(meta(a))
+		}
+	}
+	return bw.Flush()
+}
+
+// meta formats the metadata line for an annotation.
+func meta(a Annotation) string {
+	var s []string
+	s = append(s, string(a.Type))
+	if a.Color != "" {
+		s = append(s, a.Color)
+	}
+	if a.Progress != 0 {
+		s = append(s, strconv.FormatFloat(a.Progress, 'f', -1, 64)+"%")
+	}
+	if !a.Created.IsZero() {
+		s = append(s, a.Created.Format("2006-01-02 15:04"))
+	}
+	return "<sub>" + strings.Join(s, " · ") + "</sub>\n"
+}
+
+// quote formats text as a Markdown blockquote.
+func quote(s string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		b.WriteString(strings.TrimRight("> "+strings.TrimSpace(line), " "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// oneLine collapses whitespace in s.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -1,14 +1,70 @@
 package kobo
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"path"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// DefaultAPIBaseURL is the base URL of the Kobo API used by nickel.
+const DefaultAPIBaseURL = "https://api.kobobooks.com"
+
+// DefaultStoreAPIBaseURL is the base URL of the Kobo store API used by nickel
+// (the api_endpoint in the OneStoreServices section of the config).
+const DefaultStoreAPIBaseURL = "https://storeapi.kobo.com"
+
+// APIClient queries the Kobo API.
+type APIClient struct {
+	// BaseURL is the base URL of the API. If empty, DefaultAPIBaseURL is used.
+	BaseURL string
+
+	// StoreBaseURL is the base URL of the store API, which provides the
+	// initialization resources. If empty, DefaultStoreAPIBaseURL is used.
+	StoreBaseURL string
+
+	// HTTPClient is used to make requests. If nil, a client with a 10 second
+	// timeout is used.
+	HTTPClient *http.Client
+
+	// Resources, if not nil, provides the endpoints discovered with
+	// Initialization, which take precedence over BaseURL.
+	Resources *Resources
+}
+
+// DefaultAPIClient is the APIClient used by CheckUpgrade.
+var DefaultAPIClient = &APIClient{}
+
+var defaultHTTPClient = &http.Client{Timeout: time.Second * 10}
+
+func (c *APIClient) baseURL() string {
+	if c.BaseURL == "" {
+		return DefaultAPIBaseURL
+	}
+	return strings.TrimSuffix(c.BaseURL, "/")
+}
+
+func (c *APIClient) storeBaseURL() string {
+	if c.StoreBaseURL == "" {
+		return DefaultStoreAPIBaseURL
+	}
+	return strings.TrimSuffix(c.StoreBaseURL, "/")
+}
+
+func (c *APIClient) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return defaultHTTPClient
+	}
+	return c.HTTPClient
+}
+
 // UpgradeCheckResult represents an update check result from the Kobo API.
 type UpgradeCheckResult struct {
 	Data           interface{}
@@ -19,9 +75,14 @@ type UpgradeCheckResult struct {
 
 var verRe = regexp.MustCompile(`[0-9]+\.[0-9]+(\.[0-9]+)?`)
 
-// ParseVersion tries to extract the version from the UpgradeURL. It returns 0.0.0 if none is present.
+// ParseVersion tries to extract the version from the filename in the
+// UpgradeURL. It returns 0.0.0 if none is present.
 func (u UpgradeCheckResult) ParseVersion() string {
-	m := verRe.FindString(u.UpgradeURL)
+	p := u.UpgradeURL
+	if x, err := url.Parse(p); err == nil {
+		p = x.Path
+	}
+	m := verRe.FindString(path.Base(p))
 	if !u.UpgradeType.IsUpdate() || m == "" {
 		return "0.0.0"
 	}
@@ -56,9 +117,26 @@ func (u UpgradeType) IsUpdate() bool {
 	return u != UpgradeTypeNone
 }
 
-// CheckUpgrade queries the Kobo API for an update.
+// CheckUpgrade queries the Kobo API for an update using DefaultAPIClient.
 func CheckUpgrade(device, affiliate, curVersion, serial string) (*UpgradeCheckResult, error) {
-	resp, err := (&http.Client{Timeout: time.Second * 10}).Get(fmt.Sprintf("https://api.kobobooks.com/1.0/UpgradeCheck/Device/%s/%s/%s/%s", device, affiliate, curVersion, serial))
+	return DefaultAPIClient.CheckUpgrade(context.Background(), device, affiliate, curVersion, serial)
+}
+
+// CheckUpgrade queries the Kobo API for an update.
+func (c *APIClient) CheckUpgrade(ctx context.Context, device, affiliate, curVersion, serial string) (*UpgradeCheckResult, error) {
+	u := fmt.Sprintf("%s/1.0/UpgradeCheck/Device/%s/%s/%s/%s", c.baseURL(), url.PathEscape(device), url.PathEscape(affiliate), url.PathEscape(curVersion), url.PathEscape(serial))
+	if c.Resources != nil {
+		if x, ok := c.Resources.UpgradeCheckURL(device, affiliate, curVersion, serial); ok {
+			u = x
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient().Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -73,3 +151,24 @@ func CheckUpgrade(device, affiliate, curVersion, serial string) (*UpgradeCheckRe
 
 	return &res, err
 }
+
+// ReleaseNotes fetches the release notes HTML from the ReleaseNoteURL of an
+// upgrade check result.
+func (c *APIClient) ReleaseNotes(ctx context.Context, releaseNoteURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, releaseNoteURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("response status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,199 @@
+package kobo_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/kobotest"
+)
+
+func TestCheckUpgrade(t *testing.T) {
+	s := kobotest.NewServer()
+	defer s.Close()
+
+	s.AddUpgrade(kobotest.Upgrade{
+		Device:    kobo.DeviceLibra2,
+		Affiliate: "Kobo",
+		Version:   "4.30.18838",
+		Result: kobo.UpgradeCheckResult{
+			UpgradeType: kobo.UpgradeTypeRequired,
+			UpgradeURL:  "/firmwares/kobo9/Apr2023/kobo-update-4.36.21095.zip",
+		},
+	})
+
+	res, err := s.APIClient().CheckUpgrade(context.Background(), kobo.DeviceLibra2.IDString(), "Kobo", "4.30.18838", "N418000000000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.UpgradeType != kobo.UpgradeTypeRequired {
+		t.Errorf("expected required upgrade, got %s", res.UpgradeType)
+	}
+	if v := res.ParseVersion(); v != "4.36.21095" {
+		t.Errorf("expected version 4.36.21095, got %s", v)
+	}
+
+	res, err = s.APIClient().CheckUpgrade(context.Background(), kobo.DeviceLibra2.IDString(), "Kobo", "4.36.21095", "N418000000000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.UpgradeType.IsUpdate() || res.UpgradeURL != "" || res.ParseVersion() != "0.0.0" {
+		t.Errorf("expected no update, got %#v", res)
+	}
+
+	if c := s.UpgradeChecks(); len(c) != 2 || c[0].Serial != "N418000000000" || c[0].Device != kobo.DeviceLibra2.IDString() {
+		t.Errorf("unexpected upgrade checks %#v", c)
+	}
+}
+
+func TestUpgradeCheckResultParseVersion(t *testing.T) {
+	for _, c := range []struct {
+		URL     string
+		Version string
+	}{
+		{"https://ereaderfiles.kobo.com/firmwares/kobo9/Apr2023/kobo-update-4.36.21095.zip", "4.36.21095"},
+		{"http://127.0.0.1:8080/firmwares/kobo9/Apr2023/kobo-update-4.36.21095.zip", "4.36.21095"},
+		{"http://10.0.0.1/v1.2/kobo-update-4.36.21095.zip?v=5.6.7", "4.36.21095"},
+		{"http://127.0.0.1:8080/firmwares/kobo9/Apr2023/kobo-update.zip", "0.0.0"},
+		{"", "0.0.0"},
+	} {
+		if v := (kobo.UpgradeCheckResult{UpgradeType: kobo.UpgradeTypeRequired, UpgradeURL: c.URL}).ParseVersion(); v != c.Version {
+			t.Errorf("%q: expected version %s, got %s", c.URL, c.Version, v)
+		}
+	}
+}
+
+func TestInitialization(t *testing.T) {
+	s := kobotest.NewServer()
+	defer s.Close()
+
+	s.AddUpgrade(kobotest.Upgrade{
+		Result: kobo.UpgradeCheckResult{
+			UpgradeType: kobo.UpgradeTypeAvailable,
+			UpgradeURL:  "/firmwares/kobo9/Apr2023/kobo-update-4.36.21095.zip",
+		},
+	})
+
+	c := s.APIClient()
+	res, err := c.Initialization(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := s.URL + "/1.0/UpgradeCheck/Device/{0}/{1}/{2}/{3}"; res.UpgradeCheck != exp {
+		t.Errorf("expected upgrade_check %q, got %q", exp, res.UpgradeCheck)
+	}
+	if exp := s.URL + "/v1/library/sync"; res.LibrarySync != exp {
+		t.Errorf("expected library_sync %q, got %q", exp, res.LibrarySync)
+	}
+	if v, ok := res.Bool("kobo_subscriptions_enabled"); !ok || v {
+		t.Errorf("expected kobo_subscriptions_enabled to be false")
+	}
+	if v, ok := res.String("use_one_store"); !ok || v != "True" {
+		t.Errorf("expected use_one_store to be True, got %q", v)
+	}
+
+	// simulate a firmware with a different endpoint
+	s.SetResource("upgrade_check", "/2.0/UpgradeCheck/{1}/{0}/{2}/{3}")
+	s.SetResource("use_one_store", nil)
+	s.SetResource("free_books_page", map[string]string{"EN": "https://www.kobo.com/free"})
+
+	res2, err := c.Initialization(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	diff, err := kobo.DiffResources(res, res2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ds []string
+	for _, d := range diff {
+		ds = append(ds, d.String())
+	}
+	if exp := []string{
+		`+ free_books_page: {"EN":"https://www.kobo.com/free"}`,
+		`~ upgrade_check: "` + s.URL + `/1.0/UpgradeCheck/Device/{0}/{1}/{2}/{3}" -> "` + s.URL + `/2.0/UpgradeCheck/{1}/{0}/{2}/{3}"`,
+		`- use_one_store: "True"`,
+	}; strings.Join(ds, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("unexpected diff:\n%s\nexpected:\n%s", strings.Join(ds, "\n"), strings.Join(exp, "\n"))
+	}
+
+	c.Resources = res2
+	if r, err := c.CheckUpgrade(context.Background(), kobo.DeviceLibra2.IDString(), "Kobo", "4.30.18838", "N418000000000"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if r.ParseVersion() != "4.36.21095" {
+		t.Errorf("unexpected result %#v", r)
+	}
+	if cs := s.UpgradeChecks(); len(cs) != 1 || cs[0].Device != kobo.DeviceLibra2.IDString() || cs[0].Affiliate != "Kobo" || cs[0].Version != "4.30.18838" {
+		t.Errorf("expected upgrade check at the discovered endpoint, got %#v", cs)
+	}
+}
+
+func TestInitializationStore(t *testing.T) {
+	api, store := kobotest.NewServer(), kobotest.NewServer()
+	defer api.Close()
+	defer store.Close()
+
+	api.AddUpgrade(kobotest.Upgrade{
+		Result: kobo.UpgradeCheckResult{
+			UpgradeType: kobo.UpgradeTypeAvailable,
+			UpgradeURL:  "/firmwares/kobo9/Apr2023/kobo-update-4.36.21095.zip",
+		},
+	})
+	api.SetResource("host", "api")
+	store.SetResource("host", "store")
+	store.SetResource("upgrade_check", api.URL+"/1.0/UpgradeCheck/Device/{0}/{1}/{2}/{3}")
+
+	c := &kobo.APIClient{
+		BaseURL:      api.URL,
+		StoreBaseURL: store.URL,
+		HTTPClient:   api.Client(),
+	}
+	res, err := c.Initialization(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, _ := res.String("host"); v != "store" {
+		t.Errorf("expected resources from the store api, got %q", v)
+	}
+
+	c.Resources = res
+	if r, err := c.CheckUpgrade(context.Background(), kobo.DeviceLibra2.IDString(), "Kobo", "4.30.18838", "N418000000000"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if r.ParseVersion() != "4.36.21095" {
+		t.Errorf("unexpected result %#v", r)
+	}
+	if len(api.UpgradeChecks()) != 1 || len(store.UpgradeChecks()) != 0 {
+		t.Errorf("expected upgrade check on the api, got %d on the api and %d on the store", len(api.UpgradeChecks()), len(store.UpgradeChecks()))
+	}
+}
+
+func TestResourcesUpgradeCheckURL(t *testing.T) {
+	for tmpl, exp := range map[string]string{
+		"": "",
+		"https://example.com/1.0/UpgradeCheck/Device/{0}/{1}/{2}/{3}": "https://example.com/1.0/UpgradeCheck/Device/00000000-0000-0000-0000-000000000387/Kobo%2FTest/4.30.18838/N418",
+		"https://example.com/check/":                                  "https://example.com/check/00000000-0000-0000-0000-000000000387/Kobo%2FTest/4.30.18838/N418",
+	} {
+		u, ok := kobo.Resources{UpgradeCheck: tmpl}.UpgradeCheckURL("00000000-0000-0000-0000-000000000387", "Kobo/Test", "4.30.18838", "N418")
+		if ok != (exp != "") || u != exp {
+			t.Errorf("%q: expected %q, got %q", tmpl, exp, u)
+		}
+	}
+}
+
+func TestResourcesJSON(t *testing.T) {
+	var r kobo.Resources
+	if err := json.Unmarshal([]byte(`{"upgrade_check":"a","library_sync":123,"other":"b"}`), &r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.UpgradeCheck != "a" || r.LibrarySync != "" {
+		t.Errorf("unexpected typed fields %#v", r)
+	}
+	r.UpgradeCheck = "c"
+	if buf, err := json.Marshal(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if string(buf) != `{"library_sync":123,"other":"b","upgrade_check":"c"}` {
+		t.Errorf("unexpected json %s", buf)
+	}
+}
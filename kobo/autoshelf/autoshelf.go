@@ -0,0 +1,343 @@
+// Package autoshelf manages collections generated from the folders, series,
+// and subjects of sideloaded books.
+//
+// Shelves created by this package have IDs starting with IDPrefix, and are the
+// only ones it will modify. Books added to them manually will be removed the
+// next time they are synced.
+package autoshelf
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/epub"
+)
+
+// IDPrefix is the prefix of the IDs of shelves managed by this package.
+const IDPrefix = "koboutils-autoshelf-"
+
+// Source is a source of shelf names for a book.
+type Source string
+
+// Sources.
+const (
+	SourceFolder  Source = "folder"  // the folder containing the book
+	SourceSeries  Source = "series"  // the series from the database or EPUB
+	SourceSubject Source = "subject" // the dc:subject tags (e.g., calibre tags) from the EPUB
+)
+
+// ParseSource parses a source name.
+func ParseSource(s string) (Source, error) {
+	switch x := Source(strings.ToLower(s)); x {
+	case SourceFolder, SourceSeries, SourceSubject:
+		return x, nil
+	case "tag", "tags", "subjects":
+		return SourceSubject, nil
+	}
+	return "", fmt.Errorf("unknown shelf source %q", s)
+}
+
+// Options configures Scan.
+type Options struct {
+	// Sources to generate shelves from.
+	Sources []Source
+
+	// Root is the slash-separated path of the directory to scan relative to
+	// the root of the Kobo. Folder shelves are named by the path of the
+	// folder relative to it, and books directly in it don't get one.
+	Root string
+
+	// Prefix is added to the shelf names (e.g., "Series: ").
+	Prefix string
+
+	// MinBooks is the minimum number of books a shelf must have to be
+	// created.
+	MinBooks int
+}
+
+// Plan is the shelves which should exist.
+type Plan struct {
+	Shelves map[string][]string // shelf name -> sorted ContentIDs
+	Errors  []string            // books which couldn't be fully read
+}
+
+// bookExts are the extensions of files imported by nickel.
+var bookExts = []string{".kepub.epub", ".epub", ".pdf", ".mobi", ".txt", ".html", ".htm", ".rtf", ".cbz", ".cbr"}
+
+// Scan scans the books on the Kobo at kpath to determine the shelves they
+// should be on. Only books which have been imported by nickel are included.
+func Scan(ctx context.Context, kpath string, d *db.DB, opts *Options) (*Plan, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	var folder, series, subject bool
+	for _, src := range opts.Sources {
+		switch src {
+		case SourceFolder:
+			folder = true
+		case SourceSeries:
+			series = true
+		case SourceSubject:
+			subject = true
+		default:
+			return nil, fmt.Errorf("unknown shelf source %q", src)
+		}
+	}
+
+	bs, err := d.Books(ctx)
+	if err != nil {
+		return nil, err
+	}
+	books := map[string]db.Book{}
+	for _, b := range bs {
+		books[b.ContentID] = b
+	}
+
+	p := &Plan{Shelves: map[string][]string{}}
+	root := filepath.Join(kpath, filepath.FromSlash(opts.Root))
+	if err := filepath.WalkDir(root, func(fn string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if fn != root && (strings.HasPrefix(e.Name(), ".") || strings.HasPrefix(e.Name(), "$")) {
+			if e.IsDir() {
+				return fs.SkipDir // e.g., .kobo, .adds, $RECYCLE.BIN
+			}
+			return nil // e.g., ._ files from macOS
+		}
+		if e.IsDir() || !isBook(e.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(kpath, fn)
+		if err != nil {
+			return err
+		}
+		b, ok := books[kobo.PathToContentID(rel)]
+		if !ok {
+			return nil
+		}
+
+		var m *epub.Metadata
+		if isEPUB(e.Name()) && (subject || (series && b.Series == "")) {
+			if m, err = epub.Open(fn); err != nil {
+				p.Errors = append(p.Errors, fmt.Sprintf("%s: %v", filepath.ToSlash(rel), err))
+				m = nil
+			}
+		}
+
+		var names []string
+		if folder {
+			if dir, err := filepath.Rel(root, filepath.Dir(fn)); err == nil && dir != "." {
+				names = append(names, filepath.ToSlash(dir))
+			}
+		}
+		if series {
+			if b.Series != "" {
+				names = append(names, b.Series)
+			} else if m != nil && m.Series != "" {
+				names = append(names, m.Series)
+			}
+		}
+		if subject && m != nil {
+			names = append(names, m.Subjects...)
+		}
+
+		seen := map[string]bool{}
+		for _, name := range names {
+			if name = strings.TrimSpace(opts.Prefix + strings.TrimSpace(name)); name != "" && !seen[name] {
+				seen[name] = true
+				p.Shelves[name] = append(p.Shelves[name], b.ContentID)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("scan books: %w", err)
+	}
+
+	for name, ids := range p.Shelves {
+		if len(ids) < opts.MinBooks {
+			delete(p.Shelves, name)
+			continue
+		}
+		sort.Strings(ids)
+	}
+	return p, nil
+}
+
+// isBook checks if a file is probably a book which nickel will import.
+func isBook(name string) bool {
+	for _, ext := range bookExts {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEPUB checks if a file is an epub or kepub.
+func isEPUB(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".epub")
+}
+
+// Changes are the changes needed to make the shelves match a Plan.
+type Changes struct {
+	Create    []string            // shelves to create
+	Restore   []db.Shelf          // deleted managed shelves to restore
+	Delete    []db.Shelf          // managed shelves which are no longer needed
+	Add       map[string][]string // shelf name -> ContentIDs to add
+	Remove    map[string][]string // shelf name -> ContentIDs to remove
+	Conflicts []string            // shelves not created since a manual shelf (including a deleted one) has the same name
+}
+
+// Empty returns true if there aren't any changes.
+func (c *Changes) Empty() bool {
+	return len(c.Create) == 0 && len(c.Restore) == 0 && len(c.Delete) == 0 && len(c.Add) == 0 && len(c.Remove) == 0
+}
+
+// Diff determines the changes needed to make the managed shelves match the
+// plan. Managed shelves which aren't in the plan are deleted.
+func Diff(ctx context.Context, d *db.DB, p *Plan) (*Changes, error) {
+	if err := d.CheckSchema(map[string][]string{
+		"Shelf":        {"Id", "Name", "_IsDeleted"},
+		"ShelfContent": {"ShelfName", "ContentId", "_IsDeleted"},
+	}); err != nil && !errors.Is(err, db.ErrUnknownVersion) {
+		return nil, err
+	}
+	shelves, err := d.Shelves(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items, err := d.ShelfItems(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	managed := map[string]db.Shelf{}
+	manual := map[string]bool{}
+	for _, s := range shelves {
+		if !strings.HasPrefix(s.ID, IDPrefix) {
+			// deleted shelves count too since ShelfContent is keyed by the
+			// shelf name, so a new shelf would take over its books
+			manual[strings.ToLower(s.Name)] = true
+		} else if x, ok := managed[s.Name]; !ok || (x.Deleted && !s.Deleted) {
+			managed[s.Name] = s
+		}
+	}
+	current := map[string]map[string]bool{}
+	for _, it := range items {
+		if !it.Deleted {
+			if current[it.ShelfName] == nil {
+				current[it.ShelfName] = map[string]bool{}
+			}
+			current[it.ShelfName][it.ContentID] = true
+		}
+	}
+
+	c := &Changes{
+		Add:    map[string][]string{},
+		Remove: map[string][]string{},
+	}
+	for _, name := range sortedNames(p.Shelves) {
+		s, ok := managed[name]
+		switch {
+		case ok && !s.Deleted:
+		case manual[strings.ToLower(name)]:
+			c.Conflicts = append(c.Conflicts, name)
+			continue
+		case ok:
+			c.Restore = append(c.Restore, s)
+		default:
+			c.Create = append(c.Create, name)
+		}
+
+		want := map[string]bool{}
+		for _, id := range p.Shelves[name] {
+			want[id] = true
+			if !current[name][id] || (ok && s.Deleted) {
+				c.Add[name] = append(c.Add[name], id)
+			}
+		}
+		if ok && !s.Deleted {
+			for _, id := range sortedNames(current[name]) {
+				if !want[id] {
+					c.Remove[name] = append(c.Remove[name], id)
+				}
+			}
+		}
+	}
+	for _, name := range sortedNames(managed) {
+		if s := managed[name]; !s.Deleted {
+			if _, ok := p.Shelves[name]; !ok {
+				c.Delete = append(c.Delete, s)
+			}
+		}
+	}
+	return c, nil
+}
+
+// Apply makes the changes.
+func Apply(ctx context.Context, tx *db.Tx, c *Changes, now time.Time) error {
+	for _, name := range c.Create {
+		if err := tx.CreateShelf(ctx, newID(), name, now); err != nil {
+			return err
+		}
+	}
+	for _, s := range c.Restore {
+		if err := tx.SetShelfDeleted(ctx, s.ID, false, now); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedNames(c.Add) {
+		for _, id := range c.Add[name] {
+			if err := tx.SetShelfItem(ctx, name, id, false, now); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range sortedNames(c.Remove) {
+		for _, id := range c.Remove[name] {
+			if err := tx.SetShelfItem(ctx, name, id, true, now); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range c.Delete {
+		if err := tx.SetShelfDeleted(ctx, s.ID, true, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newID generates a new ID for a managed shelf.
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return IDPrefix + hex.EncodeToString(b[:])
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	ns := make([]string, 0, len(m))
+	for n := range m {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
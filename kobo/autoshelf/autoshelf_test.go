@@ -0,0 +1,172 @@
+package autoshelf
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/db/dbtest"
+	"github.com/pgaskin/koboutils/v2/kobo/epub/epubtest"
+)
+
+const (
+	dune       = "file:///mnt/onboard/Books/Dune.kepub.epub"
+	emma       = "file:///mnt/onboard/Books/Emma.epub"
+	persuasion = "file:///mnt/onboard/Books/Classics/Persuasion.epub"
+)
+
+func testDevice(t *testing.T) string {
+	t.Helper()
+	kpath := dbtest.NewDevice(t, dbtest.Recent,
+		`INSERT INTO content (ContentID, ContentType, MimeType, Title, Attribution, ___UserID) VALUES ('`+persuasion+`', '6', 'application/epub+zip', 'Persuasion', 'Jane Austen', 'adobe_user')`,
+	)
+	epubtest.Write(t, filepath.Join(kpath, "Books", "Dune.kepub.epub"), `<dc:title>Dune</dc:title><dc:subject>Sci-Fi</dc:subject>`)
+	epubtest.Write(t, filepath.Join(kpath, "Books", "Emma.epub"), `<dc:title>Emma</dc:title><dc:subject>Classics</dc:subject>`)
+	epubtest.Write(t, filepath.Join(kpath, "Books", "Classics", "Persuasion.epub"), `<dc:title>Persuasion</dc:title><dc:subject>Classics</dc:subject><dc:subject>Romance</dc:subject><meta name="calibre:series" content="Austen Novels"/>`)
+	epubtest.Write(t, filepath.Join(kpath, "Books", "Unimported.epub"), `<dc:title>Unimported</dc:title><dc:subject>Classics</dc:subject>`)
+	epubtest.Write(t, filepath.Join(kpath, "Books", ".hidden", "Emma.epub"), `<dc:title>Hidden</dc:title><dc:subject>Hidden</dc:subject>`)
+	return kpath
+}
+
+// sync scans and diffs the shelves, then applies the changes.
+func sync(t *testing.T, kpath string, opts *Options) *Changes {
+	t.Helper()
+	var c *Changes
+	if _, err := db.Update(context.Background(), kpath, &db.UpdateOptions{BackupDir: t.TempDir()}, func(ctx context.Context, tx *db.Tx) error {
+		p, err := Scan(ctx, kpath, tx.DB, opts)
+		if err != nil {
+			return err
+		}
+		if len(p.Errors) != 0 {
+			t.Errorf("unexpected scan errors: %q", p.Errors)
+		}
+		if c, err = Diff(ctx, tx.DB, p); err != nil {
+			return err
+		}
+		return Apply(ctx, tx, c, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return c
+}
+
+func names(ss []db.Shelf) []string {
+	var ns []string
+	for _, s := range ss {
+		ns = append(ns, s.Name)
+	}
+	return ns
+}
+
+func TestAutoShelf(t *testing.T) {
+	kpath := testDevice(t)
+	fn := filepath.Join(kpath, filepath.FromSlash(db.Path))
+	opts := &Options{
+		Sources: []Source{SourceFolder, SourceSeries, SourceSubject},
+		Root:    "Books",
+	}
+
+	c := sync(t, kpath, opts)
+	if exp := []string{"Austen Novels", "Classics", "Dune", "Romance"}; !reflect.DeepEqual(c.Create, exp) {
+		t.Errorf("expected created shelves %q, got %q", exp, c.Create)
+	}
+	if exp := []string{"Sci-Fi"}; !reflect.DeepEqual(c.Conflicts, exp) {
+		t.Errorf("expected conflicting shelves %q, got %q", exp, c.Conflicts)
+	}
+	if exp := map[string][]string{
+		"Austen Novels": {persuasion},
+		"Classics":      {persuasion, emma},
+		"Dune":          {dune},
+		"Romance":       {persuasion},
+	}; !reflect.DeepEqual(c.Add, exp) {
+		t.Errorf("expected added books %q, got %q", exp, c.Add)
+	}
+
+	if ids := dbtest.Query(t, fn, `SELECT Id FROM Shelf WHERE Name = 'Classics' AND Type = ? AND _IsDeleted = 'false'`, db.ShelfType); len(ids) != 1 || !strings.HasPrefix(ids[0], IDPrefix) {
+		t.Errorf("expected shelf to be tagged, got %q", ids)
+	}
+	if items := dbtest.Query(t, fn, `SELECT ContentId FROM ShelfContent WHERE ShelfName = 'Sci-Fi' ORDER BY ContentId`); !reflect.DeepEqual(items, []string{dune}) {
+		t.Errorf("expected manual shelf to be untouched, got %q", items)
+	}
+
+	if c := sync(t, kpath, opts); !c.Empty() {
+		t.Errorf("expected no changes the second time, got %+v", c)
+	}
+
+	dbtest.Exec(t, fn, `INSERT INTO ShelfContent (ShelfName, ContentId, DateModified, _IsDeleted, _IsSynced) VALUES ('Dune', '`+emma+`', '2024-01-01T00:00:00Z', 'false', 'false')`)
+	opts.MinBooks = 2
+	c = sync(t, kpath, opts)
+	if exp := []string{"Austen Novels", "Dune", "Romance"}; !reflect.DeepEqual(names(c.Delete), exp) {
+		t.Errorf("expected deleted shelves %q, got %q", exp, names(c.Delete))
+	}
+	if len(c.Remove) != 0 || len(c.Add) != 0 || len(c.Create) != 0 {
+		t.Errorf("unexpected changes %+v", c)
+	}
+	if items := dbtest.Query(t, fn, `SELECT ContentId FROM ShelfContent WHERE ShelfName = 'Dune' AND _IsDeleted = 'false'`); len(items) != 0 {
+		t.Errorf("expected books on deleted shelf to be removed, got %q", items)
+	}
+
+	opts.MinBooks = 0
+	c = sync(t, kpath, opts)
+	if exp := []string{"Austen Novels", "Dune", "Romance"}; !reflect.DeepEqual(names(c.Restore), exp) || len(c.Create) != 0 {
+		t.Errorf("expected restored shelves %q, got %+v", exp, c)
+	}
+	if exp := map[string][]string{"Austen Novels": {persuasion}, "Dune": {dune}, "Romance": {persuasion}}; !reflect.DeepEqual(c.Add, exp) {
+		t.Errorf("expected added books %q, got %q", exp, c.Add)
+	}
+	if ids := dbtest.Query(t, fn, `SELECT COUNT(*) FROM Shelf WHERE Name = 'Dune'`); !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Errorf("expected shelf to be restored rather than re-created, got %q", ids)
+	}
+
+	dbtest.Exec(t, fn, `UPDATE ShelfContent SET _IsDeleted = 'false' WHERE ShelfName = 'Dune' AND ContentId = '`+emma+`'`)
+	c = sync(t, kpath, &Options{Sources: []Source{SourceSeries}, Prefix: "Series: "})
+	if exp := []string{"Series: Austen Novels", "Series: Dune"}; !reflect.DeepEqual(c.Create, exp) {
+		t.Errorf("expected created shelves %q, got %q", exp, c.Create)
+	}
+	if exp := []string{"Austen Novels", "Classics", "Dune", "Romance"}; !reflect.DeepEqual(names(c.Delete), exp) {
+		t.Errorf("expected deleted shelves %q, got %q", exp, names(c.Delete))
+	}
+	if exp := []string{"Old", "Sci-Fi"}; !reflect.DeepEqual(dbtest.Query(t, fn, `SELECT Name FROM Shelf WHERE Id NOT LIKE 'koboutils-%' ORDER BY Name`), exp) {
+		t.Errorf("expected manual shelves to be untouched")
+	}
+}
+
+func TestDeletedManualShelf(t *testing.T) {
+	kpath := testDevice(t)
+	fn := filepath.Join(kpath, filepath.FromSlash(db.Path))
+	epubtest.Write(t, filepath.Join(kpath, "Books", "Emma.epub"), `<dc:title>Emma</dc:title><dc:subject>Old</dc:subject>`)
+
+	c := sync(t, kpath, &Options{Sources: []Source{SourceSubject}})
+	if exp := []string{"Old", "Sci-Fi"}; !reflect.DeepEqual(c.Conflicts, exp) {
+		t.Errorf("expected conflicting shelves %q, got %q", exp, c.Conflicts)
+	}
+	if _, ok := c.Add["Old"]; ok {
+		t.Errorf("expected books not to be added to the deleted manual shelf, got %q", c.Add)
+	}
+	if ids := dbtest.Query(t, fn, `SELECT Id FROM Shelf WHERE Name = 'Old'`); !reflect.DeepEqual(ids, []string{"shelf-2"}) {
+		t.Errorf("expected no managed shelf to be created, got %q", ids)
+	}
+	if items := dbtest.Query(t, fn, `SELECT ContentId FROM ShelfContent WHERE ShelfName = 'Old' AND _IsDeleted = 'false'`); len(items) != 0 {
+		t.Errorf("expected deleted manual shelf items to be untouched, got %q", items)
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	for in, exp := range map[string]Source{
+		"folder":  SourceFolder,
+		"Series":  SourceSeries,
+		"subject": SourceSubject,
+		"tags":    SourceSubject,
+	} {
+		if s, err := ParseSource(in); err != nil || s != exp {
+			t.Errorf("%q: expected %q, got %q %v", in, exp, s, err)
+		}
+	}
+	if _, err := ParseSource("author"); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,273 @@
+// Package check finds inconsistencies between the library database, the books
+// on the internal storage, and the cover cache.
+//
+// Books on the SD card and the cover cache for it are not checked.
+package check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+)
+
+// CoverDir is the cover cache for the internal storage, relative to the root
+// of a Kobo.
+const CoverDir = ".kobo-images"
+
+// Kind is a class of inconsistency.
+type Kind string
+
+// Kinds.
+const (
+	KindMissingFile     Kind = "missing_file"     // a sideloaded book whose file doesn't exist
+	KindOrphanedContent Kind = "orphaned_content" // a row (e.g., a chapter) for a book which doesn't exist
+	KindDuplicate       Kind = "duplicate"        // the same book at another path
+	KindOrphanedCover   Kind = "orphaned_cover"   // a cached cover for a book which doesn't exist
+)
+
+// Kinds are all kinds of inconsistencies, in the order they are reported.
+var Kinds = []Kind{KindMissingFile, KindOrphanedContent, KindDuplicate, KindOrphanedCover}
+
+// Problem is an inconsistency.
+type Problem struct {
+	Kind      Kind
+	ContentID string   // the content row, if any
+	Path      string   // the slash-separated file relative to the root of the Kobo, if any
+	Keep      string   // for KindDuplicate, the ContentID of the copy which is kept
+	Covers    []string // cached covers which will be removed along with the book
+}
+
+func (p Problem) String() string {
+	switch p.Kind {
+	case KindMissingFile:
+		return fmt.Sprintf("%s: file %q doesn't exist", p.Kind, p.Path)
+	case KindOrphanedContent:
+		return fmt.Sprintf("%s: %q is for a book which doesn't exist", p.Kind, p.ContentID)
+	case KindDuplicate:
+		if keep, ok := kobo.ContentIDToPath(p.Keep); ok {
+			return fmt.Sprintf("%s: %q is the same book as %q", p.Kind, p.Path, keep)
+		}
+		return fmt.Sprintf("%s: %q is the same book as %q", p.Kind, p.Path, p.Keep)
+	case KindOrphanedCover:
+		return fmt.Sprintf("%s: %q is for a book which doesn't exist", p.Kind, p.Path)
+	}
+	return fmt.Sprintf("%s: %s", p.Kind, p.ContentID)
+}
+
+// Check checks the Kobo at kpath, using the database d.
+//
+// Sideloaded books are duplicates if they have the same title, author,
+// file size, and format. The most recently read copy is kept.
+func Check(ctx context.Context, kpath string, d *db.DB) ([]Problem, error) {
+	bs, err := d.Books(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ps []Problem
+	var kept []db.Book
+	dupes := map[string][]db.Book{}
+	for _, b := range bs {
+		p, ok := kobo.ContentIDToPath(b.ContentID)
+		if !ok {
+			kept = append(kept, b)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(kpath, filepath.FromSlash(p))); errors.Is(err, fs.ErrNotExist) {
+			ps = append(ps, Problem{
+				Kind:      KindMissingFile,
+				ContentID: b.ContentID,
+				Path:      p,
+				Covers:    coverPaths(b),
+			})
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("check %q: %w", p, err)
+		}
+		if k := dupeKey(b); k != "" {
+			dupes[k] = append(dupes[k], b)
+		} else {
+			kept = append(kept, b)
+		}
+	}
+
+	ids, err := d.OrphanedContent(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		ps = append(ps, Problem{
+			Kind:      KindOrphanedContent,
+			ContentID: id,
+		})
+	}
+
+	for _, k := range sortedKeys(dupes) {
+		g := dupes[k]
+		if len(g) < 2 {
+			kept = append(kept, g...)
+			continue
+		}
+		sort.SliceStable(g, func(i, j int) bool {
+			if !g[i].DateLastRead.Equal(g[j].DateLastRead) {
+				return g[i].DateLastRead.After(g[j].DateLastRead)
+			}
+			if g[i].PercentRead != g[j].PercentRead {
+				return g[i].PercentRead > g[j].PercentRead
+			}
+			return g[i].ContentID < g[j].ContentID
+		})
+		kept = append(kept, g[0])
+		for _, b := range g[1:] {
+			p, _ := kobo.ContentIDToPath(b.ContentID)
+			ps = append(ps, Problem{
+				Kind:      KindDuplicate,
+				ContentID: b.ContentID,
+				Path:      p,
+				Keep:      g[0].ContentID,
+				Covers:    coverPaths(b),
+			})
+		}
+	}
+
+	// the cover for a removed book may be shared with one which is kept
+	// (e.g., store books with the same ImageId)
+	covers := map[string]bool{}
+	for _, b := range kept {
+		for _, c := range coverPaths(b) {
+			covers[c] = true
+		}
+	}
+	removed := map[string]bool{}
+	for i, p := range ps {
+		var cs []string
+		for _, c := range existing(kpath, p.Covers) {
+			if !covers[c] {
+				cs = append(cs, c)
+				removed[c] = true
+			}
+		}
+		ps[i].Covers = cs
+	}
+
+	if err := filepath.WalkDir(filepath.Join(kpath, CoverDir), func(fn string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !e.Type().IsRegular() || !strings.HasSuffix(e.Name(), ".parsed") {
+			return nil
+		}
+		rel, err := filepath.Rel(kpath, fn)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); !covers[rel] && !removed[rel] {
+			ps = append(ps, Problem{
+				Kind: KindOrphanedCover,
+				Path: rel,
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("check covers: %w", err)
+	}
+
+	return ps, nil
+}
+
+// Fix removes the database rows for the problems. The files must be removed
+// with RemoveFiles after the changes have been committed.
+func Fix(ctx context.Context, tx *db.Tx, ps []Problem, now time.Time) error {
+	if err := tx.CheckSchema(map[string][]string{
+		"content": {"ContentID", "BookID"},
+	}); err != nil && !errors.Is(err, db.ErrUnknownVersion) {
+		return err
+	}
+	for _, p := range ps {
+		switch p.Kind {
+		case KindMissingFile, KindOrphanedContent, KindDuplicate:
+			if err := tx.DeleteContent(ctx, p.ContentID, now); err != nil && !errors.Is(err, db.ErrNotFound) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveFiles removes the duplicate books and the orphaned covers, along with
+// the covers for removed books.
+func RemoveFiles(kpath string, ps []Problem) error {
+	var errs []error
+	remove := func(p string) {
+		if err := os.Remove(filepath.Join(kpath, filepath.FromSlash(p))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	for _, p := range ps {
+		switch p.Kind {
+		case KindDuplicate, KindOrphanedCover:
+			remove(p.Path)
+		}
+		for _, c := range p.Covers {
+			remove(c)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// coverPaths gets the paths of the cached covers for a book.
+func coverPaths(b db.Book) []string {
+	iid := b.ImageID
+	if iid == "" {
+		iid = kobo.ContentIDToImageID(b.ContentID)
+	}
+	var cs []string
+	for _, t := range kobo.CoverTypes() {
+		cs = append(cs, t.GeneratePath(false, iid))
+	}
+	return cs
+}
+
+// existing filters paths to the ones which exist.
+func existing(kpath string, ps []string) []string {
+	var res []string
+	for _, p := range ps {
+		if _, err := os.Stat(filepath.Join(kpath, filepath.FromSlash(p))); err == nil {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// dupeKey gets the key used to find duplicates of a sideloaded book, or an
+// empty string if there isn't enough information to tell.
+func dupeKey(b db.Book) string {
+	title := strings.Join(strings.Fields(strings.ToLower(b.Title)), " ")
+	if title == "" || b.FileSize <= 0 {
+		return ""
+	}
+	p, _ := kobo.ContentIDToPath(b.ContentID)
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%s", title, strings.ToLower(b.Attribution), b.FileSize, strings.ToLower(path.Ext(p)))
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
@@ -0,0 +1,114 @@
+package check
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/db/dbtest"
+)
+
+const (
+	dune = "file:///mnt/onboard/Books/Dune.kepub.epub"
+	dupe = "file:///mnt/onboard/Copy/Dune.kepub.epub"
+	emma = "file:///mnt/onboard/Books/Emma.epub"
+)
+
+func write(t *testing.T, kpath string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		fn := filepath.Join(kpath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte("test"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exists(kpath, name string) bool {
+	_, err := os.Stat(filepath.Join(kpath, filepath.FromSlash(name)))
+	return err == nil
+}
+
+func TestCheck(t *testing.T) {
+	kpath := dbtest.NewDevice(t, dbtest.Recent,
+		`INSERT INTO content (ContentID, ContentType, MimeType, Title, Attribution, ___FileSize, ReadStatus, ___UserID) VALUES ('`+dupe+`', 6, 'application/x-kobo-epub+zip', 'DUNE', 'Frank Herbert', 1234567, 0, 'adobe_user')`,
+		`INSERT INTO content (ContentID, ContentType, MimeType, BookID, Title, ___UserID) VALUES ('`+dupe+`!OEBPS!ch1.xhtml-1', 9, 'application/xhtml+xml', '`+dupe+`', 'Chapter 1', 'adobe_user')`,
+		`INSERT INTO content (ContentID, ContentType, MimeType, BookID, Title, ___UserID) VALUES ('file:///mnt/onboard/Gone.epub#(0)ch1.xhtml', 9, 'application/xhtml+xml', 'file:///mnt/onboard/Gone.epub', 'Gone', 'adobe_user')`,
+		`INSERT INTO ShelfContent (ShelfName, ContentId, DateModified, _IsDeleted, _IsSynced) VALUES ('Sci-Fi', '`+dupe+`', '2024-01-02T00:00:00Z', 'false', 'true')`,
+	)
+
+	var (
+		duneCover   = kobo.CoverTypeFull.GeneratePath(false, "file____mnt_onboard_Books_Dune_kepub_epub")
+		dupeCover   = kobo.CoverTypeLibGrid.GeneratePath(false, kobo.ContentIDToImageID(dupe))
+		emmaCover   = kobo.CoverTypeFull.GeneratePath(false, kobo.ContentIDToImageID(emma))
+		storeCover  = kobo.CoverTypeLibFull.GeneratePath(false, "0a1b2c3d-store-book")
+		orphanCover = kobo.CoverTypeFull.GeneratePath(false, kobo.ContentIDToImageID("file:///mnt/onboard/Gone.epub"))
+	)
+	write(t, kpath,
+		"Books/Dune.kepub.epub",
+		"Copy/Dune.kepub.epub",
+		duneCover, dupeCover, emmaCover, storeCover, orphanCover,
+		".kobo-images/other.txt",
+	)
+
+	d := dbtest.Open(t, kpath)
+	ps, err := Check(context.Background(), kpath, d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []Problem{
+		{Kind: KindMissingFile, ContentID: emma, Path: "Books/Emma.epub", Covers: []string{emmaCover}},
+		{Kind: KindOrphanedContent, ContentID: "file:///mnt/onboard/Gone.epub#(0)ch1.xhtml"},
+		{Kind: KindDuplicate, ContentID: dupe, Path: "Copy/Dune.kepub.epub", Keep: dune, Covers: []string{dupeCover}},
+		{Kind: KindOrphanedCover, Path: orphanCover},
+	}; !reflect.DeepEqual(ps, exp) {
+		t.Fatalf("expected problems:\n%+v\ngot:\n%+v", exp, ps)
+	}
+
+	if _, err := db.Update(context.Background(), kpath, &db.UpdateOptions{BackupDir: t.TempDir()}, func(ctx context.Context, tx *db.Tx) error {
+		return Fix(ctx, tx, ps, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := RemoveFiles(kpath, ps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ps, err := Check(context.Background(), kpath, d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(ps) != 0 {
+		t.Errorf("expected no problems after fixing, got %+v", ps)
+	}
+	for name, exp := range map[string]bool{
+		"Books/Dune.kepub.epub": true,
+		"Copy/Dune.kepub.epub":  false,
+		duneCover:               true,
+		dupeCover:               false,
+		emmaCover:               false,
+		storeCover:              true,
+		orphanCover:             false,
+	} {
+		if act := exists(kpath, name); act != exp {
+			t.Errorf("%s: expected exists=%t, got %t", name, exp, act)
+		}
+	}
+
+	fn := filepath.Join(kpath, filepath.FromSlash(db.Path))
+	if n := dbtest.Query(t, fn, `SELECT COUNT(*) FROM content WHERE ContentID = ? OR BookID = ?`, dupe, dupe); n[0] != "0" {
+		t.Errorf("expected the duplicate and its chapters to be removed, got %s rows", n[0])
+	}
+	if v := dbtest.Query(t, fn, `SELECT _IsDeleted || ' ' || _IsSynced || ' ' || DateModified FROM ShelfContent WHERE ContentId = ?`, dupe); !reflect.DeepEqual(v, []string{"true false 2024-03-01T00:00:00Z"}) {
+		t.Errorf("expected the duplicate to be removed from shelves, got %q", v)
+	}
+	if n := dbtest.Query(t, fn, `SELECT COUNT(*) FROM Bookmark WHERE VolumeID = ?`, emma); n[0] != "1" {
+		t.Errorf("expected bookmarks to be kept")
+	}
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadingSession is a reading session from the LeaveContent analytics events.
+// Nickel removes analytics events after they are uploaded, so these will
+// usually only cover recent sessions on devices signed into a Kobo account.
+type ReadingSession struct {
+	ID            string
+	ContentID     string
+	Start         time.Time // estimated from the end and duration
+	End           time.Time
+	Duration      time.Duration
+	PagesTurned   int
+	WordsRead     int     // zero if unknown
+	StartProgress float64 // 0-100, -1 if unknown
+	EndProgress   float64 // 0-100, -1 if unknown
+}
+
+// ReadingSessions returns the reading sessions, sorted by end time. Sessions
+// with malformed attributes or metrics are skipped.
+func (d *DB) ReadingSessions(ctx context.Context) ([]ReadingSession, error) {
+	if !d.HasTable("AnalyticsEvents") {
+		return nil, nil
+	}
+	rows, err := d.db.QueryContext(ctx, `SELECT `+d.columns("AnalyticsEvents", "Id", "Timestamp", "Attributes", "Metrics")+` FROM AnalyticsEvents WHERE Type = 'LeaveContent' ORDER BY Timestamp, Id`)
+	if err != nil {
+		return nil, fmt.Errorf("get reading sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var ss []ReadingSession
+	for rows.Next() {
+		var (
+			id, attributes, metrics text
+			ts                      timestamp
+		)
+		if err := rows.Scan(&id, &ts, &attributes, &metrics); err != nil {
+			return nil, fmt.Errorf("get reading sessions: %w", err)
+		}
+		attr, ok1 := parseAnalytics(string(attributes))
+		metr, ok2 := parseAnalytics(string(metrics))
+		if !ok1 || !ok2 || time.Time(ts).IsZero() {
+			continue
+		}
+		s := ReadingSession{
+			ID:            string(id),
+			ContentID:     attr.str("volumeid", "ContentID"),
+			End:           time.Time(ts),
+			Duration:      time.Duration(metr.num(0, "SecondsRead")) * time.Second,
+			PagesTurned:   int(metr.num(0, "PagesTurned")),
+			WordsRead:     int(metr.num(0, "WordsRead")),
+			StartProgress: attr.num(-1, "StartProgress"),
+			EndProgress:   attr.num(-1, "progress", "Progress"),
+		}
+		if s.ContentID == "" {
+			continue
+		}
+		s.Start = s.End.Add(-s.Duration)
+		ss = append(ss, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get reading sessions: %w", err)
+	}
+	return ss, nil
+}
+
+// analytics is the attributes or metrics of an analytics event. Nickel stores
+// them as JSON objects, with values which are sometimes numbers and sometimes
+// strings.
+type analytics map[string]any
+
+func parseAnalytics(s string) (analytics, bool) {
+	if strings.TrimSpace(s) == "" {
+		return analytics{}, true
+	}
+	var a analytics
+	if err := json.Unmarshal([]byte(s), &a); err != nil {
+		return nil, false
+	}
+	return a, true
+}
+
+// get gets the value of the first key which exists (case-insensitive).
+func (a analytics) get(keys ...string) (any, bool) {
+	for _, k := range keys {
+		for x, v := range a {
+			if strings.EqualFold(x, k) && v != nil {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (a analytics) str(keys ...string) string {
+	switch v, _ := a.get(keys...); v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+func (a analytics) num(def float64, keys ...string) float64 {
+	switch v, _ := a.get(keys...); v := v.(type) {
+	case float64:
+		return v
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
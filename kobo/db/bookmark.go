@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BookmarkType is the type of a bookmark.
+type BookmarkType string
+
+// Bookmark types.
+const (
+	BookmarkTypeHighlight BookmarkType = "highlight"
+	BookmarkTypeNote      BookmarkType = "note"
+	BookmarkTypeDogEar    BookmarkType = "dogear" // a bookmarked page
+	BookmarkTypeMarkup    BookmarkType = "markup" // a handwritten annotation
+)
+
+// BookmarkColor is the highlight color of a bookmark on color devices.
+type BookmarkColor int
+
+// Bookmark colors.
+const (
+	BookmarkColorNone   BookmarkColor = -1 // not supported by the firmware
+	BookmarkColorYellow BookmarkColor = 0
+	BookmarkColorPink   BookmarkColor = 1
+	BookmarkColorBlue   BookmarkColor = 2
+	BookmarkColorGreen  BookmarkColor = 3
+)
+
+func (c BookmarkColor) String() string {
+	switch c {
+	case BookmarkColorNone:
+		return "none"
+	case BookmarkColorYellow:
+		return "yellow"
+	case BookmarkColorPink:
+		return "pink"
+	case BookmarkColorBlue:
+		return "blue"
+	case BookmarkColorGreen:
+		return "green"
+	default:
+		return fmt.Sprintf("BookmarkColor(%d)", int(c))
+	}
+}
+
+// Bookmark is a bookmark, highlight, or note.
+type Bookmark struct {
+	ID              string
+	VolumeID        string // the ContentID of the book
+	ContentID       string // the ContentID of the chapter or section
+	Type            BookmarkType
+	Text            string // the highlighted text
+	Annotation      string // the note
+	Color           BookmarkColor
+	StartPath       string // the start position, as a path in the chapter
+	StartOffset     int
+	EndPath         string
+	EndOffset       int
+	ChapterProgress float64 // the position in the chapter, from 0 to 1
+	Created         time.Time
+	Modified        time.Time
+	Hidden          bool
+}
+
+// Bookmarks returns the bookmarks in the book with the ContentID, sorted by
+// creation time. If volumeID is empty, the bookmarks for every book are
+// returned, sorted by book.
+func (d *DB) Bookmarks(ctx context.Context, volumeID string) ([]Bookmark, error) {
+	if !d.HasTable("Bookmark") {
+		return nil, nil
+	}
+	q := `SELECT ` + d.columns("Bookmark", "BookmarkID", "VolumeID", "ContentID", "Type", "Text", "Annotation", "Color", "StartContainerPath", "StartOffset", "EndContainerPath", "EndOffset", "ChapterProgress", "DateCreated", "DateModified", "Hidden") + ` FROM Bookmark`
+	args := []any{}
+	if volumeID != "" {
+		q += ` WHERE VolumeID = ?`
+		args = append(args, volumeID)
+	}
+	q += ` ORDER BY VolumeID, DateCreated, BookmarkID`
+
+	rows, err := d.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	var bs []Bookmark
+	for rows.Next() {
+		var (
+			id, volume, content, typ, txt, annotation text
+			color                                     *number
+			startPath, endPath                        text
+			startOffset, endOffset, progress          number
+			created, modified                         timestamp
+			hidden                                    flag
+		)
+		if err := rows.Scan(&id, &volume, &content, &typ, &txt, &annotation, &color, &startPath, &startOffset, &endPath, &endOffset, &progress, &created, &modified, &hidden); err != nil {
+			return nil, fmt.Errorf("get bookmarks: %w", err)
+		}
+		b := Bookmark{
+			ID:              string(id),
+			VolumeID:        string(volume),
+			ContentID:       string(content),
+			Type:            BookmarkType(typ),
+			Text:            string(txt),
+			Annotation:      string(annotation),
+			Color:           BookmarkColorNone,
+			StartPath:       string(startPath),
+			StartOffset:     int(startOffset),
+			EndPath:         string(endPath),
+			EndOffset:       int(endOffset),
+			ChapterProgress: float64(progress),
+			Created:         time.Time(created),
+			Modified:        time.Time(modified),
+			Hidden:          bool(hidden),
+		}
+		if color != nil {
+			b.Color = BookmarkColor(*color)
+		}
+		if b.Type == "" {
+			// older firmware doesn't have the type column
+			switch {
+			case b.Annotation != "":
+				b.Type = BookmarkTypeNote
+			case b.Text != "":
+				b.Type = BookmarkTypeHighlight
+			default:
+				b.Type = BookmarkTypeDogEar
+			}
+		}
+		bs = append(bs, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get bookmarks: %w", err)
+	}
+	return bs, nil
+}
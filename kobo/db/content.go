@@ -0,0 +1,377 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the type of a content row.
+type ContentType int
+
+// Content types.
+const (
+	ContentTypeBook    ContentType = 6
+	ContentTypeChapter ContentType = 9
+)
+
+// ReadStatus is the read status of a book.
+type ReadStatus int
+
+// Read statuses.
+const (
+	ReadStatusUnread   ReadStatus = 0
+	ReadStatusReading  ReadStatus = 1
+	ReadStatusFinished ReadStatus = 2
+)
+
+func (s ReadStatus) String() string {
+	switch s {
+	case ReadStatusUnread:
+		return "unread"
+	case ReadStatusReading:
+		return "reading"
+	case ReadStatusFinished:
+		return "finished"
+	default:
+		return fmt.Sprintf("ReadStatus(%d)", int(s))
+	}
+}
+
+// Book is a book (a content row with ContentTypeBook).
+type Book struct {
+	ContentID    string // file:///mnt/onboard/... for sideloaded books
+	Title        string
+	Subtitle     string
+	Attribution  string // the authors, as shown by nickel
+	Publisher    string
+	Description  string
+	Language     string
+	ISBN         string
+	Series       string
+	SeriesNumber string
+	MimeType     string
+	ImageID      string // may be empty for sideloaded books, see kobo.ContentIDToImageID
+	FileSize     int64
+	WordCount    int64 // zero if unknown
+	Downloaded   bool
+	DateCreated  time.Time
+
+	ReadingState
+}
+
+// ReadingState is the reading state of a book.
+type ReadingState struct {
+	ReadStatus              ReadStatus
+	PercentRead             int    // 0-100
+	ChapterIDBookmarked     string // the current position
+	DateLastRead            time.Time
+	TimeSpentReading        time.Duration
+	TimesStartedReading     int
+	LastTimeStartedReading  time.Time
+	LastTimeFinishedReading time.Time
+}
+
+// Sideloaded returns true if the book was sideloaded rather than from the
+// store.
+func (b Book) Sideloaded() bool {
+	return strings.HasPrefix(b.ContentID, "file://")
+}
+
+var bookColumns = []string{
+	"ContentID", "Title", "Subtitle", "Attribution", "Publisher", "Description",
+	"Language", "ISBN", "Series", "SeriesNumber", "MimeType", "ImageId",
+	"___FileSize", "WordCount", "IsDownloaded", "DateCreated",
+}
+
+func (b *Book) scan(s interface{ Scan(...any) error }) error {
+	var (
+		contentID, title, subtitle, attribution, publisher, description text
+		language, isbn, series, seriesNumber, mimeType, imageID         text
+		fileSize, wordCount                                             number
+		downloaded                                                      flag
+		created                                                         timestamp
+		rs                                                              readingState
+	)
+	if err := s.Scan(append([]any{
+		&contentID, &title, &subtitle, &attribution, &publisher, &description,
+		&language, &isbn, &series, &seriesNumber, &mimeType, &imageID,
+		&fileSize, &wordCount, &downloaded, &created,
+	}, rs.dest()...)...); err != nil {
+		return err
+	}
+	*b = Book{
+		ContentID:    string(contentID),
+		Title:        string(title),
+		Subtitle:     string(subtitle),
+		Attribution:  string(attribution),
+		Publisher:    string(publisher),
+		Description:  string(description),
+		Language:     string(language),
+		ISBN:         string(isbn),
+		Series:       string(series),
+		SeriesNumber: string(seriesNumber),
+		MimeType:     string(mimeType),
+		ImageID:      string(imageID),
+		FileSize:     int64(fileSize),
+		WordCount:    max(int64(wordCount), 0), // -1 if not counted yet
+		Downloaded:   bool(downloaded),
+		DateCreated:  time.Time(created),
+		ReadingState: rs.value(),
+	}
+	return nil
+}
+
+var readingStateColumns = []string{
+	"ReadStatus", "___PercentRead", "ChapterIDBookmarked", "DateLastRead",
+	"TimeSpentReading", "TimesStartedReading", "LastTimeStartedReading",
+	"LastTimeFinishedReading",
+}
+
+type readingState struct {
+	status, percent           number
+	chapter                   text
+	lastRead                  timestamp
+	spent, started            number
+	lastStarted, lastFinished timestamp
+}
+
+func (r *readingState) dest() []any {
+	return []any{&r.status, &r.percent, &r.chapter, &r.lastRead, &r.spent, &r.started, &r.lastStarted, &r.lastFinished}
+}
+
+func (r *readingState) value() ReadingState {
+	return ReadingState{
+		ReadStatus:              ReadStatus(r.status),
+		PercentRead:             int(r.percent),
+		ChapterIDBookmarked:     string(r.chapter),
+		DateLastRead:            time.Time(r.lastRead),
+		TimeSpentReading:        time.Duration(r.spent) * time.Second,
+		TimesStartedReading:     int(r.started),
+		LastTimeStartedReading:  time.Time(r.lastStarted),
+		LastTimeFinishedReading: time.Time(r.lastFinished),
+	}
+}
+
+func (d *DB) bookQuery(where string) string {
+	return `SELECT ` + d.columns("content", append(bookColumns, readingStateColumns...)...) + ` FROM content WHERE ContentType = 6` + where
+}
+
+// Books returns the books, sorted by ContentID.
+func (d *DB) Books(ctx context.Context) ([]Book, error) {
+	rows, err := d.db.QueryContext(ctx, d.bookQuery(` ORDER BY ContentID`))
+	if err != nil {
+		return nil, fmt.Errorf("get books: %w", err)
+	}
+	defer rows.Close()
+
+	var bs []Book
+	for rows.Next() {
+		var b Book
+		if err := b.scan(rows); err != nil {
+			return nil, fmt.Errorf("get books: %w", err)
+		}
+		bs = append(bs, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get books: %w", err)
+	}
+	return bs, nil
+}
+
+// Book returns the book with the ContentID, or an error wrapping ErrNotFound.
+func (d *DB) Book(ctx context.Context, contentID string) (Book, error) {
+	var b Book
+	if err := b.scan(d.db.QueryRowContext(ctx, d.bookQuery(` AND ContentID = ?`), contentID)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNotFound
+		}
+		return Book{}, fmt.Errorf("get book %q: %w", contentID, err)
+	}
+	return b, nil
+}
+
+// ReadingState returns the reading state of the book with the ContentID, or an
+// error wrapping ErrNotFound.
+func (d *DB) ReadingState(ctx context.Context, contentID string) (ReadingState, error) {
+	var rs readingState
+	if err := d.db.QueryRowContext(ctx, `SELECT `+d.columns("content", readingStateColumns...)+` FROM content WHERE ContentType = 6 AND ContentID = ?`, contentID).Scan(rs.dest()...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNotFound
+		}
+		return ReadingState{}, fmt.Errorf("get reading state %q: %w", contentID, err)
+	}
+	return rs.value(), nil
+}
+
+// Chapter is a chapter of a book (a content row with ContentTypeChapter).
+type Chapter struct {
+	ContentID  string
+	BookID     string // the ContentID of the book
+	Title      string
+	Index      int // VolumeIndex, the order in the book
+	Depth      int // the nesting level in the table of contents
+	FileOffset int64
+	FileSize   int64
+}
+
+// Chapters returns the chapters of the book with the ContentID, in order.
+func (d *DB) Chapters(ctx context.Context, bookID string) ([]Chapter, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT `+d.columns("content", "ContentID", "BookID", "Title", "VolumeIndex", "Depth", "___FileOffset", "___FileSize")+` FROM content WHERE ContentType = 9 AND BookID = ? ORDER BY VolumeIndex, ContentID`, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("get chapters %q: %w", bookID, err)
+	}
+	defer rows.Close()
+
+	var cs []Chapter
+	for rows.Next() {
+		var (
+			contentID, book, title             text
+			index, depth, fileOffset, fileSize number
+		)
+		if err := rows.Scan(&contentID, &book, &title, &index, &depth, &fileOffset, &fileSize); err != nil {
+			return nil, fmt.Errorf("get chapters %q: %w", bookID, err)
+		}
+		cs = append(cs, Chapter{
+			ContentID:  string(contentID),
+			BookID:     string(book),
+			Title:      string(title),
+			Index:      int(index),
+			Depth:      int(depth),
+			FileOffset: int64(fileOffset),
+			FileSize:   int64(fileSize),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get chapters %q: %w", bookID, err)
+	}
+	return cs, nil
+}
+
+// SetReadingState updates the reading position and status of a book. Only the
+// ReadStatus, PercentRead, ChapterIDBookmarked, DateLastRead, and (if not
+// zero) LastTimeFinishedReading are written.
+func (t *Tx) SetReadingState(ctx context.Context, contentID string, rs ReadingState) error {
+	values := map[string]any{
+		"ReadStatus":          int(rs.ReadStatus),
+		"___PercentRead":      rs.PercentRead,
+		"ChapterIDBookmarked": rs.ChapterIDBookmarked,
+		"FirstTimeReading":    formatFlag(rs.ReadStatus == ReadStatusUnread),
+		"DateLastRead":        nil,
+	}
+	if !rs.DateLastRead.IsZero() {
+		values["DateLastRead"] = formatTime(rs.DateLastRead)
+	}
+	if !rs.LastTimeFinishedReading.IsZero() {
+		values["LastTimeFinishedReading"] = formatTime(rs.LastTimeFinishedReading)
+	}
+	n, err := t.update(ctx, "content", values, `ContentType = 6 AND ContentID = ?`, contentID)
+	if err == nil && n == 0 {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("update reading state of %q: %w", contentID, err)
+	}
+	return nil
+}
+
+// OrphanedContent returns the ContentIDs of the rows (e.g., chapters) for
+// books which don't exist, sorted by ContentID.
+func (d *DB) OrphanedContent(ctx context.Context) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT ContentID FROM content WHERE ContentType != 6 AND IFNULL(BookID, '') != '' AND BookID NOT IN (SELECT ContentID FROM content WHERE ContentType = 6) ORDER BY ContentID`)
+	if err != nil {
+		return nil, fmt.Errorf("get orphaned content: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id text
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("get orphaned content: %w", err)
+		}
+		ids = append(ids, string(id))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get orphaned content: %w", err)
+	}
+	return ids, nil
+}
+
+// DeleteContent removes a content row, along with the chapters if it is a
+// book. Like nickel, the book is marked as removed from shelves so the removal
+// can be synced, and bookmarks are kept in case the book is added again.
+func (t *Tx) DeleteContent(ctx context.Context, contentID string, now time.Time) error {
+	res, err := t.db.ExecContext(ctx, `DELETE FROM content WHERE ContentID = ? OR BookID = ?`, contentID, contentID)
+	if err != nil {
+		return fmt.Errorf("delete content %q: %w", contentID, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("delete content %q: %w", contentID, err)
+	} else if n == 0 {
+		return fmt.Errorf("delete content %q: %w", contentID, ErrNotFound)
+	}
+	if t.HasTable("ShelfContent") {
+		if _, err := t.update(ctx, "ShelfContent", map[string]any{
+			"DateModified": formatTime(now),
+			"_IsDeleted":   formatFlag(true),
+			"_IsSynced":    formatFlag(false),
+		}, `ContentId = ? AND IFNULL(_IsDeleted, 'false') NOT IN ('true', '1', 1)`, contentID); err != nil {
+			return fmt.Errorf("delete content %q: %w", contentID, err)
+		}
+	}
+	return nil
+}
+
+// BookMetadata is the metadata of a book which nickel doesn't read from
+// sideloaded books.
+type BookMetadata struct {
+	Subtitle     string
+	Publisher    string
+	Series       string
+	SeriesNumber string // e.g., 1 or 2.5
+}
+
+// Metadata returns the editable metadata of the book.
+func (b Book) Metadata() BookMetadata {
+	return BookMetadata{
+		Subtitle:     b.Subtitle,
+		Publisher:    b.Publisher,
+		Series:       b.Series,
+		SeriesNumber: b.SeriesNumber,
+	}
+}
+
+// SetBookMetadata updates the metadata of a book. The SeriesID and
+// SeriesNumberFloat used by newer firmware for the series list and sorting are
+// also updated. Like calibre, the series name is used as the SeriesID, since
+// sideloaded books don't have a store series ID. Columns which don't exist in
+// the schema are skipped.
+func (t *Tx) SetBookMetadata(ctx context.Context, contentID string, m BookMetadata) error {
+	values := map[string]any{
+		"Subtitle":          m.Subtitle,
+		"Publisher":         m.Publisher,
+		"Series":            m.Series,
+		"SeriesID":          nil,
+		"SeriesNumber":      m.SeriesNumber,
+		"SeriesNumberFloat": nil,
+	}
+	if m.Series != "" {
+		values["SeriesID"] = m.Series
+	}
+	if f, err := strconv.ParseFloat(m.SeriesNumber, 64); err == nil {
+		values["SeriesNumberFloat"] = f
+	}
+	n, err := t.update(ctx, "content", values, `ContentType = 6 AND ContentID = ?`, contentID)
+	if err == nil && n == 0 {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("update metadata of %q: %w", contentID, err)
+	}
+	return nil
+}
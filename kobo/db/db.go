@@ -0,0 +1,200 @@
+// Package db reads the nickel library database (KoboReader.sqlite).
+//
+// The schema changes between firmware versions, so the tables and columns are
+// detected when the database is opened. Columns which don't exist in the
+// schema are read as their zero values. Schema and CheckSchema can be used to
+// check the schema before writing.
+//
+// Databases are opened read-only. Changes are made with Update, which takes a
+// backup and checks the integrity of the database before and after.
+//
+// The SQLite driver (modernc.org/sqlite) only supports darwin/amd64,
+// darwin/arm64, freebsd/386, freebsd/amd64, freebsd/arm, freebsd/arm64,
+// linux/386, linux/amd64, linux/arm, linux/arm64, linux/loong64,
+// linux/ppc64le, linux/riscv64, linux/s390x, netbsd/amd64, openbsd/amd64,
+// openbsd/arm64, windows/amd64, and windows/arm64. On other platforms (e.g.,
+// windows/386), the package builds, but opening a database returns an error.
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_ "github.com/pgaskin/koboutils/v2/internal/sqlite"
+)
+
+// Path is the path of the database relative to the root of a Kobo.
+const Path = ".kobo/KoboReader.sqlite"
+
+// ErrNotFound is returned if a row doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrUnsupportedSchema is returned by Open if the database is missing tables
+// or columns required to read it.
+var ErrUnsupportedSchema = errors.New("unsupported database schema")
+
+// required are the columns required to open a database.
+var required = map[string][]string{
+	"DbVersion": {"version"},
+	"content":   {"ContentID", "ContentType", "BookID", "Title"},
+}
+
+// DB is a read-only nickel database.
+type DB struct {
+	db      querier
+	conn    *sql.DB // nil for a transaction
+	version int
+	tables  map[string]map[string]string // lowercase table -> lowercase column -> column
+	names   map[string]string            // lowercase table -> table
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Open opens the database of the Kobo at kpath read-only.
+func Open(kpath string) (*DB, error) {
+	return OpenFile(filepath.Join(kpath, filepath.FromSlash(Path)))
+}
+
+// OpenFile opens the database at name read-only.
+func OpenFile(name string) (*DB, error) {
+	if _, err := os.Stat(name); err != nil {
+		return nil, err // sqlite's errors are less useful
+	}
+	u, err := fileURI(name, url.Values{
+		"mode":    {"ro"},
+		"_pragma": {"query_only(1)"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	sdb, err := sql.Open("sqlite", u)
+	if err != nil {
+		return nil, err
+	}
+	d := &DB{db: sdb, conn: sdb}
+	if err := d.init(context.Background()); err != nil {
+		sdb.Close()
+		return nil, fmt.Errorf("open %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// fileURI returns a SQLite URI for the file at name.
+func fileURI(name string, q url.Values) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(abs), RawQuery: q.Encode()}
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path // windows
+	}
+	return u.String(), nil
+}
+
+func (d *DB) init(ctx context.Context) error {
+	rows, err := d.db.QueryContext(ctx, `SELECT m.name, p.name FROM sqlite_master AS m JOIN pragma_table_info(m.name) AS p WHERE m.type = 'table'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	d.tables = map[string]map[string]string{}
+	d.names = map[string]string{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return err
+		}
+		t := strings.ToLower(table)
+		if d.tables[t] == nil {
+			d.tables[t] = map[string]string{}
+			d.names[t] = table
+		}
+		d.tables[t][strings.ToLower(column)] = column
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for table, columns := range required {
+		for _, column := range columns {
+			if !d.HasColumn(table, column) {
+				return fmt.Errorf("%w: missing column %s.%s", ErrUnsupportedSchema, table, column)
+			}
+		}
+	}
+
+	var v number
+	if err := d.db.QueryRowContext(ctx, `SELECT version FROM DbVersion`).Scan(&v); err != nil {
+		return fmt.Errorf("get version: %w", err)
+	}
+	d.version = int(v)
+	return nil
+}
+
+// Close closes the database. It does nothing for a Tx.
+func (d *DB) Close() error {
+	if d.conn == nil {
+		return nil
+	}
+	return d.conn.Close()
+}
+
+// Version returns the schema version from the DbVersion table.
+func (d *DB) Version() int {
+	return d.version
+}
+
+// Tables returns the sorted names of the tables in the database.
+func (d *DB) Tables() []string {
+	ts := make([]string, 0, len(d.names))
+	for _, t := range d.names {
+		ts = append(ts, t)
+	}
+	sort.Strings(ts)
+	return ts
+}
+
+// HasTable returns true if the table exists (case-insensitive).
+func (d *DB) HasTable(table string) bool {
+	_, ok := d.tables[strings.ToLower(table)]
+	return ok
+}
+
+// HasColumn returns true if the table has the column (case-insensitive).
+func (d *DB) HasColumn(table, column string) bool {
+	_, ok := d.tables[strings.ToLower(table)][strings.ToLower(column)]
+	return ok
+}
+
+// columns returns a select list for the columns of the table, with NULL in
+// place of missing ones.
+func (d *DB) columns(table string, columns ...string) string {
+	var b strings.Builder
+	for i, c := range columns {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		if d.HasColumn(table, c) {
+			b.WriteString(table)
+			b.WriteString(".")
+			b.WriteString(c)
+		} else {
+			b.WriteString("NULL")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,277 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/db/dbtest"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestOpen(t *testing.T) {
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent))
+	if v := d.Version(); v != 174 {
+		t.Errorf("expected version 174, got %d", v)
+	}
+	if exp := []string{"AnalyticsEvents", "Bookmark", "DbVersion", "Shelf", "ShelfContent", "content"}; !reflect.DeepEqual(d.Tables(), exp) {
+		t.Errorf("expected tables %q, got %q", exp, d.Tables())
+	}
+	if !d.HasTable("bookmark") || !d.HasColumn("CONTENT", "series") || d.HasColumn("content", "nonexistent") || d.HasColumn("nonexistent", "ContentID") {
+		t.Errorf("incorrect schema detection")
+	}
+
+	if _, err := db.ExecContext(d, context.Background(), `DELETE FROM content`); err == nil {
+		t.Errorf("expected database to be read-only")
+	}
+
+	if _, err := db.Open(t.TempDir()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist error for missing database, got %v", err)
+	}
+
+	fn := filepath.Join(t.TempDir(), "other.sqlite")
+	dbtest.Exec(t, fn, `CREATE TABLE DbVersion (version INTEGER)`)
+	if _, err := db.OpenFile(fn); !errors.Is(err, db.ErrUnsupportedSchema) {
+		t.Errorf("expected unsupported schema error, got %v", err)
+	}
+}
+
+func TestBooks(t *testing.T) {
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent))
+
+	bs, err := d.Books(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bs) != 3 {
+		t.Fatalf("expected 3 books, got %d", len(bs))
+	}
+	if bs[0].ContentID != "0a1b2c3d-store-book" || bs[0].Sideloaded() || bs[0].Downloaded {
+		t.Errorf("unexpected store book %+v", bs[0])
+	}
+
+	exp := db.Book{
+		ContentID:    "file:///mnt/onboard/Books/Dune.kepub.epub",
+		Title:        "Dune",
+		Subtitle:     "Book One",
+		Attribution:  "Frank Herbert",
+		Publisher:    "Ace",
+		Description:  "A desert planet.",
+		Language:     "en",
+		ISBN:         "9780441013593",
+		Series:       "Dune",
+		SeriesNumber: "1",
+		MimeType:     "application/x-kobo-epub+zip",
+		ImageID:      "file____mnt_onboard_Books_Dune_kepub_epub",
+		FileSize:     1234567,
+		WordCount:    188000,
+		Downloaded:   true,
+		DateCreated:  date("2024-01-02T03:04:05Z"),
+		ReadingState: db.ReadingState{
+			ReadStatus:             db.ReadStatusReading,
+			PercentRead:            42,
+			ChapterIDBookmarked:    "OEBPS/ch2.xhtml#kobo.3.1",
+			DateLastRead:           date("2024-02-03T04:05:06Z"),
+			TimeSpentReading:       time.Hour,
+			TimesStartedReading:    2,
+			LastTimeStartedReading: date("2024-02-03T03:05:06Z"),
+		},
+	}
+	if !reflect.DeepEqual(bs[1], exp) {
+		t.Errorf("expected %+v, got %+v", exp, bs[1])
+	}
+	if !bs[1].Sideloaded() {
+		t.Errorf("expected sideloaded book")
+	}
+
+	if b, err := d.Book(context.Background(), exp.ContentID); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(b, exp) {
+		t.Errorf("expected %+v, got %+v", exp, b)
+	}
+	if _, err := d.Book(context.Background(), "nonexistent"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	if rs, err := d.ReadingState(context.Background(), "file:///mnt/onboard/Books/Emma.epub"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if rs.ReadStatus != db.ReadStatusFinished || rs.PercentRead != 100 || !rs.LastTimeFinishedReading.Equal(date("2023-12-25T10:00:00Z")) {
+		t.Errorf("unexpected reading state %+v", rs)
+	}
+	if _, err := d.ReadingState(context.Background(), "nonexistent"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestChapters(t *testing.T) {
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent))
+
+	cs, err := d.Chapters(context.Background(), "file:///mnt/onboard/Books/Dune.kepub.epub")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []db.Chapter{
+		{ContentID: "file:///mnt/onboard/Books/Dune.kepub.epub!OEBPS!ch1.xhtml-1", BookID: "file:///mnt/onboard/Books/Dune.kepub.epub", Title: "Chapter 1", Index: 0, Depth: 1, FileOffset: 0, FileSize: 50},
+		{ContentID: "file:///mnt/onboard/Books/Dune.kepub.epub!OEBPS!ch2.xhtml-1", BookID: "file:///mnt/onboard/Books/Dune.kepub.epub", Title: "Chapter 2", Index: 1, Depth: 1, FileOffset: 50, FileSize: 50},
+	}
+	if !reflect.DeepEqual(cs, exp) {
+		t.Errorf("expected %+v, got %+v", exp, cs)
+	}
+
+	if cs, err := d.Chapters(context.Background(), "nonexistent"); err != nil || len(cs) != 0 {
+		t.Errorf("expected no chapters, got %v %v", cs, err)
+	}
+}
+
+func TestShelves(t *testing.T) {
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent))
+
+	ss, err := d.Shelves(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []db.Shelf{
+		{ID: "shelf-2", Name: "Old", InternalName: "Old", Type: "Custom", Created: date("2023-01-01T00:00:00Z"), Modified: date("2023-01-02T00:00:00Z"), Deleted: true, Synced: true},
+		{ID: "shelf-1", Name: "Sci-Fi", InternalName: "Sci-Fi", Type: "Custom", Created: date("2024-01-01T00:00:00Z"), Modified: date("2024-01-02T00:00:00Z"), Visible: true},
+	}
+	if !reflect.DeepEqual(ss, exp) {
+		t.Errorf("expected %+v, got %+v", exp, ss)
+	}
+
+	is, err := d.ShelfItems(context.Background(), "Sci-Fi")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []db.ShelfItem{{ShelfName: "Sci-Fi", ContentID: "file:///mnt/onboard/Books/Dune.kepub.epub", Modified: date("2024-01-02T00:00:00Z")}}; !reflect.DeepEqual(is, exp) {
+		t.Errorf("expected %+v, got %+v", exp, is)
+	}
+	if is, err := d.ShelfItems(context.Background(), ""); err != nil || len(is) != 2 || is[0].ShelfName != "Old" || !is[0].Deleted {
+		t.Errorf("unexpected shelf items %+v %v", is, err)
+	}
+}
+
+func TestBookmarks(t *testing.T) {
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent))
+
+	bs, err := d.Bookmarks(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bs) != 3 {
+		t.Fatalf("expected 3 bookmarks, got %d", len(bs))
+	}
+	exp := db.Bookmark{
+		ID:              "bm-1",
+		VolumeID:        "file:///mnt/onboard/Books/Dune.kepub.epub",
+		ContentID:       "OEBPS/ch1.xhtml",
+		Type:            db.BookmarkTypeHighlight,
+		Text:            "Fear is the mind-killer.",
+		Color:           db.BookmarkColorBlue,
+		StartPath:       `span#kobo\.1\.1`,
+		EndPath:         `span#kobo\.1\.1`,
+		EndOffset:       24,
+		ChapterProgress: 0.25,
+		Created:         date("2024-02-01T10:00:00Z"),
+		Modified:        date("2024-02-01T10:00:00Z"),
+	}
+	if !reflect.DeepEqual(bs[0], exp) {
+		t.Errorf("expected %+v, got %+v", exp, bs[0])
+	}
+	if b := bs[1]; b.Type != db.BookmarkTypeNote || b.Annotation != "Melange" || b.Color != db.BookmarkColorYellow {
+		t.Errorf("unexpected note %+v", b)
+	}
+	if b := bs[2]; b.Type != db.BookmarkTypeDogEar || b.Color != db.BookmarkColorNone || !b.Modified.IsZero() {
+		t.Errorf("unexpected dogear %+v", b)
+	}
+
+	if bs, err := d.Bookmarks(context.Background(), "file:///mnt/onboard/Books/Emma.epub"); err != nil || len(bs) != 1 || bs[0].ID != "bm-3" {
+		t.Errorf("unexpected bookmarks %+v %v", bs, err)
+	}
+}
+
+func TestLegacySchema(t *testing.T) {
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Legacy))
+	if v := d.Version(); v != 89 {
+		t.Errorf("expected version 89, got %d", v)
+	}
+	if d.HasColumn("content", "Series") {
+		t.Errorf("expected no series column")
+	}
+
+	b, err := d.Book(context.Background(), "file:///mnt/onboard/Dune.epub")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Title != "Dune" || b.Series != "" || b.Subtitle != "" || !b.Downloaded || b.PercentRead != 12 || b.TimeSpentReading != 0 {
+		t.Errorf("unexpected book %+v", b)
+	}
+	if !b.DateCreated.Equal(date("2015-01-02T03:04:05Z")) || !b.DateLastRead.Equal(date("2015-02-03T04:05:06Z")) {
+		t.Errorf("incorrect dates %s %s", b.DateCreated, b.DateLastRead)
+	}
+
+	if cs, err := d.Chapters(context.Background(), b.ContentID); err != nil || len(cs) != 1 || cs[0].Title != "One" {
+		t.Errorf("unexpected chapters %+v %v", cs, err)
+	}
+
+	bs, err := d.Bookmarks(context.Background(), b.ContentID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var types []db.BookmarkType
+	for _, x := range bs {
+		types = append(types, x.Type)
+		if x.Color != db.BookmarkColorNone {
+			t.Errorf("expected no color, got %s", x.Color)
+		}
+	}
+	if exp := []db.BookmarkType{db.BookmarkTypeHighlight, db.BookmarkTypeNote, db.BookmarkTypeDogEar}; !reflect.DeepEqual(types, exp) {
+		t.Errorf("expected inferred types %q, got %q", exp, types)
+	}
+}
+
+func TestReadingSessions(t *testing.T) {
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent))
+
+	ss, err := d.ReadingSessions(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ss) != 5 {
+		t.Fatalf("expected 5 sessions, got %d", len(ss))
+	}
+	if exp := (db.ReadingSession{
+		ID:            "ae-1",
+		ContentID:     "file:///mnt/onboard/Books/Dune.kepub.epub",
+		Start:         date("2024-02-01T10:30:00Z"),
+		End:           date("2024-02-01T11:00:00Z"),
+		Duration:      30 * time.Minute,
+		PagesTurned:   30,
+		WordsRead:     9000,
+		StartProgress: 5,
+		EndProgress:   20,
+	}); !reflect.DeepEqual(ss[0], exp) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", exp, ss[0])
+	}
+	if s := ss[1]; s.Duration != 20*time.Minute || s.PagesTurned != 20 || s.WordsRead != 0 {
+		t.Errorf("expected string metrics to be parsed, got %+v", s)
+	}
+	if s := ss[4]; s.ID != "ae-5" || s.StartProgress != -1 || s.EndProgress != 100 {
+		t.Errorf("expected missing progress to be -1, got %+v", s)
+	}
+
+	if ss, err := dbtest.Open(t, dbtest.NewFile(t, dbtest.Legacy)).ReadingSessions(context.Background()); err != nil || ss != nil {
+		t.Errorf("expected no sessions without analytics table, got %v %v", ss, err)
+	}
+}
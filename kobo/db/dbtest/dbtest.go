@@ -0,0 +1,125 @@
+// Package dbtest creates nickel databases from fixtures for use in tests.
+package dbtest
+
+import (
+	"database/sql"
+	"embed"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/pgaskin/koboutils/v2/internal/sqlite"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+)
+
+// Fixtures.
+const (
+	// Recent is a subset of a recent schema with a few books, chapters,
+	// shelves, and bookmarks.
+	Recent = "recent"
+
+	// Legacy is a subset of an older schema (without series, subtitles,
+	// reading time, bookmark types, or colors) with a book, a chapter, and
+	// a few bookmarks.
+	Legacy = "legacy"
+)
+
+//go:embed *.sql
+var fixtures embed.FS
+
+// Script returns the SQL script for a fixture.
+func Script(fixture string) string {
+	buf, err := fixtures.ReadFile(fixture + ".sql")
+	if err != nil {
+		panic("dbtest: unknown fixture " + fixture)
+	}
+	return string(buf)
+}
+
+// NewFile creates a database from a fixture in a temporary directory, then
+// runs the optional extra SQL. It returns the path to the database.
+func NewFile(t testing.TB, fixture string, extra ...string) string {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), "KoboReader.sqlite")
+	Exec(t, fn, append([]string{Script(fixture)}, extra...)...)
+	return fn
+}
+
+// NewDevice creates a fake device with a database from a fixture in a
+// temporary directory, then runs the optional extra SQL. It returns the path
+// to the device.
+func NewDevice(t testing.TB, fixture string, extra ...string) string {
+	t.Helper()
+	kpath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(kpath, ".kobo"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(kpath, ".kobo", "version"), []byte("N418210012345,4.1.15,4.38.21908,4.38.21908,4.38.21908,00000000-0000-0000-0000-000000000388\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	Exec(t, filepath.Join(kpath, ".kobo", "KoboReader.sqlite"), append([]string{Script(fixture)}, extra...)...)
+	return kpath
+}
+
+// Open opens the database at name, which is either a device (see NewDevice) or
+// a database file (see NewFile), read-only. It is closed when the test ends.
+func Open(t testing.TB, name string) *db.DB {
+	t.Helper()
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := db.OpenFile
+	if fi.IsDir() {
+		open = db.Open
+	}
+	d, err := open(name)
+	if err != nil {
+		t.Fatalf("dbtest: open %s: %v", name, err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// Exec runs SQL against the database at fn, creating it if it doesn't exist.
+func Exec(t testing.TB, fn string, query ...string) {
+	t.Helper()
+	db, err := sql.Open("sqlite", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, q := range query {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("dbtest: exec: %v", err)
+		}
+	}
+}
+
+// Query runs a query against the database at fn, and returns the first column
+// of each row as a string.
+func Query(t testing.TB, fn string, query string, args ...any) []string {
+	t.Helper()
+	db, err := sql.Open("sqlite", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("dbtest: query: %v", err)
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var v sql.NullString
+		if err := rows.Scan(&v); err != nil {
+			t.Fatalf("dbtest: query: %v", err)
+		}
+		res = append(res, v.String)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("dbtest: query: %v", err)
+	}
+	return res
+}
@@ -0,0 +1,91 @@
+-- A subset of an older KoboReader.sqlite schema (without series, subtitles,
+-- reading time, bookmark types, or colors), with a few rows.
+
+CREATE TABLE DbVersion (version INTEGER);
+INSERT INTO DbVersion VALUES (89);
+
+CREATE TABLE content (
+    ContentID TEXT NOT NULL,
+    ContentType TEXT NOT NULL,
+    MimeType TEXT NOT NULL,
+    BookID TEXT,
+    BookTitle TEXT,
+    ImageId TEXT,
+    Title TEXT COLLATE NOCASE,
+    Attribution TEXT COLLATE NOCASE,
+    Description TEXT,
+    DateCreated TEXT,
+    Publisher TEXT,
+    DateLastRead TEXT,
+    ChapterIDBookmarked TEXT,
+    VolumeIndex INTEGER,
+    ReadStatus INTEGER,
+    ___UserID TEXT NOT NULL,
+    ___FileOffset INTEGER,
+    ___FileSize INTEGER,
+    ___PercentRead INTEGER,
+    Language TEXT,
+    IsDownloaded BIT DEFAULT 1,
+    Depth INTEGER,
+    ISBN TEXT,
+    PRIMARY KEY (ContentID)
+);
+
+CREATE TABLE Shelf (
+    CreationDate TEXT,
+    Id TEXT,
+    InternalName TEXT,
+    LastModified TEXT,
+    Name TEXT,
+    Type TEXT,
+    _IsDeleted BOOL,
+    _IsVisible BOOL,
+    _IsSynced BOOL,
+    PRIMARY KEY (Id)
+);
+
+CREATE TABLE ShelfContent (
+    ShelfName TEXT,
+    ContentId TEXT,
+    DateModified TEXT,
+    _IsDeleted BOOL,
+    _IsSynced BOOL,
+    PRIMARY KEY (ShelfName, ContentId)
+);
+
+CREATE TABLE Bookmark (
+    BookmarkID TEXT NOT NULL,
+    VolumeID TEXT NOT NULL,
+    ContentID TEXT NOT NULL,
+    StartContainerPath TEXT NOT NULL,
+    StartContainerChildIndex INTEGER NOT NULL,
+    StartOffset INTEGER NOT NULL,
+    EndContainerPath TEXT NOT NULL,
+    EndContainerChildIndex INTEGER NOT NULL,
+    EndOffset INTEGER NOT NULL,
+    Text TEXT,
+    Annotation TEXT,
+    ExtraAnnotationData BLOB,
+    DateCreated TEXT,
+    ChapterProgress REAL NOT NULL DEFAULT 0,
+    Hidden BOOL NOT NULL DEFAULT 0,
+    Version TEXT,
+    DateModified TEXT,
+    Creator TEXT,
+    UUID TEXT,
+    UserID TEXT,
+    SyncTime TEXT,
+    Published BIT DEFAULT false,
+    PRIMARY KEY (BookmarkID)
+);
+
+INSERT INTO content (ContentID, ContentType, MimeType, BookID, Title, Attribution, ImageId, ___FileSize, IsDownloaded, DateCreated, ___UserID, ReadStatus, ___PercentRead, ChapterIDBookmarked, DateLastRead) VALUES
+    ('file:///mnt/onboard/Dune.epub', 6, 'application/epub+zip', NULL, 'Dune', 'Frank Herbert', NULL, 1000, 1, '2015-01-02 03:04:05.000+00:00', 'adobe_user', 1, 12, 'OEBPS/ch1.xhtml', '2015-02-03T04:05:06');
+
+INSERT INTO content (ContentID, ContentType, MimeType, BookID, Title, VolumeIndex, Depth, ___FileOffset, ___FileSize, ___UserID) VALUES
+    ('file:///mnt/onboard/Dune.epub#(0)OEBPS/ch1.xhtml', 9, 'application/xhtml+xml', 'file:///mnt/onboard/Dune.epub', 'One', 0, 1, 0, 100, 'adobe_user');
+
+INSERT INTO Bookmark (BookmarkID, VolumeID, ContentID, StartContainerPath, StartContainerChildIndex, StartOffset, EndContainerPath, EndContainerChildIndex, EndOffset, Text, Annotation, DateCreated, ChapterProgress, Hidden) VALUES
+    ('bm-1', 'file:///mnt/onboard/Dune.epub', 'OEBPS/ch1.xhtml', 'p[1]', 0, 0, 'p[1]', 0, 5, 'Arrakis', NULL, '2015-02-01T10:00:00', 0.2, 0),
+    ('bm-2', 'file:///mnt/onboard/Dune.epub', 'OEBPS/ch1.xhtml', 'p[2]', 0, 0, 'p[2]', 0, 5, 'Dune', 'planet', '2015-02-02T10:00:00', 0.3, 0),
+    ('bm-3', 'file:///mnt/onboard/Dune.epub', 'OEBPS/ch1.xhtml', 'p[3]', 0, 0, 'p[3]', 0, 0, NULL, NULL, '2015-02-03T10:00:00', 0.4, 0);
@@ -0,0 +1,197 @@
+-- A subset of a recent KoboReader.sqlite schema, with a few books, chapters,
+-- shelves, and bookmarks.
+
+CREATE TABLE DbVersion (version INTEGER);
+INSERT INTO DbVersion VALUES (174);
+
+CREATE TABLE content (
+    ContentID TEXT NOT NULL,
+    ContentType TEXT NOT NULL,
+    MimeType TEXT NOT NULL,
+    BookID TEXT,
+    BookTitle TEXT,
+    ImageId TEXT,
+    Title TEXT COLLATE NOCASE,
+    Attribution TEXT COLLATE NOCASE,
+    Description TEXT,
+    DateCreated TEXT,
+    ShortCoverKey TEXT,
+    adobe_location TEXT,
+    Publisher TEXT,
+    IsEncrypted BOOL,
+    DateLastRead TEXT,
+    FirstTimeReading BOOL,
+    ChapterIDBookmarked TEXT,
+    ParagraphBookmarked INTEGER,
+    BookmarkWordOffset INTEGER,
+    NumShortcovers INTEGER,
+    VolumeIndex INTEGER,
+    ___NumPages INTEGER,
+    ReadStatus INTEGER,
+    ___SyncTime TEXT,
+    ___UserID TEXT NOT NULL,
+    PublicationId TEXT,
+    ___FileOffset INTEGER,
+    ___FileSize INTEGER,
+    ___PercentRead INTEGER,
+    ___ExpirationStatus INTEGER,
+    FavouritesIndex NUMERIC DEFAULT -1,
+    Accessibility INTEGER DEFAULT 1,
+    ContentURL TEXT,
+    Language TEXT,
+    BookshelfTags TEXT,
+    IsDownloaded BIT DEFAULT 1,
+    FeedbackType INTEGER DEFAULT 0,
+    AverageRating INTEGER DEFAULT 0,
+    Depth INTEGER,
+    PageProgressDirection TEXT,
+    InWishlist TEXT DEFAULT 'FALSE' NOT NULL,
+    ISBN TEXT,
+    WishlistedDate TEXT DEFAULT '0000-00-00T00:00:00.000',
+    FeedbackTypeSynced INTEGER DEFAULT 0,
+    IsSocialEnabled TEXT DEFAULT 'true',
+    EpubType INTEGER DEFAULT -1,
+    Monetization INTEGER DEFAULT 2,
+    ExternalId TEXT,
+    Series TEXT,
+    SeriesNumber TEXT,
+    Subtitle TEXT,
+    WordCount INTEGER DEFAULT -1,
+    Fallback TEXT,
+    RestOfBookEstimate INTEGER,
+    CurrentChapterEstimate INTEGER,
+    CurrentChapterProgress FLOAT,
+    PocketStatus INTEGER DEFAULT 0,
+    UnsyncedPocketChanges TEXT,
+    ImageUrl TEXT,
+    DateAdded TEXT,
+    WorkId TEXT,
+    Properties TEXT,
+    RenditionSpread TEXT,
+    RatingCount INTEGER DEFAULT 0,
+    ReviewsSyncDate TEXT,
+    MediaOverlay TEXT,
+    MediaOverlayType TEXT,
+    RedirectPreviewUrl BOOL,
+    PreviewFileSize INTEGER,
+    EntitlementId TEXT,
+    CrossRevisionId TEXT,
+    DownloadUrl BOOL,
+    ReadStateSynced BOOL DEFAULT false,
+    TimesStartedReading INTEGER,
+    TimeSpentReading INTEGER,
+    LastTimeStartedReading TEXT,
+    LastTimeFinishedReading TEXT,
+    ApplicableSubscriptions TEXT,
+    ExternalIds TEXT,
+    PurchaseRate TEXT,
+    SeriesID TEXT,
+    SeriesNumberFloat REAL,
+    AdobeLoanExpiration TEXT,
+    HideFromHomePage BOOL,
+    IsInternetArchive BOOL,
+    titleKana TEXT,
+    subtitleKana TEXT,
+    seriesKana TEXT,
+    attributionKana TEXT,
+    publisherKana TEXT,
+    IsPurchaseable BOOL,
+    IsSupported BOOL,
+    AnnotationsSyncToken TEXT,
+    DateModified TEXT,
+    PRIMARY KEY (ContentID)
+);
+
+CREATE TABLE Shelf (
+    CreationDate TEXT,
+    Id TEXT,
+    InternalName TEXT,
+    LastModified TEXT,
+    Name TEXT,
+    Type TEXT,
+    _IsDeleted BOOL,
+    _IsVisible BOOL,
+    _IsSynced BOOL,
+    _SyncTime TEXT,
+    LastAccessed TEXT,
+    PRIMARY KEY (Id)
+);
+
+CREATE TABLE ShelfContent (
+    ShelfName TEXT,
+    ContentId TEXT,
+    DateModified TEXT,
+    _IsDeleted BOOL,
+    _IsSynced BOOL,
+    PRIMARY KEY (ShelfName, ContentId)
+);
+
+CREATE TABLE AnalyticsEvents (
+    Id TEXT NOT NULL,
+    Timestamp TEXT NOT NULL,
+    Type TEXT NOT NULL,
+    Attributes TEXT,
+    Metrics TEXT,
+    PRIMARY KEY (Id)
+);
+
+CREATE TABLE Bookmark (
+    BookmarkID TEXT NOT NULL,
+    VolumeID TEXT NOT NULL,
+    ContentID TEXT NOT NULL,
+    StartContainerPath TEXT NOT NULL,
+    StartContainerChildIndex INTEGER NOT NULL,
+    StartOffset INTEGER NOT NULL,
+    EndContainerPath TEXT NOT NULL,
+    EndContainerChildIndex INTEGER NOT NULL,
+    EndOffset INTEGER NOT NULL,
+    Text TEXT,
+    Annotation TEXT,
+    ExtraAnnotationData BLOB,
+    DateCreated TEXT,
+    ChapterProgress REAL NOT NULL DEFAULT 0,
+    Hidden BOOL NOT NULL DEFAULT 0,
+    Version TEXT,
+    DateModified TEXT,
+    Creator TEXT,
+    UUID TEXT,
+    UserID TEXT,
+    SyncTime TEXT,
+    Published BIT DEFAULT false,
+    ContextString TEXT,
+    Type TEXT,
+    Color INTEGER,
+    PRIMARY KEY (BookmarkID)
+);
+
+INSERT INTO content (ContentID, ContentType, MimeType, BookID, Title, Attribution, Publisher, Description, Language, ISBN, Series, SeriesNumber, Subtitle, ImageId, ___FileSize, IsDownloaded, DateCreated, ___UserID, ReadStatus, ___PercentRead, ChapterIDBookmarked, DateLastRead, TimeSpentReading, TimesStartedReading, LastTimeStartedReading, LastTimeFinishedReading, WordCount) VALUES
+    ('file:///mnt/onboard/Books/Dune.kepub.epub', '6', 'application/x-kobo-epub+zip', NULL, 'Dune', 'Frank Herbert', 'Ace', 'A desert planet.', 'en', '9780441013593', 'Dune', '1', 'Book One', 'file____mnt_onboard_Books_Dune_kepub_epub', 1234567, 'true', '2024-01-02T03:04:05Z', 'adobe_user', 1, 42, 'OEBPS/ch2.xhtml#kobo.3.1', '2024-02-03T04:05:06.000', 3600, 2, '2024-02-03T03:05:06Z', NULL, 188000),
+    ('file:///mnt/onboard/Books/Emma.epub', '6', 'application/epub+zip', NULL, 'Emma', 'Jane Austen', NULL, NULL, 'en', NULL, NULL, NULL, NULL, '', 5000, 'true', '2023-12-01T00:00:00Z', 'adobe_user', 2, 100, NULL, '2023-12-25T10:00:00Z', 7200, 1, '2023-12-20T10:00:00Z', '2023-12-25T10:00:00Z', 160000),
+    ('0a1b2c3d-store-book', '6', 'application/x-kobo-epub+zip', NULL, 'Store Book', 'Someone', 'Kobo', NULL, 'fr', NULL, NULL, NULL, NULL, '0a1b2c3d-store-book', 99, 'false', '2023-01-01T00:00:00Z', 'user', 0, 0, NULL, NULL, NULL, NULL, NULL, NULL, -1);
+
+INSERT INTO content (ContentID, ContentType, MimeType, BookID, Title, VolumeIndex, Depth, ___FileOffset, ___FileSize, ___UserID) VALUES
+    ('file:///mnt/onboard/Books/Dune.kepub.epub!OEBPS!ch2.xhtml-1', '9', 'application/xhtml+xml', 'file:///mnt/onboard/Books/Dune.kepub.epub', 'Chapter 2', 1, 1, 50, 50, 'adobe_user'),
+    ('file:///mnt/onboard/Books/Dune.kepub.epub!OEBPS!ch1.xhtml-1', '9', 'application/xhtml+xml', 'file:///mnt/onboard/Books/Dune.kepub.epub', 'Chapter 1', 0, 1, 0, 50, 'adobe_user'),
+    ('file:///mnt/onboard/Books/Emma.epub#(0)OEBPS/ch1.xhtml', '9', 'application/xhtml+xml', 'file:///mnt/onboard/Books/Emma.epub', 'Volume I', 0, 1, 0, 100, 'adobe_user');
+
+INSERT INTO Shelf (CreationDate, Id, InternalName, LastModified, Name, Type, _IsDeleted, _IsVisible, _IsSynced) VALUES
+    ('2024-01-01T00:00:00Z', 'shelf-1', 'Sci-Fi', '2024-01-02T00:00:00Z', 'Sci-Fi', 'Custom', 'false', 'true', 'false'),
+    ('2023-01-01T00:00:00Z', 'shelf-2', 'Old', '2023-01-02T00:00:00Z', 'Old', 'Custom', 'true', 'false', 'true');
+
+INSERT INTO ShelfContent (ShelfName, ContentId, DateModified, _IsDeleted, _IsSynced) VALUES
+    ('Sci-Fi', 'file:///mnt/onboard/Books/Dune.kepub.epub', '2024-01-02T00:00:00Z', 'false', 'false'),
+    ('Old', 'file:///mnt/onboard/Books/Emma.epub', '2023-01-02T00:00:00Z', 'true', 'true');
+
+INSERT INTO Bookmark (BookmarkID, VolumeID, ContentID, StartContainerPath, StartContainerChildIndex, StartOffset, EndContainerPath, EndContainerChildIndex, EndOffset, Text, Annotation, DateCreated, ChapterProgress, Hidden, DateModified, Type, Color) VALUES
+    ('bm-1', 'file:///mnt/onboard/Books/Dune.kepub.epub', 'OEBPS/ch1.xhtml', 'span#kobo\.1\.1', 0, 0, 'span#kobo\.1\.1', 0, 24, 'Fear is the mind-killer.', NULL, '2024-02-01T10:00:00.000', 0.25, 'false', '2024-02-01T10:00:00.000', 'highlight', 2),
+    ('bm-2', 'file:///mnt/onboard/Books/Dune.kepub.epub', 'OEBPS/ch2.xhtml', 'span#kobo\.3\.1', 0, 5, 'span#kobo\.3\.2', 0, 10, 'spice', 'Melange', '2024-02-02T10:00:00.000', 0.5, 'false', '2024-02-02T11:00:00.000', 'note', 0),
+    ('bm-3', 'file:///mnt/onboard/Books/Emma.epub', 'OEBPS/ch1.xhtml', '', 0, 0, '', 0, 0, NULL, NULL, '2023-12-21T10:00:00.000', 0.1, 'false', NULL, 'dogear', NULL);
+
+INSERT INTO AnalyticsEvents (Id, Timestamp, Type, Attributes, Metrics) VALUES
+    ('ae-1', '2024-02-01T11:00:00.000', 'LeaveContent', '{"volumeid":"file:///mnt/onboard/Books/Dune.kepub.epub","StartProgress":"5","progress":"20","ContentType":"6"}', '{"SecondsRead":1800,"PagesTurned":30,"WordsRead":9000}'),
+    ('ae-2', '2024-02-02T21:20:00.000', 'LeaveContent', '{"volumeid":"file:///mnt/onboard/Books/Dune.kepub.epub","StartProgress":"20","progress":"30"}', '{"SecondsRead":"1200","PagesTurned":"20"}'),
+    ('ae-3', '2024-02-03T08:10:00.000', 'LeaveContent', '{"volumeid":"file:///mnt/onboard/Books/Dune.kepub.epub","StartProgress":"30","progress":"42"}', '{"SecondsRead":600,"PagesTurned":10}'),
+    ('ae-4', '2024-02-03T20:15:00.000', 'LeaveContent', '{"volumeid":"file:///mnt/onboard/Books/Emma.epub","StartProgress":"90","progress":"100"}', '{"SecondsRead":900,"PagesTurned":12}'),
+    ('ae-5', '2024-02-05T07:05:00.000', 'LeaveContent', '{"volumeid":"file:///mnt/onboard/Books/Emma.epub","progress":"100"}', '{"SecondsRead":300,"PagesTurned":5}'),
+    ('ae-6', '2024-02-05T07:00:00.000', 'OpenContent', '{"volumeid":"file:///mnt/onboard/Books/Emma.epub"}', NULL),
+    ('ae-7', '2024-02-06T07:00:00.000', 'LeaveContent', '{"volumeid":"file:///mnt/onboard/Books/Emma.epub"}', 'invalid');
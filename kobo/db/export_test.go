@@ -0,0 +1,12 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ExecContext runs a query on the underlying connection of d, bypassing the
+// read-only API.
+func ExecContext(d *DB, ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return d.db.ExecContext(ctx, query, args...)
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLite columns are dynamically typed, and nickel isn't consistent about the
+// types it stores (e.g., booleans are usually the strings true and false, but
+// are sometimes integers), so values are scanned with these lenient types.
+
+// text scans a value as a string. NULL is scanned as an empty string.
+type text string
+
+func (t *text) Scan(v any) error {
+	switch v := v.(type) {
+	case nil:
+		*t = ""
+	case string:
+		*t = text(v)
+	case []byte:
+		*t = text(v)
+	case int64:
+		*t = text(strconv.FormatInt(v, 10))
+	case float64:
+		*t = text(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		*t = text(strconv.FormatBool(v))
+	case time.Time:
+		*t = text(v.UTC().Format(time.RFC3339Nano))
+	default:
+		return fmt.Errorf("unsupported text value %T", v)
+	}
+	return nil
+}
+
+// number scans a value as a number. NULL and empty strings are scanned as 0.
+type number float64
+
+func (n *number) Scan(v any) error {
+	switch v := v.(type) {
+	case nil:
+		*n = 0
+	case int64:
+		*n = number(v)
+	case float64:
+		*n = number(v)
+	case bool:
+		if *n = 0; v {
+			*n = 1
+		}
+	case string, []byte:
+		var s text
+		s.Scan(v)
+		if s = text(strings.TrimSpace(string(s))); s == "" {
+			*n = 0
+			return nil
+		}
+		f, err := strconv.ParseFloat(string(s), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		*n = number(f)
+	default:
+		return fmt.Errorf("unsupported number value %T", v)
+	}
+	return nil
+}
+
+// flag scans a value as a boolean. NULL is scanned as false.
+type flag bool
+
+func (f *flag) Scan(v any) error {
+	switch v := v.(type) {
+	case nil:
+		*f = false
+	case bool:
+		*f = flag(v)
+	case int64:
+		*f = v != 0
+	case float64:
+		*f = v != 0
+	case string, []byte:
+		var s text
+		s.Scan(v)
+		switch strings.ToLower(strings.TrimSpace(string(s))) {
+		case "true", "1":
+			*f = true
+		case "false", "0", "":
+			*f = false
+		default:
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+	default:
+		return fmt.Errorf("unsupported boolean value %T", v)
+	}
+	return nil
+}
+
+// timestamp scans a value as a time. NULL, empty strings, and unparseable
+// times are scanned as the zero time. Times without a zone are UTC.
+type timestamp time.Time
+
+// timeLayouts are the formats nickel has used for times.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+func (t *timestamp) Scan(v any) error {
+	switch v := v.(type) {
+	case nil:
+		*t = timestamp{}
+	case time.Time:
+		*t = timestamp(v.UTC())
+	case int64:
+		*t = timestamp(time.Unix(v, 0).UTC())
+	case string, []byte:
+		var s text
+		s.Scan(v)
+		*t = timestamp{}
+		for _, l := range timeLayouts {
+			if x, err := time.Parse(l, strings.TrimSpace(string(s))); err == nil {
+				*t = timestamp(x.UTC())
+				break
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported time value %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestScan(t *testing.T) {
+	for _, c := range []struct {
+		In  any
+		Out time.Time
+	}{
+		{"2024-01-02T03:04:05Z", date("2024-01-02T03:04:05Z")},
+		{"2024-01-02T03:04:05.123", date("2024-01-02T03:04:05.123Z")},
+		{"2024-01-02T03:04:05", date("2024-01-02T03:04:05Z")},
+		{"2024-01-02 03:04:05.000+02:00", date("2024-01-02T01:04:05Z")},
+		{"2024-01-02", date("2024-01-02T00:00:00Z")},
+		{[]byte("2024-01-02T03:04:05+01:00"), date("2024-01-02T02:04:05Z")},
+		{"0000-00-00T00:00:00.000", time.Time{}},
+		{"", time.Time{}},
+		{nil, time.Time{}},
+	} {
+		var ts timestamp
+		if err := ts.Scan(c.In); err != nil {
+			t.Errorf("%v: unexpected error: %v", c.In, err)
+		} else if !time.Time(ts).Equal(c.Out) {
+			t.Errorf("%v: expected %s, got %s", c.In, c.Out, time.Time(ts))
+		}
+	}
+
+	for _, c := range []struct {
+		In  any
+		Out bool
+		Err bool
+	}{
+		{"true", true, false},
+		{"TRUE", true, false},
+		{"false", false, false},
+		{int64(1), true, false},
+		{int64(0), false, false},
+		{"1", true, false},
+		{nil, false, false},
+		{"maybe", false, true},
+	} {
+		var f flag
+		if err := f.Scan(c.In); c.Err != (err != nil) {
+			t.Errorf("%v: unexpected error %v", c.In, err)
+		} else if bool(f) != c.Out {
+			t.Errorf("%v: expected %t, got %t", c.In, c.Out, f)
+		}
+	}
+
+	var n number
+	if err := n.Scan("12.5"); err != nil || n != 12.5 {
+		t.Errorf("unexpected number %v %v", n, err)
+	}
+	if err := n.Scan(""); err != nil || n != 0 {
+		t.Errorf("unexpected number %v %v", n, err)
+	}
+	if err := n.Scan("x"); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxVersion is the newest schema version which koboutils has been checked
+// against (the version of the dbtest.Recent fixture). Newer versions usually
+// only add tables and columns, but may change the meaning of existing ones.
+//
+// The firmware versions which produced each schema version are not mapped,
+// since that needs the schemas of real databases from known firmware
+// versions, which koboutils doesn't have.
+const MaxVersion = 174
+
+// ErrUnknownVersion is returned by CheckSchema if the schema version is newer
+// than MaxVersion.
+var ErrUnknownVersion = errors.New("unknown database schema version")
+
+// Schema describes the tables and columns of a database.
+type Schema struct {
+	Version int
+	Tables  []Table // sorted by name
+}
+
+// Table is a table in a database.
+type Table struct {
+	Name    string
+	Columns []Column // in order
+}
+
+// Column is a column of a table.
+type Column struct {
+	Name       string
+	Type       string // the declared type, which SQLite doesn't enforce
+	NotNull    bool
+	Default    string // the SQL expression, if any
+	PrimaryKey bool
+}
+
+// Schema reads the tables and columns of the database.
+func (d *DB) Schema(ctx context.Context) (*Schema, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT m.name, p.name, p.type, p."notnull", IFNULL(p.dflt_value, ''), p.pk FROM sqlite_master AS m JOIN pragma_table_info(m.name) AS p WHERE m.type = 'table' ORDER BY m.name COLLATE BINARY, p.cid`)
+	if err != nil {
+		return nil, fmt.Errorf("get schema: %w", err)
+	}
+	defer rows.Close()
+
+	s := &Schema{Version: d.version}
+	for rows.Next() {
+		var (
+			table string
+			c     Column
+			pk    int
+		)
+		if err := rows.Scan(&table, &c.Name, &c.Type, &c.NotNull, &c.Default, &pk); err != nil {
+			return nil, fmt.Errorf("get schema: %w", err)
+		}
+		c.PrimaryKey = pk != 0
+		if n := len(s.Tables); n == 0 || s.Tables[n-1].Name != table {
+			s.Tables = append(s.Tables, Table{Name: table})
+		}
+		t := &s.Tables[len(s.Tables)-1]
+		t.Columns = append(t.Columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get schema: %w", err)
+	}
+	return s, nil
+}
+
+// String formats the schema as text, with one line per table and column. The
+// output is stable, so it can be compared against a snapshot.
+func (s *Schema) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "version %d\n", s.Version)
+	for _, t := range s.Tables {
+		fmt.Fprintf(&b, "table %s\n", t.Name)
+		for _, c := range t.Columns {
+			fmt.Fprintf(&b, "\t%s", c.Name)
+			if c.Type != "" {
+				fmt.Fprintf(&b, " %s", c.Type)
+			}
+			if c.PrimaryKey {
+				b.WriteString(" PRIMARY KEY")
+			}
+			if c.NotNull {
+				b.WriteString(" NOT NULL")
+			}
+			if c.Default != "" {
+				fmt.Fprintf(&b, " DEFAULT %s", c.Default)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// CheckSchema checks that the tables and columns (a map of table to columns)
+// exist, for tools to call before writing. It returns an error wrapping
+// ErrUnsupportedSchema listing all missing tables and columns, or one wrapping
+// ErrUnknownVersion if they exist but the schema is newer than MaxVersion.
+func (d *DB) CheckSchema(required map[string][]string) error {
+	var missing []string
+	for table, columns := range required {
+		if !d.HasTable(table) {
+			missing = append(missing, table)
+			continue
+		}
+		for _, column := range columns {
+			if !d.HasColumn(table, column) {
+				missing = append(missing, table+"."+column)
+			}
+		}
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%w (version %d): missing %s", ErrUnsupportedSchema, d.version, strings.Join(missing, ", "))
+	}
+	if d.version > MaxVersion {
+		return fmt.Errorf("%w %d (newest known: %d)", ErrUnknownVersion, d.version, MaxVersion)
+	}
+	return nil
+}
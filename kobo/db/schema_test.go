@@ -0,0 +1,68 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/db/dbtest"
+)
+
+var updateSnapshots = flag.Bool("update", false, "update the schema snapshots in testdata")
+
+// TestSchema compares the schema of the dbtest fixtures against the snapshots.
+// Since the fixtures are written by hand, the snapshots are synthetic, and this
+// only catches accidental changes to them or to Schema.String, not differences
+// from real databases.
+func TestSchema(t *testing.T) {
+	for _, fixture := range []string{dbtest.Recent, dbtest.Legacy} {
+		t.Run(fixture, func(t *testing.T) {
+			s, err := dbtest.Open(t, dbtest.NewFile(t, fixture)).Schema(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			fn := filepath.Join("testdata", fixture+".synthetic.schema")
+			if *updateSnapshots {
+				if err := os.WriteFile(fn, []byte(s.String()), 0644); err != nil {
+					t.Fatalf("update snapshot: %v", err)
+				}
+			}
+			buf, err := os.ReadFile(fn)
+			if err != nil {
+				t.Fatalf("read snapshot: %v", err)
+			}
+			if act := s.String(); act != string(buf) {
+				t.Errorf("schema doesn't match %s (run with -update if this is expected):\n%s", fn, act)
+			}
+		})
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent))
+	if err := d.CheckSchema(map[string][]string{
+		"content": {"ContentID", "ReadStatus", "___PercentRead"},
+		"shelf":   {"Name"},
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := d.CheckSchema(map[string][]string{
+		"content": {"ContentID", "Nonexistent"},
+		"Missing": {"Id"},
+	}); !errors.Is(err, db.ErrUnsupportedSchema) {
+		t.Errorf("expected unsupported schema error, got %v", err)
+	} else if exp := "unsupported database schema (version 174): missing Missing, content.Nonexistent"; err.Error() != exp {
+		t.Errorf("expected error %q, got %q", exp, err)
+	}
+
+	d = dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent, `UPDATE DbVersion SET version = `+strconv.Itoa(db.MaxVersion+1)))
+	if err := d.CheckSchema(nil); !errors.Is(err, db.ErrUnknownVersion) {
+		t.Errorf("expected unknown version error, got %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Shelf is a shelf (called a collection in the UI). Deleted shelves are kept
+// by nickel until they are synced.
+type Shelf struct {
+	ID           string
+	Name         string
+	InternalName string
+	Type         string // e.g., Custom, UserTag, SystemTag
+	Created      time.Time
+	Modified     time.Time
+	Deleted      bool
+	Visible      bool
+	Synced       bool
+}
+
+// ShelfItem is a book on a shelf.
+type ShelfItem struct {
+	ShelfName string
+	ContentID string
+	Modified  time.Time
+	Deleted   bool
+	Synced    bool
+}
+
+// Shelves returns the shelves, including deleted ones, sorted by name.
+func (d *DB) Shelves(ctx context.Context) ([]Shelf, error) {
+	if !d.HasTable("Shelf") {
+		return nil, nil
+	}
+	rows, err := d.db.QueryContext(ctx, `SELECT `+d.columns("Shelf", "Id", "Name", "InternalName", "Type", "CreationDate", "LastModified", "_IsDeleted", "_IsVisible", "_IsSynced")+` FROM Shelf ORDER BY Name, Id`)
+	if err != nil {
+		return nil, fmt.Errorf("get shelves: %w", err)
+	}
+	defer rows.Close()
+
+	var ss []Shelf
+	for rows.Next() {
+		var (
+			id, name, internalName, typ text
+			created, modified           timestamp
+			deleted, visible, synced    flag
+		)
+		if err := rows.Scan(&id, &name, &internalName, &typ, &created, &modified, &deleted, &visible, &synced); err != nil {
+			return nil, fmt.Errorf("get shelves: %w", err)
+		}
+		ss = append(ss, Shelf{
+			ID:           string(id),
+			Name:         string(name),
+			InternalName: string(internalName),
+			Type:         string(typ),
+			Created:      time.Time(created),
+			Modified:     time.Time(modified),
+			Deleted:      bool(deleted),
+			Visible:      bool(visible),
+			Synced:       bool(synced),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get shelves: %w", err)
+	}
+	return ss, nil
+}
+
+// ShelfItems returns the books on the shelf, including deleted ones, sorted by
+// ContentID. If shelf is empty, the books on every shelf are returned, sorted
+// by shelf name.
+func (d *DB) ShelfItems(ctx context.Context, shelf string) ([]ShelfItem, error) {
+	if !d.HasTable("ShelfContent") {
+		return nil, nil
+	}
+	q := `SELECT ` + d.columns("ShelfContent", "ShelfName", "ContentId", "DateModified", "_IsDeleted", "_IsSynced") + ` FROM ShelfContent`
+	args := []any{}
+	if shelf != "" {
+		q += ` WHERE ShelfName = ?`
+		args = append(args, shelf)
+	}
+	q += ` ORDER BY ShelfName, ContentId`
+
+	rows, err := d.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get shelf items: %w", err)
+	}
+	defer rows.Close()
+
+	var is []ShelfItem
+	for rows.Next() {
+		var (
+			name, contentID text
+			modified        timestamp
+			deleted, synced flag
+		)
+		if err := rows.Scan(&name, &contentID, &modified, &deleted, &synced); err != nil {
+			return nil, fmt.Errorf("get shelf items: %w", err)
+		}
+		is = append(is, ShelfItem{
+			ShelfName: string(name),
+			ContentID: string(contentID),
+			Modified:  time.Time(modified),
+			Deleted:   bool(deleted),
+			Synced:    bool(synced),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get shelf items: %w", err)
+	}
+	return is, nil
+}
+
+// ShelfType is the shelf type used by nickel for collections created by the
+// user.
+const ShelfType = "UserTag"
+
+// CreateShelf adds a visible collection. The id must be unique.
+func (t *Tx) CreateShelf(ctx context.Context, id, name string, now time.Time) error {
+	if !t.HasTable("Shelf") {
+		return fmt.Errorf("create shelf: %w: missing table Shelf", ErrUnsupportedSchema)
+	}
+	if err := t.insert(ctx, "Shelf", map[string]any{
+		"Id":           id,
+		"Name":         name,
+		"InternalName": name,
+		"Type":         ShelfType,
+		"CreationDate": formatTime(now),
+		"LastModified": formatTime(now),
+		"_IsDeleted":   formatFlag(false),
+		"_IsVisible":   formatFlag(true),
+		"_IsSynced":    formatFlag(false),
+	}); err != nil {
+		return fmt.Errorf("create shelf %q: %w", name, err)
+	}
+	return nil
+}
+
+// SetShelfDeleted marks the shelf with the id as deleted (along with the books
+// on it) or restores it. Like nickel, deleted shelves are kept so the deletion
+// can be synced.
+func (t *Tx) SetShelfDeleted(ctx context.Context, id string, deleted bool, now time.Time) error {
+	var name text
+	if err := t.db.QueryRowContext(ctx, `SELECT Name FROM Shelf WHERE Id = ?`, id).Scan(&name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNotFound
+		}
+		return fmt.Errorf("update shelf %q: %w", id, err)
+	}
+	if _, err := t.update(ctx, "Shelf", map[string]any{
+		"LastModified": formatTime(now),
+		"_IsDeleted":   formatFlag(deleted),
+		"_IsVisible":   formatFlag(!deleted),
+		"_IsSynced":    formatFlag(false),
+	}, `Id = ?`, id); err != nil {
+		return fmt.Errorf("update shelf %q: %w", name, err)
+	}
+	if deleted && t.HasTable("ShelfContent") {
+		if _, err := t.update(ctx, "ShelfContent", map[string]any{
+			"DateModified": formatTime(now),
+			"_IsDeleted":   formatFlag(true),
+			"_IsSynced":    formatFlag(false),
+		}, `ShelfName = ? AND IFNULL(_IsDeleted, 'false') NOT IN ('true', '1', 1)`, string(name)); err != nil {
+			return fmt.Errorf("update shelf %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// SetShelfItem adds or removes a book from the shelf named shelf. Like
+// nickel, removed books are kept so the removal can be synced.
+func (t *Tx) SetShelfItem(ctx context.Context, shelf, contentID string, deleted bool, now time.Time) error {
+	if !t.HasTable("ShelfContent") {
+		return fmt.Errorf("update shelf item: %w: missing table ShelfContent", ErrUnsupportedSchema)
+	}
+	n, err := t.update(ctx, "ShelfContent", map[string]any{
+		"DateModified": formatTime(now),
+		"_IsDeleted":   formatFlag(deleted),
+		"_IsSynced":    formatFlag(false),
+	}, `ShelfName = ? AND ContentId = ?`, shelf, contentID)
+	if err == nil && n == 0 && !deleted {
+		err = t.insert(ctx, "ShelfContent", map[string]any{
+			"ShelfName":    shelf,
+			"ContentId":    contentID,
+			"DateModified": formatTime(now),
+			"_IsDeleted":   formatFlag(false),
+			"_IsSynced":    formatFlag(false),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("update shelf item %q in %q: %w", contentID, shelf, err)
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockPath is the path of the lock file relative to the root of a Kobo. It is
+// held by koboutils tools while they write to the database, and is removed
+// when they are done.
+const LockPath = ".kobo/koboutils.lock"
+
+// ErrLocked is returned by Update if another tool holds the lock file.
+var ErrLocked = errors.New("database is locked by another tool")
+
+// ErrIntegrity is returned if the database fails the integrity check.
+var ErrIntegrity = errors.New("database integrity check failed")
+
+// UpdateOptions configures Update.
+type UpdateOptions struct {
+	// Tool is the name of the tool to write in the lock file. If empty, the
+	// name of the executable is used.
+	Tool string
+
+	// BackupDir is the directory to write the backup to. If empty, it is
+	// written next to the database.
+	BackupDir string
+
+	// StaleLock is the age after which the lock file is assumed to have been
+	// left behind by a tool which was interrupted (e.g., by unplugging the
+	// device). The modification time of the lock file is refreshed while it
+	// is held, so long updates don't make it stale. If zero, it is 10 minutes.
+	StaleLock time.Duration
+
+	// BusyTimeout is how long to wait for other connections to finish
+	// writing to the database. If zero, it is 5 seconds.
+	BusyTimeout time.Duration
+}
+
+// Tx is a write transaction. The embedded DB reads within the transaction.
+type Tx struct {
+	*DB
+	tx *sql.Tx
+}
+
+// ExecContext executes a query within the transaction.
+func (t *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, query, args...)
+}
+
+// Update safely modifies the database of the Kobo at kpath. See UpdateFile.
+func Update(ctx context.Context, kpath string, opts *UpdateOptions, fn func(context.Context, *Tx) error) (backup string, err error) {
+	return UpdateFile(ctx, filepath.Join(kpath, filepath.FromSlash(Path)), opts, fn)
+}
+
+// UpdateFile safely modifies the database at name. It:
+//
+//   - takes the lock file (koboutils.lock next to the database), failing with
+//     ErrLocked if another tool holds it,
+//   - runs an integrity check, failing with ErrIntegrity if the database is
+//     already corrupt,
+//   - takes a timestamped backup of the database,
+//   - calls fn in a transaction, then runs another integrity check before
+//     committing it, rolling back if either fails,
+//   - re-opens the database and runs a final integrity check, restoring the
+//     backup if it fails (e.g., if the commit was interrupted).
+//
+// The path to the backup is returned if one was taken, even if an error
+// occurs. It is left for the caller to remove.
+func UpdateFile(ctx context.Context, name string, opts *UpdateOptions, fn func(context.Context, *Tx) error) (backup string, err error) {
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+	if _, err := os.Stat(name); err != nil {
+		return "", err // sqlite's errors are less useful
+	}
+
+	unlock, err := lock(filepath.Join(filepath.Dir(name), filepath.Base(LockPath)), opts)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	busy := opts.BusyTimeout
+	if busy == 0 {
+		busy = 5 * time.Second
+	}
+	u, err := fileURI(name, url.Values{
+		"mode":    {"rw"},
+		"_txlock": {"immediate"},
+		"_pragma": {"busy_timeout(" + strconv.FormatInt(busy.Milliseconds(), 10) + ")"},
+	})
+	if err != nil {
+		return "", err
+	}
+	sdb, err := sql.Open("sqlite", u)
+	if err != nil {
+		return "", err
+	}
+	defer sdb.Close()
+	sdb.SetMaxOpenConns(1)
+
+	d := &DB{db: sdb, conn: sdb}
+	if err := d.IntegrityCheck(ctx); err != nil {
+		return "", fmt.Errorf("update %s: %w", name, err)
+	}
+	if err := d.init(ctx); err != nil {
+		return "", fmt.Errorf("update %s: %w", name, err)
+	}
+
+	if backup, err = backupFile(ctx, sdb, name, opts.BackupDir); err != nil {
+		return "", fmt.Errorf("update %s: backup: %w", name, err)
+	}
+
+	uerr := update(ctx, sdb, fn)
+	sdb.Close()
+
+	// check what actually made it to the disk, bypassing the page cache
+	if err := checkFile(ctx, name); err != nil {
+		if uerr != nil {
+			err = fmt.Errorf("%w (after: %v)", err, uerr)
+		}
+		if rerr := restore(name, backup); rerr != nil {
+			return backup, fmt.Errorf("update %s: %w (and failed to restore backup: %v)", name, err, rerr)
+		}
+		return backup, fmt.Errorf("update %s: %w (restored backup)", name, err)
+	}
+	if uerr != nil {
+		return backup, fmt.Errorf("update %s: %w", name, uerr)
+	}
+	return backup, nil
+}
+
+// checkFile opens the database at name and checks its integrity.
+func checkFile(ctx context.Context, name string) error {
+	d, err := OpenFile(name)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrIntegrity, err)
+	}
+	defer d.Close()
+	return d.IntegrityCheck(ctx)
+}
+
+// update calls fn in a transaction.
+func update(ctx context.Context, sdb *sql.DB, fn func(context.Context, *Tx) error) error {
+	tx, err := sdb.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	t := &Tx{DB: &DB{db: tx}, tx: tx}
+	if err := t.init(ctx); err != nil {
+		return err
+	}
+	if err := fn(ctx, t); err != nil {
+		return err
+	}
+	if err := t.IntegrityCheck(ctx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// insert inserts a row, skipping columns which don't exist in the schema.
+func (t *Tx) insert(ctx context.Context, table string, values map[string]any) error {
+	var cs, ps []string
+	var args []any
+	for _, c := range sortedKeys(values) {
+		if t.HasColumn(table, c) {
+			cs = append(cs, c)
+			ps = append(ps, "?")
+			args = append(args, values[c])
+		}
+	}
+	_, err := t.tx.ExecContext(ctx, `INSERT INTO `+table+` (`+strings.Join(cs, ", ")+`) VALUES (`+strings.Join(ps, ", ")+`)`, args...)
+	return err
+}
+
+// update updates rows matching where, skipping columns which don't exist in
+// the schema. It returns the number of rows updated.
+func (t *Tx) update(ctx context.Context, table string, values map[string]any, where string, args ...any) (int64, error) {
+	var ss []string
+	var xargs []any
+	for _, c := range sortedKeys(values) {
+		if t.HasColumn(table, c) {
+			ss = append(ss, c+" = ?")
+			xargs = append(xargs, values[c])
+		}
+	}
+	if len(ss) == 0 {
+		return 0, nil
+	}
+	res, err := t.tx.ExecContext(ctx, `UPDATE `+table+` SET `+strings.Join(ss, ", ")+` WHERE `+where, append(xargs, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func sortedKeys(m map[string]any) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// formatTime formats a time like nickel does for shelves and most content
+// columns.
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// formatFlag formats a boolean like nickel does.
+func formatFlag(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+// IntegrityCheck runs PRAGMA integrity_check, returning an error wrapping
+// ErrIntegrity with the first few problems if it fails.
+func (d *DB) IntegrityCheck(ctx context.Context) error {
+	rows, err := d.db.QueryContext(ctx, `PRAGMA integrity_check(10)`)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrIntegrity, err)
+	}
+	defer rows.Close()
+
+	var msgs []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return fmt.Errorf("%w: %v", ErrIntegrity, err)
+		}
+		if msg != "ok" {
+			msgs = append(msgs, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrIntegrity, err)
+	}
+	if len(msgs) != 0 {
+		return fmt.Errorf("%w: %s", ErrIntegrity, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// lock creates the lock file at name, removing it first if it is stale. The
+// modification time of the lock file is refreshed until unlock is called.
+func lock(name string, opts *UpdateOptions) (unlock func(), err error) {
+	tool := opts.Tool
+	if tool == "" {
+		tool = filepath.Base(os.Args[0])
+	}
+	stale := opts.StaleLock
+	if stale == 0 {
+		stale = 10 * time.Minute
+	}
+	host, _ := os.Hostname()
+
+	for i := 0; ; i++ {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%s pid=%d host=%s time=%s\n", tool, os.Getpid(), host, time.Now().UTC().Format(time.RFC3339))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(name)
+				return nil, fmt.Errorf("lock: %w", err)
+			}
+			done, stopped := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(stopped)
+				t := time.NewTicker(stale / 4)
+				defer t.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-t.C:
+						now := time.Now()
+						os.Chtimes(name, now, now)
+					}
+				}
+			}()
+			return func() {
+				close(done)
+				<-stopped
+				os.Remove(name)
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("lock: %w", err)
+		}
+		if fi, err := os.Stat(name); err == nil && i == 0 && time.Since(fi.ModTime()) > stale {
+			if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("lock: remove stale lock: %w", err)
+			}
+			continue
+		}
+		if buf, err := os.ReadFile(name); err == nil && len(buf) != 0 {
+			return nil, fmt.Errorf("%w (%s)", ErrLocked, strings.TrimSpace(string(buf)))
+		}
+		return nil, ErrLocked
+	}
+}
+
+// backupFile writes a consistent copy of the database to a new timestamped
+// file in dir (or next to the database if empty).
+func backupFile(ctx context.Context, sdb *sql.DB, name, dir string) (string, error) {
+	if dir == "" {
+		dir = filepath.Dir(name)
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	base := filepath.Join(dir, filepath.Base(name)+"."+time.Now().UTC().Format("20060102T150405Z"))
+	fn := base + ".bak"
+	for i := 2; ; i++ {
+		if _, err := os.Stat(fn); errors.Is(err, os.ErrNotExist) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		fn = base + "-" + strconv.Itoa(i) + ".bak"
+	}
+	if _, err := sdb.ExecContext(ctx, `VACUUM INTO ?`, fn); err != nil {
+		os.Remove(fn)
+		return "", err
+	}
+	return fn, nil
+}
+
+// restore replaces the database at name with the backup. The database must
+// not be open.
+func restore(name, backup string) error {
+	src, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := name + ".koboutils-restore"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	// the journals belong to the database being replaced
+	for _, sfx := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(name + sfx); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,220 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/db/dbtest"
+)
+
+func shelfNames(t *testing.T, fn string) []string {
+	t.Helper()
+	return dbtest.Query(t, fn, `SELECT Name FROM Shelf ORDER BY Name`)
+}
+
+// corrupt overwrites the page containing the root of the Bookmark table.
+func corrupt(t *testing.T, fn string) {
+	t.Helper()
+	page := dbtest.Query(t, fn, `SELECT rootpage FROM sqlite_master WHERE name = 'Bookmark'`)
+	size := dbtest.Query(t, fn, `PRAGMA page_size`)
+	if len(page) != 1 || len(size) != 1 {
+		t.Fatalf("could not find Bookmark table")
+	}
+	p, err := strconv.ParseInt(page[0], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := strconv.ParseInt(size[0], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(fn, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte(strings.Repeat("\xAA", int(s))), (p-1)*s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	kpath := dbtest.NewDevice(t, dbtest.Recent)
+	fn := filepath.Join(kpath, filepath.FromSlash(db.Path))
+	orig := shelfNames(t, fn)
+
+	backup, err := db.Update(context.Background(), kpath, &db.UpdateOptions{Tool: "test"}, func(ctx context.Context, tx *db.Tx) error {
+		if _, err := os.Stat(filepath.Join(kpath, filepath.FromSlash(db.LockPath))); err != nil {
+			t.Errorf("expected lock file to exist during update: %v", err)
+		}
+		if !tx.HasColumn("Shelf", "InternalName") {
+			t.Errorf("expected schema to be available in transaction")
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO Shelf (Id, Name, InternalName, _IsDeleted, _IsVisible, _IsSynced) VALUES ('new', 'New', 'New', 'false', 'true', 'false')`); err != nil {
+			return err
+		}
+		ss, err := tx.Shelves(ctx)
+		if err != nil {
+			return err
+		}
+		if len(ss) != len(orig)+1 {
+			t.Errorf("expected reads to see uncommitted changes, got %d shelves", len(ss))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp := append([]string{"New"}, orig...)
+	sort.Strings(exp)
+	if !reflect.DeepEqual(shelfNames(t, fn), exp) {
+		t.Errorf("expected shelf to be added, got %q", shelfNames(t, fn))
+	}
+	if filepath.Dir(backup) != filepath.Dir(fn) || !strings.HasPrefix(filepath.Base(backup), "KoboReader.sqlite.") || !strings.HasSuffix(backup, ".bak") {
+		t.Errorf("unexpected backup path %q", backup)
+	}
+	if !reflect.DeepEqual(shelfNames(t, backup), orig) {
+		t.Errorf("expected backup to contain original shelves, got %q", shelfNames(t, backup))
+	}
+	if _, err := os.Stat(filepath.Join(kpath, filepath.FromSlash(db.LockPath))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected lock file to be removed, got %v", err)
+	}
+
+	backup2, err := db.Update(context.Background(), kpath, &db.UpdateOptions{BackupDir: filepath.Join(kpath, "backups")}, func(ctx context.Context, tx *db.Tx) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filepath.Dir(backup2) != filepath.Join(kpath, "backups") || backup2 == backup {
+		t.Errorf("unexpected backup path %q", backup2)
+	}
+}
+
+func TestUpdateError(t *testing.T) {
+	fn := dbtest.NewFile(t, dbtest.Recent)
+	orig := shelfNames(t, fn)
+
+	errTest := errors.New("test")
+	backup, err := db.UpdateFile(context.Background(), fn, nil, func(ctx context.Context, tx *db.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM Shelf`); err != nil {
+			return err
+		}
+		return errTest
+	})
+	if !errors.Is(err, errTest) {
+		t.Errorf("expected test error, got %v", err)
+	}
+	if backup == "" {
+		t.Errorf("expected backup to be returned")
+	}
+	if !reflect.DeepEqual(shelfNames(t, fn), orig) {
+		t.Errorf("expected changes to be rolled back, got %q", shelfNames(t, fn))
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(fn), filepath.Base(db.LockPath))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected lock file to be removed, got %v", err)
+	}
+}
+
+func TestUpdateLocked(t *testing.T) {
+	fn := dbtest.NewFile(t, dbtest.Recent)
+	lfn := filepath.Join(filepath.Dir(fn), filepath.Base(db.LockPath))
+	if err := os.WriteFile(lfn, []byte("other pid=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.UpdateFile(context.Background(), fn, nil, func(ctx context.Context, tx *db.Tx) error {
+		t.Errorf("expected fn not to be called")
+		return nil
+	})
+	if !errors.Is(err, db.ErrLocked) || !strings.Contains(err.Error(), "other pid=1") {
+		t.Errorf("expected locked error with owner, got %v", err)
+	}
+	if _, err := os.Stat(lfn); err != nil {
+		t.Errorf("expected other lock file to be left alone, got %v", err)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lfn, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UpdateFile(context.Background(), fn, nil, func(ctx context.Context, tx *db.Tx) error {
+		return nil
+	}); err != nil {
+		t.Errorf("expected stale lock to be replaced, got %v", err)
+	}
+}
+
+func TestUpdateLockRefresh(t *testing.T) {
+	fn := dbtest.NewFile(t, dbtest.Recent)
+	opts := &db.UpdateOptions{StaleLock: 200 * time.Millisecond}
+	if _, err := db.UpdateFile(context.Background(), fn, opts, func(ctx context.Context, tx *db.Tx) error {
+		time.Sleep(opts.StaleLock * 3)
+		if _, err := db.UpdateFile(ctx, fn, opts, func(ctx context.Context, tx *db.Tx) error {
+			t.Errorf("expected fn not to be called")
+			return nil
+		}); !errors.Is(err, db.ErrLocked) {
+			t.Errorf("expected held lock not to become stale, got %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestUpdateCorrupt(t *testing.T) {
+	fn := dbtest.NewFile(t, dbtest.Recent)
+	corrupt(t, fn)
+
+	backup, err := db.UpdateFile(context.Background(), fn, nil, func(ctx context.Context, tx *db.Tx) error {
+		t.Errorf("expected fn not to be called")
+		return nil
+	})
+	if !errors.Is(err, db.ErrIntegrity) {
+		t.Errorf("expected integrity error, got %v", err)
+	}
+	if backup != "" {
+		t.Errorf("expected no backup of corrupt database")
+	}
+}
+
+func TestUpdateRestore(t *testing.T) {
+	fn := dbtest.NewFile(t, dbtest.Recent)
+	orig := shelfNames(t, fn)
+
+	// simulate something else scribbling over the database mid-update
+	backup, err := db.UpdateFile(context.Background(), fn, nil, func(ctx context.Context, tx *db.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM Shelf`); err != nil {
+			return err
+		}
+		corrupt(t, fn)
+		return nil
+	})
+	if !errors.Is(err, db.ErrIntegrity) || !strings.Contains(err.Error(), "restored backup") {
+		t.Errorf("expected integrity error with restored backup, got %v", err)
+	}
+	if d, err := db.OpenFile(fn); err != nil {
+		t.Errorf("expected restored database to open, got %v", err)
+	} else {
+		if err := d.IntegrityCheck(context.Background()); err != nil {
+			t.Errorf("expected restored database to pass integrity check, got %v", err)
+		}
+		d.Close()
+	}
+	if !reflect.DeepEqual(shelfNames(t, fn), orig) {
+		t.Errorf("expected original shelves to be restored, got %q", shelfNames(t, fn))
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Errorf("expected backup to be kept, got %v", err)
+	}
+}
@@ -4,6 +4,8 @@ package kobo
 import (
 	"fmt"
 	"image"
+	"strconv"
+	"strings"
 )
 
 // See https://gist.github.com/pgaskin/613b34c23f026f7c39c50ee32f5e167e and
@@ -150,6 +152,33 @@ func DeviceByID(id string) (Device, bool) {
 	return 0, false
 }
 
+// DeviceByNumericID gets a device by its numerical ID.
+func DeviceByNumericID(id int) (Device, bool) {
+	for _, device := range Devices() {
+		if device.ID() == id {
+			return device, true
+		}
+	}
+	return 0, false
+}
+
+// ParseDevice gets a device by its numerical ID, full ID string, or name
+// (case-insensitive, with or without the Kobo prefix).
+func ParseDevice(s string) (Device, bool) {
+	if d, ok := DeviceByID(s); ok {
+		return d, true
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return DeviceByNumericID(n)
+	}
+	for _, d := range Devices() {
+		if strings.EqualFold(d.Name(), s) || strings.EqualFold(strings.TrimPrefix(d.Name(), "Kobo "), s) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
 // ID returns the numerical device ID.
 func (d Device) ID() int {
 	return int(d)
@@ -534,3 +563,12 @@ func (d Device) DisplayPPI() int {
 	}
 	panic("unknown device")
 }
+
+// ColorDisplay returns true if a Device has a color display.
+func (d Device) ColorDisplay() bool {
+	switch d {
+	case DeviceLibraColour, DeviceVisionColour, DeviceClaraColour, DeviceShineColor:
+		return true
+	}
+	return false
+}
@@ -21,6 +21,24 @@ func TestDeviceList(t *testing.T) {
 	}
 }
 
+func TestParseDevice(t *testing.T) {
+	for _, c := range []struct {
+		In  string
+		Out Device
+	}{
+		{"00000000-0000-0000-0000-000000000376", DeviceClaraHD},
+		{"376", DeviceClaraHD},
+		{"Kobo Clara HD", DeviceClaraHD},
+		{"clara hd", DeviceClaraHD},
+		{"999", 0},
+		{"Clara", 0},
+	} {
+		if d, ok := ParseDevice(c.In); d != c.Out || ok != (c.Out != 0) {
+			t.Errorf("%q: expected %v, got %v (ok: %t)", c.In, c.Out, d, ok)
+		}
+	}
+}
+
 func TestCoverGeneratePath(t *testing.T) {
 	for _, tc := range []struct {
 		ct  CoverType
@@ -0,0 +1,481 @@
+// Package diag builds privacy-safe diagnostic bundles from Kobo devices.
+package diag
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/internal"
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+)
+
+// Paths of the files included in a bundle, relative to the device root. In a
+// zip bundle, they are stored at the same paths, so an extracted bundle can be
+// used as a device.
+const (
+	VersionPath   = ".kobo/version"
+	AffiliatePath = ".kobo/affiliate.conf"
+	SettingsPath  = ".kobo/Kobo/Kobo eReader.conf"
+	DatabasePath  = db.Path
+)
+
+// BundlePath is the path of the JSON report in a zip bundle.
+const BundlePath = "diag.json"
+
+// Options configures how a bundle is built.
+type Options struct {
+	// Rules are the redaction rules, applied in order (see Rule). If nil,
+	// DefaultRules is used. To disable redaction, use an empty non-nil slice.
+	Rules []Rule
+
+	// Salt is used when hashing values. It should be kept secret and reused
+	// to correlate bundles from the same device. If empty, a random salt is
+	// generated for each bundle, since hashes of serial numbers without a
+	// secret salt can be reversed by trying every serial for the model.
+	Salt string
+
+	// Time is the bundle creation time. If zero, it is omitted, and the zip
+	// entries use a fixed time, so the bundle is reproducible if Salt is set.
+	Time time.Time
+
+	// NoStorage disables walking the device for storage usage, which can be
+	// slow for large libraries.
+	NoStorage bool
+}
+
+// Bundle is a diagnostic bundle.
+type Bundle struct {
+	Created   *time.Time                   `json:"created,omitempty"`
+	Device    Device                       `json:"device"`
+	Affiliate string                       `json:"affiliate,omitempty"`
+	Settings  map[string]map[string]string `json:"settings,omitempty"`
+	DBVersion int                          `json:"db_version,omitempty"`
+	Storage   *Storage                     `json:"storage,omitempty"`
+	Addons    []Addon                      `json:"addons"`
+	Redacted  []string                     `json:"redacted,omitempty"` // redacted fields as file:section/key
+	Warnings  []string                     `json:"warnings,omitempty"`
+	Errors    []string                     `json:"errors,omitempty"` // parts which could not be collected
+
+	files map[string][]byte // redacted files by path
+}
+
+// Device contains information from the version file.
+type Device struct {
+	Serial      string `json:"serial"`
+	ModelNumber string `json:"model_number,omitempty"`
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Hardware    string `json:"hardware,omitempty"`
+	Firmware    string `json:"firmware"`
+}
+
+// Storage contains storage usage information in bytes.
+type Storage struct {
+	Total     uint64 `json:"total,omitempty"` // zero if unknown
+	Free      uint64 `json:"free,omitempty"`  // zero if unknown
+	Books     int64  `json:"books"`
+	BookCount int    `json:"book_count"`
+	Kobo      int64  `json:"kobo"`   // .kobo
+	Addons    int64  `json:"addons"` // .adds
+	Other     int64  `json:"other"`
+}
+
+// Addon is an installed add-on or pending installation.
+type Addon struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+}
+
+// addons maps the directories in .adds to the add-on names.
+var addons = map[string]string{
+	"nm":         "NickelMenu",
+	"kfmon":      "KFMon",
+	"koreader":   "KOReader",
+	"plato":      "Plato",
+	"nickeldbus": "NickelDBus",
+	"kobocloud":  "KoboCloud",
+	"vlasovsoft": "Vlasovsoft",
+	"telnet":     "Telnet",
+}
+
+// bookExts are the extensions counted as books.
+var bookExts = map[string]bool{
+	".epub": true, ".kepub": true, ".pdf": true, ".mobi": true, ".cbz": true,
+	".cbr": true, ".txt": true, ".html": true, ".htm": true, ".rtf": true,
+}
+
+// Collect builds a bundle from the device at kpath. Parts which can't be
+// collected are recorded in the bundle's Errors, with paths relative to kpath.
+func Collect(kpath string, opts *Options) (*Bundle, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if !kobo.IsKobo(kpath) {
+		return nil, fmt.Errorf("not a kobo: %s", kpath)
+	}
+
+	rules := opts.Rules
+	if rules == nil {
+		rules = DefaultRules()
+	}
+	salt := opts.Salt
+	if salt == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("generate salt: %w", err)
+		}
+		salt = hex.EncodeToString(buf)
+	}
+	r := &redactor{rules: rules, salt: salt}
+
+	b := &Bundle{
+		Addons: []Addon{},
+		files:  map[string][]byte{},
+	}
+	if !opts.Time.IsZero() {
+		t := opts.Time
+		b.Created = &t
+	}
+	fsys := os.DirFS(kpath)
+
+	if err := b.collectVersion(fsys, r); err != nil {
+		b.Errors = append(b.Errors, err.Error())
+	}
+	if err := b.collectAffiliate(fsys, r); err != nil {
+		b.Errors = append(b.Errors, err.Error())
+	}
+	if err := b.collectSettings(fsys, r); err != nil {
+		b.Errors = append(b.Errors, err.Error())
+	}
+	if err := b.collectDB(kpath); err != nil {
+		b.Errors = append(b.Errors, err.Error())
+	}
+	if err := b.collectAddons(fsys); err != nil {
+		b.Errors = append(b.Errors, err.Error())
+	}
+	if !opts.NoStorage {
+		if err := b.collectStorage(kpath, fsys); err != nil {
+			b.Errors = append(b.Errors, err.Error())
+		}
+	}
+
+	b.Redacted = dedupe(r.redacted)
+	return b, nil
+}
+
+func (b *Bundle) collectVersion(fsys fs.FS, r *redactor) error {
+	buf, err := fs.ReadFile(fsys, VersionPath)
+	if err != nil {
+		return err
+	}
+	spl := strings.Split(strings.TrimSpace(string(buf)), ",")
+	if len(spl) != 6 {
+		return fmt.Errorf("%s: expected 6 fields, got %d", VersionPath, len(spl))
+	}
+
+	serial, id := spl[0], spl[5]
+	b.Device.ID = id
+	b.Device.Firmware = spl[2]
+	if d, ok := kobo.DeviceByID(id); ok {
+		b.Device.Name = d.Name()
+		b.Device.Hardware = d.Hardware().String()
+	}
+	if s, err := kobo.CheckSerial(serial, id); err == nil || errors.Is(err, kobo.ErrSerialMismatch) {
+		b.Device.ModelNumber = s.Model
+		if err != nil {
+			b.Warnings = append(b.Warnings, err.Error())
+		}
+	} else if m := hashRe.FindStringSubmatch(serial); m != nil && m[1] != "" {
+		b.Device.ModelNumber = strings.TrimSuffix(m[1], "-") // from a redacted bundle
+	}
+
+	for i, k := range []string{"serial", "kernel", "firmware", "field3", "field4", "id"} {
+		spl[i] = r.value(path.Base(VersionPath), "", k, spl[i])
+	}
+	b.Device.Serial = spl[0]
+	b.files[VersionPath] = []byte(strings.Join(spl, ",") + "\n")
+	return nil
+}
+
+func (b *Bundle) collectAffiliate(fsys fs.FS, r *redactor) error {
+	c, err := readConf(fsys, AffiliatePath)
+	if err != nil {
+		return err
+	}
+	b.Affiliate = c.Affiliate()
+	buf, _ := redactConf(c, path.Base(AffiliatePath), r)
+	b.files[AffiliatePath] = buf
+	return nil
+}
+
+func (b *Bundle) collectSettings(fsys fs.FS, r *redactor) error {
+	c, err := readConf(fsys, SettingsPath)
+	if err != nil {
+		return err
+	}
+	buf, settings := redactConf(c, path.Base(SettingsPath), r)
+	b.Settings = settings
+	b.files[SettingsPath] = buf
+	return nil
+}
+
+// readConf reads a QSettings INI file (the affiliate.conf parser handles any
+// of them).
+func readConf(fsys fs.FS, name string) (*kobo.AffiliateConf, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := kobo.ParseAffiliateConf(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return c, nil
+}
+
+// redactConf redacts a config file in-place, returning the new file and the
+// settings.
+func redactConf(c *kobo.AffiliateConf, file string, r *redactor) ([]byte, map[string]map[string]string) {
+	settings := map[string]map[string]string{}
+	for _, section := range c.Sections() {
+		if settings[section] == nil {
+			settings[section] = map[string]string{}
+		}
+		for _, key := range c.Keys(section) {
+			v, _ := c.Get(section, key)
+			if nv := r.value(file, section, key, v); nv != v {
+				c.Set(section, key, nv)
+				v = nv
+			}
+			settings[section][key] = v
+		}
+	}
+	var buf bytes.Buffer
+	c.WriteTo(&buf)
+	return buf.Bytes(), settings
+}
+
+func (b *Bundle) collectDB(kpath string) error {
+	d, err := db.Open(kpath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", DatabasePath, errors.Unwrap(err)) // without the full path
+	}
+	defer d.Close()
+	b.DBVersion = d.Version()
+	return nil
+}
+
+func (b *Bundle) collectAddons(fsys fs.FS) error {
+	des, err := fs.ReadDir(fsys, ".adds")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, de := range des {
+		if !de.IsDir() || strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		a := Addon{
+			Name: de.Name(),
+			Path: path.Join(".adds", de.Name()),
+		}
+		if n, ok := addons[strings.ToLower(de.Name())]; ok {
+			a.Name = n
+		}
+		if buf, err := fs.ReadFile(fsys, path.Join(a.Path, "git-rev")); err == nil {
+			a.Version = strings.TrimSpace(string(buf))
+		}
+		b.Addons = append(b.Addons, a)
+	}
+	for _, p := range []string{".kobo/KoboRoot.tgz", ".kobo/KoboRoot.tar.gz"} {
+		if _, err := fs.Stat(fsys, p); err == nil {
+			b.Addons = append(b.Addons, Addon{
+				Name: "pending installation",
+				Path: p,
+			})
+		}
+	}
+	sort.SliceStable(b.Addons, func(i, j int) bool {
+		return b.Addons[i].Path < b.Addons[j].Path
+	})
+	return nil
+}
+
+func (b *Bundle) collectStorage(kpath string, fsys fs.FS) error {
+	s := &Storage{}
+	s.Total, s.Free, _ = internal.Statfs(kpath)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip unreadable files
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		switch top, _, _ := strings.Cut(p, "/"); {
+		case top == ".kobo":
+			s.Kobo += fi.Size()
+		case top == ".adds":
+			s.Addons += fi.Size()
+		case !strings.HasPrefix(path.Base(p), ".") && bookExts[strings.ToLower(path.Ext(p))]:
+			s.Books += fi.Size()
+			s.BookCount++
+		default:
+			s.Other += fi.Size()
+		}
+		return nil
+	})
+	b.Storage = s
+	return err
+}
+
+func dedupe(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	sort.Strings(s)
+	n := 1
+	for i := 1; i < len(s); i++ {
+		if s[i] != s[n-1] {
+			s[n] = s[i]
+			n++
+		}
+	}
+	return s[:n]
+}
+
+// Files returns the paths of the redacted files in the bundle, sorted.
+func (b *Bundle) Files() []string {
+	ps := make([]string, 0, len(b.files))
+	for p := range b.files {
+		ps = append(ps, p)
+	}
+	sort.Strings(ps)
+	return ps
+}
+
+// File returns the contents of a redacted file in the bundle.
+func (b *Bundle) File(name string) ([]byte, bool) {
+	buf, ok := b.files[name]
+	return buf, ok
+}
+
+// WriteJSON writes the bundle report as indented JSON.
+func (b *Bundle) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(b)
+}
+
+// zipTime is the modification time used for zip entries in reproducible
+// bundles.
+var zipTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// WriteZip writes the bundle as a zip file containing the JSON report and the
+// redacted files.
+func (b *Bundle) WriteZip(w io.Writer) error {
+	mt := zipTime
+	if b.Created != nil {
+		mt = *b.Created
+	}
+
+	zw := zip.NewWriter(w)
+	add := func(name string, buf []byte) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: mt,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(buf)
+		return err
+	}
+
+	var rep bytes.Buffer
+	if err := b.WriteJSON(&rep); err != nil {
+		return err
+	}
+	if err := add(BundlePath, rep.Bytes()); err != nil {
+		return err
+	}
+	for _, p := range b.Files() {
+		if err := add(p, b.files[p]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ReadZip reads a bundle written by WriteZip.
+func ReadZip(r io.ReaderAt, size int64) (*Bundle, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{files: map[string][]byte{}}
+	var found bool
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		buf, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", zf.Name, err)
+		}
+		if zf.Name == BundlePath {
+			if err := json.Unmarshal(buf, b); err != nil {
+				return nil, fmt.Errorf("read %s: %w", zf.Name, err)
+			}
+			found = true
+		} else {
+			b.files[zf.Name] = buf
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("missing %s", BundlePath)
+	}
+	return b, nil
+}
+
+// Extract writes the redacted files in the bundle to dir, which can then be
+// used as a device (e.g., for test fixtures).
+func (b *Bundle) Extract(dir string) error {
+	for _, p := range b.Files() {
+		if !fs.ValidPath(p) {
+			return fmt.Errorf("invalid path %q", p)
+		}
+		fn := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(fn), 0777); err != nil {
+			return err
+		}
+		if err := os.WriteFile(fn, b.files[p], 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
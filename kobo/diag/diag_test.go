@@ -0,0 +1,295 @@
+package diag
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSettings = `[ApplicationPreferences]
+SideloadedMode=true
+
+[OneStoreServices]
+api_endpoint=https://storeapi.kobo.com
+
+[user]
+UserDisplayName=Jane Doe
+UserEmail=jane@example.com
+UserID=8d5c9b2e-1f3a-4c6d-9e7b-0a1b2c3d4e5f
+UserKey=abcdef
+
+[Reading]
+lastReadNote=Emailed jane@example.com about N418210012345
+`
+
+// testDevice creates a fake device.
+func testDevice(t *testing.T) string {
+	t.Helper()
+	kpath := t.TempDir()
+	for name, data := range map[string]string{
+		".kobo/version":                "N418210012345,4.1.15,4.38.21908,4.1.15,4.1.15,00000000-0000-0000-0000-000000000388\n",
+		".kobo/affiliate.conf":         "[General]\naffiliate=Kobo\n",
+		".kobo/Kobo/Kobo eReader.conf": testSettings,
+		".kobo/KoboRoot.tgz":           "pending",
+		".adds/nm/config":              "menu_item:main:test:cmd_spawn:true\n",
+		".adds/koreader/git-rev":       "v2024.04\n",
+		"Books/Test.kepub.epub":        "kepub",
+		"Books/Other.pdf":              "pdf data",
+		"Books/.hidden.epub":           "x",
+		"notes.bin":                    "other",
+	} {
+		fn := filepath.Join(kpath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(kpath, ".kobo", "KoboReader.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE DbVersion (version INTEGER); INSERT INTO DbVersion VALUES (174); CREATE TABLE content (ContentID TEXT, ContentType TEXT, BookID TEXT, Title TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	return kpath
+}
+
+func TestCollect(t *testing.T) {
+	kpath := testDevice(t)
+
+	b, err := Collect(kpath, &Options{Salt: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(b.Errors) != 0 {
+		t.Errorf("unexpected errors: %v", b.Errors)
+	}
+
+	if b.Device.ModelNumber != "N418" || b.Device.Name != "Kobo Libra 2" || b.Device.Firmware != "4.38.21908" {
+		t.Errorf("unexpected device %+v", b.Device)
+	}
+	if !regexp.MustCompile(`^N418-\[HASH:[0-9a-f]{12}\]$`).MatchString(b.Device.Serial) {
+		t.Errorf("expected hashed serial, got %q", b.Device.Serial)
+	}
+	if b.Affiliate != "Kobo" {
+		t.Errorf("unexpected affiliate %q", b.Affiliate)
+	}
+	if b.DBVersion != 174 {
+		t.Errorf("unexpected db version %d", b.DBVersion)
+	}
+
+	u := b.Settings["user"]
+	if u["UserEmail"] != Redacted || u["UserDisplayName"] != Redacted || u["UserKey"] != Redacted {
+		t.Errorf("expected user info to be redacted, got %v", u)
+	}
+	if !strings.HasPrefix(u["UserID"], "[HASH:") {
+		t.Errorf("expected user id to be hashed, got %q", u["UserID"])
+	}
+	if v := b.Settings["Reading"]["lastReadNote"]; v != "Emailed "+Redacted+" about "+b.Device.Serial {
+		t.Errorf("expected email and serial in value to be redacted, got %q", v)
+	}
+	if v := b.Settings["OneStoreServices"]["api_endpoint"]; v != "https://storeapi.kobo.com" {
+		t.Errorf("expected api endpoint to be kept, got %q", v)
+	}
+	if v := b.Settings["ApplicationPreferences"]["SideloadedMode"]; v != "true" {
+		t.Errorf("expected setting to be kept, got %q", v)
+	}
+
+	if buf, _ := b.File(SettingsPath); bytes.Contains(buf, []byte("jane")) || bytes.Contains(buf, []byte("N418210012345")) {
+		t.Errorf("identifying information leaked into settings file:\n%s", buf)
+	} else if !bytes.Contains(buf, []byte("[OneStoreServices]\napi_endpoint=https://storeapi.kobo.com\n")) {
+		t.Errorf("expected settings file to be preserved:\n%s", buf)
+	}
+	if buf, _ := b.File(VersionPath); bytes.Contains(buf, []byte("N418210012345")) {
+		t.Errorf("serial leaked into version file: %s", buf)
+	}
+
+	if exp := []string{
+		"Kobo eReader.conf:Reading/lastReadNote",
+		"Kobo eReader.conf:user/UserDisplayName",
+		"Kobo eReader.conf:user/UserEmail",
+		"Kobo eReader.conf:user/UserID",
+		"Kobo eReader.conf:user/UserKey",
+		"version:serial",
+	}; !reflect.DeepEqual(b.Redacted, exp) {
+		t.Errorf("unexpected redacted fields %q", b.Redacted)
+	}
+
+	if exp := []Addon{
+		{Name: "KOReader", Path: ".adds/koreader", Version: "v2024.04"},
+		{Name: "NickelMenu", Path: ".adds/nm"},
+		{Name: "pending installation", Path: ".kobo/KoboRoot.tgz"},
+	}; !reflect.DeepEqual(b.Addons, exp) {
+		t.Errorf("unexpected addons %+v", b.Addons)
+	}
+
+	if s := b.Storage; s == nil {
+		t.Errorf("expected storage")
+	} else if s.BookCount != 2 || s.Books != int64(len("kepub")+len("pdf data")) || s.Addons == 0 || s.Kobo == 0 || s.Other != int64(len("x")+len("other")) {
+		t.Errorf("unexpected storage %+v", s)
+	}
+
+	// the salt should make hashes differ
+	if b2, err := Collect(kpath, &Options{Salt: "other", NoStorage: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if b2.Device.Serial == b.Device.Serial {
+		t.Errorf("expected different salt to produce a different hash")
+	} else if b2.Storage != nil {
+		t.Errorf("expected no storage")
+	}
+
+	// without a salt, a random one should be used for each bundle
+	if b1, err := Collect(kpath, &Options{NoStorage: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if b2, err := Collect(kpath, &Options{NoStorage: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if b1.Device.Serial == b2.Device.Serial || b1.Device.Serial == b.Device.Serial {
+		t.Errorf("expected a random salt to be used, got %q and %q", b1.Device.Serial, b2.Device.Serial)
+	}
+}
+
+func TestZip(t *testing.T) {
+	kpath := testDevice(t)
+	opts := &Options{Salt: "test", NoStorage: true}
+
+	var z1, z2 bytes.Buffer
+	for _, z := range []*bytes.Buffer{&z1, &z2} {
+		b, err := Collect(kpath, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := b.WriteZip(z); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !bytes.Equal(z1.Bytes(), z2.Bytes()) {
+		t.Errorf("expected bundle to be reproducible")
+	}
+
+	b, err := ReadZip(bytes.NewReader(z1.Bytes()), int64(z1.Len()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []string{SettingsPath, AffiliatePath, VersionPath}; !reflect.DeepEqual(b.Files(), exp) {
+		t.Errorf("unexpected files %q", b.Files())
+	}
+
+	// replay the bundle as a device
+	dir := t.TempDir()
+	if err := b.Extract(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rb, err := Collect(dir, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rb.Device != b.Device || rb.Affiliate != b.Affiliate || !reflect.DeepEqual(rb.Settings, b.Settings) {
+		t.Errorf("replayed bundle differs:\n%+v\n%+v", rb, b)
+	}
+	if len(rb.Redacted) != 0 {
+		t.Errorf("expected nothing to be redacted again, got %q", rb.Redacted)
+	}
+}
+
+func TestCreated(t *testing.T) {
+	kpath := testDevice(t)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	b, err := Collect(kpath, &Options{Time: ts, NoStorage: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := b.WriteJSON(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"created": "2024-05-01T12:00:00Z"`)) {
+		t.Errorf("expected created time in json:\n%s", buf.Bytes())
+	}
+}
+
+func TestRules(t *testing.T) {
+	kpath := testDevice(t)
+
+	keep, err := ParseRule("Kobo eReader.conf:user/UserEmail", ActionKeep)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	api, err := ParseRule("onestoreservices/api_*", ActionRedact)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := Collect(kpath, &Options{Rules: append([]Rule{keep, api}, DefaultRules()...), NoStorage: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := b.Settings["user"]["UserEmail"]; v != "jane@example.com" {
+		t.Errorf("expected email to be kept, got %q", v)
+	}
+	if v := b.Settings["OneStoreServices"]["api_endpoint"]; v != Redacted {
+		t.Errorf("expected api endpoint to be redacted, got %q", v)
+	}
+
+	b, err = Collect(kpath, &Options{Rules: []Rule{}, NoStorage: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Device.Serial != "N418210012345" || len(b.Redacted) != 0 {
+		t.Errorf("expected nothing to be redacted without rules")
+	}
+
+	for _, c := range []struct {
+		In  string
+		Out Rule
+		Err bool
+	}{
+		{In: "UserEmail", Out: Rule{Key: "UserEmail"}},
+		{In: "user/*", Out: Rule{Section: "user", Key: "*"}},
+		{In: "version:serial", Out: Rule{File: "version", Key: "serial"}},
+		{In: "Kobo eReader.conf:a/b/c", Out: Rule{File: "Kobo eReader.conf", Section: "a/b", Key: "c"}},
+		{In: "user/", Err: true},
+		{In: "[", Err: true},
+	} {
+		r, err := ParseRule(c.In, ActionRedact)
+		if c.Err {
+			if err == nil {
+				t.Errorf("%q: expected error", c.In)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.In, err)
+		} else if !reflect.DeepEqual(r, c.Out) {
+			t.Errorf("%q: expected %+v, got %+v", c.In, c.Out, r)
+		}
+	}
+}
+
+func TestCollectMissing(t *testing.T) {
+	kpath := t.TempDir()
+	if _, err := Collect(kpath, nil); err == nil {
+		t.Errorf("expected error for non-kobo")
+	}
+	if err := os.Mkdir(filepath.Join(kpath, ".kobo"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	b, err := Collect(kpath, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(b.Errors) != 4 {
+		t.Errorf("expected 4 errors, got %q", b.Errors)
+	}
+	for _, e := range b.Errors {
+		if strings.Contains(e, kpath) {
+			t.Errorf("error contains device path: %s", e)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package diag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+// Action is what to do with a value matched by a Rule.
+type Action int
+
+// Actions.
+const (
+	// ActionRedact replaces the value with Redacted.
+	ActionRedact Action = iota
+
+	// ActionHash replaces the value with a hash of it (salted with
+	// Options.Salt), so identical values can still be correlated within a
+	// bundle, or across bundles with the same salt. The model number prefix
+	// of serial numbers is kept.
+	ActionHash
+
+	// ActionKeep keeps the value as-is. It can be used to override later
+	// rules.
+	ActionKeep
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionRedact:
+		return "redact"
+	case ActionHash:
+		return "hash"
+	case ActionKeep:
+		return "keep"
+	default:
+		return fmt.Sprintf("Action(%d)", int(a))
+	}
+}
+
+// Redacted replaces values redacted by ActionRedact.
+const Redacted = "[REDACTED]"
+
+// Rule matches values to redact. The File, Section, and Key are
+// case-insensitive path.Match patterns, and empty ones match anything. Files
+// are identified by their base name (e.g., version, affiliate.conf, or Kobo
+// eReader.conf). The fields of the version file are serial, kernel, firmware,
+// field3, field4, and id.
+//
+// For each value, the first matching rule without a Value pattern applies to
+// the whole value, and the matching rules with one before it are applied to
+// the parts of the value they match.
+type Rule struct {
+	File    string
+	Section string
+	Key     string
+
+	// Value, if not nil, restricts the rule to values matching it, and only
+	// the matching parts of the value are replaced.
+	Value *regexp.Regexp
+
+	Action Action
+}
+
+// ParseRule parses a rule in the form [FILE:][SECTION/]KEY, where each part is
+// a pattern.
+func ParseRule(s string, a Action) (Rule, error) {
+	r := Rule{Action: a}
+	if f, rest, ok := strings.Cut(s, ":"); ok {
+		r.File, s = f, rest
+	}
+	if i := strings.LastIndex(s, "/"); i != -1 {
+		r.Section, s = s[:i], s[i+1:]
+	}
+	r.Key = s
+	for _, p := range []string{r.File, r.Section, r.Key} {
+		if _, err := path.Match(p, ""); err != nil {
+			return Rule{}, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	if r.Key == "" {
+		return Rule{}, fmt.Errorf("rule %q: key pattern must not be empty", s)
+	}
+	return r, nil
+}
+
+func (r Rule) String() string {
+	var b strings.Builder
+	b.WriteString(r.Action.String())
+	b.WriteString(" ")
+	if r.File != "" {
+		b.WriteString(r.File)
+		b.WriteString(":")
+	}
+	if r.Section != "" {
+		b.WriteString(r.Section)
+		b.WriteString("/")
+	}
+	if r.Key != "" {
+		b.WriteString(r.Key)
+	} else {
+		b.WriteString("*")
+	}
+	if r.Value != nil {
+		b.WriteString(" =~ ")
+		b.WriteString(r.Value.String())
+	}
+	return b.String()
+}
+
+var (
+	emailRe  = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
+	serialRe = regexp.MustCompile(`\bN[0-9]{3}[0-9A-Z]{7,12}\b`)
+	hashRe   = regexp.MustCompile(`^(N[0-9]{3}-)?\[HASH:[0-9a-f]{12}\]$`)
+)
+
+// DefaultRules returns the default redaction rules, which cover serial
+// numbers, account information, credentials, and email addresses.
+func DefaultRules() []Rule {
+	return []Rule{
+		{File: "version", Key: "serial", Action: ActionHash},
+		{Key: "*serial*", Action: ActionHash},
+		{Key: "userid", Action: ActionHash},
+		{Key: "*macaddress*", Action: ActionHash},
+		{Key: "*email*", Action: ActionRedact},
+		{Key: "*user*name*", Action: ActionRedact},
+		{Key: "*password*", Action: ActionRedact},
+		{Key: "*passwd*", Action: ActionRedact},
+		{Key: "*passphrase*", Action: ActionRedact},
+		{Key: "*psk*", Action: ActionRedact},
+		{Key: "*ssid*", Action: ActionRedact},
+		{Key: "*secret*", Action: ActionRedact},
+		{Key: "*token*", Action: ActionRedact},
+		{Key: "*userkey*", Action: ActionRedact},
+		{Key: "*cookie*", Action: ActionRedact},
+		{Value: emailRe, Action: ActionRedact},
+		{Value: serialRe, Action: ActionHash},
+	}
+}
+
+// redactor applies rules to values.
+type redactor struct {
+	rules    []Rule
+	salt     string
+	redacted []string
+}
+
+func match(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(s))
+	return ok
+}
+
+// value returns the redacted value, recording the field if it was changed. The
+// first matching rule without a value pattern applies to the whole value, but
+// every matching value pattern before it is applied.
+func (r *redactor) value(file, section, key, v string) string {
+	if v == "" || v == Redacted || hashRe.MatchString(v) {
+		return v // empty or already redacted
+	}
+	nv := v
+	for _, rule := range r.rules {
+		if !match(rule.File, file) || !match(rule.Section, section) || !match(rule.Key, key) {
+			continue
+		}
+		if rule.Value != nil {
+			if rule.Action != ActionKeep {
+				nv = rule.Value.ReplaceAllStringFunc(nv, func(s string) string {
+					return r.apply(rule.Action, s)
+				})
+			}
+			continue
+		}
+		if rule.Action != ActionKeep {
+			nv = r.apply(rule.Action, v)
+		}
+		break
+	}
+	if nv != v {
+		field := file + ":" + key
+		if section != "" {
+			field = file + ":" + section + "/" + key
+		}
+		r.redacted = append(r.redacted, field)
+	}
+	return nv
+}
+
+func (r *redactor) apply(a Action, v string) string {
+	switch a {
+	case ActionHash:
+		if hashRe.MatchString(v) {
+			return v
+		}
+		h := sha256.Sum256([]byte(r.salt + v))
+		x := "[HASH:" + hex.EncodeToString(h[:6]) + "]"
+		if s, err := kobo.ParseSerial(v); err == nil {
+			x = s.Model + "-" + x
+		}
+		return x
+	case ActionKeep:
+		return v
+	default:
+		return Redacted
+	}
+}
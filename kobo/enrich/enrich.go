@@ -0,0 +1,210 @@
+// Package enrich copies metadata which nickel doesn't import from sideloaded
+// EPUBs and kepubs (the series, series number, subtitle, and publisher) into
+// the library database.
+//
+// Nickel resets the metadata when it re-imports a book (e.g., after the file
+// is replaced), so the changes are always determined by comparing the
+// database against the books. Running it again re-applies the metadata to any
+// re-imported books, and does nothing for the others. Since nickel imports
+// books after the device is ejected, kobo-enrich --watch does this each time
+// the device is connected.
+package enrich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/epub"
+)
+
+// Field is a metadata field.
+type Field string
+
+// Fields.
+const (
+	FieldSubtitle     Field = "subtitle"
+	FieldPublisher    Field = "publisher"
+	FieldSeries       Field = "series"
+	FieldSeriesNumber Field = "series_number"
+)
+
+// Fields are all fields, in the order they are shown.
+var Fields = []Field{FieldSeries, FieldSeriesNumber, FieldSubtitle, FieldPublisher}
+
+// Column gets the content column for the field.
+func (f Field) Column() string {
+	switch f {
+	case FieldSubtitle:
+		return "Subtitle"
+	case FieldPublisher:
+		return "Publisher"
+	case FieldSeries:
+		return "Series"
+	case FieldSeriesNumber:
+		return "SeriesNumber"
+	}
+	return ""
+}
+
+// ParseField parses a field name.
+func ParseField(s string) (Field, error) {
+	for _, f := range Fields {
+		if strings.EqualFold(s, string(f)) || strings.EqualFold(s, f.Column()) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown field %q", s)
+}
+
+func (f Field) get(m *db.BookMetadata) *string {
+	switch f {
+	case FieldSubtitle:
+		return &m.Subtitle
+	case FieldPublisher:
+		return &m.Publisher
+	case FieldSeries:
+		return &m.Series
+	case FieldSeriesNumber:
+		return &m.SeriesNumber
+	}
+	panic("unknown field")
+}
+
+// Options configures Plan.
+type Options struct {
+	// Fields are the fields to update. If empty, all fields which exist in the
+	// database are updated.
+	Fields []Field
+}
+
+// Change is an update to the metadata of a book.
+type Change struct {
+	ContentID string
+	Path      string // slash-separated, relative to the root of the Kobo
+	Title     string
+	Old       db.BookMetadata
+	New       db.BookMetadata
+	Fields    []Field // the changed fields
+}
+
+// Result is the result of Plan.
+type Result struct {
+	Changes []Change // sorted by ContentID
+	Errors  []string // books which couldn't be read
+}
+
+// Plan reads the metadata of the sideloaded EPUBs and kepubs on the Kobo at
+// kpath, and compares it against the database. Fields are only set, never
+// cleared: if a book doesn't have a value for a field, it is left as-is.
+func Plan(ctx context.Context, kpath string, d *db.DB, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	fields := opts.Fields
+	if len(fields) == 0 {
+		for _, f := range Fields {
+			if d.HasColumn("content", f.Column()) {
+				fields = append(fields, f)
+			}
+		}
+	} else {
+		var columns []string
+		for _, f := range fields {
+			columns = append(columns, f.Column())
+		}
+		if err := d.CheckSchema(map[string][]string{"content": columns}); err != nil && !errors.Is(err, db.ErrUnknownVersion) {
+			return nil, err
+		}
+	}
+
+	bs, err := d.Books(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Result{}
+	for _, b := range bs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rel, ok := kobo.ContentIDToPath(b.ContentID)
+		if !ok || !isEPUB(rel) {
+			continue
+		}
+		m, err := epub.Open(filepath.Join(kpath, filepath.FromSlash(rel)))
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", rel, err))
+			}
+			continue
+		}
+
+		c := Change{
+			ContentID: b.ContentID,
+			Path:      rel,
+			Title:     b.Title,
+			Old:       b.Metadata(),
+			New:       b.Metadata(),
+		}
+		from := db.BookMetadata{
+			Subtitle:     m.Subtitle,
+			Publisher:    m.Publisher,
+			Series:       m.Series,
+			SeriesNumber: seriesNumber(m.SeriesIndex),
+		}
+		if from.Series == "" {
+			from.SeriesNumber = ""
+		}
+		for _, f := range fields {
+			if v := *f.get(&from); v != "" && v != *f.get(&c.Old) {
+				*f.get(&c.New) = v
+				c.Fields = append(c.Fields, f)
+			}
+		}
+		if len(c.Fields) != 0 {
+			r.Changes = append(r.Changes, c)
+		}
+	}
+	return r, nil
+}
+
+// Apply writes the changes.
+func Apply(ctx context.Context, tx *db.Tx, cs []Change) error {
+	for _, c := range cs {
+		if err := tx.SetBookMetadata(ctx, c.ContentID, c.New); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Diff describes the changes to a book, with one line per field.
+func (c Change) Diff() []string {
+	var ls []string
+	for _, f := range c.Fields {
+		ls = append(ls, fmt.Sprintf("%s: %q -> %q", f, *f.get(&c.Old), *f.get(&c.New)))
+	}
+	return ls
+}
+
+// seriesNumber normalizes a series index like nickel does for store books
+// (e.g., calibre writes 1.0 for 1).
+func seriesNumber(s string) string {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return s
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// isEPUB checks if the file is an EPUB or kepub.
+func isEPUB(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".epub")
+}
@@ -0,0 +1,125 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/db/dbtest"
+	"github.com/pgaskin/koboutils/v2/kobo/epub/epubtest"
+)
+
+const emma = "file:///mnt/onboard/Books/Emma.epub"
+
+func TestEnrich(t *testing.T) {
+	kpath := dbtest.NewDevice(t, dbtest.Recent,
+		`INSERT INTO content (ContentID, ContentType, MimeType, Title, ___UserID) VALUES ('file:///mnt/onboard/Books/Broken.epub', 6, 'application/epub+zip', 'Broken', 'adobe_user')`,
+	)
+	epubtest.Write(t, filepath.Join(kpath, "Books", "Dune.kepub.epub"), `
+<dc:title>Dune</dc:title>
+<dc:publisher>Ace</dc:publisher>
+<meta name="calibre:series" content="Dune"/>
+<meta name="calibre:series_index" content="1.0"/>`)
+	epubtest.Write(t, filepath.Join(kpath, "Books", "Emma.epub"), `
+<dc:title id="t1">Emma</dc:title>
+<dc:title id="t2">A Novel</dc:title>
+<meta refines="#t2" property="title-type">subtitle</meta>
+<dc:publisher>Penguin</dc:publisher>
+<meta property="belongs-to-collection" id="c1">Novels</meta>
+<meta refines="#c1" property="group-position">4.0</meta>`)
+	if err := os.WriteFile(filepath.Join(kpath, "Books", "Broken.epub"), []byte("test"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	d := dbtest.Open(t, kpath)
+	r, err := Plan(context.Background(), kpath, d, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.Errors) != 1 {
+		t.Errorf("expected an error for the invalid epub, got %q", r.Errors)
+	}
+	if exp := []Change{{
+		ContentID: emma,
+		Path:      "Books/Emma.epub",
+		Title:     "Emma",
+		New:       db.BookMetadata{Subtitle: "A Novel", Publisher: "Penguin", Series: "Novels", SeriesNumber: "4"},
+		Fields:    []Field{FieldSeries, FieldSeriesNumber, FieldSubtitle, FieldPublisher},
+	}}; !reflect.DeepEqual(r.Changes, exp) {
+		t.Fatalf("expected changes:\n%+v\ngot:\n%+v", exp, r.Changes)
+	}
+	if exp := []string{`series: "" -> "Novels"`, `series_number: "" -> "4"`, `subtitle: "" -> "A Novel"`, `publisher: "" -> "Penguin"`}; !reflect.DeepEqual(r.Changes[0].Diff(), exp) {
+		t.Errorf("expected diff %q, got %q", exp, r.Changes[0].Diff())
+	}
+
+	apply := func(cs []Change) {
+		t.Helper()
+		if _, err := db.Update(context.Background(), kpath, &db.UpdateOptions{BackupDir: t.TempDir()}, func(ctx context.Context, tx *db.Tx) error {
+			return Apply(ctx, tx, cs)
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	apply(r.Changes)
+
+	fn := filepath.Join(kpath, filepath.FromSlash(db.Path))
+	if v := dbtest.Query(t, fn, `SELECT Series || '|' || SeriesID || '|' || SeriesNumber || '|' || SeriesNumberFloat || '|' || Subtitle || '|' || Publisher FROM content WHERE ContentID = ?`, emma); !reflect.DeepEqual(v, []string{"Novels|Novels|4|4.0|A Novel|Penguin"}) {
+		t.Errorf("unexpected metadata %q", v)
+	}
+	if r, err := Plan(context.Background(), kpath, d, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(r.Changes) != 0 {
+		t.Errorf("expected no changes after applying, got %+v", r.Changes)
+	}
+
+	// nickel resets the metadata when re-importing a book
+	dbtest.Exec(t, fn, `UPDATE content SET Series = NULL, SeriesID = NULL, SeriesNumber = NULL, SeriesNumberFloat = NULL WHERE ContentID = '`+emma+`'`)
+	if r, err = Plan(context.Background(), kpath, d, &Options{Fields: []Field{FieldSeriesNumber}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.Changes) != 1 || !reflect.DeepEqual(r.Changes[0].Fields, []Field{FieldSeriesNumber}) || r.Changes[0].New.Series != "" {
+		t.Fatalf("expected only the series number to be re-applied, got %+v", r.Changes)
+	}
+	if r, err = Plan(context.Background(), kpath, d, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.Changes) != 1 || !reflect.DeepEqual(r.Changes[0].Fields, []Field{FieldSeries, FieldSeriesNumber}) {
+		t.Fatalf("expected the series to be re-applied, got %+v", r.Changes)
+	}
+	apply(r.Changes)
+	if v := dbtest.Query(t, fn, `SELECT Series || '|' || SeriesID || '|' || SeriesNumber || '|' || SeriesNumberFloat FROM content WHERE ContentID = ?`, emma); !reflect.DeepEqual(v, []string{"Novels|Novels|4|4.0"}) {
+		t.Errorf("unexpected metadata %q", v)
+	}
+}
+
+func TestEnrichLegacy(t *testing.T) {
+	kpath := dbtest.NewDevice(t, dbtest.Legacy)
+	d := dbtest.Open(t, kpath)
+	if _, err := Plan(context.Background(), kpath, d, &Options{Fields: []Field{FieldSubtitle}}); !errors.Is(err, db.ErrUnsupportedSchema) {
+		t.Errorf("expected unsupported schema error, got %v", err)
+	}
+	if _, err := Plan(context.Background(), kpath, d, nil); err != nil {
+		t.Errorf("expected missing columns to be skipped, got %v", err)
+	}
+}
+
+func TestParseField(t *testing.T) {
+	for _, c := range []struct {
+		In  string
+		Out Field
+	}{
+		{"series", FieldSeries},
+		{"SeriesNumber", FieldSeriesNumber},
+		{"series_number", FieldSeriesNumber},
+		{"Subtitle", FieldSubtitle},
+		{"title", ""},
+	} {
+		if f, err := ParseField(c.In); f != c.Out || (err == nil) != (c.Out != "") {
+			t.Errorf("%q: expected %q, got %q (err: %v)", c.In, c.Out, f, err)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Package epub reads the metadata of EPUB (and kepub) files.
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Metadata is the metadata from the package document (OPF) of an EPUB.
+type Metadata struct {
+	Title       string
+	Subtitle    string // from an EPUB3 title with the subtitle title-type
+	Creators    []string
+	Publisher   string
+	Description string
+	Language    string
+	Date        string
+	Identifiers []Identifier
+	Subjects    []string // e.g., calibre tags
+	Series      string   // from calibre:series or belongs-to-collection
+	SeriesIndex string
+	Cover       string // path of the cover image in the EPUB, if any
+}
+
+// Identifier is a dc:identifier.
+type Identifier struct {
+	Scheme string // e.g., ISBN, UUID, calibre (may be empty)
+	Value  string
+}
+
+// ISBN returns the first ISBN identifier, if any.
+func (m *Metadata) ISBN() string {
+	for _, id := range m.Identifiers {
+		if strings.EqualFold(id.Scheme, "isbn") {
+			return id.Value
+		}
+		if v := strings.TrimPrefix(strings.ToLower(id.Value), "urn:isbn:"); v != strings.ToLower(id.Value) {
+			return id.Value[len(id.Value)-len(v):]
+		}
+	}
+	return ""
+}
+
+// ErrNotEPUB is returned if a file isn't a valid EPUB.
+var ErrNotEPUB = errors.New("not an epub")
+
+// Open reads the metadata of the EPUB at name.
+func Open(name string) (*Metadata, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return Read(f, fi.Size())
+}
+
+// Read reads the metadata of an EPUB.
+func Read(r io.ReaderAt, size int64) (*Metadata, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotEPUB, err)
+	}
+
+	var container struct {
+		Rootfiles []struct {
+			FullPath  string `xml:"full-path,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := decodeFile(zr, "META-INF/container.xml", &container); err != nil {
+		return nil, fmt.Errorf("%w: read container: %v", ErrNotEPUB, err)
+	}
+	var opf string
+	for _, rf := range container.Rootfiles {
+		if rf.MediaType == "" || rf.MediaType == "application/oebps-package+xml" {
+			opf = rf.FullPath
+			break
+		}
+	}
+	if opf == "" {
+		return nil, fmt.Errorf("%w: no package document", ErrNotEPUB)
+	}
+
+	var pkg opfPackage
+	if err := decodeFile(zr, opf, &pkg); err != nil {
+		return nil, fmt.Errorf("%w: read package document: %v", ErrNotEPUB, err)
+	}
+	return pkg.metadata(path.Dir(opf)), nil
+}
+
+// decodeFile decodes the XML file at name in the zip.
+func decodeFile(zr *zip.Reader, name string, v any) error {
+	for _, zf := range zr.File {
+		if zf.Name != name && !strings.EqualFold(zf.Name, name) {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		d := xml.NewDecoder(rc)
+		d.Strict = false
+		d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+			return input, nil // almost always utf-8 anyways
+		}
+		return d.Decode(v)
+	}
+	return fmt.Errorf("%s: %w", name, os.ErrNotExist)
+}
+
+type opfPackage struct {
+	Metadata struct {
+		Titles []struct {
+			ID    string `xml:"id,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"title"`
+		Creators     []string `xml:"creator"`
+		Publishers   []string `xml:"publisher"`
+		Descriptions []string `xml:"description"`
+		Languages    []string `xml:"language"`
+		Dates        []string `xml:"date"`
+		Subjects     []string `xml:"subject"`
+		Identifiers  []struct {
+			ID     string `xml:"id,attr"`
+			Scheme string `xml:"scheme,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"identifier"`
+		Meta []struct {
+			Name     string `xml:"name,attr"`
+			Content  string `xml:"content,attr"`
+			Property string `xml:"property,attr"`
+			Refines  string `xml:"refines,attr"`
+			ID       string `xml:"id,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+}
+
+func (p *opfPackage) metadata(dir string) *Metadata {
+	m := &Metadata{
+		Publisher:   first(p.Metadata.Publishers),
+		Description: first(p.Metadata.Descriptions),
+		Language:    first(p.Metadata.Languages),
+		Date:        first(p.Metadata.Dates),
+	}
+	for _, c := range p.Metadata.Creators {
+		if c = clean(c); c != "" {
+			m.Creators = append(m.Creators, c)
+		}
+	}
+	seen := map[string]bool{}
+	for _, s := range p.Metadata.Subjects {
+		if s = clean(s); s != "" && !seen[strings.ToLower(s)] {
+			seen[strings.ToLower(s)] = true
+			m.Subjects = append(m.Subjects, s)
+		}
+	}
+	for _, id := range p.Metadata.Identifiers {
+		if v := clean(id.Value); v != "" {
+			m.Identifiers = append(m.Identifiers, Identifier{Scheme: clean(id.Scheme), Value: v})
+		}
+	}
+
+	titleTypes := map[string]string{}
+	for _, x := range p.Metadata.Meta {
+		if x.Property == "title-type" && strings.HasPrefix(x.Refines, "#") {
+			titleTypes[x.Refines[1:]] = clean(x.Value)
+		}
+	}
+	var hasMain bool
+	for _, t := range p.Metadata.Titles {
+		v := clean(t.Value)
+		if v == "" {
+			continue
+		}
+		switch titleTypes[t.ID] {
+		case "subtitle":
+			if m.Subtitle == "" {
+				m.Subtitle = v
+			}
+		case "main":
+			if !hasMain {
+				m.Title, hasMain = v, true // takes precedence over untyped titles
+			}
+		default:
+			if m.Title == "" {
+				m.Title = v
+			}
+		}
+	}
+
+	// the series name and index are both taken from calibre's metadata if
+	// present, or from the first EPUB3 collection otherwise
+	var (
+		coverID                       string
+		calibreSeries, calibreIndex   string
+		collection, collectionID, pos string
+	)
+	for _, x := range p.Metadata.Meta {
+		switch {
+		case x.Name == "calibre:series":
+			calibreSeries = clean(x.Content)
+		case x.Name == "calibre:series_index":
+			calibreIndex = clean(x.Content)
+		case x.Name == "cover":
+			coverID = x.Content
+		case x.Property == "belongs-to-collection" && x.Refines == "" && collection == "":
+			collection, collectionID = clean(x.Value), x.ID
+		}
+	}
+	if collectionID != "" {
+		for _, x := range p.Metadata.Meta {
+			if x.Property == "group-position" && x.Refines == "#"+collectionID {
+				pos = clean(x.Value)
+			}
+		}
+	}
+	if calibreSeries != "" {
+		m.Series, m.SeriesIndex = calibreSeries, calibreIndex
+	} else {
+		m.Series, m.SeriesIndex = collection, pos
+	}
+
+	for _, it := range p.Manifest {
+		if strings.Contains(" "+it.Properties+" ", " cover-image ") || (coverID != "" && it.ID == coverID) {
+			m.Cover = path.Join(dir, it.Href)
+			break
+		}
+	}
+	return m
+}
+
+// first returns the first non-empty value.
+func first(vs []string) string {
+	for _, v := range vs {
+		if v = clean(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// clean collapses whitespace in s.
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
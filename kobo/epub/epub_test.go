@@ -0,0 +1,122 @@
+package epub
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pgaskin/koboutils/v2/kobo/epub/epubtest"
+)
+
+func TestOpen(t *testing.T) {
+	for _, c := range []struct {
+		What     string
+		Metadata string
+		Out      Metadata
+	}{
+		{"calibre", `
+    <dc:title>Dune</dc:title>
+    <dc:creator opf:role="aut">Frank Herbert</dc:creator>
+    <dc:publisher>Ace</dc:publisher>
+    <dc:language>en</dc:language>
+    <dc:identifier opf:scheme="ISBN">9780441013593</dc:identifier>
+    <dc:identifier id="uid" opf:scheme="uuid">1234</dc:identifier>
+    <dc:subject>Science Fiction</dc:subject>
+    <dc:subject>  Classics </dc:subject>
+    <dc:subject>science fiction</dc:subject>
+    <meta name="calibre:series" content="Dune"/>
+    <meta name="calibre:series_index" content="1.0"/>`, Metadata{
+			Title:       "Dune",
+			Creators:    []string{"Frank Herbert"},
+			Publisher:   "Ace",
+			Language:    "en",
+			Identifiers: []Identifier{{"ISBN", "9780441013593"}, {"uuid", "1234"}},
+			Subjects:    []string{"Science Fiction", "Classics"},
+			Series:      "Dune",
+			SeriesIndex: "1.0",
+			Cover:       "OEBPS/cover.jpg",
+		}},
+		{"epub3", `
+    <dc:title>Emma</dc:title>
+    <dc:creator>Jane Austen</dc:creator>
+    <dc:identifier id="uid">urn:isbn:9780141439587</dc:identifier>
+    <meta property="belongs-to-collection" id="c1">Novels</meta>
+    <meta refines="#c1" property="collection-type">series</meta>
+    <meta refines="#c1" property="group-position">4</meta>`, Metadata{
+			Title:       "Emma",
+			Creators:    []string{"Jane Austen"},
+			Identifiers: []Identifier{{"", "urn:isbn:9780141439587"}},
+			Series:      "Novels",
+			SeriesIndex: "4",
+			Cover:       "OEBPS/cover.jpg",
+		}},
+		{"both", `
+    <dc:title>Emma</dc:title>
+    <meta property="belongs-to-collection" id="c1">Novels</meta>
+    <meta refines="#c1" property="group-position">4</meta>
+    <meta name="calibre:series" content="Austen"/>
+    <meta name="calibre:series_index" content="2"/>`, Metadata{
+			Title:       "Emma",
+			Series:      "Austen",
+			SeriesIndex: "2",
+			Cover:       "OEBPS/cover.jpg",
+		}},
+		{"both without index", `
+    <dc:title>Emma</dc:title>
+    <meta property="belongs-to-collection" id="c1">Novels</meta>
+    <meta refines="#c1" property="group-position">4</meta>
+    <meta name="calibre:series" content="Austen"/>`, Metadata{
+			Title:  "Emma",
+			Series: "Austen",
+			Cover:  "OEBPS/cover.jpg",
+		}},
+		{"subtitle", `
+    <dc:title id="t2">A Novel</dc:title>
+    <dc:title id="t1">Emma</dc:title>
+    <meta refines="#t1" property="title-type">main</meta>
+    <meta refines="#t2" property="title-type">subtitle</meta>`, Metadata{
+			Title:    "Emma",
+			Subtitle: "A Novel",
+			Cover:    "OEBPS/cover.jpg",
+		}},
+	} {
+		t.Run(c.What, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), "book.epub")
+			epubtest.Write(t, fn, c.Metadata)
+			m, err := Open(fn)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(*m, c.Out) {
+				t.Errorf("expected:\n%+v\ngot:\n%+v", c.Out, *m)
+			}
+		})
+	}
+}
+
+func TestISBN(t *testing.T) {
+	for _, c := range []struct {
+		IDs []Identifier
+		Out string
+	}{
+		{nil, ""},
+		{[]Identifier{{"uuid", "1"}, {"ISBN", "123"}}, "123"},
+		{[]Identifier{{"", "URN:ISBN:456"}}, "456"},
+	} {
+		if v := (&Metadata{Identifiers: c.IDs}).ISBN(); v != c.Out {
+			t.Errorf("%v: expected %q, got %q", c.IDs, c.Out, v)
+		}
+	}
+}
+
+func TestNotEPUB(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(fn, []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(fn); !errors.Is(err, ErrNotEPUB) {
+		t.Errorf("expected not epub error, got %v", err)
+	}
+}
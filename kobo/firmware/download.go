@@ -0,0 +1,357 @@
+package firmware
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+// ErrVerify is wrapped by errors returned when a downloaded file does not
+// match what was expected.
+var ErrVerify = errors.New("verification failed")
+
+// Downloader downloads firmware update packages. The zero value is ready to
+// use.
+type Downloader struct {
+	// HTTPClient is used to make requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Progress, if not nil, is called as the download progresses with the
+	// number of bytes written to the destination so far (including any
+	// previously downloaded part) and the total size, or -1 if unknown.
+	Progress func(done, total int64)
+
+	// ReadTemp is passed to Parse when inspecting the package. If nil,
+	// ReadTempDirAuto("") is used.
+	ReadTemp func(r io.Reader) (io.ReaderAt, error)
+}
+
+// Download describes a firmware download.
+type Download struct {
+	// URL is the URL of the update package.
+	URL string
+
+	// Dest is the destination path. If it is empty or an existing directory,
+	// the filename from the URL is used. Data is downloaded to Dest with a
+	// .part suffix, and is resumed from there if it already exists (or
+	// restarted if it can't be resumed). It is only renamed to Dest after being
+	// verified.
+	Dest string
+
+	// Size, if positive, is the expected size in bytes.
+	Size int64
+
+	// Hash, if not empty, is the expected hash in the form algorithm:hex,
+	// where algorithm is one of md5, sha1, or sha256.
+	Hash string
+
+	// Version, if not zero, is the version which the package must contain. If
+	// zero, the version is taken from the URL filename if present.
+	Version kobo.Version
+
+	// NoInspect disables parsing the downloaded update package.
+	NoInspect bool
+}
+
+// DownloadResult contains information about a completed download.
+type DownloadResult struct {
+	Path    string
+	Size    int64
+	SHA256  string
+	Resumed bool
+	Package Package // zero if not inspected
+}
+
+// UpgradeDownload returns a Download for the package in an upgrade check
+// result.
+func UpgradeDownload(res *kobo.UpgradeCheckResult, dest string) (Download, error) {
+	if res == nil || !res.UpgradeType.IsUpdate() || res.UpgradeURL == "" {
+		return Download{}, errors.New("no upgrade available")
+	}
+	dl := Download{
+		URL:  res.UpgradeURL,
+		Dest: dest,
+	}
+	if v, err := kobo.ParseVersion(res.ParseVersion()); err == nil {
+		dl.Version = v
+	}
+	return dl, nil
+}
+
+var updateFilenameRe = regexp.MustCompile(`^kobo-update-([0-9]+\.[0-9]+\.[0-9]+)\.zip$`)
+
+// Download downloads, verifies, and inspects a firmware update package.
+func (d *Downloader) Download(ctx context.Context, dl Download) (*DownloadResult, error) {
+	u, err := url.Parse(dl.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+
+	var hashAlg, hashSum string
+	if dl.Hash != "" {
+		var ok bool
+		if hashAlg, hashSum, ok = strings.Cut(dl.Hash, ":"); !ok || newHash(hashAlg) == nil {
+			return nil, fmt.Errorf("invalid hash %q", dl.Hash)
+		}
+		hashSum = strings.ToLower(hashSum)
+	}
+
+	expVersion := dl.Version
+	if expVersion.IsZero() {
+		if m := updateFilenameRe.FindStringSubmatch(path.Base(u.Path)); m != nil {
+			expVersion, _ = kobo.ParseVersion(m[1])
+		}
+	}
+
+	dest := dl.Dest
+	if fi, err := os.Stat(dest); dest == "" || (err == nil && fi.IsDir()) {
+		name := path.Base(u.Path)
+		if name == "" || name == "." || name == "/" {
+			return nil, fmt.Errorf("no filename in url %q", dl.URL)
+		}
+		dest = filepath.Join(dest, name)
+	}
+	part := dest + ".part"
+
+	res := &DownloadResult{Path: dest}
+	if res.Resumed, err = d.fetch(ctx, dl.URL, part); err != nil {
+		return res, err
+	}
+
+	if err := func() error {
+		f, err := os.Open(part)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		res.Size = fi.Size()
+
+		if dl.Size > 0 && res.Size != dl.Size {
+			return fmt.Errorf("%w: expected size %d, got %d", ErrVerify, dl.Size, res.Size)
+		}
+
+		hs := sha256.New()
+		hw := []io.Writer{hs}
+		var hx hash.Hash
+		if hashAlg != "" {
+			hx = newHash(hashAlg)
+			hw = append(hw, hx)
+		}
+		if _, err := io.Copy(io.MultiWriter(hw...), f); err != nil {
+			return fmt.Errorf("hash: %w", err)
+		}
+		res.SHA256 = hex.EncodeToString(hs.Sum(nil))
+
+		if hx != nil {
+			if sum := hex.EncodeToString(hx.Sum(nil)); sum != hashSum {
+				return fmt.Errorf("%w: expected %s %s, got %s", ErrVerify, hashAlg, hashSum, sum)
+			}
+		}
+
+		if !dl.NoInspect {
+			z, err := zip.NewReader(f, res.Size)
+			if err != nil {
+				return fmt.Errorf("%w: read update package: %v", ErrVerify, err)
+			}
+
+			readTemp := d.ReadTemp
+			if readTemp == nil {
+				readTemp = ReadTempDirAuto("")
+			}
+
+			if res.Package, err = Parse(z, readTemp); err != nil {
+				return fmt.Errorf("%w: parse update package: %v", ErrVerify, err)
+			}
+			if res.Package.Format == PackageFormatUnknown {
+				return fmt.Errorf("%w: unknown update package format", ErrVerify)
+			}
+			if !expVersion.IsZero() && res.Package.Version.Compare(expVersion) != 0 {
+				return fmt.Errorf("%w: expected version %s, got %s", ErrVerify, expVersion, res.Package.Version)
+			}
+		}
+		return nil
+	}(); err != nil {
+		if errors.Is(err, ErrVerify) {
+			os.Remove(part) // don't try to resume from a bad file
+		}
+		return res, err
+	}
+
+	if err := os.Rename(part, dest); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// fetch downloads url to the specified file, resuming the download if it
+// already exists. If it can't be resumed (e.g., it is larger than the remote
+// file), the download is restarted.
+func (d *Downloader) fetch(ctx context.Context, url, name string) (resumed bool, err error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	off, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+
+	c := d.HTTPClient
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	var resp *http.Response
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return false, err
+		}
+		if off > 0 {
+			req.Header.Set("Range", "bytes="+strconv.FormatInt(off, 10)+"-")
+		}
+		if resp, err = c.Do(req); err != nil {
+			return false, err
+		}
+		if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || off == 0 {
+			break
+		}
+		resp.Body.Close()
+
+		if _, size, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && size == off {
+			if d.Progress != nil {
+				d.Progress(off, off)
+			}
+			return true, nil // already complete
+		}
+		if err := f.Truncate(0); err != nil {
+			return false, err
+		}
+		if off, err = f.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+	}
+	defer resp.Body.Close()
+
+	total := int64(-1)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if off != 0 {
+			if err := f.Truncate(0); err != nil {
+				return false, err
+			}
+			if off, err = f.Seek(0, io.SeekStart); err != nil {
+				return false, err
+			}
+		}
+		if resp.ContentLength >= 0 {
+			total = resp.ContentLength
+		}
+	case http.StatusPartialContent:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != off {
+			return false, fmt.Errorf("unexpected content range %q for offset %d", resp.Header.Get("Content-Range"), off)
+		}
+		total, resumed = size, true
+	default:
+		return false, fmt.Errorf("response status %d", resp.StatusCode)
+	}
+
+	var w io.Writer = f
+	if d.Progress != nil {
+		d.Progress(off, total)
+		w = &progressWriter{w: f, n: off, total: total, fn: d.Progress}
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return resumed, fmt.Errorf("download: %w", err)
+	}
+	return resumed, nil
+}
+
+// parseContentRange parses a Content-Range header in the form
+// "bytes start-end/size" or "bytes */size". If the size is unknown, it will be
+// -1.
+func parseContentRange(s string) (start, size int64, ok bool) {
+	s, ok = strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, false
+	}
+	rng, sz, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, false
+	}
+	if sz == "*" {
+		size = -1
+	} else if n, err := strconv.ParseInt(sz, 10, 64); err == nil {
+		size = n
+	} else {
+		return 0, 0, false
+	}
+	if rng == "*" {
+		return -1, size, true
+	}
+	a, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	n, err := strconv.ParseInt(a, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return n, size, true
+}
+
+func newHash(alg string) hash.Hash {
+	switch alg {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// progressWriter calls fn after every write.
+type progressWriter struct {
+	w     io.Writer
+	n     int64
+	total int64
+	fn    func(done, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.n += int64(n)
+	p.fn(p.n, p.total)
+	return n, err
+}
@@ -0,0 +1,162 @@
+package firmware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/kobotest"
+)
+
+func TestDownload(t *testing.T) {
+	s := kobotest.NewServer()
+	defer s.Close()
+
+	date := time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)
+	buf, err := kobotest.FirmwareZip("4.36.21095", date)
+	if err != nil {
+		t.Fatalf("generate firmware: %v", err)
+	}
+	sum := sha256.Sum256(buf)
+
+	good := s.AddFile("/firmwares/kobo7/Apr2023/kobo-update-4.36.21095.zip", buf, date)
+	wrong := s.AddFile("/firmwares/kobo7/Apr2023/kobo-update-4.37.21582.zip", buf, date)
+
+	t.Run("Full", func(t *testing.T) {
+		td := t.TempDir()
+
+		var last int64
+		d := &Downloader{
+			HTTPClient: s.Client(),
+			Progress: func(done, total int64) {
+				if total != int64(len(buf)) {
+					t.Errorf("progress: expected total %d, got %d", len(buf), total)
+				}
+				last = done
+			},
+		}
+		res, err := d.Download(context.Background(), Download{
+			URL:  good,
+			Dest: td,
+			Size: int64(len(buf)),
+			Hash: "sha256:" + hex.EncodeToString(sum[:]),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if last != int64(len(buf)) {
+			t.Errorf("progress: expected final %d, got %d", len(buf), last)
+		}
+		if res.Path != filepath.Join(td, "kobo-update-4.36.21095.zip") {
+			t.Errorf("unexpected path %q", res.Path)
+		}
+		if res.Resumed {
+			t.Errorf("expected download not to be resumed")
+		}
+		if res.Package.Format != PackageFormatKoboRoot || res.Package.Version.String() != "4.36.21095" {
+			t.Errorf("unexpected package %s", res.Package)
+		}
+		if _, err := os.Stat(res.Path + ".part"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected part file to be removed")
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "fw.zip")
+		if err := os.WriteFile(dest+".part", buf[:100], 0666); err != nil {
+			t.Fatal(err)
+		}
+		res, err := (&Downloader{HTTPClient: s.Client()}).Download(context.Background(), Download{
+			URL:  good,
+			Dest: dest,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !res.Resumed {
+			t.Errorf("expected download to be resumed")
+		}
+		if res.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("incorrect hash after resume")
+		}
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "fw.zip")
+		if err := os.WriteFile(dest+".part", buf, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (&Downloader{HTTPClient: s.Client()}).Download(context.Background(), Download{
+			URL:  good,
+			Dest: dest,
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Larger", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "fw.zip")
+		if err := os.WriteFile(dest+".part", append(append([]byte(nil), buf...), "garbage"...), 0666); err != nil {
+			t.Fatal(err)
+		}
+		res, err := (&Downloader{HTTPClient: s.Client()}).Download(context.Background(), Download{
+			URL:  good,
+			Dest: dest,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if res.Resumed {
+			t.Errorf("expected download to be restarted")
+		}
+		if res.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("incorrect hash after restart")
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		dl   Download
+	}{
+		{"BadSize", Download{URL: good, Size: 1}},
+		{"BadHash", Download{URL: good, Hash: "md5:00000000000000000000000000000000"}},
+		{"BadVersion", Download{URL: good, Version: kobo.Version{Major: 4, Minor: 1, Patch: 1}}},
+		{"BadURLVersion", Download{URL: wrong}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.dl.Dest = t.TempDir()
+			res, err := (&Downloader{HTTPClient: s.Client()}).Download(context.Background(), tc.dl)
+			if !errors.Is(err, ErrVerify) {
+				t.Fatalf("expected verification error, got %v", err)
+			}
+			if _, err := os.Stat(res.Path + ".part"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected part file to be removed")
+			}
+			if _, err := os.Stat(res.Path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected destination not to exist")
+			}
+		})
+	}
+}
+
+func TestUpgradeDownload(t *testing.T) {
+	if _, err := UpgradeDownload(&kobo.UpgradeCheckResult{}, ""); err == nil {
+		t.Errorf("expected error for no upgrade")
+	}
+	dl, err := UpgradeDownload(&kobo.UpgradeCheckResult{
+		UpgradeType: kobo.UpgradeTypeAvailable,
+		UpgradeURL:  "https://example.com/firmwares/kobo7/Apr2023/kobo-update-4.36.21095.zip",
+	}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dl.Version.String() != "4.36.21095" {
+		t.Errorf("unexpected version %s", dl.Version)
+	}
+}
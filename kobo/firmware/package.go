@@ -1,4 +1,5 @@
-package main
+// Package firmware inspects and downloads Kobo firmware update packages.
+package firmware
 
 import (
 	"archive/tar"
@@ -14,12 +15,13 @@ import (
 	"time"
 
 	"github.com/klauspost/compress/gzip"
+	"github.com/pgaskin/koboutils/v2/kobo"
 )
 
 // Package contains metadata for a firmware update package.
 type Package struct {
 	Format   PackageFormat
-	Version  Version
+	Version  kobo.Version
 	Branch   string
 	Revision string
 	Date     time.Time
@@ -29,7 +31,7 @@ type Package struct {
 type PackageFormat int
 
 const (
-	PackageFormatUnknown = iota
+	PackageFormatUnknown PackageFormat = iota
 
 	// PackageFormatKobo is an update distributed as a Kobo.tgz.
 	//
@@ -394,8 +396,8 @@ func (p *Package) parseGeneric(z fs.FS, readTemp func(r io.Reader) (io.ReaderAt,
 
 func (p *Package) parse(handler func(fsDate func(t time.Time), push func(filename string, r io.Reader) error) error) error {
 	var (
-		verSW      Version
-		verNickel  Version
+		verSW      kobo.Version
+		verNickel  kobo.Version
 		dateFS     time.Time
 		dateNickel time.Time
 	)
@@ -413,7 +415,7 @@ func (p *Package) parse(handler func(fsDate func(t time.Time), push func(filenam
 				case "/usr/local/Kobo/libnickel.so.1.0.0":
 					if i := bytes.Index(buf, []byte("Kobo Touch %2/%3")); i != -1 {
 						if m := regexp.MustCompile(`[1234].[0-9]+\.[0-9]+`).FindAll(buf[i:i+200], -1); len(m) == 1 {
-							if v, err := ParseVersion(string(m[0])); err == nil {
+							if v, err := kobo.ParseVersion(string(m[0])); err == nil {
 								verNickel = v
 							}
 						}
@@ -428,7 +430,7 @@ func (p *Package) parse(handler func(fsDate func(t time.Time), push func(filenam
 						}
 					}
 				case "/usr/local/Kobo/softwareversion": // provided since v5
-					v, err := ParseVersion(strings.TrimSpace(string(buf)))
+					v, err := kobo.ParseVersion(strings.TrimSpace(string(buf)))
 					if err != nil {
 						return err
 					}
@@ -0,0 +1,37 @@
+package firmware
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/kobotest"
+)
+
+func TestParseKoboRoot(t *testing.T) {
+	date := time.Date(2022, time.June, 14, 0, 0, 0, 0, time.UTC)
+	buf, err := kobotest.FirmwareZip("4.33.19759", date)
+	if err != nil {
+		t.Fatalf("generate firmware: %v", err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatalf("read firmware: %v", err)
+	}
+
+	p, err := Parse(z, ReadTempMem)
+	if err != nil {
+		t.Fatalf("parse firmware: %v", err)
+	}
+	if p.Format != PackageFormatKoboRoot {
+		t.Errorf("expected %s, got %s", PackageFormatKoboRoot, p.Format)
+	}
+	if p.Version.String() != "4.33.19759" {
+		t.Errorf("expected version 4.33.19759, got %s", p.Version)
+	}
+	if !p.Date.Equal(date) {
+		t.Errorf("expected date %s, got %s", date, p.Date)
+	}
+}
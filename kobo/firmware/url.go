@@ -0,0 +1,195 @@
+package firmware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+// DownloadBases are the known base URLs of the Kobo firmware download server.
+var DownloadBases = []string{
+	"https://ereaderfiles.kobo.com/firmwares",
+	"https://kbdownload1-a.akamaihd.net/firmwares",
+}
+
+// DefaultLayout is the path layout used by the Kobo firmware download server,
+// relative to the base URL. The month is the month the update was published
+// (which is not necessarily the month it was built).
+const DefaultLayout = "{hardware}/{month}/kobo-update-{version}.zip"
+
+// ErrNotFound is returned by Resolve if none of the candidate URLs exist.
+var ErrNotFound = errors.New("firmware not found")
+
+// ErrNoDate is returned by URLBuilder.URLs if all of the bases need the month,
+// but the date isn't set.
+var ErrNoDate = errors.New("release date is needed for the {month} placeholder")
+
+// URLBuilder builds candidate download URLs for firmware versions.
+type URLBuilder struct {
+	// Bases are the URLs to use, in order of preference. If a base contains a
+	// placeholder ({hardware}, {version}, {month}), it is used as a template
+	// for the full URL. Otherwise, DefaultLayout is appended to it. If empty,
+	// DownloadBases is used.
+	Bases []string
+
+	// Date is the approximate release date, used to fill the {month}
+	// placeholder. If zero, only templates without the {month} placeholder
+	// are used (note that DefaultLayout uses it).
+	Date time.Time
+
+	// Window is the number of months before and after Date to try, since the
+	// exact publishing date is usually not known.
+	Window int
+}
+
+// URLs returns the candidate URLs for a firmware version for the hardware
+// revision of a device (see kobo.Device.Hardware). URLs for the months closest
+// to Date are returned first. If Date is zero and all of the bases need the
+// month (like the default ones), an error wrapping ErrNoDate is returned.
+func (b URLBuilder) URLs(hw kobo.Hardware, v kobo.Version) ([]string, error) {
+	bases := b.Bases
+	if len(bases) == 0 {
+		bases = DownloadBases
+	}
+
+	var months []string
+	if !b.Date.IsZero() {
+		m := time.Date(b.Date.Year(), b.Date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		months = append(months, m.Format("Jan2006"))
+		for i := 1; i <= b.Window; i++ {
+			months = append(months, m.AddDate(0, i, 0).Format("Jan2006"), m.AddDate(0, -i, 0).Format("Jan2006"))
+		}
+	}
+
+	var urls []string
+	seen := map[string]bool{}
+	add := func(u string) {
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	for _, base := range bases {
+		tpl := base
+		if !strings.Contains(tpl, "{") {
+			tpl = strings.TrimSuffix(tpl, "/") + "/" + DefaultLayout
+		}
+		tpl = strings.NewReplacer(
+			"{hardware}", hw.String(),
+			"{version}", v.String(),
+		).Replace(tpl)
+		if !strings.Contains(tpl, "{month}") {
+			add(tpl)
+			continue
+		}
+		for _, m := range months {
+			add(strings.ReplaceAll(tpl, "{month}", m))
+		}
+	}
+	if len(urls) == 0 && len(months) == 0 {
+		return nil, fmt.Errorf("firmware %s for %s: %w", v, hw, ErrNoDate)
+	}
+	return urls, nil
+}
+
+// Resolver checks the availability of download URLs.
+type Resolver struct {
+	// HTTPClient is used to make requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Concurrency is the maximum number of concurrent requests. If zero, it
+	// defaults to 4.
+	Concurrency int
+}
+
+// Availability is the result of checking a URL.
+type Availability struct {
+	URL       string
+	Available bool
+	Size      int64 // -1 if unknown
+	Modified  time.Time
+	Err       error // if the request failed
+}
+
+// Check makes a HEAD request to each URL, returning the results in the same
+// order.
+func (r *Resolver) Check(ctx context.Context, urls []string) []Availability {
+	c := r.HTTPClient
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	n := r.Concurrency
+	if n <= 0 {
+		n = 4
+	}
+
+	res := make([]Availability, len(urls))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, n)
+	for i, u := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(a *Availability, u string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			a.URL, a.Size = u, -1
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+			if err != nil {
+				a.Err = err
+				return
+			}
+
+			resp, err := c.Do(req)
+			if err != nil {
+				a.Err = err
+				return
+			}
+			resp.Body.Close()
+
+			switch {
+			case resp.StatusCode == http.StatusOK:
+				a.Available = true
+				a.Size = resp.ContentLength
+				if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+					a.Modified = t
+				}
+			case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusForbidden, resp.StatusCode == http.StatusGone:
+				// not available (CDNs commonly return 403 for missing files)
+			default:
+				a.Err = fmt.Errorf("response status %d", resp.StatusCode)
+			}
+		}(&res[i], u)
+	}
+	wg.Wait()
+	return res
+}
+
+// Resolve returns the first available URL. If none are available, an error
+// wrapping ErrNotFound is returned.
+func (r *Resolver) Resolve(ctx context.Context, urls []string) (Availability, error) {
+	var errs []error
+	for _, a := range r.Check(ctx, urls) {
+		if a.Available {
+			return a, nil
+		}
+		if a.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.URL, a.Err))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return Availability{}, err
+	}
+	if len(errs) != 0 {
+		return Availability{}, fmt.Errorf("%w (checked %d urls): %w", ErrNotFound, len(urls), errors.Join(errs...))
+	}
+	return Availability{}, fmt.Errorf("%w (checked %d urls)", ErrNotFound, len(urls))
+}
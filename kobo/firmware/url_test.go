@@ -0,0 +1,107 @@
+package firmware
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/kobotest"
+)
+
+func TestURLBuilder(t *testing.T) {
+	v := kobo.Version{Major: 4, Minor: 36, Patch: 21095}
+	for _, tc := range []struct {
+		name string
+		b    URLBuilder
+		urls []string
+		err  error
+	}{
+		{
+			"Default",
+			URLBuilder{Date: time.Date(2023, time.April, 12, 0, 0, 0, 0, time.UTC)},
+			[]string{
+				"https://ereaderfiles.kobo.com/firmwares/kobo7/Apr2023/kobo-update-4.36.21095.zip",
+				"https://kbdownload1-a.akamaihd.net/firmwares/kobo7/Apr2023/kobo-update-4.36.21095.zip",
+			},
+			nil,
+		},
+		{
+			"Window",
+			URLBuilder{Bases: []string{"https://example.com/fw/"}, Date: time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC), Window: 1},
+			[]string{
+				"https://example.com/fw/kobo7/Jan2023/kobo-update-4.36.21095.zip",
+				"https://example.com/fw/kobo7/Feb2023/kobo-update-4.36.21095.zip",
+				"https://example.com/fw/kobo7/Dec2022/kobo-update-4.36.21095.zip",
+			},
+			nil,
+		},
+		{
+			"NoDate",
+			URLBuilder{Bases: []string{"https://example.com/fw", "https://mirror.example.com/{version}/{hardware}.zip"}},
+			[]string{
+				"https://mirror.example.com/4.36.21095/kobo7.zip",
+			},
+			nil,
+		},
+		{
+			"NoDateDefault",
+			URLBuilder{},
+			nil,
+			ErrNoDate,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			urls, err := tc.b.URLs(kobo.DeviceClaraHD.Hardware(), v)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(urls, tc.urls) {
+				t.Errorf("expected %q, got %q", tc.urls, urls)
+			}
+		})
+	}
+}
+
+func TestResolver(t *testing.T) {
+	s := kobotest.NewServer()
+	defer s.Close()
+
+	date := time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)
+	u, err := s.AddFirmware(kobo.HardwareKobo7, "4.36.21095", date)
+	if err != nil {
+		t.Fatalf("add firmware: %v", err)
+	}
+
+	b := URLBuilder{
+		Bases:  []string{s.URL + "/mirror", s.URL + "/firmwares"},
+		Date:   time.Date(2023, time.March, 20, 0, 0, 0, 0, time.UTC),
+		Window: 2,
+	}
+	r := &Resolver{HTTPClient: s.Client()}
+
+	urls, err := b.URLs(kobo.HardwareKobo7, kobo.Version{Major: 4, Minor: 36, Patch: 21095})
+	if err != nil {
+		t.Fatalf("urls: %v", err)
+	}
+	a, err := r.Resolve(context.Background(), urls)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if a.URL != u {
+		t.Errorf("expected %q, got %q", u, a.URL)
+	}
+	if a.Size <= 0 || !a.Modified.Equal(date) {
+		t.Errorf("unexpected size %d or date %s", a.Size, a.Modified)
+	}
+
+	urls, err = b.URLs(kobo.HardwareKobo7, kobo.Version{Major: 4, Minor: 37, Patch: 21582})
+	if err != nil {
+		t.Fatalf("urls: %v", err)
+	}
+	if _, err := r.Resolve(context.Background(), urls); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
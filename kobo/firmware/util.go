@@ -1,4 +1,4 @@
-package main
+package firmware
 
 import (
 	"bytes"
@@ -15,6 +15,8 @@ import (
 	"github.com/klauspost/compress/zstd"
 )
 
+// ReadTempDirAuto is the preferred implementation of ReadTempDir for the
+// current platform.
 var ReadTempDirAuto = ReadTempDir
 
 // ReadTempMem reads into an in-memory byte slice.
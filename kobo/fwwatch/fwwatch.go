@@ -0,0 +1,313 @@
+// Package fwwatch watches the Kobo API for new firmware releases.
+package fwwatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+// Target is a device/affiliate combination to watch.
+type Target struct {
+	Device    kobo.Device
+	Affiliate string
+}
+
+// Key returns the key used to identify the target in the state.
+func (t Target) Key() string {
+	return strconv.Itoa(t.Device.ID()) + "/" + t.Affiliate
+}
+
+func (t Target) String() string {
+	return t.Device.Name() + " (" + t.Affiliate + ")"
+}
+
+// Targets returns the targets for every combination of the provided devices
+// and affiliates.
+func Targets(devices []kobo.Device, affiliates []string) []Target {
+	ts := make([]Target, 0, len(devices)*len(affiliates))
+	for _, d := range devices {
+		for _, a := range affiliates {
+			ts = append(ts, Target{d, a})
+		}
+	}
+	return ts
+}
+
+// Event is emitted when the latest version for a target changes.
+type Event struct {
+	Time            time.Time `json:"time"`
+	Key             string    `json:"key"`
+	DeviceID        string    `json:"device_id"`
+	DeviceName      string    `json:"device_name"`
+	Affiliate       string    `json:"affiliate"`
+	PreviousVersion string    `json:"previous_version,omitempty"`
+	Version         string    `json:"version"`
+	UpgradeURL      string    `json:"upgrade_url,omitempty"`
+	ReleaseNoteURL  string    `json:"release_note_url,omitempty"`
+}
+
+// State contains the last-seen version for each target.
+type State struct {
+	Seen map[string]Seen `json:"seen"`
+}
+
+// Seen is the last-seen version for a target.
+type Seen struct {
+	Version    string    `json:"version"`
+	UpgradeURL string    `json:"upgrade_url,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// LoadState reads the state from a file. If it does not exist, an empty state
+// is returned.
+func LoadState(name string) (*State, error) {
+	s := &State{Seen: map[string]Seen{}}
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, s); err != nil {
+		return nil, fmt.Errorf("parse state: %w", err)
+	}
+	if s.Seen == nil {
+		s.Seen = map[string]Seen{}
+	}
+	return s, nil
+}
+
+// Save atomically writes the state to a file.
+func (s *State) Save(name string) error {
+	buf, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// Watcher periodically checks targets for new firmware versions.
+type Watcher struct {
+	// Client is used to check for upgrades. If nil, kobo.DefaultAPIClient is
+	// used.
+	Client *kobo.APIClient
+
+	// Targets are the device/affiliate combinations to check.
+	Targets []Target
+
+	// Interval is the time between polls. If zero, it defaults to 30 minutes.
+	Interval time.Duration
+
+	// Jitter is the maximum random delay added to each interval.
+	Jitter time.Duration
+
+	// Concurrency is the maximum number of concurrent requests. If zero, it
+	// defaults to 4.
+	Concurrency int
+
+	// StatePath, if not empty, is where the state is persisted between runs.
+	StatePath string
+
+	// NotifyInitial causes events to be emitted for targets which have not
+	// been seen before.
+	NotifyInitial bool
+
+	// Notifiers are called with each event. An error from a notifier is
+	// logged but does not stop the watcher. The target is only recorded as
+	// seen once all notifiers succeed, so failed events are retried (for all
+	// notifiers) on the next poll.
+	Notifiers []Notifier
+
+	// Logf, if not nil, is called with diagnostic messages.
+	Logf func(format string, a ...interface{})
+
+	state *State
+}
+
+// Run polls until ctx is cancelled.
+func (w *Watcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = time.Minute * 30
+	}
+	for {
+		if _, err := w.Poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			w.logf("poll: %v", err)
+		}
+
+		d := interval
+		if w.Jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(w.Jitter)))
+		}
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Poll checks each target once, notifies about and returns any changes, and
+// saves the state. Errors for individual targets and notifiers are logged
+// rather than returned, but if any notifiers fail, an error is returned after
+// saving the state.
+func (w *Watcher) Poll(ctx context.Context) ([]Event, error) {
+	if w.state == nil {
+		if w.StatePath != "" {
+			s, err := LoadState(w.StatePath)
+			if err != nil {
+				return nil, fmt.Errorf("load state: %w", err)
+			}
+			w.state = s
+		} else {
+			w.state = &State{Seen: map[string]Seen{}}
+		}
+	}
+
+	c := w.Client
+	if c == nil {
+		c = kobo.DefaultAPIClient
+	}
+
+	n := w.Concurrency
+	if n <= 0 {
+		n = 4
+	}
+
+	type result struct {
+		target Target
+		res    *kobo.UpgradeCheckResult
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results []result
+		sem     = make(chan struct{}, n)
+	)
+	for _, t := range w.Targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(t Target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res, err := c.CheckUpgrade(ctx, t.Device.IDString(), t.Affiliate, "0.0.0", "N0")
+			if err != nil {
+				w.logf("check %s: %v", t, err)
+				return
+			}
+			mu.Lock()
+			results = append(results, result{t, res})
+			mu.Unlock()
+		}(t)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].target.Key() < results[j].target.Key()
+	})
+
+	var (
+		evs    []Event
+		failed int
+	)
+	now := time.Now().UTC()
+	for _, r := range results {
+		if !r.res.UpgradeType.IsUpdate() {
+			continue
+		}
+		version := r.res.ParseVersion()
+		key := r.target.Key()
+
+		prev, seen := w.state.Seen[key]
+		if seen && prev.Version == version {
+			continue
+		}
+		if seen || w.NotifyInitial {
+			ev := Event{
+				Time:            now,
+				Key:             key,
+				DeviceID:        r.target.Device.IDString(),
+				DeviceName:      r.target.Device.Name(),
+				Affiliate:       r.target.Affiliate,
+				PreviousVersion: prev.Version,
+				Version:         version,
+				UpgradeURL:      r.res.UpgradeURL,
+				ReleaseNoteURL:  r.res.ReleaseNoteURL,
+			}
+			evs = append(evs, ev)
+			if !w.notify(ctx, ev) {
+				failed++
+				continue
+			}
+		}
+		w.state.Seen[key] = Seen{
+			Version:    version,
+			UpgradeURL: r.res.UpgradeURL,
+			Time:       now,
+		}
+	}
+
+	if w.StatePath != "" {
+		if err := w.state.Save(w.StatePath); err != nil {
+			return evs, fmt.Errorf("save state: %w", err)
+		}
+	}
+	if failed != 0 {
+		return evs, fmt.Errorf("failed to notify %d events, will retry on the next poll", failed)
+	}
+	return evs, nil
+}
+
+// notify calls each notifier with the event, returning false if any of them
+// failed.
+func (w *Watcher) notify(ctx context.Context, ev Event) bool {
+	ok := true
+	for _, n := range w.Notifiers {
+		if err := n.Notify(ctx, ev); err != nil {
+			w.logf("notify %s: %v", ev.Key, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func (w *Watcher) logf(format string, a ...interface{}) {
+	if w.Logf != nil {
+		w.Logf(format, a...)
+	}
+}
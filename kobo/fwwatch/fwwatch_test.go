@@ -0,0 +1,198 @@
+package fwwatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/kobotest"
+)
+
+func TestWatcher(t *testing.T) {
+	s := kobotest.NewServer()
+	defer s.Close()
+
+	upgrade := func(d kobo.Device, version string) kobotest.Upgrade {
+		return kobotest.Upgrade{
+			Device: d,
+			Result: kobo.UpgradeCheckResult{
+				UpgradeType: kobo.UpgradeTypeAvailable,
+				UpgradeURL:  "/firmwares/" + d.Hardware().String() + "/Jan2024/kobo-update-" + version + ".zip",
+			},
+		}
+	}
+
+	var (
+		hookMu sync.Mutex
+		hook   []Event
+	)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("webhook: decode event: %v", err)
+		}
+		hookMu.Lock()
+		hook = append(hook, ev)
+		hookMu.Unlock()
+	}))
+	defer hs.Close()
+
+	var out bytes.Buffer
+	state := filepath.Join(t.TempDir(), "state.json")
+	newWatcher := func() *Watcher {
+		return &Watcher{
+			Client:    s.APIClient(),
+			Targets:   Targets([]kobo.Device{kobo.DeviceClaraHD, kobo.DeviceLibra2}, []string{"Kobo"}),
+			StatePath: state,
+			Notifiers: []Notifier{
+				&JSONLines{W: &out},
+				&Webhook{URL: hs.URL, HTTPClient: hs.Client()},
+			},
+			Logf: t.Logf,
+		}
+	}
+
+	s.AddUpgrade(upgrade(kobo.DeviceClaraHD, "4.38.21908"))
+
+	w := newWatcher()
+	if evs, err := w.Poll(context.Background()); err != nil {
+		t.Fatalf("poll: %v", err)
+	} else if len(evs) != 0 {
+		t.Errorf("expected no events for initial poll, got %v", evs)
+	}
+
+	if evs, err := w.Poll(context.Background()); err != nil {
+		t.Fatalf("poll: %v", err)
+	} else if len(evs) != 0 {
+		t.Errorf("expected no events for unchanged poll, got %v", evs)
+	}
+
+	s.ResetUpgrades()
+	s.AddUpgrade(upgrade(kobo.DeviceClaraHD, "4.39.22801"), upgrade(kobo.DeviceLibra2, "4.39.22801"))
+
+	w = newWatcher() // reload state from disk
+	evs, err := w.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(evs) != 1 {
+		t.Fatalf("expected 1 event, got %v", evs)
+	}
+	if ev := evs[0]; ev.Key != "376/Kobo" || ev.PreviousVersion != "4.38.21908" || ev.Version != "4.39.22801" || ev.DeviceName != "Kobo Clara HD" {
+		t.Errorf("unexpected event %#v", ev)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"version":"4.39.22801"`) {
+		t.Errorf("unexpected json lines output %q", out.String())
+	}
+	if len(hook) != 1 || hook[0].Key != "376/Kobo" {
+		t.Errorf("unexpected webhook events %v", hook)
+	}
+
+	st, err := LoadState(state)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if v := st.Seen["388/Kobo"].Version; v != "4.39.22801" {
+		t.Errorf("expected libra 2 to be recorded in state, got %q", v)
+	}
+}
+
+func TestWatcherNotifyInitial(t *testing.T) {
+	s := kobotest.NewServer()
+	defer s.Close()
+
+	s.AddUpgrade(kobotest.Upgrade{
+		Result: kobo.UpgradeCheckResult{
+			UpgradeType: kobo.UpgradeTypeAvailable,
+			UpgradeURL:  "/kobo-update-4.39.22801.zip",
+		},
+	})
+
+	w := &Watcher{
+		Client:        s.APIClient(),
+		Targets:       Targets([]kobo.Device{kobo.DeviceClaraHD}, []string{"Kobo", "Indigo"}),
+		NotifyInitial: true,
+	}
+	evs, err := w.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(evs) != 2 || evs[0].Key != "376/Indigo" || evs[1].Key != "376/Kobo" || evs[0].PreviousVersion != "" {
+		t.Errorf("unexpected events %v", evs)
+	}
+}
+
+func TestWatcherRetry(t *testing.T) {
+	s := kobotest.NewServer()
+	defer s.Close()
+
+	s.AddUpgrade(kobotest.Upgrade{
+		Result: kobo.UpgradeCheckResult{
+			UpgradeType: kobo.UpgradeTypeAvailable,
+			UpgradeURL:  "/kobo-update-4.39.22801.zip",
+		},
+	})
+
+	var (
+		hookMu sync.Mutex
+		hook   []Event
+		fail   = true
+	)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hookMu.Lock()
+		defer hookMu.Unlock()
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var ev Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("webhook: decode event: %v", err)
+		}
+		hook = append(hook, ev)
+	}))
+	defer hs.Close()
+
+	state := filepath.Join(t.TempDir(), "state.json")
+	w := &Watcher{
+		Client:        s.APIClient(),
+		Targets:       Targets([]kobo.Device{kobo.DeviceClaraHD}, []string{"Kobo"}),
+		StatePath:     state,
+		NotifyInitial: true,
+		Notifiers:     []Notifier{&Webhook{URL: hs.URL, HTTPClient: hs.Client()}},
+		Logf:          t.Logf,
+	}
+	if evs, err := w.Poll(context.Background()); err == nil {
+		t.Errorf("expected error for failed notification")
+	} else if len(evs) != 1 {
+		t.Errorf("expected 1 event, got %v", evs)
+	}
+	if st, err := LoadState(state); err != nil {
+		t.Fatalf("load state: %v", err)
+	} else if _, ok := st.Seen["376/Kobo"]; ok {
+		t.Errorf("expected target not to be recorded after a failed notification")
+	}
+
+	hookMu.Lock()
+	fail = false
+	hookMu.Unlock()
+
+	if evs, err := w.Poll(context.Background()); err != nil {
+		t.Fatalf("poll: %v", err)
+	} else if len(evs) != 1 || len(hook) != 1 || hook[0].Version != "4.39.22801" {
+		t.Errorf("expected the event to be retried, got %v (webhook: %v)", evs, hook)
+	}
+	if evs, err := w.Poll(context.Background()); err != nil {
+		t.Fatalf("poll: %v", err)
+	} else if len(evs) != 0 {
+		t.Errorf("expected no events after a successful notification, got %v", evs)
+	}
+}
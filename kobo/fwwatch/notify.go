@@ -0,0 +1,122 @@
+package fwwatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Notifier is notified about events.
+type Notifier interface {
+	Notify(ctx context.Context, ev Event) error
+}
+
+// NotifierFunc adapts a function into a Notifier.
+type NotifierFunc func(ctx context.Context, ev Event) error
+
+// Notify implements Notifier.
+func (fn NotifierFunc) Notify(ctx context.Context, ev Event) error {
+	return fn(ctx, ev)
+}
+
+// JSONLines writes each event as a line of JSON.
+type JSONLines struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+// Notify implements Notifier.
+func (n *JSONLines) Notify(ctx context.Context, ev Event) error {
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, err = n.W.Write(append(buf, '\n'))
+	return err
+}
+
+// Exec runs a command for each event. The event is written to stdin as JSON,
+// and the main fields are also set as FWWATCH_* environment variables.
+type Exec struct {
+	Name string
+	Args []string
+
+	// Stdout and Stderr are used for the command output. If nil, the output
+	// is discarded.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Notify implements Notifier.
+func (n *Exec) Notify(ctx context.Context, ev Event) error {
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, n.Name, n.Args...)
+	cmd.Stdin = bytes.NewReader(buf)
+	cmd.Stdout = n.Stdout
+	cmd.Stderr = n.Stderr
+	cmd.Env = append(os.Environ(),
+		"FWWATCH_KEY="+ev.Key,
+		"FWWATCH_DEVICE_ID="+ev.DeviceID,
+		"FWWATCH_DEVICE_NAME="+ev.DeviceName,
+		"FWWATCH_AFFILIATE="+ev.Affiliate,
+		"FWWATCH_PREVIOUS_VERSION="+ev.PreviousVersion,
+		"FWWATCH_VERSION="+ev.Version,
+		"FWWATCH_UPGRADE_URL="+ev.UpgradeURL,
+		"FWWATCH_RELEASE_NOTE_URL="+ev.ReleaseNoteURL,
+	)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("exec %s: %w", n.Name, err)
+	}
+	return nil
+}
+
+// Webhook POSTs each event as JSON to a URL.
+type Webhook struct {
+	URL string
+
+	// HTTPClient is used to make requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+// Notify implements Notifier.
+func (n *Webhook) Notify(ctx context.Context, ev Event) error {
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	c := n.HTTPClient
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook response status %d: %q", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Package kobohttp detects Kobo devices making HTTP requests.
+package kobohttp
+
+import (
+	"context"
+	"image"
+	"net/http"
+	"strings"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+// Client contains information about the Kobo device making a request.
+type Client struct {
+	UserAgent kobo.UserAgent
+	Device    kobo.Device  // zero if the device is unknown
+	Version   kobo.Version // firmware version
+	Screen    image.Point  // full-screen cover size (i.e., portrait resolution), zero if unknown
+	PPI       int          // zero if unknown
+}
+
+type contextKey struct{}
+
+// NewContext returns a new context carrying c.
+func NewContext(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the Client stored in ctx by Middleware, if any.
+func FromContext(ctx context.Context) (*Client, bool) {
+	c, ok := ctx.Value(contextKey{}).(*Client)
+	return c, ok && c != nil
+}
+
+// FromRequest returns the Client for a request. If Middleware was used, the
+// stored Client is returned, otherwise, the User-Agent is parsed.
+func FromRequest(r *http.Request) (*Client, bool) {
+	if c, ok := FromContext(r.Context()); ok {
+		return c, true
+	}
+	return Detect(r.UserAgent())
+}
+
+// Detect parses a user agent string, returning false if it isn't a Kobo.
+func Detect(ua string) (*Client, bool) {
+	u, err := kobo.ParseUserAgent(ua)
+	if err != nil {
+		return nil, false
+	}
+	c := &Client{
+		UserAgent: u,
+		Device:    u.Device,
+		Version:   u.Version,
+	}
+	if c.Device != 0 {
+		c.Screen = c.Device.CoverSize(kobo.CoverTypeFull)
+		c.PPI = c.Device.DisplayPPI()
+	}
+	return c, true
+}
+
+// Middleware detects Kobo devices from the User-Agent header and stores the
+// Client in the request context for the next handler. Since the response may
+// depend on it, User-Agent is added to the Vary header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "User-Agent")
+		if c, ok := Detect(r.UserAgent()); ok {
+			r = r.WithContext(NewContext(r.Context(), c))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PrefersKepub returns true if the client should be given kepubs rather than
+// epubs.
+func (c *Client) PrefersKepub() bool {
+	return c != nil
+}
+
+// CoverSize returns the size a cover image of size orig should be resized to
+// for the specified cover type on the client's device. If the client or device
+// is unknown, orig is returned.
+func (c *Client) CoverSize(t kobo.CoverType, orig image.Point) image.Point {
+	if c == nil || c.Device == 0 {
+		return orig
+	}
+	return c.Device.CoverSized(t, orig)
+}
+
+// PrefersKepub returns true if the request is from a client which should be
+// given kepubs rather than epubs.
+func PrefersKepub(r *http.Request) bool {
+	c, _ := FromRequest(r)
+	return c.PrefersKepub()
+}
+
+// CoverSize returns the size a cover image of size orig should be resized to
+// for the specified cover type for the client making the request. If the
+// request is not from a known device, orig is returned.
+func CoverSize(r *http.Request, t kobo.CoverType, orig image.Point) image.Point {
+	c, _ := FromRequest(r)
+	return c.CoverSize(t, orig)
+}
+
+// IsKepub returns true if the filename is a kepub.
+func IsKepub(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".kepub.epub") || strings.HasSuffix(strings.ToLower(name), ".kepub")
+}
+
+// ChooseVariant chooses the best file for the client making the request from
+// a set of variants of the same book (e.g., book.epub and book.kepub.epub).
+// Kepubs are preferred for Kobo devices and avoided otherwise. If there are no
+// variants, an empty string is returned.
+func ChooseVariant(r *http.Request, variants ...string) string {
+	if len(variants) == 0 {
+		return ""
+	}
+	kepub := PrefersKepub(r)
+	for _, v := range variants {
+		if IsKepub(v) == kepub {
+			return v
+		}
+	}
+	return variants[0]
+}
@@ -0,0 +1,87 @@
+package kobohttp
+
+import (
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+// recorded user agents
+const (
+	uaClaraHD = "Mozilla/5.0 (Linux; U; Android 2.0; en-us;) AppleWebKit/538.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/538.1 (Kobo Touch 0376/4.38.21908)"
+	uaAuraH2O = "Mozilla/5.0 (Linux; U; Android 2.0; en-us;) AppleWebKit/538.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/538.1 (Kobo Touch 0370/4.20.14622)"
+	uaChrome  = "Mozilla/5.0 (Linux; Android 8.0.0; SM-G930F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.162 Mobile Safari/537.36"
+)
+
+func TestMiddleware(t *testing.T) {
+	var (
+		got *Client
+		ok  bool
+	)
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok = FromContext(r.Context())
+	}))
+
+	for _, tc := range []struct {
+		ua     string
+		device kobo.Device
+		screen image.Point
+	}{
+		{uaClaraHD, kobo.DeviceClaraHD, image.Pt(1072, 1448)},
+		{uaAuraH2O, kobo.DeviceAuraH2O, image.Pt(1080, 1429)},
+		{uaChrome, 0, image.Point{}},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("User-Agent", tc.ua)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Header().Get("Vary") != "User-Agent" {
+			t.Errorf("%q: expected Vary header", tc.ua)
+		}
+		if ok != (tc.device != 0) {
+			t.Errorf("%q: expected detected=%t", tc.ua, tc.device != 0)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got.Device != tc.device || got.Screen != tc.screen || got.PPI == 0 {
+			t.Errorf("%q: unexpected client %#v", tc.ua, got)
+		}
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	kr := httptest.NewRequest(http.MethodGet, "/", nil)
+	kr.Header.Set("User-Agent", uaClaraHD)
+
+	or := httptest.NewRequest(http.MethodGet, "/", nil)
+	or.Header.Set("User-Agent", uaChrome)
+
+	if !PrefersKepub(kr) || PrefersKepub(or) {
+		t.Errorf("incorrect kepub preference")
+	}
+
+	variants := []string{"Book.epub", "Book.kepub.epub"}
+	if v := ChooseVariant(kr, variants...); v != "Book.kepub.epub" {
+		t.Errorf("expected kepub for kobo, got %q", v)
+	}
+	if v := ChooseVariant(or, variants...); v != "Book.epub" {
+		t.Errorf("expected epub for others, got %q", v)
+	}
+	if v := ChooseVariant(or, "Book.kepub.epub"); v != "Book.kepub.epub" {
+		t.Errorf("expected fallback to only variant, got %q", v)
+	}
+
+	orig := image.Pt(1391, 2200)
+	if sz := CoverSize(kr, kobo.CoverTypeLibFull, orig); sz != kobo.DeviceClaraHD.CoverSized(kobo.CoverTypeLibFull, orig) {
+		t.Errorf("unexpected cover size %s", sz)
+	}
+	if sz := CoverSize(or, kobo.CoverTypeLibFull, orig); sz != orig {
+		t.Errorf("expected original size for non-kobo, got %s", sz)
+	}
+}
@@ -0,0 +1,453 @@
+// Package kobosync implements the parts of the Kobo store sync protocol needed
+// to sync a private library to a Kobo device.
+//
+// To use it, set api_endpoint in the [OneStoreServices] section of
+// .kobo/Kobo/Kobo eReader.conf on the device to the URL of the Server. Store
+// features (e.g., purchases, recommendations, and the user profile) are not
+// implemented.
+package kobosync
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSyncLimit is the default maximum number of books returned in a single
+// library sync response.
+const DefaultSyncLimit = 100
+
+// Server serves the Kobo sync protocol for a Library.
+type Server struct {
+	// Library provides the books. It must not be nil.
+	Library Library
+
+	// BaseURL is the external URL of the server, including any path prefix.
+	// If empty, it is derived from the request, assuming the server is
+	// mounted at the root.
+	BaseURL string
+
+	// Resources, if not nil, are added to or override the resources returned
+	// by the initialization endpoint.
+	Resources map[string]string
+
+	// SyncLimit is the maximum number of books returned in a single library
+	// sync response. If zero, DefaultSyncLimit is used.
+	SyncLimit int
+
+	// Logf, if not nil, is called with diagnostic messages.
+	Logf func(format string, a ...interface{})
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d := DeviceFromRequest(r)
+	r = r.WithContext(context.WithValue(r.Context(), deviceKey{}, d))
+
+	p := strings.Split(strings.Trim(path.Clean("/"+r.URL.Path), "/"), "/")
+	switch {
+	case len(p) == 2 && p[0] == "v1" && p[1] == "initialization":
+		s.method(w, r, s.handleInitialization, http.MethodGet)
+	case len(p) == 3 && p[0] == "v1" && p[1] == "auth" && p[2] == "device":
+		s.method(w, r, s.handleAuthDevice, http.MethodPost)
+	case len(p) == 3 && p[0] == "v1" && p[1] == "library" && p[2] == "sync":
+		s.method(w, r, s.handleSync, http.MethodGet)
+	case len(p) == 4 && p[0] == "v1" && p[1] == "library" && p[3] == "metadata":
+		s.method(w, r, func(w http.ResponseWriter, r *http.Request) { s.handleMetadata(w, r, p[2]) }, http.MethodGet)
+	case len(p) == 4 && p[0] == "v1" && p[1] == "library" && p[3] == "state":
+		s.method(w, r, func(w http.ResponseWriter, r *http.Request) { s.handleState(w, r, p[2]) }, http.MethodGet, http.MethodPut)
+	case len(p) == 3 && p[0] == "download":
+		s.method(w, r, func(w http.ResponseWriter, r *http.Request) { s.handleDownload(w, r, p[1], Format(p[2])) }, http.MethodGet, http.MethodHead)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) method(w http.ResponseWriter, r *http.Request, h http.HandlerFunc, methods ...string) {
+	for _, m := range methods {
+		if r.Method == m {
+			h(w, r)
+			return
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+func (s *Server) handleInitialization(w http.ResponseWriter, r *http.Request) {
+	res := s.resources(r)
+	w.Header().Set("X-Kobo-ApiToken", "e30=")
+	s.json(w, http.StatusOK, map[string]interface{}{
+		"Resources": res,
+	})
+}
+
+// resources returns the initialization resources for a request.
+func (s *Server) resources(r *http.Request) map[string]string {
+	base := s.baseURL(r)
+	res := map[string]string{
+		"library_sync":     base + "/v1/library/sync",
+		"library_metadata": base + "/v1/library/{Ids}/metadata",
+		"reading_state":    base + "/v1/library/{Ids}/state",
+		"device_auth":      base + "/v1/auth/device",
+	}
+	for k, v := range s.Resources {
+		res[k] = v
+	}
+	return res
+}
+
+// handleAuthDevice accepts any device, returning placeholder tokens.
+func (s *Server) handleAuthDevice(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserKey string `json:"UserKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	s.json(w, http.StatusOK, map[string]string{
+		"AccessToken":  "kobosync",
+		"RefreshToken": "kobosync",
+		"TokenType":    "Bearer",
+		"TrackingId":   "",
+		"UserKey":      req.UserKey,
+	})
+}
+
+// syncToken is the position in the library sync. Books are sorted by the time
+// they were last changed, then by ID. Since is the position of the last
+// completed sync, and is used to determine which books are new to the device.
+type syncToken struct {
+	Version int       `json:"v"`
+	Since   time.Time `json:"s"`
+	Time    time.Time `json:"t"`
+	ID      string    `json:"id"`
+}
+
+// parseSyncToken parses a sync token. Invalid tokens (e.g., ones from the Kobo
+// store) are treated as the start of the sync.
+func parseSyncToken(s string) syncToken {
+	var t syncToken
+	if buf, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		if err := json.Unmarshal(buf, &t); err == nil && t.Version == 1 {
+			return t
+		}
+	}
+	return syncToken{Version: 1}
+}
+
+func (t syncToken) String() string {
+	buf, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// initial returns true if the token is for the start of the sync.
+func (t syncToken) initial() bool {
+	return t.Time.IsZero() && t.ID == ""
+}
+
+// fresh returns true if the device hasn't completed a sync yet.
+func (t syncToken) fresh() bool {
+	return t.Since.IsZero()
+}
+
+// before returns true if the book is before or at the token position.
+func (t syncToken) before(b *Book) bool {
+	c := b.changed()
+	return c.Before(t.Time) || (c.Equal(t.Time) && b.ID <= t.ID)
+}
+
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	d, _ := DeviceFromContext(r.Context())
+	tok := parseSyncToken(r.Header.Get("X-Kobo-SyncToken"))
+
+	books, err := s.Library.Books(r.Context(), d, tok.Time)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+
+	var pending []*Book
+	for _, b := range books {
+		if tok.fresh() && b.Removed {
+			continue // the device never had it
+		}
+		if tok.initial() || !tok.before(b) {
+			pending = append(pending, b)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if ci, cj := pending[i].changed(), pending[j].changed(); !ci.Equal(cj) {
+			return ci.Before(cj)
+		}
+		return pending[i].ID < pending[j].ID
+	})
+
+	limit := s.SyncLimit
+	if limit <= 0 {
+		limit = DefaultSyncLimit
+	}
+	more := len(pending) > limit
+	if more {
+		pending = pending[:limit]
+		w.Header().Set("X-Kobo-Sync", "continue")
+	}
+
+	events := []syncEvent{}
+	for _, b := range pending {
+		events = append(events, s.syncEvent(r, tok, b))
+	}
+
+	next := tok
+	if len(pending) != 0 {
+		last := pending[len(pending)-1]
+		next.Time, next.ID = last.changed(), last.ID
+	}
+	if !more {
+		next.Since = next.Time
+	}
+	w.Header().Set("X-Kobo-SyncToken", next.String())
+	s.json(w, http.StatusOK, events)
+}
+
+// syncEvent returns the sync event for a book changed since tok. The metadata
+// is compared against the start of the sync rather than the current position,
+// since a book on a continuation page may have been modified before the
+// position but after the last completed sync.
+func (s *Server) syncEvent(r *http.Request, tok syncToken, b *Book) syncEvent {
+	isNew := tok.fresh() || b.Created.After(tok.Since)
+	if !isNew && !b.Modified.After(tok.Since) {
+		return syncEvent{ChangedReadingState: &syncReadingState{s.readingState(b)}}
+	}
+	e := &syncEntitlement{
+		BookEntitlement: s.entitlement(b),
+		BookMetadata:    s.metadata(r, b),
+		ReadingState:    s.readingState(b),
+	}
+	if isNew {
+		return syncEvent{NewEntitlement: e}
+	}
+	return syncEvent{ChangedEntitlement: e}
+}
+
+func (s *Server) entitlement(b *Book) bookEntitlement {
+	return bookEntitlement{
+		Accessibility:   "Full",
+		ActivePeriod:    timePeriod{From: Time{b.Created}},
+		Created:         Time{b.Created},
+		CrossRevisionID: b.ID,
+		ID:              b.ID,
+		IsRemoved:       b.Removed,
+		LastModified:    Time{b.Modified},
+		OriginCategory:  "Imported",
+		RevisionID:      b.ID,
+		Status:          "Active",
+	}
+}
+
+func (s *Server) metadata(r *http.Request, b *Book) bookMetadata {
+	m := bookMetadata{
+		Categories:       []string{"00000000-0000-0000-0000-000000000001"},
+		ContributorRoles: []contributorRole{},
+		Contributors:     []string{},
+		CrossRevisionID:  b.ID,
+		CurrentDisplayPrice: price{
+			CurrencyCode: "USD",
+		},
+		Description:     b.Description,
+		DownloadUrls:    []downloadURL{},
+		EntitlementID:   b.ID,
+		ExternalIDs:     []string{},
+		Genre:           "00000000-0000-0000-0000-000000000001",
+		IsSocialEnabled: true,
+		Language:        b.Language,
+		PublicationDate: Time{b.PublicationDate},
+		Publisher:       publisher{Name: b.Publisher},
+		RevisionID:      b.ID,
+		Title:           b.Title,
+		WorkID:          b.ID,
+	}
+	if m.Language == "" {
+		m.Language = "en"
+	}
+	for _, a := range b.Authors {
+		m.ContributorRoles = append(m.ContributorRoles, contributorRole{Name: a})
+		m.Contributors = append(m.Contributors, a)
+	}
+	if b.Series != "" {
+		m.Series = &series{
+			Name:        b.Series,
+			Number:      strconv.FormatFloat(b.SeriesNumber, 'f', -1, 64),
+			NumberFloat: b.SeriesNumber,
+			ID:          b.Series,
+		}
+	}
+	base := s.baseURL(r)
+	for _, f := range b.Files {
+		m.DownloadUrls = append(m.DownloadUrls, downloadURL{
+			Format:   f.Format,
+			Size:     f.Size,
+			URL:      base + "/download/" + url.PathEscape(b.ID) + "/" + url.PathEscape(string(f.Format)),
+			Platform: "Generic",
+		})
+	}
+	return m
+}
+
+// readingState returns the reading state of a book, or the initial state if it
+// hasn't been opened.
+func (s *Server) readingState(b *Book) *ReadingState {
+	if b.ReadingState != nil {
+		rs := *b.ReadingState
+		rs.EntitlementID = b.ID
+		return &rs
+	}
+	return &ReadingState{
+		EntitlementID:     b.ID,
+		Created:           Time{b.Created},
+		LastModified:      Time{b.Created},
+		PriorityTimestamp: Time{b.Created},
+		StatusInfo: &StatusInfo{
+			LastModified: Time{b.Created},
+			Status:       ReadingStatusReadyToRead,
+		},
+		Statistics: &Statistics{
+			LastModified: Time{b.Created},
+		},
+		CurrentBookmark: &CurrentBookmark{
+			LastModified: Time{b.Created},
+		},
+	}
+}
+
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request, id string) {
+	d, _ := DeviceFromContext(r.Context())
+	b, err := s.Library.Book(r.Context(), d, id)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	s.json(w, http.StatusOK, []bookMetadata{s.metadata(r, b)})
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request, id string) {
+	d, _ := DeviceFromContext(r.Context())
+	b, err := s.Library.Book(r.Context(), d, id)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		s.json(w, http.StatusOK, []*ReadingState{s.readingState(b)})
+		return
+	}
+
+	var req readingStateUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.ReadingStates) == 0 {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	res := readingStateResult{RequestResult: "Success"}
+	for _, u := range req.ReadingStates {
+		if u.EntitlementID != "" && u.EntitlementID != id {
+			http.Error(w, "Entitlement ID mismatch.", http.StatusBadRequest)
+			return
+		}
+
+		rs := s.readingState(b).merge(u)
+		rs.LastModified = Time{time.Now().UTC().Truncate(time.Second)}
+		if err := s.Library.SetReadingState(r.Context(), d, id, rs); err != nil {
+			s.error(w, r, err)
+			return
+		}
+		b.ReadingState = rs
+
+		res.UpdateResults = append(res.UpdateResults, readingStateUpdateResult{
+			EntitlementID:         id,
+			CurrentBookmarkResult: result{"Success"},
+			StatisticsResult:      result{"Success"},
+			StatusInfoResult:      result{"Success"},
+		})
+	}
+	s.json(w, http.StatusOK, res)
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request, id string, f Format) {
+	d, _ := DeviceFromContext(r.Context())
+	b, err := s.Library.Book(r.Context(), d, id)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+
+	rsc, err := s.Library.Open(r.Context(), d, id, f)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	defer rsc.Close()
+
+	ext := ".epub"
+	switch f {
+	case FormatKepub:
+		ext = ".kepub.epub"
+	case FormatPDF:
+		ext = ".pdf"
+	}
+	if f == FormatPDF {
+		w.Header().Set("Content-Type", "application/pdf")
+	} else {
+		w.Header().Set("Content-Type", "application/epub+zip")
+	}
+	http.ServeContent(w, r, id+ext, b.Modified, rsc)
+}
+
+// baseURL returns the external URL of the server for a request.
+func (s *Server) baseURL(r *http.Request) string {
+	if s.BaseURL != "" {
+		return strings.TrimSuffix(s.BaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (s *Server) json(w http.ResponseWriter, status int, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		s.logf("kobosync: encode response: %v", err)
+		http.Error(w, "Failed to encode response.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+func (s *Server) error(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	s.logf("kobosync: %s %s: %v", r.Method, r.URL.Path, err)
+	http.Error(w, "Internal server error.", http.StatusInternalServerError)
+}
+
+func (s *Server) logf(format string, a ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, a...)
+	}
+}
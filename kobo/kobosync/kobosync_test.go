@@ -0,0 +1,540 @@
+package kobosync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+const testBookID = "a3e1f6c2-5b7d-4e9a-8c1f-2d3b4a5c6e7f"
+
+// testLibrary is an in-memory Library.
+type testLibrary struct {
+	mu     sync.Mutex
+	books  map[string]*Book
+	files  map[string][]byte
+	device *Device
+}
+
+func newTestLibrary() *testLibrary {
+	return &testLibrary{
+		books: map[string]*Book{},
+		files: map[string][]byte{},
+	}
+}
+
+func (l *testLibrary) add(b *Book, f Format, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if data != nil {
+		b.Files = append(b.Files, File{f, int64(len(data))})
+		l.files[b.ID+"/"+string(f)] = data
+	}
+	l.books[b.ID] = b
+}
+
+func (l *testLibrary) Books(ctx context.Context, d *Device, since time.Time) ([]*Book, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.device = d
+	var bs []*Book
+	for _, b := range l.books {
+		if !b.changed().Before(since) {
+			c := *b
+			bs = append(bs, &c)
+		}
+	}
+	return bs, nil
+}
+
+func (l *testLibrary) Book(ctx context.Context, d *Device, id string) (*Book, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.books[id]; ok {
+		c := *b
+		return &c, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (l *testLibrary) Open(ctx context.Context, d *Device, id string, f Format) (io.ReadSeekCloser, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if buf, ok := l.files[id+"/"+string(f)]; ok {
+		return nopCloser{bytes.NewReader(buf)}, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (l *testLibrary) SetReadingState(ctx context.Context, d *Device, id string, rs *ReadingState) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.books[id]
+	if !ok {
+		return ErrNotFound
+	}
+	b.ReadingState = rs
+	return nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// replay replays the raw HTTP requests in a file, returning the responses.
+func replay(t *testing.T, h http.Handler, name string) []*http.Response {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+
+	var res []*http.Response
+	br := bufio.NewReader(f)
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			break
+		}
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			t.Fatalf("read request %d from %s: %v", len(res), name, err)
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("read request %d from %s: %v", len(res), name, err)
+		}
+
+		r := httptest.NewRequest(req.Method, req.RequestURI, bytes.NewReader(body))
+		r.Header = req.Header
+		r.Host = req.Host
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		res = append(res, w.Result())
+	}
+	return res
+}
+
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	if resp.StatusCode != http.StatusOK {
+		buf, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s %s: unexpected status %d: %s", resp.Request.Method, resp.Request.URL, resp.StatusCode, buf)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: decode response: %v", resp.Request.Method, resp.Request.URL, err)
+	}
+}
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestDeviceSync(t *testing.T) {
+	lib := newTestLibrary()
+	lib.add(&Book{
+		ID:           testBookID,
+		Title:        "Test Book",
+		Authors:      []string{"Author One", "Author Two"},
+		Language:     "en",
+		Series:       "Test Series",
+		SeriesNumber: 1.5,
+		Created:      date("2024-05-01T10:00:00Z"),
+		Modified:     date("2024-05-01T10:00:00Z"),
+	}, FormatKepub, []byte("kepub data"))
+	lib.add(&Book{
+		ID:       "removed",
+		Title:    "Removed",
+		Created:  date("2024-04-01T10:00:00Z"),
+		Modified: date("2024-04-02T10:00:00Z"),
+		Removed:  true,
+	}, "", nil)
+
+	s := &Server{Library: lib}
+	res := replay(t, s, "testdata/device.http")
+
+	var init struct {
+		Resources map[string]string
+	}
+	decode(t, res[0], &init)
+	if v := init.Resources["library_sync"]; v != "http://192.168.1.10:8080/v1/library/sync" {
+		t.Errorf("unexpected library_sync resource %q", v)
+	}
+	if v := init.Resources["library_metadata"]; v != "http://192.168.1.10:8080/v1/library/{Ids}/metadata" {
+		t.Errorf("unexpected library_metadata resource %q", v)
+	}
+
+	var auth map[string]string
+	decode(t, res[1], &auth)
+	if auth["UserKey"] != "8d5c9b2e-1f3a-4c6d-9e7b-0a1b2c3d4e5f" || auth["AccessToken"] == "" {
+		t.Errorf("unexpected auth response %v", auth)
+	}
+
+	var events []map[string]json.RawMessage
+	decode(t, res[2], &events)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event (the removed book should be skipped on the initial sync), got %d", len(events))
+	}
+	var ent struct {
+		BookEntitlement struct {
+			ID        string `json:"Id"`
+			IsRemoved bool
+		}
+		BookMetadata struct {
+			Title        string
+			Contributors []string
+			Series       struct {
+				Number      string
+				NumberFloat float64
+			}
+			DownloadUrls []struct {
+				Format Format
+				Size   int64
+				URL    string `json:"Url"`
+			}
+		}
+		ReadingState struct {
+			StatusInfo struct {
+				Status ReadingStatus
+			}
+		}
+	}
+	if err := json.Unmarshal(events[0]["NewEntitlement"], &ent); err != nil {
+		t.Fatalf("decode NewEntitlement: %v", err)
+	}
+	if ent.BookEntitlement.ID != testBookID || ent.BookMetadata.Title != "Test Book" || len(ent.BookMetadata.Contributors) != 2 {
+		t.Errorf("unexpected entitlement %+v", ent)
+	}
+	if ent.BookMetadata.Series.Number != "1.5" || ent.BookMetadata.Series.NumberFloat != 1.5 {
+		t.Errorf("unexpected series %+v", ent.BookMetadata.Series)
+	}
+	if len(ent.BookMetadata.DownloadUrls) != 1 {
+		t.Fatalf("expected 1 download url, got %d", len(ent.BookMetadata.DownloadUrls))
+	} else if u := ent.BookMetadata.DownloadUrls[0]; u.Format != FormatKepub || u.Size != 10 || u.URL != "http://192.168.1.10:8080/download/"+testBookID+"/KEPUB" {
+		t.Errorf("unexpected download url %+v", u)
+	}
+	if ent.ReadingState.StatusInfo.Status != ReadingStatusReadyToRead {
+		t.Errorf("expected initial reading state, got %q", ent.ReadingState.StatusInfo.Status)
+	}
+	if res[2].Header.Get("X-Kobo-SyncToken") == "" {
+		t.Errorf("expected sync token")
+	}
+	if res[2].Header.Get("X-Kobo-Sync") != "" {
+		t.Errorf("expected sync to be complete")
+	}
+
+	if lib.device == nil {
+		t.Fatalf("expected device to be passed to library")
+	} else if lib.device.Model != kobo.DeviceClaraHD || lib.device.Version != (kobo.Version{Major: 4, Minor: 38, Patch: 21908}) || lib.device.ID != "4b1d6e0c8d7a9f2e3c5b1a0d9e8f7c6b5a4d3e2f" || lib.device.Affiliate != "Kobo" {
+		t.Errorf("unexpected device %+v", lib.device)
+	}
+}
+
+func TestDeviceReading(t *testing.T) {
+	lib := newTestLibrary()
+	lib.add(&Book{
+		ID:       testBookID,
+		Title:    "Test Book",
+		Created:  date("2024-05-01T10:00:00Z"),
+		Modified: date("2024-05-01T10:00:00Z"),
+	}, FormatKepub, []byte("kepub data"))
+
+	s := &Server{Library: lib, BaseURL: "https://books.example.com/kobo/"}
+	res := replay(t, s, "testdata/reading.http")
+
+	var md []struct {
+		Title        string
+		DownloadUrls []struct {
+			URL string `json:"Url"`
+		}
+	}
+	decode(t, res[0], &md)
+	if len(md) != 1 || md[0].Title != "Test Book" || len(md[0].DownloadUrls) != 1 || md[0].DownloadUrls[0].URL != "https://books.example.com/kobo/download/"+testBookID+"/KEPUB" {
+		t.Errorf("unexpected metadata %+v", md)
+	}
+
+	if res[1].StatusCode != http.StatusOK {
+		t.Errorf("download: unexpected status %d", res[1].StatusCode)
+	} else if buf, _ := io.ReadAll(res[1].Body); string(buf) != "kepub data" {
+		t.Errorf("download: unexpected data %q", buf)
+	} else if ct := res[1].Header.Get("Content-Type"); ct != "application/epub+zip" {
+		t.Errorf("download: unexpected content type %q", ct)
+	}
+
+	var rs []*ReadingState
+	decode(t, res[2], &rs)
+	if len(rs) != 1 || rs[0].EntitlementID != testBookID || rs[0].StatusInfo == nil || rs[0].StatusInfo.Status != ReadingStatusReadyToRead {
+		t.Errorf("unexpected initial reading state %+v", rs)
+	}
+
+	var put struct {
+		RequestResult string
+		UpdateResults []struct {
+			EntitlementID         string `json:"EntitlementId"`
+			CurrentBookmarkResult struct{ Result string }
+		}
+	}
+	decode(t, res[3], &put)
+	if put.RequestResult != "Success" || len(put.UpdateResults) != 1 || put.UpdateResults[0].EntitlementID != testBookID || put.UpdateResults[0].CurrentBookmarkResult.Result != "Success" {
+		t.Errorf("unexpected reading state update result %+v", put)
+	}
+
+	b, _ := lib.Book(context.Background(), nil, testBookID)
+	if x := b.ReadingState; x == nil {
+		t.Fatalf("expected reading state to be set")
+	} else {
+		if x.StatusInfo.Status != ReadingStatusReading || x.StatusInfo.TimesStartedReading != 1 || !x.StatusInfo.LastTimeStartedReading.Equal(date("2024-05-02T17:40:02Z")) {
+			t.Errorf("unexpected status info %+v", x.StatusInfo)
+		}
+		if x.CurrentBookmark.ProgressPercent != 23 || x.CurrentBookmark.Location == nil || x.CurrentBookmark.Location.Value != "kobo.14.2" {
+			t.Errorf("unexpected bookmark %+v", x.CurrentBookmark)
+		}
+		if x.Statistics.SpentReadingMinutes != 41 {
+			t.Errorf("unexpected statistics %+v", x.Statistics)
+		}
+		if x.Created.IsZero() || x.LastModified.IsZero() {
+			t.Errorf("expected timestamps to be set")
+		}
+	}
+}
+
+func TestSyncContinuation(t *testing.T) {
+	lib := newTestLibrary()
+	base := date("2024-05-01T10:00:00Z")
+	for i := 0; i < 5; i++ {
+		lib.add(&Book{
+			ID:       "book" + strconv.Itoa(i),
+			Title:    "Book " + strconv.Itoa(i),
+			Created:  base.Add(time.Duration(i/2) * time.Hour), // with duplicate times
+			Modified: base.Add(time.Duration(i/2) * time.Hour),
+		}, FormatEpub, []byte("epub"))
+	}
+	s := &Server{Library: lib, SyncLimit: 2}
+	ua := kobo.NickelUserAgent(kobo.DeviceClaraHD, kobo.Version{Major: 4, Minor: 38, Patch: 21908}).String()
+
+	sync := func(tok string) (events []syncEvent, next string, more bool) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/v1/library/sync", nil)
+		r.Header.Set("User-Agent", ua)
+		if tok != "" {
+			r.Header.Set("X-Kobo-SyncToken", tok)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		resp := w.Result()
+		decode(t, resp, &events)
+		return events, resp.Header.Get("X-Kobo-SyncToken"), resp.Header.Get("X-Kobo-Sync") == "continue"
+	}
+
+	var tok string
+	seen := map[string]bool{}
+	for i := 0; ; i++ {
+		events, next, more := sync(tok)
+		for _, e := range events {
+			if e.NewEntitlement == nil {
+				t.Fatalf("expected only new entitlements on the initial sync, got %+v", e)
+			}
+			id := e.NewEntitlement.BookEntitlement.ID
+			if seen[id] {
+				t.Errorf("book %s returned twice", id)
+			}
+			seen[id] = true
+		}
+		tok = next
+		if !more {
+			break
+		}
+		if i > 5 {
+			t.Fatalf("sync did not finish")
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("expected 5 books, got %d", len(seen))
+	}
+
+	if events, _, more := sync(tok); len(events) != 0 || more {
+		t.Errorf("expected no changes, got %d", len(events))
+	}
+
+	// modify a book, update the reading state of another, and remove one
+	lib.mu.Lock()
+	lib.books["book1"].Modified = base.Add(time.Hour * 5)
+	lib.books["book2"].ReadingState = &ReadingState{
+		LastModified: Time{base.Add(time.Hour * 6)},
+		StatusInfo:   &StatusInfo{Status: ReadingStatusFinished},
+	}
+	lib.books["book3"].Removed = true
+	lib.books["book3"].Modified = base.Add(time.Hour * 7)
+	lib.books["book5"] = &Book{ID: "book5", Created: base.Add(time.Hour * 8), Modified: base.Add(time.Hour * 8)}
+	lib.mu.Unlock()
+
+	var events []syncEvent
+	for {
+		es, next, more := sync(tok)
+		events, tok = append(events, es...), next
+		if !more {
+			break
+		}
+	}
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	if e := events[0].ChangedEntitlement; e == nil || e.BookEntitlement.ID != "book1" {
+		t.Errorf("expected book1 to be changed, got %+v", events[0])
+	}
+	if e := events[1].ChangedReadingState; e == nil || e.ReadingState.EntitlementID != "book2" || e.ReadingState.StatusInfo.Status != ReadingStatusFinished {
+		t.Errorf("expected book2 reading state to be changed, got %+v", events[1])
+	}
+	if e := events[2].ChangedEntitlement; e == nil || e.BookEntitlement.ID != "book3" || !e.BookEntitlement.IsRemoved {
+		t.Errorf("expected book3 to be removed, got %+v", events[2])
+	}
+	if e := events[3].NewEntitlement; e == nil || e.BookEntitlement.ID != "book5" {
+		t.Errorf("expected book5 to be new, got %+v", events[3])
+	}
+
+	// modify a book, then update its reading state after the first page
+	lib.mu.Lock()
+	lib.books["book0"].Modified = base.Add(time.Hour * 9)
+	lib.books["book4"].Modified = base.Add(time.Hour * 10)
+	lib.books["book1"].Modified = base.Add(time.Hour*9 + time.Minute*30)
+	lib.books["book1"].ReadingState = &ReadingState{
+		LastModified: Time{base.Add(time.Hour * 11)},
+		StatusInfo:   &StatusInfo{Status: ReadingStatusReading},
+	}
+	lib.mu.Unlock()
+
+	events, more := events[:0], false
+	for i := 0; ; i++ {
+		var es []syncEvent
+		es, tok, more = sync(tok)
+		events = append(events, es...)
+		if !more {
+			if i == 0 {
+				t.Fatalf("expected multiple pages")
+			}
+			break
+		}
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	for i, id := range []string{"book0", "book4", "book1"} {
+		if e := events[i].ChangedEntitlement; e == nil || e.BookEntitlement.ID != id {
+			t.Errorf("expected %s to be changed, got %+v", id, events[i])
+		}
+	}
+	if e := events[2].ChangedEntitlement; e != nil && (e.ReadingState == nil || e.ReadingState.StatusInfo.Status != ReadingStatusReading) {
+		t.Errorf("expected book1 reading state to be included, got %+v", e.ReadingState)
+	}
+}
+
+func TestSyncBackdated(t *testing.T) {
+	lib := newTestLibrary()
+	base := date("2024-05-01T10:00:00Z")
+	lib.add(&Book{ID: "book0", Created: base, Modified: base}, FormatEpub, []byte("epub"))
+	s := &Server{Library: lib}
+	ua := kobo.NickelUserAgent(kobo.DeviceClaraHD, kobo.Version{Major: 4, Minor: 38, Patch: 21908}).String()
+
+	sync := func(tok string) (events []syncEvent, next string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/v1/library/sync", nil)
+		r.Header.Set("User-Agent", ua)
+		if tok != "" {
+			r.Header.Set("X-Kobo-SyncToken", tok)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		resp := w.Result()
+		decode(t, resp, &events)
+		return events, resp.Header.Get("X-Kobo-SyncToken")
+	}
+
+	events, tok := sync("")
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+
+	// an old book added after the sync, with Created set to the time it was
+	// added, is new
+	lib.add(&Book{
+		ID:              "book1",
+		PublicationDate: date("1965-08-01T00:00:00Z"),
+		Created:         base.Add(time.Hour),
+		Modified:        base.Add(time.Hour),
+	}, FormatEpub, []byte("epub"))
+
+	// but one with Created set to an earlier time is never synced
+	lib.add(&Book{
+		ID:       "book2",
+		Created:  base.Add(-time.Hour),
+		Modified: base.Add(-time.Hour),
+	}, FormatEpub, []byte("epub"))
+
+	events, _ = sync(tok)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	if e := events[0].NewEntitlement; e == nil || e.BookEntitlement.ID != "book1" {
+		t.Errorf("expected book1 to be new, got %+v", events[0])
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	s := &Server{Library: newTestLibrary()}
+	for _, x := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/v1/library/missing/metadata", http.StatusNotFound},
+		{http.MethodGet, "/v1/library/missing/state", http.StatusNotFound},
+		{http.MethodGet, "/download/missing/KEPUB", http.StatusNotFound},
+		{http.MethodGet, "/v1/user/profile", http.StatusNotFound},
+		{http.MethodPost, "/v1/library/sync", http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(x.method, x.path, nil))
+		if w.Code != x.status {
+			t.Errorf("%s %s: expected status %d, got %d", x.method, x.path, x.status, w.Code)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	for _, s := range []string{
+		`"2024-05-02T18:23:11Z"`,
+		`"2024-05-02T18:23:11.000Z"`,
+		`"2024-05-02T18:23:11.0000000Z"`,
+		`"2024-05-02T14:23:11-04:00"`,
+		`"2024-05-02T18:23:11"`,
+	} {
+		var v Time
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Errorf("%s: unexpected error: %v", s, err)
+		} else if !v.Equal(date("2024-05-02T18:23:11Z")) {
+			t.Errorf("%s: unexpected time %s", s, v)
+		} else if buf, _ := json.Marshal(v); string(buf) != `"2024-05-02T18:23:11Z"` {
+			t.Errorf("%s: unexpected marshaled time %s", s, buf)
+		}
+	}
+	if buf, _ := json.Marshal(Time{}); string(buf) != "null" {
+		t.Errorf("expected zero time to be null, got %s", buf)
+	}
+}
@@ -0,0 +1,119 @@
+package kobosync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+// ErrNotFound is returned by a Library if a book or file does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Library provides the books synced to a device. All methods must be safe for
+// concurrent use.
+//
+// Devices only receive the books which changed since their last sync, so
+// Book.Created must be the time the book was added to the library, and
+// Book.Modified the time it was last changed in it. A book added with an
+// earlier time (e.g., the modification time of the file or the publication
+// date) will not be synced to devices which have already synced past it.
+type Library interface {
+	// Books returns the books (including removed ones) which were created,
+	// modified, or had their reading state modified at or after since. If
+	// since is zero, all books should be returned. The returned books may be
+	// in any order.
+	Books(ctx context.Context, d *Device, since time.Time) ([]*Book, error)
+
+	// Book returns a single book, or ErrNotFound.
+	Book(ctx context.Context, d *Device, id string) (*Book, error)
+
+	// Open opens a book file in the specified format, or returns ErrNotFound.
+	Open(ctx context.Context, d *Device, id string, f Format) (io.ReadSeekCloser, error)
+
+	// SetReadingState updates the reading state of a book. The state is
+	// complete, with the parts not sent by the device copied from the
+	// previous state.
+	SetReadingState(ctx context.Context, d *Device, id string, rs *ReadingState) error
+}
+
+// Book is a book in a Library.
+type Book struct {
+	ID              string // should be a UUID, used as the entitlement, revision, and work ID
+	Title           string
+	Authors         []string
+	Description     string // may contain HTML
+	Language        string // e.g., en
+	Publisher       string
+	PublicationDate time.Time
+	Series          string
+	SeriesNumber    float64
+	Created         time.Time // when the book was added to the library (see Library)
+	Modified        time.Time // when the book was last changed in the library
+	Removed         bool
+	Files           []File
+	ReadingState    *ReadingState // nil if the book hasn't been opened
+}
+
+// File is a downloadable file for a Book.
+type File struct {
+	Format Format
+	Size   int64
+}
+
+// Format is a book format as used by the Kobo API.
+type Format string
+
+// Formats.
+const (
+	FormatKepub Format = "KEPUB"
+	FormatEpub  Format = "EPUB"
+	FormatEpub3 Format = "EPUB3"
+	FormatPDF   Format = "PDF"
+)
+
+// changed returns the time the book or its reading state were last changed.
+func (b *Book) changed() time.Time {
+	t := b.Modified
+	if t.Before(b.Created) {
+		t = b.Created
+	}
+	if b.ReadingState != nil && b.ReadingState.LastModified.After(t) {
+		t = b.ReadingState.LastModified.Time
+	}
+	return t
+}
+
+// Device identifies the device making a request.
+type Device struct {
+	ID        string         // from the X-Kobo-DeviceId header, if present
+	Affiliate string         // from the X-Kobo-AffiliateName header, if present
+	UserAgent kobo.UserAgent // zero if not a Kobo user agent
+	Model     kobo.Device    // zero if unknown
+	Version   kobo.Version   // firmware version, zero if unknown
+}
+
+// DeviceFromRequest identifies the device making a request.
+func DeviceFromRequest(r *http.Request) *Device {
+	d := &Device{
+		ID:        r.Header.Get("X-Kobo-DeviceId"),
+		Affiliate: r.Header.Get("X-Kobo-AffiliateName"),
+	}
+	if u, err := kobo.ParseUserAgent(r.UserAgent()); err == nil {
+		d.UserAgent = u
+		d.Model = u.Device
+		d.Version = u.Version
+	}
+	return d
+}
+
+type deviceKey struct{}
+
+// DeviceFromContext returns the Device for a request handled by a Server.
+func DeviceFromContext(ctx context.Context) (*Device, bool) {
+	d, ok := ctx.Value(deviceKey{}).(*Device)
+	return d, ok && d != nil
+}
@@ -0,0 +1,221 @@
+package kobosync
+
+import (
+	"strings"
+	"time"
+)
+
+// Time is a timestamp in the format used by the Kobo API.
+type Time struct {
+	time.Time
+}
+
+// TimeFormat is the format used for timestamps sent to the device.
+const TimeFormat = "2006-01-02T15:04:05Z"
+
+// MarshalJSON implements json.Marshaler.
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(`"` + t.UTC().Format(TimeFormat) + `"`), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Timestamps with fractional
+// seconds and without a time zone (assumed to be UTC) are accepted.
+func (t *Time) UnmarshalJSON(buf []byte) error {
+	s := string(buf)
+	if s == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if v, err := time.Parse(layout, s); err == nil {
+			t.Time = v.UTC()
+			return nil
+		}
+	}
+	_, err := time.Parse(time.RFC3339Nano, s)
+	return err
+}
+
+// ReadingState is the reading progress of a book.
+type ReadingState struct {
+	EntitlementID     string           `json:"EntitlementId"`
+	Created           Time             `json:"Created"`
+	LastModified      Time             `json:"LastModified"`
+	PriorityTimestamp Time             `json:"PriorityTimestamp"`
+	StatusInfo        *StatusInfo      `json:"StatusInfo,omitempty"`
+	Statistics        *Statistics      `json:"Statistics,omitempty"`
+	CurrentBookmark   *CurrentBookmark `json:"CurrentBookmark,omitempty"`
+}
+
+// ReadingStatus is the status of a book.
+type ReadingStatus string
+
+// Reading statuses.
+const (
+	ReadingStatusReadyToRead ReadingStatus = "ReadyToRead"
+	ReadingStatusReading     ReadingStatus = "Reading"
+	ReadingStatusFinished    ReadingStatus = "Finished"
+)
+
+// StatusInfo is the read status of a book.
+type StatusInfo struct {
+	LastModified           Time          `json:"LastModified"`
+	Status                 ReadingStatus `json:"Status"`
+	TimesStartedReading    int           `json:"TimesStartedReading"`
+	LastTimeStartedReading Time          `json:"LastTimeStartedReading"`
+}
+
+// Statistics contains reading statistics for a book.
+type Statistics struct {
+	LastModified         Time `json:"LastModified"`
+	SpentReadingMinutes  int  `json:"SpentReadingMinutes,omitempty"`
+	RemainingTimeMinutes int  `json:"RemainingTimeMinutes,omitempty"`
+}
+
+// CurrentBookmark is the current position in a book.
+type CurrentBookmark struct {
+	LastModified                 Time      `json:"LastModified"`
+	ProgressPercent              float64   `json:"ProgressPercent,omitempty"`
+	ContentSourceProgressPercent float64   `json:"ContentSourceProgressPercent,omitempty"`
+	Location                     *Location `json:"Location,omitempty"`
+}
+
+// Location is a location in a book.
+type Location struct {
+	Value  string `json:"Value"`
+	Type   string `json:"Type"`   // e.g., KoboSpan
+	Source string `json:"Source"` // e.g., the content file
+}
+
+// merge returns a copy of rs with the parts set in u replaced.
+func (rs ReadingState) merge(u *ReadingState) *ReadingState {
+	if u.StatusInfo != nil {
+		rs.StatusInfo = u.StatusInfo
+	}
+	if u.Statistics != nil {
+		rs.Statistics = u.Statistics
+	}
+	if u.CurrentBookmark != nil {
+		rs.CurrentBookmark = u.CurrentBookmark
+	}
+	if !u.PriorityTimestamp.IsZero() {
+		rs.PriorityTimestamp = u.PriorityTimestamp
+	}
+	return &rs
+}
+
+// The following types are the JSON sent to the device.
+
+type bookEntitlement struct {
+	Accessibility       string     `json:"Accessibility"`
+	ActivePeriod        timePeriod `json:"ActivePeriod"`
+	Created             Time       `json:"Created"`
+	CrossRevisionID     string     `json:"CrossRevisionId"`
+	ID                  string     `json:"Id"`
+	IsHiddenFromArchive bool       `json:"IsHiddenFromArchive"`
+	IsLocked            bool       `json:"IsLocked"`
+	IsRemoved           bool       `json:"IsRemoved"`
+	LastModified        Time       `json:"LastModified"`
+	OriginCategory      string     `json:"OriginCategory"`
+	RevisionID          string     `json:"RevisionId"`
+	Status              string     `json:"Status"`
+}
+
+type timePeriod struct {
+	From Time `json:"From"`
+}
+
+type bookMetadata struct {
+	Categories              []string          `json:"Categories"`
+	ContributorRoles        []contributorRole `json:"ContributorRoles"`
+	Contributors            []string          `json:"Contributors"`
+	CoverImageID            string            `json:"CoverImageId,omitempty"`
+	CrossRevisionID         string            `json:"CrossRevisionId"`
+	CurrentDisplayPrice     price             `json:"CurrentDisplayPrice"`
+	CurrentLoveDisplayPrice price             `json:"CurrentLoveDisplayPrice"`
+	Description             string            `json:"Description,omitempty"`
+	DownloadUrls            []downloadURL     `json:"DownloadUrls"`
+	EntitlementID           string            `json:"EntitlementId"`
+	ExternalIDs             []string          `json:"ExternalIds"`
+	Genre                   string            `json:"Genre"`
+	IsEligibleForKoboLove   bool              `json:"IsEligibleForKoboLove"`
+	IsInternetArchive       bool              `json:"IsInternetArchive"`
+	IsPreOrder              bool              `json:"IsPreOrder"`
+	IsSocialEnabled         bool              `json:"IsSocialEnabled"`
+	Language                string            `json:"Language"`
+	PhoneticPronunciations  struct{}          `json:"PhoneticPronunciations"`
+	PublicationDate         Time              `json:"PublicationDate"`
+	Publisher               publisher         `json:"Publisher"`
+	RevisionID              string            `json:"RevisionId"`
+	Series                  *series           `json:"Series,omitempty"`
+	Title                   string            `json:"Title"`
+	WorkID                  string            `json:"WorkId"`
+}
+
+type contributorRole struct {
+	Name string `json:"Name"`
+}
+
+type price struct {
+	CurrencyCode string  `json:"CurrencyCode,omitempty"`
+	TotalAmount  float64 `json:"TotalAmount"`
+}
+
+type downloadURL struct {
+	Format   Format `json:"Format"`
+	Size     int64  `json:"Size"`
+	URL      string `json:"Url"`
+	Platform string `json:"Platform"`
+}
+
+type publisher struct {
+	Imprint string `json:"Imprint"`
+	Name    string `json:"Name"`
+}
+
+type series struct {
+	Name        string  `json:"Name"`
+	Number      string  `json:"Number"`
+	NumberFloat float64 `json:"NumberFloat"`
+	ID          string  `json:"Id"`
+}
+
+type syncEntitlement struct {
+	BookEntitlement bookEntitlement `json:"BookEntitlement"`
+	BookMetadata    bookMetadata    `json:"BookMetadata"`
+	ReadingState    *ReadingState   `json:"ReadingState,omitempty"`
+}
+
+type syncReadingState struct {
+	ReadingState *ReadingState `json:"ReadingState"`
+}
+
+type syncEvent struct {
+	NewEntitlement      *syncEntitlement  `json:"NewEntitlement,omitempty"`
+	ChangedEntitlement  *syncEntitlement  `json:"ChangedEntitlement,omitempty"`
+	ChangedReadingState *syncReadingState `json:"ChangedReadingState,omitempty"`
+}
+
+type readingStateUpdate struct {
+	ReadingStates []*ReadingState `json:"ReadingStates"`
+}
+
+type readingStateResult struct {
+	RequestResult string                     `json:"RequestResult"`
+	UpdateResults []readingStateUpdateResult `json:"UpdateResults"`
+}
+
+type readingStateUpdateResult struct {
+	EntitlementID         string `json:"EntitlementId"`
+	CurrentBookmarkResult result `json:"CurrentBookmarkResult"`
+	StatisticsResult      result `json:"StatisticsResult"`
+	StatusInfoResult      result `json:"StatusInfoResult"`
+}
+
+type result struct {
+	Result string `json:"Result"`
+}
@@ -0,0 +1,88 @@
+package kobotest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"time"
+)
+
+// FirmwareZip generates a minimal KoboRoot.tgz firmware update package with
+// enough of a libnickel, softwareversion, and revinfo for the version and date
+// to be detected by tools which inspect update packages.
+func FirmwareZip(version string, date time.Time) ([]byte, error) {
+	var tgz bytes.Buffer
+	zw := gzip.NewWriter(&tgz)
+	zw.ModTime = date
+
+	tw := tar.NewWriter(zw)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"./usr/local/Kobo/libnickel.so.1.0.0", FakeLibnickel(version, date)},
+		{"./usr/local/Kobo/softwareversion", []byte(version + "\n")},
+		{"./usr/local/Kobo/revinfo", []byte("0000000000000000000000000000000000000000\n")},
+	} {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Mode:     0644,
+			Size:     int64(len(f.data)),
+			ModTime:  date,
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"KoboRoot.tgz", tgz.Bytes()},
+		{"manifest.md5sum", nil},
+	} {
+		w, err := z.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Store,
+			Modified: date,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FakeLibnickel returns a blob containing the strings used to identify the
+// version and build date of libnickel.
+func FakeLibnickel(version string, date time.Time) []byte {
+	var b bytes.Buffer
+	b.WriteString("\x7fELF (not really)\x00")
+	b.WriteString("Mozilla/5.0 (Linux; U; Android 2.0; en-us;) AppleWebKit/538.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/538.1 (Kobo Touch %2/%3)\x00")
+	b.WriteString("Kobo Touch %2/%3\x00")
+	b.WriteString(version + "\x00")
+	b.WriteString("%1/revinfo\x00")
+	b.WriteString(date.Format("Jan 2 2006") + "\x00")
+	b.WriteString("MMM d yyyy\x00")
+	b.Write(make([]byte, 256)) // padding so readers can look ahead
+	return b.Bytes()
+}
@@ -0,0 +1,287 @@
+// Package kobotest provides a local stand-in for the Kobo API for use in tests.
+package kobotest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+// Server is a fake Kobo API server. The zero value is not usable; use
+// NewServer.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	upgrades  []Upgrade
+	files     map[string]file
+	checks    []UpgradeCheck
+	resources map[string]interface{}
+}
+
+// DefaultResources returns the resources served by the initialization
+// endpoint of a new Server. Values starting with a slash are resolved against
+// the server URL.
+func DefaultResources() map[string]interface{} {
+	return map[string]interface{}{
+		"upgrade_check":              "/1.0/UpgradeCheck/Device/{0}/{1}/{2}/{3}",
+		"device_auth":                "/v1/auth/device",
+		"device_refresh":             "/v1/auth/refresh",
+		"library_sync":               "/v1/library/sync",
+		"library_metadata":           "/v1/library/{Ids}/metadata",
+		"reading_state":              "/v1/library/{Ids}/state",
+		"image_host":                 "/",
+		"image_url_template":         "/{ImageId}/{Width}/{Height}/false/image.jpg",
+		"image_url_quality_template": "/{ImageId}/{Width}/{Height}/{Quality}/{IsGreyscale}/image.jpg",
+		"user_profile":               "/v1/user/profile",
+		"post_analytics_event":       "/v1/analytics/event",
+		"kobo_subscriptions_enabled": "False",
+		"use_one_store":              "True",
+	}
+}
+
+// Upgrade is an entry in the upgrade check table. The first entry matching the
+// request is used. Zero-valued Device, Affiliate, and Version fields match
+// anything.
+type Upgrade struct {
+	Device    kobo.Device
+	Affiliate string
+	Version   string
+
+	// Status, if non-zero, causes the server to respond with the specified
+	// HTTP status code instead of a result.
+	Status int
+
+	// Result is the response. If the UpgradeURL or ReleaseNoteURL start with
+	// a slash, they are resolved against the server URL.
+	Result kobo.UpgradeCheckResult
+}
+
+// UpgradeCheck is a request to the upgrade check endpoint.
+type UpgradeCheck struct {
+	Device    string
+	Affiliate string
+	Version   string
+	Serial    string
+}
+
+type file struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewServer starts a new Server. It should be closed when no longer needed.
+func NewServer() *Server {
+	s := &Server{
+		files:     map[string]file{},
+		resources: DefaultResources(),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// APIClient returns a kobo.APIClient using the server for both the API and
+// the store API.
+func (s *Server) APIClient() *kobo.APIClient {
+	return &kobo.APIClient{
+		BaseURL:      s.URL,
+		StoreBaseURL: s.URL,
+		HTTPClient:   s.Client(),
+	}
+}
+
+// AddUpgrade appends entries to the upgrade check table.
+func (s *Server) AddUpgrade(u ...Upgrade) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upgrades = append(s.upgrades, u...)
+}
+
+// ResetUpgrades clears the upgrade check table.
+func (s *Server) ResetUpgrades() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upgrades = nil
+}
+
+// UpgradeChecks returns the upgrade checks received so far.
+func (s *Server) UpgradeChecks() []UpgradeCheck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]UpgradeCheck(nil), s.checks...)
+}
+
+// SetResource sets a resource served by the initialization endpoint. If v is
+// nil, the resource is removed. If v is a string starting with a slash, it is
+// resolved against the server URL. If the upgrade_check resource is changed,
+// upgrade checks are served at the new path.
+func (s *Server) SetResource(key string, v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v == nil {
+		delete(s.resources, key)
+	} else {
+		s.resources[key] = v
+	}
+}
+
+// AddFile serves data at the specified absolute path, returning the full URL.
+// Range and HEAD requests are supported.
+func (s *Server) AddFile(path string, data []byte, modTime time.Time) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = file{data, modTime}
+	return s.URL + path
+}
+
+// AddFirmware generates a fake firmware update package with FirmwareZip and
+// serves it at the path used by the Kobo download server, returning the full
+// URL.
+func (s *Server) AddFirmware(hw kobo.Hardware, version string, date time.Time) (string, error) {
+	buf, err := FirmwareZip(version, date)
+	if err != nil {
+		return "", err
+	}
+	return s.AddFile(FirmwarePath(hw, version, date), buf, date), nil
+}
+
+// FirmwarePath returns the path used by the Kobo download server for the
+// specified firmware.
+func FirmwarePath(hw kobo.Hardware, version string, date time.Time) string {
+	return "/firmwares/" + hw.String() + "/" + date.Format("Jan2006") + "/kobo-update-" + version + ".zip"
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/initialization" && r.Method == http.MethodGet {
+		s.serveInitialization(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	tmpl, _ := s.resources["upgrade_check"].(string)
+	s.mu.Unlock()
+	if args, ok := matchTemplate(tmpl, r.URL.Path); ok && r.Method == http.MethodGet {
+		s.serveUpgradeCheck(w, r, UpgradeCheck{
+			Device:    args[0],
+			Affiliate: args[1],
+			Version:   args[2],
+			Serial:    args[3],
+		})
+		return
+	}
+
+	s.mu.Lock()
+	f, ok := s.files[r.URL.Path]
+	s.mu.Unlock()
+	if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, r.URL.Path, f.modTime, bytes.NewReader(f.data))
+}
+
+// matchTemplate matches a path against the path of an upgrade check URL
+// template, returning the values of the placeholders {0} to {3}.
+func matchTemplate(tmpl, p string) ([4]string, bool) {
+	var args [4]string
+	if u, err := url.Parse(tmpl); err == nil {
+		tmpl = u.Path
+	}
+	ts, ps := strings.Split(tmpl, "/"), strings.Split(p, "/")
+	if tmpl == "" || len(ts) != len(ps) {
+		return args, false
+	}
+	var n int
+	for i, t := range ts {
+		if len(t) == 3 && t[0] == '{' && t[2] == '}' && t[1] >= '0' && t[1] <= '3' {
+			args[t[1]-'0'] = ps[i]
+			n++
+		} else if t != ps[i] {
+			return args, false
+		}
+	}
+	return args, n == 4
+}
+
+func (s *Server) serveInitialization(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	res := make(map[string]interface{}, len(s.resources))
+	for k, v := range s.resources {
+		if x, ok := v.(string); ok {
+			v = s.resolve(x)
+		}
+		res[k] = v
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"Resources": res,
+	})
+}
+
+func (s *Server) serveUpgradeCheck(w http.ResponseWriter, r *http.Request, c UpgradeCheck) {
+	s.mu.Lock()
+	s.checks = append(s.checks, c)
+	u, ok := s.matchUpgrade(c)
+	s.mu.Unlock()
+
+	if ok && u.Status != 0 {
+		http.Error(w, http.StatusText(u.Status), u.Status)
+		return
+	}
+
+	var res struct {
+		Data           interface{}
+		ReleaseNoteURL *string
+		UpgradeType    kobo.UpgradeType
+		UpgradeURL     *string
+	}
+	if ok {
+		res.Data = u.Result.Data
+		res.UpgradeType = u.Result.UpgradeType
+		if x := s.resolve(u.Result.ReleaseNoteURL); x != "" {
+			res.ReleaseNoteURL = &x
+		}
+		if x := s.resolve(u.Result.UpgradeURL); x != "" {
+			res.UpgradeURL = &x
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) matchUpgrade(c UpgradeCheck) (Upgrade, bool) {
+	for _, u := range s.upgrades {
+		if u.Device != 0 && u.Device.IDString() != c.Device {
+			continue
+		}
+		if u.Affiliate != "" && u.Affiliate != c.Affiliate {
+			continue
+		}
+		if u.Version != "" && u.Version != c.Version {
+			continue
+		}
+		return u, true
+	}
+	return Upgrade{}, false
+}
+
+func (s *Server) resolve(u string) string {
+	if strings.HasPrefix(u, "/") {
+		return s.URL + u
+	}
+	return u
+}
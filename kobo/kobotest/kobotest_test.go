@@ -0,0 +1,123 @@
+package kobotest
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+)
+
+func TestServerUpgradeCheck(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	date := time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)
+	s.AddUpgrade(
+		Upgrade{
+			Device:  kobo.DeviceClaraHD,
+			Version: "4.38.21908",
+		},
+		Upgrade{
+			Device:    kobo.DeviceClaraHD,
+			Affiliate: "Kobo",
+			Result: kobo.UpgradeCheckResult{
+				UpgradeType:    kobo.UpgradeTypeAvailable,
+				UpgradeURL:     FirmwarePath(kobo.DeviceClaraHD.Hardware(), "4.38.21908", date),
+				ReleaseNoteURL: "https://example.com/notes",
+			},
+		},
+		Upgrade{
+			Affiliate: "Broken",
+			Status:    http.StatusInternalServerError,
+		},
+	)
+
+	c := s.APIClient()
+	for _, tc := range []struct {
+		device, affiliate, version string
+		url                        string
+		err                        bool
+	}{
+		{kobo.DeviceClaraHD.IDString(), "Kobo", "4.20.14622", s.URL + "/firmwares/kobo7/Apr2023/kobo-update-4.38.21908.zip", false},
+		{kobo.DeviceClaraHD.IDString(), "Kobo", "4.38.21908", "", false},
+		{kobo.DeviceClaraHD.IDString(), "Indigo", "4.20.14622", "", false},
+		{kobo.DeviceForma.IDString(), "Kobo", "4.20.14622", "", false},
+		{kobo.DeviceForma.IDString(), "Broken", "4.20.14622", "", true},
+	} {
+		res, err := c.CheckUpgrade(context.Background(), tc.device, tc.affiliate, tc.version, "N0")
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s/%s/%s: expected error", tc.device, tc.affiliate, tc.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s/%s/%s: unexpected error: %v", tc.device, tc.affiliate, tc.version, err)
+			continue
+		}
+		if res.UpgradeURL != tc.url {
+			t.Errorf("%s/%s/%s: expected url %q, got %q", tc.device, tc.affiliate, tc.version, tc.url, res.UpgradeURL)
+		}
+		if res.UpgradeType.IsUpdate() != (tc.url != "") {
+			t.Errorf("%s/%s/%s: unexpected upgrade type %s", tc.device, tc.affiliate, tc.version, res.UpgradeType)
+		}
+	}
+
+	if n := len(s.UpgradeChecks()); n != 5 {
+		t.Errorf("expected 5 recorded upgrade checks, got %d", n)
+	}
+}
+
+func TestServerFirmware(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	date := time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)
+	u, err := s.AddFirmware(kobo.HardwareKobo7, "4.38.21908", date)
+	if err != nil {
+		t.Fatalf("add firmware: %v", err)
+	}
+
+	resp, err := s.Client().Get(u)
+	if err != nil {
+		t.Fatalf("get firmware: %v", err)
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read firmware: %v", err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatalf("read firmware zip: %v", err)
+	}
+	if _, err := z.Open("KoboRoot.tgz"); err != nil {
+		t.Errorf("open KoboRoot.tgz: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	req.Header.Set("Range", "bytes=10-")
+	resp, err = s.Client().Do(req)
+	if err != nil {
+		t.Fatalf("get firmware range: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("expected partial content, got status %d", resp.StatusCode)
+	}
+	if rbuf, _ := io.ReadAll(resp.Body); !bytes.Equal(rbuf, buf[10:]) {
+		t.Errorf("incorrect partial content")
+	}
+
+	if !bytes.Contains(FakeLibnickel("4.38.21908", date), []byte("Kobo Touch %2/%3")) || !strings.HasSuffix(u, "/kobo-update-4.38.21908.zip") {
+		t.Errorf("unexpected firmware")
+	}
+}
@@ -0,0 +1,350 @@
+// Package progress exports and imports the reading progress of books, so it
+// can be moved between devices.
+//
+// Books are matched between devices by ContentID, then by file name (ignoring
+// the folder and the kepub extension), then by ISBN, then by title and
+// author. The position in the book is only imported if the book has the same
+// format on both devices, since epubs and kepubs store it differently.
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+)
+
+// Export is the reading progress from a device.
+type Export struct {
+	Serial   string    `json:"serial,omitempty"`
+	Exported time.Time `json:"exported"`
+	Books    []Entry   `json:"books"`
+}
+
+// Entry is the reading progress of a book.
+type Entry struct {
+	ContentID string     `json:"content_id"`
+	Path      string     `json:"path,omitempty"` // for sideloaded books, see kobo.ContentIDToPath
+	Title     string     `json:"title"`
+	Author    string     `json:"author,omitempty"`
+	ISBN      string     `json:"isbn,omitempty"`
+	MimeType  string     `json:"mime_type,omitempty"`
+	Status    string     `json:"status"`
+	Progress  int        `json:"progress"`             // 0-100
+	ChapterID string     `json:"chapter_id,omitempty"` // ChapterIDBookmarked
+	LastRead  *time.Time `json:"last_read,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+}
+
+// Read reads the reading progress of the books which have been opened.
+func Read(ctx context.Context, d *db.DB) ([]Entry, error) {
+	bs, err := d.Books(ctx)
+	if err != nil {
+		return nil, err
+	}
+	es := []Entry{}
+	for _, b := range bs {
+		if b.ReadStatus == db.ReadStatusUnread && b.DateLastRead.IsZero() {
+			continue
+		}
+		p, _ := kobo.ContentIDToPath(b.ContentID)
+		es = append(es, Entry{
+			ContentID: b.ContentID,
+			Path:      p,
+			Title:     b.Title,
+			Author:    b.Attribution,
+			ISBN:      b.ISBN,
+			MimeType:  b.MimeType,
+			Status:    b.ReadStatus.String(),
+			Progress:  b.PercentRead,
+			ChapterID: b.ChapterIDBookmarked,
+			LastRead:  timePtr(b.DateLastRead),
+			Finished:  timePtr(b.LastTimeFinishedReading),
+		})
+	}
+	return es, nil
+}
+
+// WriteJSON writes an export as JSON.
+func WriteJSON(w io.Writer, x *Export) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "    ")
+	return e.Encode(x)
+}
+
+// ReadJSON reads an export written by WriteJSON.
+func ReadJSON(r io.Reader) (*Export, error) {
+	var x Export
+	if err := json.NewDecoder(r).Decode(&x); err != nil {
+		return nil, err
+	}
+	return &x, nil
+}
+
+// Match is how an entry was matched to a book.
+type Match string
+
+// Matches.
+const (
+	MatchNone      Match = ""
+	MatchContentID Match = "content_id"
+	MatchFile      Match = "file"
+	MatchISBN      Match = "isbn"
+	MatchTitle     Match = "title"
+)
+
+// Action is what will be done for an entry.
+type Action string
+
+// Actions.
+const (
+	ActionUpdate    Action = "update"
+	ActionUnchanged Action = "unchanged" // the book already has the same progress
+	ActionNewer     Action = "newer"     // the book was read more recently on the device
+	ActionUnmatched Action = "unmatched" // the book isn't on the device
+	ActionAmbiguous Action = "ambiguous" // more than one book matches
+)
+
+// Change is the result of matching an entry.
+type Change struct {
+	Entry     Entry
+	ContentID string // the matched book, if any
+	Match     Match
+	Action    Action
+	State     db.ReadingState // the new state if the Action is ActionUpdate
+	Position  bool            // whether the position in the book will be updated
+}
+
+// Options configures Plan.
+type Options struct {
+	// Force imports progress even if the book was read more recently on the
+	// device.
+	Force bool
+}
+
+// Plan matches the entries to the books in the database and determines the
+// changes to make.
+func Plan(ctx context.Context, d *db.DB, es []Entry, opts *Options) ([]Change, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := d.CheckSchema(map[string][]string{
+		"content": {"ReadStatus", "___PercentRead", "ChapterIDBookmarked", "DateLastRead"},
+	}); err != nil && !errors.Is(err, db.ErrUnknownVersion) {
+		return nil, err
+	}
+	bs, err := d.Books(ctx)
+	if err != nil {
+		return nil, err
+	}
+	idx := newIndex(bs)
+
+	cs := make([]Change, 0, len(es))
+	for _, e := range es {
+		c := Change{Entry: e}
+		b, m, n := idx.match(e)
+		switch {
+		case n == 0:
+			c.Action = ActionUnmatched
+		case n > 1:
+			c.Match, c.Action = m, ActionAmbiguous
+		default:
+			c.ContentID, c.Match = b.ContentID, m
+			c.State, c.Position = state(e, b)
+			switch {
+			case c.State.ReadStatus == b.ReadStatus && c.State.PercentRead == b.PercentRead && c.State.ChapterIDBookmarked == b.ChapterIDBookmarked:
+				c.Action = ActionUnchanged
+			case !opts.Force && e.LastRead != nil && b.DateLastRead.After(*e.LastRead):
+				c.Action = ActionNewer
+			case !opts.Force && e.LastRead == nil && !b.DateLastRead.IsZero():
+				c.Action = ActionNewer
+			default:
+				c.Action = ActionUpdate
+			}
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// Apply imports the progress for the changes with ActionUpdate.
+func Apply(ctx context.Context, tx *db.Tx, cs []Change) error {
+	for _, c := range cs {
+		if c.Action == ActionUpdate {
+			if err := tx.SetReadingState(ctx, c.ContentID, c.State); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// state determines the new reading state of b from the entry.
+func state(e Entry, b db.Book) (db.ReadingState, bool) {
+	rs := b.ReadingState
+	switch e.Status {
+	case db.ReadStatusUnread.String():
+		rs.ReadStatus = db.ReadStatusUnread
+	case db.ReadStatusReading.String():
+		rs.ReadStatus = db.ReadStatusReading
+	case db.ReadStatusFinished.String():
+		rs.ReadStatus = db.ReadStatusFinished
+	}
+	rs.PercentRead = min(max(e.Progress, 0), 100)
+	if e.LastRead != nil {
+		rs.DateLastRead = *e.LastRead
+	}
+	if e.Finished != nil {
+		rs.LastTimeFinishedReading = *e.Finished
+	}
+
+	// chapter IDs are sometimes prefixed with the book's ContentID (e.g.,
+	// for epubs), which may be different on this device
+	if e.MimeType != b.MimeType || e.ChapterID == "" {
+		return rs, false
+	}
+	rs.ChapterIDBookmarked = e.ChapterID
+	if rest, ok := strings.CutPrefix(e.ChapterID, e.ContentID); ok {
+		rs.ChapterIDBookmarked = b.ContentID + rest
+	}
+	return rs, true
+}
+
+// index finds books by the identities of entries.
+type index struct {
+	contentID map[string][]db.Book
+	file      map[string][]db.Book
+	isbn      map[string][]db.Book
+	title     map[string][]db.Book
+}
+
+func newIndex(bs []db.Book) *index {
+	idx := &index{
+		contentID: map[string][]db.Book{},
+		file:      map[string][]db.Book{},
+		isbn:      map[string][]db.Book{},
+		title:     map[string][]db.Book{},
+	}
+	for _, b := range bs {
+		idx.contentID[b.ContentID] = append(idx.contentID[b.ContentID], b)
+		if p, ok := kobo.ContentIDToPath(b.ContentID); ok {
+			k := fileKey(p)
+			idx.file[k] = append(idx.file[k], b)
+		}
+		if k := isbnKey(b.ISBN); k != "" {
+			idx.isbn[k] = append(idx.isbn[k], b)
+		}
+		if k := titleKey(b.Title, b.Attribution); k != "" {
+			idx.title[k] = append(idx.title[k], b)
+		}
+	}
+	return idx
+}
+
+// match finds the book for the entry, returning how it was matched and the
+// number of matching books.
+func (idx *index) match(e Entry) (db.Book, Match, int) {
+	for _, x := range []struct {
+		Match Match
+		Books []db.Book
+	}{
+		{MatchContentID, idx.contentID[e.ContentID]},
+		{MatchContentID, idx.contentID[pathContentID(e.Path)]},
+		{MatchFile, idx.file[fileKey(e.Path)]},
+		{MatchISBN, idx.isbn[isbnKey(e.ISBN)]},
+		{MatchTitle, idx.title[titleKey(e.Title, e.Author)]},
+	} {
+		if len(x.Books) == 1 {
+			return x.Books[0], x.Match, 1
+		} else if len(x.Books) > 1 {
+			return db.Book{}, x.Match, len(x.Books)
+		}
+	}
+	return db.Book{}, MatchNone, 0
+}
+
+// pathContentID gets the ContentID for a sideloaded book's path, or an empty
+// string if there isn't a path.
+func pathContentID(p string) string {
+	if p == "" {
+		return ""
+	}
+	return kobo.PathToContentID(p)
+}
+
+// fileKey gets the file name without the extension (e.g., a book converted
+// from an epub to a kepub has the same key).
+func fileKey(p string) string {
+	if p == "" {
+		return ""
+	}
+	name := strings.ToLower(path.Base(p))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	return strings.TrimSuffix(name, ".kepub")
+}
+
+// isbnKey normalizes an ISBN.
+func isbnKey(isbn string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == 'X' || r == 'x' {
+			return r
+		}
+		return -1
+	}, strings.ToUpper(isbn))
+}
+
+// titleKey normalizes a title and author.
+func titleKey(title, author string) string {
+	t := strings.Join(strings.Fields(strings.ToLower(title)), " ")
+	if t == "" {
+		return ""
+	}
+	return t + "\x00" + strings.Join(strings.Fields(strings.ToLower(author)), " ")
+}
+
+// Sort sorts changes by action, then title.
+func Sort(cs []Change) {
+	sort.SliceStable(cs, func(i, j int) bool {
+		if cs[i].Action != cs[j].Action {
+			return cs[i].Action < cs[j].Action
+		}
+		return strings.ToLower(cs[i].Entry.Title) < strings.ToLower(cs[j].Entry.Title)
+	})
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// String describes the change.
+func (c Change) String() string {
+	switch c.Action {
+	case ActionUpdate:
+		pos := "progress only"
+		if c.Position {
+			pos = "with position"
+		}
+		return fmt.Sprintf("%s: %s %d%% (%s, matched by %s)", c.Entry.Title, c.State.ReadStatus, c.State.PercentRead, pos, c.Match)
+	case ActionAmbiguous:
+		return fmt.Sprintf("%s: multiple books match by %s", c.Entry.Title, c.Match)
+	case ActionNewer:
+		return fmt.Sprintf("%s: read more recently on this device", c.Entry.Title)
+	case ActionUnchanged:
+		return fmt.Sprintf("%s: already up to date", c.Entry.Title)
+	case ActionUnmatched:
+		return fmt.Sprintf("%s: not on this device", c.Entry.Title)
+	}
+	return fmt.Sprintf("%s: %s", c.Entry.Title, c.Action)
+}
@@ -0,0 +1,155 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/db/dbtest"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestProgress(t *testing.T) {
+	src := dbtest.NewFile(t, dbtest.Recent,
+		`UPDATE content SET ChapterIDBookmarked = 'file:///mnt/onboard/Books/Emma.epub#(0)OEBPS/ch1.xhtml' WHERE ContentID = 'file:///mnt/onboard/Books/Emma.epub'`,
+	)
+	es, err := Read(context.Background(), dbtest.Open(t, src))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(es) != 2 {
+		t.Fatalf("expected 2 opened books, got %+v", es)
+	}
+	if e := es[0]; e.Title != "Dune" || e.Path != "Books/Dune.kepub.epub" || e.Status != "reading" || e.Progress != 42 || e.ChapterID != "OEBPS/ch2.xhtml#kobo.3.1" || e.LastRead == nil || !e.LastRead.Equal(date("2024-02-03T04:05:06Z")) {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, &Export{Serial: "N1", Exported: date("2024-02-06T00:00:00Z"), Books: es}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	x, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(x.Books, es) {
+		t.Errorf("json round-trip failed")
+	}
+
+	// the other device has Dune as an epub, and Emma in a different folder
+	dst := dbtest.NewFile(t, dbtest.Recent,
+		`UPDATE content SET ContentID = 'file:///mnt/onboard/Dune.epub', MimeType = 'application/epub+zip' WHERE ContentID = 'file:///mnt/onboard/Books/Dune.kepub.epub'`,
+		`UPDATE content SET ContentID = 'file:///mnt/onboard/Classics/Emma.epub' WHERE ContentID = 'file:///mnt/onboard/Books/Emma.epub'`,
+		`UPDATE content SET ReadStatus = 0, ___PercentRead = 0, ChapterIDBookmarked = NULL, DateLastRead = NULL, LastTimeFinishedReading = NULL WHERE ContentType = 6`,
+	)
+
+	d := dbtest.Open(t, dst)
+	cs, err := Plan(context.Background(), d, x.Books, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cs) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", cs)
+	}
+	if c := cs[0]; c.Action != ActionUpdate || c.Match != MatchFile || c.ContentID != "file:///mnt/onboard/Dune.epub" || c.Position || c.State.ChapterIDBookmarked != "" || c.State.PercentRead != 42 {
+		t.Errorf("expected Dune to be matched by file without position, got %+v", c)
+	}
+	if c := cs[1]; c.Action != ActionUpdate || c.Match != MatchFile || !c.Position || c.State.ChapterIDBookmarked != "file:///mnt/onboard/Classics/Emma.epub#(0)OEBPS/ch1.xhtml" {
+		t.Errorf("expected Emma to be matched by file with the chapter rewritten, got %+v", c)
+	}
+
+	if _, err := db.UpdateFile(context.Background(), dst, &db.UpdateOptions{BackupDir: t.TempDir()}, func(ctx context.Context, tx *db.Tx) error {
+		return Apply(ctx, tx, cs)
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if rs, err := d.ReadingState(context.Background(), "file:///mnt/onboard/Classics/Emma.epub"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if rs.ReadStatus != db.ReadStatusFinished || rs.PercentRead != 100 || rs.ChapterIDBookmarked != "file:///mnt/onboard/Classics/Emma.epub#(0)OEBPS/ch1.xhtml" || !rs.DateLastRead.Equal(date("2023-12-25T10:00:00Z")) || !rs.LastTimeFinishedReading.Equal(date("2023-12-25T10:00:00Z")) {
+		t.Errorf("unexpected imported state %+v", rs)
+	}
+	if rs, err := d.ReadingState(context.Background(), "file:///mnt/onboard/Dune.epub"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if rs.ReadStatus != db.ReadStatusReading || rs.PercentRead != 42 || rs.ChapterIDBookmarked != "" {
+		t.Errorf("unexpected imported state %+v", rs)
+	}
+
+	if cs, err = Plan(context.Background(), d, x.Books, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, c := range cs {
+		if c.Action != ActionUnchanged {
+			t.Errorf("expected no changes after import, got %+v", c)
+		}
+	}
+}
+
+func TestProgressNewer(t *testing.T) {
+	es, err := Read(context.Background(), dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent,
+		`UPDATE content SET ___PercentRead = 50, DateLastRead = '2024-03-01T00:00:00Z' WHERE ContentID = 'file:///mnt/onboard/Books/Dune.kepub.epub'`,
+	))
+	if cs, err := Plan(context.Background(), d, es, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if c := cs[0]; c.Action != ActionNewer || c.Match != MatchContentID {
+		t.Errorf("expected newer progress to be kept, got %+v", c)
+	}
+	if cs, err := Plan(context.Background(), d, es, &Options{Force: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if c := cs[0]; c.Action != ActionUpdate || !c.Position {
+		t.Errorf("expected progress to be imported with force, got %+v", c)
+	}
+}
+
+func TestProgressSchema(t *testing.T) {
+	es, err := Read(context.Background(), dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d := dbtest.Open(t, dbtest.NewFile(t, dbtest.Recent, `ALTER TABLE content DROP COLUMN ChapterIDBookmarked`))
+	if _, err := Plan(context.Background(), d, es, nil); !errors.Is(err, db.ErrUnsupportedSchema) {
+		t.Errorf("expected unsupported schema error, got %v", err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	idx := newIndex([]db.Book{
+		{ContentID: "store-1", Title: "Store Book", Attribution: "Someone", ISBN: "978-0-441-01359-3"},
+		{ContentID: "file:///mnt/onboard/a/Book.kepub.epub", Title: "Book", Attribution: "Author"},
+		{ContentID: "file:///mnt/onboard/a/Twice.epub", Title: "Twice"},
+		{ContentID: "file:///mnt/onboard/b/Twice.epub", Title: "Twice"},
+	})
+	for _, c := range []struct {
+		Entry Entry
+		ID    string
+		Match Match
+		N     int
+	}{
+		{Entry{ContentID: "store-1"}, "store-1", MatchContentID, 1},
+		{Entry{ContentID: "x", ISBN: "9780441013593"}, "store-1", MatchISBN, 1},
+		{Entry{ContentID: "x", Title: " store  BOOK", Author: "someone"}, "store-1", MatchTitle, 1},
+		{Entry{ContentID: "x", Path: "a/Book.kepub.epub"}, "file:///mnt/onboard/a/Book.kepub.epub", MatchContentID, 1},
+		{Entry{ContentID: "x", Path: "Books/book.epub"}, "file:///mnt/onboard/a/Book.kepub.epub", MatchFile, 1},
+		{Entry{ContentID: "x", Path: "Twice.epub"}, "", MatchFile, 2},
+		{Entry{ContentID: "x", Path: "Other.epub", Title: "Other"}, "", MatchNone, 0},
+	} {
+		if b, m, n := idx.match(c.Entry); b.ContentID != c.ID || m != c.Match || n != c.N {
+			t.Errorf("%+v: expected %q %q %d, got %q %q %d", c.Entry, c.ID, c.Match, c.N, b.ContentID, m, n)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+// Package relnotes fetches and renders Kobo firmware release notes.
+package relnotes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Format is an output format for release notes.
+type Format string
+
+// Formats.
+const (
+	FormatText     Format = "text"
+	FormatMarkdown Format = "markdown"
+	FormatHTML     Format = "html"
+)
+
+// ParseFormat parses a format name.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatText, FormatMarkdown, FormatHTML:
+		return f, nil
+	case "md":
+		return FormatMarkdown, nil
+	case "txt":
+		return FormatText, nil
+	}
+	return "", fmt.Errorf("unknown release notes format %q", s)
+}
+
+// Fetcher fetches release notes, optionally caching them.
+type Fetcher struct {
+	// Client is used to fetch the release notes. If nil,
+	// kobo.DefaultAPIClient is used.
+	Client *kobo.APIClient
+
+	// CacheDir, if not empty, is a directory to cache the release notes HTML
+	// in by version.
+	CacheDir string
+}
+
+// Fetch gets the release notes HTML for a firmware version. If version is not
+// empty and the notes are cached, url is not fetched.
+func (f *Fetcher) Fetch(ctx context.Context, version, url string) ([]byte, error) {
+	var cache string
+	if f.CacheDir != "" && version != "" {
+		if _, err := kobo.ParseVersion(version); err != nil {
+			return nil, fmt.Errorf("invalid version for cache: %w", err)
+		}
+		cache = filepath.Join(f.CacheDir, version+".html")
+		if buf, err := os.ReadFile(cache); err == nil {
+			return buf, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("read cache: %w", err)
+		}
+	}
+
+	if url == "" {
+		return nil, errors.New("no release notes url")
+	}
+
+	c := f.Client
+	if c == nil {
+		c = kobo.DefaultAPIClient
+	}
+
+	buf, err := c.ReleaseNotes(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != "" {
+		if err := os.MkdirAll(f.CacheDir, 0777); err != nil {
+			return buf, fmt.Errorf("write cache: %w", err)
+		}
+		if err := os.WriteFile(cache, buf, 0666); err != nil {
+			return buf, fmt.Errorf("write cache: %w", err)
+		}
+	}
+	return buf, nil
+}
+
+// Render converts release notes HTML into the specified format.
+func Render(buf []byte, f Format) (string, error) {
+	switch f {
+	case FormatHTML:
+		return string(buf), nil
+	case FormatText, FormatMarkdown:
+		doc, err := html.Parse(bytes.NewReader(buf))
+		if err != nil {
+			return "", fmt.Errorf("parse html: %w", err)
+		}
+		r := &renderer{md: f == FormatMarkdown}
+		r.block(doc)
+		return r.String(), nil
+	}
+	return "", fmt.Errorf("unknown release notes format %q", f)
+}
+
+// Text converts release notes HTML to plain text.
+func Text(buf []byte) (string, error) {
+	return Render(buf, FormatText)
+}
+
+// Markdown converts release notes HTML to Markdown.
+func Markdown(buf []byte) (string, error) {
+	return Render(buf, FormatMarkdown)
+}
+
+// renderer converts HTML into text or Markdown.
+type renderer struct {
+	md     bool
+	code   bool // inside a code span, so Markdown isn't escaped
+	blocks []string
+	list   []listState
+}
+
+type listState struct {
+	ordered bool
+	n       int
+}
+
+var spaceRe = regexp.MustCompile(`\s+`)
+
+// mdEscaper escapes characters which have a special meaning in Markdown text.
+var mdEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`#`, `\#`,
+)
+
+func (r *renderer) String() string {
+	var b strings.Builder
+	for i, x := range r.blocks {
+		if i != 0 {
+			b.WriteString("\n")
+			if !(strings.HasPrefix(x, "  ") || isListItem(x)) || !(isListItem(r.blocks[i-1]) || strings.HasPrefix(r.blocks[i-1], "  ")) {
+				b.WriteString("\n")
+			}
+		}
+		b.WriteString(x)
+	}
+	if b.Len() != 0 {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func isListItem(s string) bool {
+	s = strings.TrimLeft(s, " ")
+	if strings.HasPrefix(s, "- ") {
+		return true
+	}
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	return i > 0 && strings.HasPrefix(s[i:], ". ")
+}
+
+// emit adds a block of text, collapsing whitespace other than line breaks.
+func (r *renderer) emit(s string) {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaceRe.ReplaceAllString(line, " "))
+	}
+	if s = strings.Trim(strings.Join(lines, "\n"), "\n"); s != "" {
+		r.blocks = append(r.blocks, s)
+	}
+}
+
+// block renders the children of n as blocks.
+func (r *renderer) block(n *html.Node) {
+	var inline strings.Builder
+	flush := func() {
+		r.emit(inline.String())
+		inline.Reset()
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			switch c.DataAtom {
+			case atom.Head, atom.Script, atom.Style, atom.Title, atom.Noscript:
+				continue
+			case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				flush()
+				text := r.inline(c)
+				if r.md {
+					text = strings.Repeat("#", int(c.Data[1]-'0')) + " " + text
+				}
+				r.emit(text)
+				continue
+			case atom.Ul, atom.Ol:
+				flush()
+				r.list = append(r.list, listState{ordered: c.DataAtom == atom.Ol})
+				r.block(c)
+				r.list = r.list[:len(r.list)-1]
+				continue
+			case atom.Li:
+				flush()
+				r.item(c)
+				continue
+			case atom.Hr:
+				flush()
+				if r.md {
+					r.emit("---")
+				}
+				continue
+			case atom.Br:
+				inline.WriteString("\n")
+				continue
+			case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Header, atom.Footer, atom.Body, atom.Html, atom.Table, atom.Tbody, atom.Thead, atom.Tr, atom.Blockquote:
+				flush()
+				r.block(c)
+				continue
+			}
+		}
+		inline.WriteString(r.inline1(c))
+	}
+	flush()
+}
+
+// item renders a list item.
+func (r *renderer) item(n *html.Node) {
+	depth := len(r.list) - 1
+	marker := "- "
+	if depth >= 0 && r.list[depth].ordered {
+		r.list[depth].n++
+		marker = fmt.Sprintf("%d. ", r.list[depth].n)
+	}
+	if depth < 0 {
+		depth = 0
+	}
+	indent := strings.Repeat("  ", depth)
+
+	// render nested blocks separately so they can be indented
+	sub := &renderer{md: r.md, code: r.code, list: r.list}
+	sub.block(n)
+	for i, x := range sub.blocks {
+		if i == 0 {
+			r.blocks = append(r.blocks, indent+marker+x)
+		} else if isListItem(x) || strings.HasPrefix(x, "  ") {
+			r.blocks = append(r.blocks, x)
+		} else {
+			r.blocks = append(r.blocks, indent+"  "+x)
+		}
+	}
+	r.list = sub.list
+}
+
+// inline renders the children of n as inline text.
+func (r *renderer) inline(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(r.inline1(c))
+	}
+	return b.String()
+}
+
+// inline1 renders n as inline text. Whitespace at the edges of elements is
+// kept outside of any Markdown formatting.
+func (r *renderer) inline1(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		text := spaceRe.ReplaceAllString(n.Data, " ")
+		if r.md && !r.code {
+			text = mdEscaper.Replace(text)
+		}
+		return text
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Script, atom.Style, atom.Img:
+			return ""
+		case atom.Br:
+			return "\n"
+		}
+		code := r.code
+		r.code = r.code || n.DataAtom == atom.Code
+		raw := r.inline(n)
+		r.code = code
+		text := strings.TrimSpace(raw)
+		if text == "" || !r.md {
+			return raw
+		}
+		i := strings.Index(raw, text)
+		pre, suf := raw[:i], raw[i+len(text):]
+		switch n.DataAtom {
+		case atom.B, atom.Strong:
+			text = "**" + text + "**"
+		case atom.I, atom.Em:
+			text = "*" + text + "*"
+		case atom.Code:
+			text = "`" + text + "`"
+		case atom.A:
+			for _, a := range n.Attr {
+				if a.Key == "href" && a.Val != "" && !strings.HasPrefix(a.Val, "#") && !strings.HasPrefix(a.Val, "javascript:") {
+					text = "[" + text + "](" + a.Val + ")"
+					break
+				}
+			}
+		}
+		return pre + text + suf
+	}
+	return ""
+}
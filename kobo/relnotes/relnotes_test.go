@@ -0,0 +1,154 @@
+package relnotes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/kobotest"
+)
+
+const testNotes = `<!DOCTYPE html>
+<html>
+<head>
+<title>Release Notes</title>
+<style>body { font-family: sans-serif; }</style>
+</head>
+<body>
+<h1>Software Update 4.38.21908</h1>
+<p>This update includes
+   the following <b>improvements</b>:</p>
+<ul>
+	<li>Improved <a href="https://help.kobo.com/">page turn</a> speed.</li>
+	<li>Fixed issues with:
+		<ol>
+			<li>Syncing</li>
+			<li><i>Dark mode</i></li>
+		</ol>
+	</li>
+	<li>Other fixes.</li>
+</ul>
+<hr>
+<p>Thanks for reading.<br>The Kobo Team</p>
+<script>alert(1)</script>
+</body>
+</html>
+`
+
+func TestText(t *testing.T) {
+	s, err := Text([]byte(testNotes))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := `Software Update 4.38.21908
+
+This update includes the following improvements:
+
+- Improved page turn speed.
+- Fixed issues with:
+  1. Syncing
+  2. Dark mode
+- Other fixes.
+
+Thanks for reading.
+The Kobo Team
+`
+	if s != exp {
+		t.Errorf("unexpected text:\n%s\nexpected:\n%s", s, exp)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	s, err := Markdown([]byte(testNotes))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := `# Software Update 4.38.21908
+
+This update includes the following **improvements**:
+
+- Improved [page turn](https://help.kobo.com/) speed.
+- Fixed issues with:
+  1. Syncing
+  2. *Dark mode*
+- Other fixes.
+
+---
+
+Thanks for reading.
+The Kobo Team
+`
+	if s != exp {
+		t.Errorf("unexpected markdown:\n%s\nexpected:\n%s", s, exp)
+	}
+}
+
+func TestMarkdownEscape(t *testing.T) {
+	s, err := Markdown([]byte(`<h2>#1 *New* [Beta]</h2><ul><li>Fixed file_name and 2*3 in <b>bold_text</b> and <code>a_b*c</code></li></ul>`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := "## \\#1 \\*New\\* \\[Beta\\]\n\n- Fixed file\\_name and 2\\*3 in **bold\\_text** and `a_b*c`\n"
+	if s != exp {
+		t.Errorf("unexpected markdown:\n%s\nexpected:\n%s", s, exp)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for s, exp := range map[string]Format{
+		"text":     FormatText,
+		"txt":      FormatText,
+		"Markdown": FormatMarkdown,
+		"md":       FormatMarkdown,
+		"html":     FormatHTML,
+	} {
+		if f, err := ParseFormat(s); err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		} else if f != exp {
+			t.Errorf("%q: expected %q, got %q", s, exp, f)
+		}
+	}
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srv := kobotest.NewServer()
+	defer srv.Close()
+
+	u := srv.AddFile("/notes/4.38.21908.html", []byte(testNotes), time.Now())
+
+	dir := t.TempDir()
+	f := &Fetcher{Client: srv.APIClient(), CacheDir: dir}
+
+	buf, err := f.Fetch(context.Background(), "4.38.21908", u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(buf) != testNotes {
+		t.Errorf("incorrect notes")
+	}
+
+	if cached, err := os.ReadFile(filepath.Join(dir, "4.38.21908.html")); err != nil {
+		t.Errorf("expected notes to be cached: %v", err)
+	} else if string(cached) != testNotes {
+		t.Errorf("incorrect cached notes")
+	}
+
+	srv.Close()
+	if buf, err := f.Fetch(context.Background(), "4.38.21908", u); err != nil {
+		t.Errorf("expected cached notes to be used, got error: %v", err)
+	} else if string(buf) != testNotes {
+		t.Errorf("incorrect notes from cache")
+	}
+
+	if _, err := f.Fetch(context.Background(), "../4.38.21908", u); err == nil {
+		t.Errorf("expected error for invalid version")
+	}
+	if _, err := f.Fetch(context.Background(), "4.39.0", u); err == nil {
+		t.Errorf("expected error for uncached notes with server closed")
+	}
+}
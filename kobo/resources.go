@@ -0,0 +1,263 @@
+package kobo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Resources is the resource map returned by the store initialization endpoint,
+// which nickel uses to find most of the endpoints it uses.
+type Resources struct {
+	// UpgradeCheck is the upgrade check URL template, with the placeholders
+	// {0}, {1}, {2}, and {3} for the device ID, affiliate, current version,
+	// and serial number.
+	UpgradeCheck string `json:"upgrade_check,omitempty"`
+
+	DeviceAuth              string `json:"device_auth,omitempty"`
+	DeviceRefresh           string `json:"device_refresh,omitempty"`
+	LibrarySync             string `json:"library_sync,omitempty"`
+	LibraryMetadata         string `json:"library_metadata,omitempty"`
+	ReadingState            string `json:"reading_state,omitempty"`
+	ImageHost               string `json:"image_host,omitempty"`
+	ImageURLTemplate        string `json:"image_url_template,omitempty"`
+	ImageURLQualityTemplate string `json:"image_url_quality_template,omitempty"`
+	DictionaryHost          string `json:"dictionary_host,omitempty"`
+	StoreHost               string `json:"store_host,omitempty"`
+	OAuthHost               string `json:"oauth_host,omitempty"`
+	UserProfile             string `json:"user_profile,omitempty"`
+	PostAnalyticsEvent      string `json:"post_analytics_event,omitempty"`
+
+	// Raw contains every resource by key, including the ones above. The
+	// non-empty typed fields take precedence over it when marshaling.
+	Raw map[string]json.RawMessage `json:"-"`
+}
+
+type resources Resources
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (r *Resources) UnmarshalJSON(buf []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return err
+	}
+	// a resource with an unexpected type is left empty, but the others are
+	// still decoded
+	var x resources
+	if err := json.Unmarshal(buf, &x); err != nil {
+		var terr *json.UnmarshalTypeError
+		if !errors.As(err, &terr) {
+			return err
+		}
+	}
+	*r = Resources(x)
+	r.Raw = raw
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r Resources) MarshalJSON() ([]byte, error) {
+	m, err := r.all()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// all returns every resource, with the non-empty typed fields taking
+// precedence.
+func (r Resources) all() (map[string]json.RawMessage, error) {
+	buf, err := json.Marshal(resources(r))
+	if err != nil {
+		return nil, err
+	}
+	var typed map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &typed); err != nil {
+		return nil, err
+	}
+	m := make(map[string]json.RawMessage, len(r.Raw)+len(typed))
+	for k, v := range r.Raw {
+		m[k] = v
+	}
+	for k, v := range typed {
+		m[k] = v
+	}
+	return m, nil
+}
+
+// String returns a string resource.
+func (r Resources) String(key string) (string, bool) {
+	m, err := r.all()
+	if err != nil {
+		return "", false
+	}
+	var s string
+	if v, ok := m[key]; !ok || json.Unmarshal(v, &s) != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// Bool returns a boolean resource (e.g., kobo_subscriptions_enabled), which are
+// usually encoded as the string True or False.
+func (r Resources) Bool(key string) (bool, bool) {
+	m, err := r.all()
+	if err != nil {
+		return false, false
+	}
+	v, ok := m[key]
+	if !ok {
+		return false, false
+	}
+	var b bool
+	if json.Unmarshal(v, &b) == nil {
+		return b, true
+	}
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// Keys returns the sorted keys of all resources.
+func (r Resources) Keys() []string {
+	m, _ := r.all()
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// UpgradeCheckURL returns the upgrade check URL from the UpgradeCheck
+// template. If the template doesn't contain any placeholders, the arguments
+// are appended as path segments.
+func (r Resources) UpgradeCheckURL(device, affiliate, curVersion, serial string) (string, bool) {
+	if r.UpgradeCheck == "" {
+		return "", false
+	}
+	args := []string{device, affiliate, curVersion, serial}
+	if !strings.Contains(r.UpgradeCheck, "{0}") {
+		u := strings.TrimSuffix(r.UpgradeCheck, "/")
+		for _, a := range args {
+			u += "/" + url.PathEscape(a)
+		}
+		return u, true
+	}
+	u := r.UpgradeCheck
+	for i, a := range args {
+		u = strings.ReplaceAll(u, fmt.Sprintf("{%d}", i), url.PathEscape(a))
+	}
+	return u, true
+}
+
+// ResourceChange is a difference between two sets of resources. Old or New is
+// nil if the resource was added or removed.
+type ResourceChange struct {
+	Key string
+	Old json.RawMessage
+	New json.RawMessage
+}
+
+func (c ResourceChange) String() string {
+	switch {
+	case c.Old == nil:
+		return "+ " + c.Key + ": " + string(c.New)
+	case c.New == nil:
+		return "- " + c.Key + ": " + string(c.Old)
+	default:
+		return "~ " + c.Key + ": " + string(c.Old) + " -> " + string(c.New)
+	}
+}
+
+// DiffResources returns the resources which were added, removed, or changed
+// between old and new, sorted by key.
+func DiffResources(old, new *Resources) ([]ResourceChange, error) {
+	var om, nm map[string]json.RawMessage
+	var err error
+	if old != nil {
+		if om, err = old.all(); err != nil {
+			return nil, err
+		}
+	}
+	if new != nil {
+		if nm, err = new.all(); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := map[string]struct{}{}
+	for k := range om {
+		keys[k] = struct{}{}
+	}
+	for k := range nm {
+		keys[k] = struct{}{}
+	}
+
+	var cs []ResourceChange
+	for k := range keys {
+		o, n := compactJSON(om[k]), compactJSON(nm[k])
+		if !bytes.Equal(o, n) {
+			cs = append(cs, ResourceChange{k, o, n})
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].Key < cs[j].Key
+	})
+	return cs, nil
+}
+
+func compactJSON(v json.RawMessage) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, v); err != nil {
+		return v
+	}
+	return b.Bytes()
+}
+
+// Initialization fetches the resources from the store initialization
+// endpoint, which is on the store API rather than the API used for upgrade
+// checks.
+func (c *APIClient) Initialization(ctx context.Context) (*Resources, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.storeBaseURL()+"/v1/initialization", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("response status %d", resp.StatusCode)
+	}
+
+	var res struct {
+		Resources *Resources
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Resources == nil {
+		return nil, fmt.Errorf("no resources in response")
+	}
+	return res.Resources, nil
+}
@@ -0,0 +1,129 @@
+package kobo
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidSerial is returned by ParseSerial if a serial number is malformed.
+var ErrInvalidSerial = errors.New("invalid serial number")
+
+// ErrSerialMismatch is returned by CheckSerial if the model number in a serial
+// number doesn't match the device.
+var ErrSerialMismatch = errors.New("serial number does not match device")
+
+// Serial is a parsed Kobo serial number.
+type Serial struct {
+	Serial  string   // normalized serial number
+	Model   string   // model number prefix (e.g., N418)
+	Devices []Device // devices with the model number, empty if unknown
+	Batch   string   // production batch code, empty if not present
+	Unit    string   // per-unit sequence number
+}
+
+// serialModels maps the model number prefix of Kobo serial numbers to the
+// devices using it. Some model numbers are used by more than one device ID
+// (e.g., for hardware revisions or storage sizes). Tolino devices don't use
+// Kobo serial numbers.
+var serialModels = map[string][]Device{
+	"N905": {DeviceTouchAB, DeviceTouchC},
+	"N613": {DeviceGlo},
+	"N705": {DeviceMini},
+	"N204": {DeviceAuraHD},
+	"N514": {DeviceAura},
+	"N250": {DeviceAuraH2O},
+	"N437": {DeviceGloHD},
+	"N587": {DeviceTouch2},
+	"N236": {DeviceAuraEdition2v1, DeviceAuraEdition2v2},
+	"N867": {DeviceAuraH2OEdition2v1, DeviceAuraH2OEdition2v2},
+	"N709": {DeviceAuraONE, DeviceAuraONELimitedEdition},
+	"N249": {DeviceClaraHD},
+	"N782": {DeviceForma, DeviceForma32},
+	"N873": {DeviceLibraH2O},
+	"N306": {DeviceNia},
+	"N778": {DeviceSage},
+	"N604": {DeviceElipsa},
+	"N418": {DeviceLibra2},
+	"N506": {DeviceClara2E},
+	"N605": {DeviceElipsa2E},
+	"N428": {DeviceLibraColour},
+	"N365": {DeviceClaraBW},
+	"N367": {DeviceClaraColour},
+}
+
+var serialRe = regexp.MustCompile(`^(N[0-9]{3})([0-9A-Z]{7,12})$`)
+
+// ParseSerial parses a Kobo serial number (the first field of .kobo/version).
+// If the serial number is malformed, an error wrapping ErrInvalidSerial is
+// returned. An unknown model number is not an error, but Devices will be
+// empty.
+//
+// Only the model number prefix is documented. The last six characters appear
+// to be a per-unit sequence number, and the characters between them and the
+// prefix are returned as the batch code without further decoding, since their
+// layout varies between models.
+func ParseSerial(serial string) (Serial, error) {
+	s := strings.ToUpper(strings.TrimSpace(serial))
+	m := serialRe.FindStringSubmatch(s)
+	if m == nil {
+		return Serial{}, fmt.Errorf("%w %q", ErrInvalidSerial, serial)
+	}
+	rest := m[2]
+	return Serial{
+		Serial:  s,
+		Model:   m[1],
+		Devices: append([]Device(nil), serialModels[m[1]]...),
+		Batch:   rest[:len(rest)-6],
+		Unit:    rest[len(rest)-6:],
+	}, nil
+}
+
+// Device returns the device for the serial number if the model number is only
+// used by a single device.
+func (s Serial) Device() (Device, bool) {
+	if len(s.Devices) != 1 {
+		return 0, false
+	}
+	return s.Devices[0], true
+}
+
+// Known returns true if the model number is known.
+func (s Serial) Known() bool {
+	return len(s.Devices) != 0
+}
+
+// Matches returns true if d is one of the devices for the model number.
+func (s Serial) Matches(d Device) bool {
+	for _, x := range s.Devices {
+		if x == d {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Serial) String() string {
+	return s.Serial
+}
+
+// CheckSerial parses a serial number and checks it against a device ID string
+// (e.g., from ParseKoboVersion). If the model number is known and doesn't
+// match the device, an error wrapping ErrSerialMismatch is returned along with
+// the parsed serial. Unknown model numbers and device IDs are not checked.
+func CheckSerial(serial, id string) (Serial, error) {
+	s, err := ParseSerial(serial)
+	if err != nil {
+		return s, err
+	}
+	d, ok := DeviceByID(id)
+	if !ok || !s.Known() || s.Matches(d) {
+		return s, nil
+	}
+	names := make([]string, len(s.Devices))
+	for i, x := range s.Devices {
+		names[i] = x.Name()
+	}
+	return s, fmt.Errorf("%w: %s is a %s, but the device is a %s", ErrSerialMismatch, s.Model, strings.Join(names, " or "), d.Name())
+}
@@ -0,0 +1,91 @@
+package kobo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSerial(t *testing.T) {
+	for _, c := range []struct {
+		In      string
+		Serial  string
+		Model   string
+		Devices []Device
+		Batch   string
+		Unit    string
+		Err     bool
+	}{
+		{In: "N418210012345", Serial: "N418210012345", Model: "N418", Devices: []Device{DeviceLibra2}, Batch: "210", Unit: "012345"},
+		{In: " n249185123456\n", Serial: "N249185123456", Model: "N249", Devices: []Device{DeviceClaraHD}, Batch: "185", Unit: "123456"},
+		{In: "N905B10123456", Serial: "N905B10123456", Model: "N905", Devices: []Device{DeviceTouchAB, DeviceTouchC}, Batch: "B10", Unit: "123456"},
+		{In: "N7821A0654321", Serial: "N7821A0654321", Model: "N782", Devices: []Device{DeviceForma, DeviceForma32}, Batch: "1A0", Unit: "654321"},
+		{In: "N9991234567", Serial: "N9991234567", Model: "N999", Batch: "1", Unit: "234567"},
+		{In: "N0", Err: true},
+		{In: "", Err: true},
+		{In: "X418210012345", Err: true},
+		{In: "N41821001234-", Err: true},
+		{In: "N41821001234567890", Err: true},
+	} {
+		s, err := ParseSerial(c.In)
+		if c.Err {
+			if !errors.Is(err, ErrInvalidSerial) {
+				t.Errorf("%q: expected ErrInvalidSerial, got %v", c.In, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.In, err)
+			continue
+		}
+		if s.Serial != c.Serial || s.Model != c.Model || s.Batch != c.Batch || s.Unit != c.Unit || len(s.Devices) != len(c.Devices) {
+			t.Errorf("%q: unexpected result %#v", c.In, s)
+			continue
+		}
+		for i := range c.Devices {
+			if s.Devices[i] != c.Devices[i] {
+				t.Errorf("%q: expected device %s, got %s", c.In, c.Devices[i], s.Devices[i])
+			}
+		}
+		if d, ok := s.Device(); ok != (len(c.Devices) == 1) || (ok && d != c.Devices[0]) {
+			t.Errorf("%q: unexpected single device %s (%t)", c.In, d, ok)
+		}
+	}
+}
+
+func TestSerialModels(t *testing.T) {
+	seen := map[Device]string{}
+	for model, ds := range serialModels {
+		for _, d := range ds {
+			if d.Family() == "" {
+				t.Errorf("%s: unknown device %d", model, d)
+			}
+			if prev, ok := seen[d]; ok {
+				t.Errorf("%s: device %s already used by %s", model, d, prev)
+			}
+			seen[d] = model
+		}
+	}
+}
+
+func TestCheckSerial(t *testing.T) {
+	if _, err := CheckSerial("N418210012345", DeviceLibra2.IDString()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := CheckSerial("N782210012345", DeviceForma32.IDString()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := CheckSerial("N999210012345", DeviceLibra2.IDString()); err != nil {
+		t.Errorf("unexpected error for unknown model: %v", err)
+	}
+	if _, err := CheckSerial("N418210012345", "00000000-0000-0000-0000-000000000999"); err != nil {
+		t.Errorf("unexpected error for unknown device: %v", err)
+	}
+	if s, err := CheckSerial("N249210012345", DeviceLibra2.IDString()); !errors.Is(err, ErrSerialMismatch) {
+		t.Errorf("expected ErrSerialMismatch, got %v", err)
+	} else if s.Model != "N249" {
+		t.Errorf("expected parsed serial to be returned")
+	}
+	if _, err := CheckSerial("N0", DeviceLibra2.IDString()); !errors.Is(err, ErrInvalidSerial) {
+		t.Errorf("expected ErrInvalidSerial, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Package sideload predicts how nickel will index a book before it is copied
+// to a device.
+//
+// The supported formats are the ones imported by current firmware. Differences
+// between devices and firmware versions aren't known, so they aren't
+// predicted.
+package sideload
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/epub"
+)
+
+// Format is a book format supported by nickel.
+type Format struct {
+	Name     string // e.g., kepub
+	Ext      string // the file extension, including the dot
+	MimeType string // the MimeType nickel stores in the database
+}
+
+// Formats are the formats nickel imports from the internal storage.
+var Formats = []Format{
+	{"kepub", ".kepub.epub", "application/x-kobo-epub+zip"}, // must be before epub
+	{"epub", ".epub", "application/epub+zip"},
+	{"pdf", ".pdf", "application/pdf"},
+	{"mobi", ".mobi", "application/x-mobipocket-ebook"},
+	{"txt", ".txt", "text/plain"},
+	{"html", ".html", "text/html"},
+	{"html", ".htm", "text/html"},
+	{"rtf", ".rtf", "text/rtf"},
+	{"cbz", ".cbz", "application/x-cbz"},
+	{"cbr", ".cbr", "application/x-cbr"},
+}
+
+// FormatByName gets the format for a file name by its extension.
+func FormatByName(name string) (Format, bool) {
+	lower := strings.ToLower(name)
+	for _, f := range Formats {
+		if strings.HasSuffix(lower, f.Ext) && len(lower) > len(f.Ext) {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// Options configures Preview.
+type Options struct {
+	// Path is the slash-separated path the book will be copied to, relative to
+	// the root of the device. If empty, it will be copied to the root.
+	Path string
+}
+
+// Book is how nickel will see a book.
+type Book struct {
+	ContentID string
+	ImageID   string
+	Title     string
+	Author    string // the creators, as shown by nickel
+	Format    Format
+	Covers    []Cover // empty if the book doesn't have a cover
+
+	// Supported is false if nickel won't show the book in the library, with
+	// the reason in Reason.
+	Supported bool
+	Reason    string
+
+	// Metadata is the EPUB metadata, if the book is a valid EPUB or kepub.
+	Metadata *epub.Metadata
+}
+
+// Cover is a cover image which nickel will generate for a book.
+type Cover struct {
+	Type kobo.CoverType
+	Path string      // see kobo.CoverType.GeneratePath
+	Size image.Point // the maximum size for the device
+}
+
+// Preview predicts how nickel will index the book at name when copied to dev.
+//
+// Like nickel, the title and author are read from the metadata of EPUBs and
+// kepubs. For other formats, or if there isn't any metadata, the title is the
+// file name and the author is empty. Covers are generated from the cover image
+// of EPUBs and kepubs, and the first page of PDFs and comics.
+func Preview(name string, dev kobo.Device, opts *Options) (*Book, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	rel := opts.Path
+	if rel == "" {
+		rel = filepath.Base(name)
+	}
+	rel = strings.TrimPrefix(path.Clean(filepath.ToSlash(rel)), "/")
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil, fmt.Errorf("invalid path %q", opts.Path)
+	}
+
+	if _, err := os.Stat(name); err != nil {
+		return nil, err
+	}
+
+	b := &Book{
+		ContentID: kobo.PathToContentID(rel),
+		Title:     path.Base(rel),
+		Supported: true,
+	}
+	b.ImageID = kobo.ContentIDToImageID(b.ContentID)
+
+	f, ok := FormatByName(rel)
+	if !ok {
+		b.Supported, b.Reason = false, fmt.Sprintf("unsupported file type %q", path.Ext(rel))
+		return b, nil
+	}
+	b.Format = f
+	b.Title = b.Title[:len(b.Title)-len(f.Ext)]
+
+	for _, c := range strings.Split(path.Dir(rel), "/") {
+		if strings.HasPrefix(c, ".") && c != "." {
+			b.Supported, b.Reason = false, fmt.Sprintf("nickel doesn't import books from hidden folders (%s)", c)
+			return b, nil
+		}
+	}
+
+	switch f.Name {
+	case "epub", "kepub":
+		m, err := epub.Open(name)
+		if errors.Is(err, epub.ErrNotEPUB) {
+			b.Supported, b.Reason = false, err.Error()
+			return b, nil
+		} else if err != nil {
+			return nil, err
+		}
+		b.Metadata = m
+		if m.Title != "" {
+			b.Title = m.Title
+		}
+		b.Author = strings.Join(m.Creators, ", ")
+		if m.Cover != "" {
+			b.Covers = covers(dev, b.ImageID)
+		}
+	case "pdf", "cbz", "cbr":
+		b.Covers = covers(dev, b.ImageID) // from the first page
+	}
+	return b, nil
+}
+
+// covers gets the covers nickel generates for an ImageID.
+func covers(dev kobo.Device, iid string) []Cover {
+	var cs []Cover
+	for _, t := range kobo.CoverTypes() {
+		cs = append(cs, Cover{
+			Type: t,
+			Path: t.GeneratePath(false, iid),
+			Size: dev.CoverSize(t),
+		})
+	}
+	return cs
+}
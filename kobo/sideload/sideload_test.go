@@ -0,0 +1,72 @@
+package sideload
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pgaskin/koboutils/v2/kobo"
+	"github.com/pgaskin/koboutils/v2/kobo/epub/epubtest"
+)
+
+func TestPreview(t *testing.T) {
+	dir := t.TempDir()
+	book := filepath.Join(dir, "dune.epub")
+	epubtest.Write(t, book, `
+<dc:title>Dune</dc:title>
+<dc:creator>Frank Herbert</dc:creator>
+<dc:creator>Someone Else</dc:creator>
+`)
+	untitled := filepath.Join(dir, "untitled.epub")
+	epubtest.Write(t, untitled, ``)
+	for _, name := range []string{"invalid.epub", "doc.pdf", "notes.docx"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("test"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := Preview(book, kobo.DeviceLibra2, &Options{Path: "Books/Dune.kepub.epub"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.ContentID != "file:///mnt/onboard/Books/Dune.kepub.epub" || b.ImageID != "file____mnt_onboard_Books_Dune_kepub_epub" {
+		t.Errorf("unexpected ids %q %q", b.ContentID, b.ImageID)
+	}
+	if b.Title != "Dune" || b.Author != "Frank Herbert, Someone Else" || b.Format.Name != "kepub" || b.Format.MimeType != "application/x-kobo-epub+zip" || !b.Supported || b.Metadata == nil {
+		t.Errorf("unexpected book %+v", b)
+	}
+	if len(b.Covers) != 4 || b.Covers[0].Type != kobo.CoverTypeFull || b.Covers[0].Path != kobo.CoverTypeFull.GeneratePath(false, b.ImageID) || b.Covers[0].Size != kobo.DeviceLibra2.CoverSize(kobo.CoverTypeFull) {
+		t.Errorf("unexpected covers %+v", b.Covers)
+	}
+
+	for _, c := range []struct {
+		Name, Path string
+		Title      string
+		Format     string
+		Covers     int
+		Supported  bool
+	}{
+		{untitled, "", "untitled", "epub", 4, true},
+		{untitled, "/Books/Some Book.EPUB", "Some Book", "epub", 4, true},
+		{untitled, ".hidden/Untitled.epub", "Untitled", "epub", 0, false},
+		{filepath.Join(dir, "invalid.epub"), "", "invalid", "epub", 0, false},
+		{filepath.Join(dir, "doc.pdf"), "", "doc", "pdf", 4, true},
+		{filepath.Join(dir, "notes.docx"), "", "notes.docx", "", 0, false},
+	} {
+		b, err := Preview(c.Name, kobo.DeviceClaraHD, &Options{Path: c.Path})
+		if err != nil {
+			t.Errorf("%s (%s): unexpected error: %v", c.Name, c.Path, err)
+			continue
+		}
+		if b.Title != c.Title || b.Format.Name != c.Format || len(b.Covers) != c.Covers || b.Supported != c.Supported || (b.Reason == "") != c.Supported || (b.Metadata != nil && !c.Supported) {
+			t.Errorf("%s (%s): unexpected book %+v", c.Name, c.Path, b)
+		}
+	}
+
+	if _, err := Preview(book, kobo.DeviceLibra2, &Options{Path: "../Dune.epub"}); err == nil {
+		t.Errorf("expected error for path outside the device")
+	}
+	if _, err := Preview(filepath.Join(dir, "missing.epub"), kobo.DeviceLibra2, nil); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package stats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tables are the tables which can be written as CSV.
+var Tables = []string{"books", "days", "devices"}
+
+// WriteJSON writes the statistics as JSON. The output can be read with
+// ReadJSON to merge it with other statistics.
+func WriteJSON(w io.Writer, st *Stats) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "    ")
+	return e.Encode(st)
+}
+
+// ReadJSON reads statistics written by WriteJSON.
+func ReadJSON(r io.Reader) (*Stats, error) {
+	var st Stats
+	if err := json.NewDecoder(r).Decode(&st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// WriteCSV writes a table of the statistics as CSV.
+func WriteCSV(w io.Writer, st *Stats, table string) error {
+	var rows [][]string
+	switch table {
+	case "books":
+		rows = append(rows, []string{
+			"content_id", "title", "author", "devices", "status", "progress",
+			"reading_seconds", "sessions", "pages_turned", "pages_per_hour",
+			"words_per_minute", "first_read", "last_read", "finished",
+		})
+		for _, b := range st.Books {
+			rows = append(rows, []string{
+				b.ContentID, b.Title, b.Author, strings.Join(b.Devices, " "), b.Status, strconv.Itoa(b.Progress),
+				i64(b.ReadingSeconds), strconv.Itoa(b.Sessions), strconv.Itoa(b.PagesTurned), f64(b.PagesPerHour),
+				f64(b.WordsPerMinute), tm(b.FirstRead), tm(b.LastRead), tm(b.Finished),
+			})
+		}
+	case "days":
+		rows = append(rows, []string{
+			"date", "reading_seconds", "sessions", "pages_turned", "books",
+		})
+		for _, d := range st.Days {
+			rows = append(rows, []string{
+				d.Date, i64(d.ReadingSeconds), strconv.Itoa(d.Sessions), strconv.Itoa(d.PagesTurned), strconv.Itoa(d.Books),
+			})
+		}
+	case "devices":
+		rows = append(rows, []string{
+			"serial", "device", "reading_seconds", "sessions", "pages_turned",
+			"books_started", "books_finished", "days_read", "current_streak",
+			"longest_streak", "first_read", "last_read",
+		})
+		for _, d := range st.Devices {
+			rows = append(rows, []string{
+				d.Serial, d.Device, i64(d.ReadingSeconds), strconv.Itoa(d.Sessions), strconv.Itoa(d.PagesTurned),
+				strconv.Itoa(d.BooksStarted), strconv.Itoa(d.BooksFinished), strconv.Itoa(d.DaysRead), strconv.Itoa(d.CurrentStreak),
+				strconv.Itoa(d.LongestStreak), tm(d.FirstRead), tm(d.LastRead),
+			})
+		}
+	default:
+		return fmt.Errorf("unknown stats table %q", table)
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func i64(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func f64(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func tm(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
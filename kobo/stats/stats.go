@@ -0,0 +1,508 @@
+// Package stats aggregates reading statistics from the nickel database.
+//
+// Statistics are collected from each device as a Source, which contains the
+// per-book totals from the content table and the reading sessions from the
+// analytics events. Sources from multiple devices (or from the same device at
+// different times) can then be aggregated together. Since nickel removes
+// analytics events after uploading them, the per-day statistics and streaks
+// only cover the sessions which were still on the device when collected, or
+// in an earlier source from the same device.
+package stats
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+)
+
+// Source is the raw reading statistics from a single device.
+type Source struct {
+	Serial    string       `json:"serial"`
+	Device    string       `json:"device,omitempty"`
+	Collected time.Time    `json:"collected"`
+	Books     []SourceBook `json:"books"`
+	Sessions  []Session    `json:"sessions"`
+}
+
+// SourceBook is the reading state of a book on a device.
+type SourceBook struct {
+	ContentID      string     `json:"content_id"`
+	Title          string     `json:"title"`
+	Author         string     `json:"author,omitempty"`
+	Status         string     `json:"status"`
+	Progress       int        `json:"progress"` // 0-100
+	ReadingSeconds int64      `json:"reading_seconds"`
+	WordCount      int64      `json:"word_count,omitempty"`
+	LastRead       *time.Time `json:"last_read,omitempty"`
+	Finished       *time.Time `json:"finished,omitempty"`
+}
+
+// Session is a reading session on a device.
+type Session struct {
+	ID            string    `json:"id"`
+	ContentID     string    `json:"content_id"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Seconds       int64     `json:"seconds"`
+	PagesTurned   int       `json:"pages_turned"`
+	WordsRead     int       `json:"words_read,omitempty"`
+	StartProgress float64   `json:"start_progress"` // -1 if unknown
+	EndProgress   float64   `json:"end_progress"`   // -1 if unknown
+}
+
+// Collect collects the reading statistics from the database of the device
+// with the specified serial number. Only books which have been opened are
+// included.
+func Collect(ctx context.Context, d *db.DB, serial, device string, now time.Time) (*Source, error) {
+	bs, err := d.Books(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := d.ReadingSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &Source{
+		Serial:    serial,
+		Device:    device,
+		Collected: now.UTC(),
+		Books:     []SourceBook{},
+		Sessions:  []Session{},
+	}
+	read := map[string]bool{}
+	for _, s := range rs {
+		read[s.ContentID] = true
+		src.Sessions = append(src.Sessions, Session{
+			ID:            s.ID,
+			ContentID:     s.ContentID,
+			Start:         s.Start,
+			End:           s.End,
+			Seconds:       int64(s.Duration / time.Second),
+			PagesTurned:   s.PagesTurned,
+			WordsRead:     s.WordsRead,
+			StartProgress: s.StartProgress,
+			EndProgress:   s.EndProgress,
+		})
+	}
+	for _, b := range bs {
+		if b.ReadStatus == db.ReadStatusUnread && b.TimeSpentReading == 0 && !read[b.ContentID] {
+			continue
+		}
+		src.Books = append(src.Books, SourceBook{
+			ContentID:      b.ContentID,
+			Title:          b.Title,
+			Author:         b.Attribution,
+			Status:         b.ReadStatus.String(),
+			Progress:       b.PercentRead,
+			ReadingSeconds: int64(b.TimeSpentReading / time.Second),
+			WordCount:      b.WordCount,
+			LastRead:       timePtr(b.DateLastRead),
+			Finished:       timePtr(b.LastTimeFinishedReading),
+		})
+	}
+	return src, nil
+}
+
+// Stats is the aggregated reading statistics.
+type Stats struct {
+	Summary Summary       `json:"summary"`
+	Devices []DeviceStats `json:"devices"`
+	Books   []BookStats   `json:"books"`
+	Days    []DayStats    `json:"days"`
+	Sources []Source      `json:"sources"` // so the stats can be merged later
+}
+
+// Summary is the overall statistics for one or more devices.
+type Summary struct {
+	ReadingSeconds int64      `json:"reading_seconds"`
+	Sessions       int        `json:"sessions"`
+	PagesTurned    int        `json:"pages_turned"`
+	BooksStarted   int        `json:"books_started"`
+	BooksFinished  int        `json:"books_finished"`
+	DaysRead       int        `json:"days_read"`
+	CurrentStreak  int        `json:"current_streak"` // consecutive days read up to today or yesterday
+	LongestStreak  int        `json:"longest_streak"`
+	FirstRead      *time.Time `json:"first_read,omitempty"`
+	LastRead       *time.Time `json:"last_read,omitempty"`
+}
+
+// DeviceStats is the statistics for a device.
+type DeviceStats struct {
+	Serial string `json:"serial"`
+	Device string `json:"device,omitempty"`
+	Summary
+}
+
+// BookStats is the statistics for a book across all devices.
+type BookStats struct {
+	ContentID      string     `json:"content_id"`
+	Title          string     `json:"title"`
+	Author         string     `json:"author,omitempty"`
+	Devices        []string   `json:"devices"` // serials
+	Status         string     `json:"status"`
+	Progress       int        `json:"progress"`
+	ReadingSeconds int64      `json:"reading_seconds"`
+	Sessions       int        `json:"sessions"`
+	PagesTurned    int        `json:"pages_turned"`
+	PagesPerHour   float64    `json:"pages_per_hour,omitempty"`   // estimated from sessions, zero if unknown
+	WordsPerMinute float64    `json:"words_per_minute,omitempty"` // estimated from sessions or the word count, zero if unknown
+	FirstRead      *time.Time `json:"first_read,omitempty"`       // only known if there are sessions
+	LastRead       *time.Time `json:"last_read,omitempty"`
+	Finished       *time.Time `json:"finished,omitempty"`
+}
+
+// DayStats is the statistics for a day across all devices.
+type DayStats struct {
+	Date           string `json:"date"` // 2006-01-02
+	ReadingSeconds int64  `json:"reading_seconds"`
+	Sessions       int    `json:"sessions"`
+	PagesTurned    int    `json:"pages_turned"`
+	Books          int    `json:"books"`
+}
+
+// Options configures Aggregate.
+type Options struct {
+	// Location is the time zone for days. If nil, it is time.Local.
+	Location *time.Location
+
+	// Now is used for the current streak. If zero, it is time.Now.
+	Now time.Time
+}
+
+// Aggregate aggregates the statistics from one or more sources. If there are
+// multiple sources with the same serial number, the books are taken from the
+// most recently collected one, and the sessions from all of them are combined
+// (since nickel removes them after uploading them), de-duplicated by ID.
+func Aggregate(srcs []Source, opts *Options) *Stats {
+	if opts == nil {
+		opts = &Options{}
+	}
+	loc, now := opts.Location, opts.Now
+	if loc == nil {
+		loc = time.Local
+	}
+	if now.IsZero() {
+		now = time.Now()
+	}
+	today := now.In(loc).Format("2006-01-02")
+
+	srcs = mergeSources(srcs)
+
+	st := &Stats{
+		Devices: []DeviceStats{},
+		Books:   []BookStats{},
+		Days:    []DayStats{},
+		Sources: srcs,
+	}
+	books := map[string]*book{}
+	days := map[string]*day{}
+	for _, src := range srcs {
+		dbooks := bookStats(src)
+		ddays := dayStats(src.Sessions, loc)
+		st.Devices = append(st.Devices, DeviceStats{
+			Serial:  src.Serial,
+			Device:  src.Device,
+			Summary: summarize(dbooks, ddays, today),
+		})
+		for cid, b := range dbooks {
+			if x, ok := books[cid]; ok {
+				x.merge(b)
+			} else {
+				books[cid] = b
+			}
+		}
+		for date, d := range ddays {
+			if x, ok := days[date]; ok {
+				x.merge(d)
+			} else {
+				days[date] = d
+			}
+		}
+	}
+	st.Summary = summarize(books, days, today)
+
+	for _, b := range books {
+		st.Books = append(st.Books, b.stats())
+	}
+	sort.Slice(st.Books, func(i, j int) bool {
+		if x, y := strings.ToLower(st.Books[i].Title), strings.ToLower(st.Books[j].Title); x != y {
+			return x < y
+		}
+		return st.Books[i].ContentID < st.Books[j].ContentID
+	})
+	for _, d := range days {
+		st.Days = append(st.Days, d.stats())
+	}
+	sort.Slice(st.Days, func(i, j int) bool {
+		return st.Days[i].Date < st.Days[j].Date
+	})
+	return st
+}
+
+// mergeSources merges the sources with the same serial number, sorted by the
+// serial number.
+func mergeSources(srcs []Source) []Source {
+	byTime := make([]Source, len(srcs))
+	copy(byTime, srcs)
+	sort.SliceStable(byTime, func(i, j int) bool {
+		return byTime[i].Collected.After(byTime[j].Collected)
+	})
+
+	merged := map[string]*Source{}
+	seen := map[string]map[string]bool{}
+	for _, src := range byTime {
+		m, ok := merged[src.Serial]
+		if !ok {
+			m = &Source{
+				Serial:    src.Serial,
+				Device:    src.Device,
+				Collected: src.Collected,
+				Books:     src.Books,
+				Sessions:  []Session{},
+			}
+			merged[src.Serial], seen[src.Serial] = m, map[string]bool{}
+		}
+		for _, s := range src.Sessions {
+			if !seen[src.Serial][s.ID] {
+				seen[src.Serial][s.ID] = true
+				m.Sessions = append(m.Sessions, s)
+			}
+		}
+	}
+
+	srcs = make([]Source, 0, len(merged))
+	for _, m := range merged {
+		sort.Slice(m.Sessions, func(i, j int) bool {
+			if x, y := m.Sessions[i].Start, m.Sessions[j].Start; !x.Equal(y) {
+				return x.Before(y)
+			}
+			return m.Sessions[i].ID < m.Sessions[j].ID
+		})
+		srcs = append(srcs, *m)
+	}
+	sort.Slice(srcs, func(i, j int) bool {
+		return srcs[i].Serial < srcs[j].Serial
+	})
+	return srcs
+}
+
+// book accumulates the statistics for a book.
+type book struct {
+	BookStats
+	sessionSeconds int64
+	words          int64 // from sessions
+	wordCount      int64
+}
+
+// bookStats calculates the statistics for the books on a device.
+func bookStats(src Source) map[string]*book {
+	books := map[string]*book{}
+	get := func(cid string) *book {
+		b, ok := books[cid]
+		if !ok {
+			b = &book{BookStats: BookStats{
+				ContentID: cid,
+				Title:     cid,
+				Devices:   []string{src.Serial},
+				Status:    db.ReadStatusUnread.String(),
+			}}
+			books[cid] = b
+		}
+		return b
+	}
+	for _, sb := range src.Books {
+		b := get(sb.ContentID)
+		b.Title = sb.Title
+		b.Author = sb.Author
+		b.Status = sb.Status
+		b.Progress = sb.Progress
+		b.ReadingSeconds = sb.ReadingSeconds
+		b.wordCount = sb.WordCount
+		b.LastRead = sb.LastRead
+		b.Finished = sb.Finished
+	}
+	for _, s := range src.Sessions {
+		b := get(s.ContentID)
+		b.Sessions++
+		b.PagesTurned += s.PagesTurned
+		b.sessionSeconds += s.Seconds
+		b.words += int64(s.WordsRead)
+		b.FirstRead = minTime(b.FirstRead, timePtr(s.Start))
+		b.LastRead = maxTime(b.LastRead, timePtr(s.End))
+	}
+	for _, b := range books {
+		// the content row has the total, but sessions may be more recent
+		b.ReadingSeconds = max(b.ReadingSeconds, b.sessionSeconds)
+	}
+	return books
+}
+
+// merge merges the statistics for the same book from another device.
+func (b *book) merge(o *book) {
+	if b.Title == b.ContentID {
+		b.Title, b.Author = o.Title, o.Author
+	}
+	b.Devices = append(b.Devices, o.Devices...)
+	if statusRank(o.Status) > statusRank(b.Status) {
+		b.Status = o.Status
+	}
+	b.Progress = max(b.Progress, o.Progress)
+	b.ReadingSeconds += o.ReadingSeconds
+	b.Sessions += o.Sessions
+	b.PagesTurned += o.PagesTurned
+	b.FirstRead = minTime(b.FirstRead, o.FirstRead)
+	b.LastRead = maxTime(b.LastRead, o.LastRead)
+	b.Finished = maxTime(b.Finished, o.Finished)
+	b.sessionSeconds += o.sessionSeconds
+	b.words += o.words
+	b.wordCount = max(b.wordCount, o.wordCount)
+}
+
+func (b *book) stats() BookStats {
+	s := b.BookStats
+	sort.Strings(s.Devices)
+	if b.sessionSeconds > 0 {
+		s.PagesPerHour = round1(float64(b.PagesTurned) / float64(b.sessionSeconds) * 3600)
+	}
+	switch {
+	case b.words > 0 && b.sessionSeconds > 0:
+		s.WordsPerMinute = round1(float64(b.words) / float64(b.sessionSeconds) * 60)
+	case b.wordCount > 0 && b.Progress > 0 && b.ReadingSeconds > 0:
+		s.WordsPerMinute = round1(float64(b.wordCount) * float64(b.Progress) / 100 / float64(b.ReadingSeconds) * 60)
+	}
+	return s
+}
+
+// day accumulates the statistics for a day.
+type day struct {
+	DayStats
+	books map[string]bool
+}
+
+// dayStats calculates the statistics for each day with sessions.
+func dayStats(sessions []Session, loc *time.Location) map[string]*day {
+	days := map[string]*day{}
+	for _, s := range sessions {
+		date := s.Start.In(loc).Format("2006-01-02")
+		d, ok := days[date]
+		if !ok {
+			d = &day{DayStats: DayStats{Date: date}, books: map[string]bool{}}
+			days[date] = d
+		}
+		d.ReadingSeconds += s.Seconds
+		d.Sessions++
+		d.PagesTurned += s.PagesTurned
+		d.books[s.ContentID] = true
+	}
+	return days
+}
+
+func (d *day) merge(o *day) {
+	d.ReadingSeconds += o.ReadingSeconds
+	d.Sessions += o.Sessions
+	d.PagesTurned += o.PagesTurned
+	for cid := range o.books {
+		d.books[cid] = true
+	}
+}
+
+func (d *day) stats() DayStats {
+	s := d.DayStats
+	s.Books = len(d.books)
+	return s
+}
+
+// summarize calculates the summary for the books and days.
+func summarize(books map[string]*book, days map[string]*day, today string) Summary {
+	var s Summary
+	for _, b := range books {
+		s.ReadingSeconds += b.ReadingSeconds
+		s.Sessions += b.Sessions
+		s.PagesTurned += b.PagesTurned
+		if b.Status != db.ReadStatusUnread.String() || b.ReadingSeconds > 0 {
+			s.BooksStarted++
+		}
+		if b.Status == db.ReadStatusFinished.String() {
+			s.BooksFinished++
+		}
+		s.FirstRead = minTime(s.FirstRead, b.FirstRead)
+		s.LastRead = maxTime(s.LastRead, b.LastRead)
+	}
+
+	dates := make([]string, 0, len(days))
+	for date := range days {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	s.DaysRead = len(dates)
+	s.CurrentStreak, s.LongestStreak = streaks(dates, today)
+	return s
+}
+
+// streaks calculates the current and longest runs of consecutive days from
+// the sorted dates.
+func streaks(dates []string, today string) (current, longest int) {
+	var run int
+	var prev time.Time
+	for _, date := range dates {
+		t, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			continue
+		}
+		if !prev.IsZero() && t.Sub(prev) == 24*time.Hour {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+		prev = t
+	}
+	if t, err := time.Parse("2006-01-02", today); err == nil && !prev.IsZero() {
+		if d := t.Sub(prev); d >= 0 && d <= 24*time.Hour {
+			current = run
+		}
+	}
+	return current, longest
+}
+
+// statusRank orders read statuses by how far along they are.
+func statusRank(s string) int {
+	switch s {
+	case db.ReadStatusReading.String():
+		return 1
+	case db.ReadStatusFinished.String():
+		return 2
+	}
+	return 0
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+func minTime(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.Before(*a)) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
+
+func round1(f float64) float64 {
+	return math.Round(f*10) / 10
+}
@@ -0,0 +1,202 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/koboutils/v2/kobo/db"
+	"github.com/pgaskin/koboutils/v2/kobo/db/dbtest"
+)
+
+const (
+	dune = "file:///mnt/onboard/Books/Dune.kepub.epub"
+	emma = "file:///mnt/onboard/Books/Emma.epub"
+)
+
+func date(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+func testSource(t *testing.T) *Source {
+	t.Helper()
+	d, err := db.OpenFile(dbtest.NewFile(t, dbtest.Recent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer d.Close()
+	src, err := Collect(context.Background(), d, "N1", "Kobo Libra 2", *date("2024-02-06T12:00:00Z"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return src
+}
+
+var testOptions = &Options{
+	Location: time.UTC,
+	Now:      *date("2024-02-06T12:00:00Z"),
+}
+
+func TestAggregate(t *testing.T) {
+	src := testSource(t)
+	if len(src.Books) != 2 || len(src.Sessions) != 5 {
+		t.Fatalf("expected only opened books and valid sessions, got %+v", src)
+	}
+
+	st := Aggregate([]Source{*src}, testOptions)
+
+	if exp := (Summary{
+		ReadingSeconds: 10800,
+		Sessions:       5,
+		PagesTurned:    77,
+		BooksStarted:   2,
+		BooksFinished:  1,
+		DaysRead:       4,
+		CurrentStreak:  1,
+		LongestStreak:  3,
+		FirstRead:      date("2024-02-01T10:30:00Z"),
+		LastRead:       date("2024-02-05T07:05:00Z"),
+	}); !reflect.DeepEqual(st.Summary, exp) {
+		t.Errorf("expected summary:\n%+v\ngot:\n%+v", exp, st.Summary)
+	}
+	if len(st.Devices) != 1 || st.Devices[0].Serial != "N1" || st.Devices[0].Device != "Kobo Libra 2" || !reflect.DeepEqual(st.Devices[0].Summary, st.Summary) {
+		t.Errorf("unexpected devices %+v", st.Devices)
+	}
+
+	if exp := []BookStats{
+		{
+			ContentID:      dune,
+			Title:          "Dune",
+			Author:         "Frank Herbert",
+			Devices:        []string{"N1"},
+			Status:         "reading",
+			Progress:       42,
+			ReadingSeconds: 3600,
+			Sessions:       3,
+			PagesTurned:    60,
+			PagesPerHour:   60,
+			WordsPerMinute: 150,
+			FirstRead:      date("2024-02-01T10:30:00Z"),
+			LastRead:       date("2024-02-03T08:10:00Z"),
+		},
+		{
+			ContentID:      emma,
+			Title:          "Emma",
+			Author:         "Jane Austen",
+			Devices:        []string{"N1"},
+			Status:         "finished",
+			Progress:       100,
+			ReadingSeconds: 7200,
+			Sessions:       2,
+			PagesTurned:    17,
+			PagesPerHour:   51,
+			WordsPerMinute: 1333.3,
+			FirstRead:      date("2024-02-03T20:00:00Z"),
+			LastRead:       date("2024-02-05T07:05:00Z"),
+			Finished:       date("2023-12-25T10:00:00Z"),
+		},
+	}; !reflect.DeepEqual(st.Books, exp) {
+		t.Errorf("expected books:\n%+v\ngot:\n%+v", exp, st.Books)
+	}
+
+	if exp := []DayStats{
+		{"2024-02-01", 1800, 1, 30, 1},
+		{"2024-02-02", 1200, 1, 20, 1},
+		{"2024-02-03", 1500, 2, 22, 2},
+		{"2024-02-05", 300, 1, 5, 1},
+	}; !reflect.DeepEqual(st.Days, exp) {
+		t.Errorf("expected days:\n%+v\ngot:\n%+v", exp, st.Days)
+	}
+
+	if st := Aggregate([]Source{*src}, &Options{Location: time.UTC, Now: *date("2024-03-01T00:00:00Z")}); st.Summary.CurrentStreak != 0 {
+		t.Errorf("expected streak to be broken, got %d", st.Summary.CurrentStreak)
+	}
+	if st := Aggregate([]Source{*src}, &Options{Location: time.FixedZone("UTC-10", -10*60*60), Now: *date("2024-02-06T12:00:00Z")}); len(st.Days) != 4 || st.Days[0].Date != "2024-02-01" || st.Days[1].Date != "2024-02-02" || st.Days[2].Sessions != 1 {
+		t.Errorf("expected days to be in the specified time zone, got %+v", st.Days)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	src := testSource(t)
+	other := Source{
+		Serial:    "N2",
+		Collected: *date("2024-02-06T00:00:00Z"),
+		Books: []SourceBook{
+			{ContentID: dune, Title: "Dune", Author: "Frank Herbert", Status: "reading", Progress: 50, ReadingSeconds: 600},
+		},
+		Sessions: []Session{
+			{ID: "x", ContentID: dune, Start: *date("2024-02-04T09:00:00Z"), End: *date("2024-02-04T09:10:00Z"), Seconds: 600, PagesTurned: 8, StartProgress: 42, EndProgress: 50},
+		},
+	}
+	stale := Source{
+		Serial:    "N1",
+		Collected: *date("2024-01-01T00:00:00Z"),
+		Books: []SourceBook{
+			{ContentID: dune, Title: "Dune", Author: "Frank Herbert", Status: "reading", Progress: 5, ReadingSeconds: 600},
+		},
+		Sessions: []Session{
+			src.Sessions[0], // still on the device when the newer one was collected
+			{ID: "old", ContentID: dune, Start: *date("2023-12-31T09:00:00Z"), End: *date("2023-12-31T09:10:00Z"), Seconds: 600, PagesTurned: 5, StartProgress: 0, EndProgress: 5},
+		},
+	}
+
+	st := Aggregate([]Source{other, *src, stale}, testOptions)
+	if len(st.Sources) != 2 || st.Sources[0].Serial != "N1" || len(st.Sources[0].Books) != 2 || !st.Sources[0].Collected.Equal(src.Collected) {
+		t.Errorf("expected the latest books for each device, got %+v", st.Sources)
+	}
+	if ss := st.Sources[0].Sessions; len(ss) != 6 || ss[0].ID != "old" {
+		t.Errorf("expected the sessions from both sources to be combined, got %+v", ss)
+	}
+	if len(st.Devices) != 2 || st.Devices[1].ReadingSeconds != 600 || st.Devices[1].LongestStreak != 1 || st.Devices[1].CurrentStreak != 0 {
+		t.Errorf("unexpected devices %+v", st.Devices)
+	}
+	if s := st.Summary; s.ReadingSeconds != 12000 || s.Sessions != 7 || s.DaysRead != 6 || s.LongestStreak != 5 || s.CurrentStreak != 5 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	if b := st.Books[0]; b.ContentID != dune || !reflect.DeepEqual(b.Devices, []string{"N1", "N2"}) || b.Progress != 50 || b.ReadingSeconds != 4800 || b.Sessions != 5 {
+		t.Errorf("unexpected merged book %+v", b)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, st); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	x, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if y := Aggregate(x.Sources, testOptions); !reflect.DeepEqual(y, st) {
+		t.Errorf("expected stats to be the same after re-aggregating the json")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	st := Aggregate([]Source{*testSource(t)}, testOptions)
+	for table, n := range map[string]int{
+		"books":   3,
+		"days":    5,
+		"devices": 2,
+	} {
+		var buf bytes.Buffer
+		if err := WriteCSV(&buf, st, table); err != nil {
+			t.Errorf("%s: unexpected error: %v", table, err)
+			continue
+		}
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", table, err)
+		} else if len(rows) != n {
+			t.Errorf("%s: expected %d rows, got %d", table, n, len(rows))
+		}
+	}
+	if err := WriteCSV(new(bytes.Buffer), st, "sessions"); err == nil {
+		t.Errorf("expected error for unknown table")
+	}
+}
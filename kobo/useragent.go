@@ -0,0 +1,176 @@
+package kobo
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// UserAgentKind is the format of a user agent string containing the Kobo
+// product token.
+type UserAgentKind int
+
+// User agent formats.
+const (
+	// UserAgentOther is a user agent with the Kobo product token and an
+	// unrecognized prefix.
+	UserAgentOther UserAgentKind = iota
+
+	// UserAgentNickel is the WebKit-style user agent used by nickel's
+	// experimental browser (since firmware 4.x). For example:
+	//
+	//  Mozilla/5.0 (Linux; U; Android 2.0; en-us;) AppleWebKit/538.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/538.1 (Kobo Touch 0376/4.38.21908)
+	//
+	// The platform, WebKit, Version, and Safari tokens differ between
+	// firmware versions and clients, so they are kept as-is.
+	UserAgentNickel
+
+	// UserAgentBare is just the Kobo product token, as used by some
+	// non-browser HTTP clients on the device. For example:
+	//
+	//  Kobo Touch 0376/4.38.21908
+	UserAgentBare
+)
+
+func (k UserAgentKind) String() string {
+	switch k {
+	case UserAgentOther:
+		return "other"
+	case UserAgentNickel:
+		return "nickel"
+	case UserAgentBare:
+		return "bare"
+	default:
+		return strconv.Itoa(int(k))
+	}
+}
+
+// UserAgent is a parsed Kobo user agent string.
+type UserAgent struct {
+	Kind     UserAgentKind
+	DeviceID int     // numeric device ID from the product token
+	Device   Device  // zero if DeviceID is not a known device
+	Version  Version // firmware version
+	WebKit   string  // WebKit version, if present
+	Language string  // language from the platform token, if present
+	Platform string  // platform token without the parens (e.g., Linux; U; Android 2.0; en-us;), if present
+	Browser  string  // Version token (e.g., 4.0), if present
+	Safari   string  // Mobile Safari token, if present
+	Raw      string  // original string, if parsed
+}
+
+// ErrNotKoboUserAgent is returned by ParseUserAgent if the string does not
+// contain the Kobo product token.
+var ErrNotKoboUserAgent = errors.New("not a kobo user agent")
+
+var (
+	uaTokenRe    = regexp.MustCompile(`\(?Kobo Touch ([0-9]{1,4})/([0-9]+\.[0-9]+\.[0-9]+)\)?$`)
+	uaNickelRe   = regexp.MustCompile(`^Mozilla/5\.0 \(([^()]*)\) AppleWebKit/([0-9.]+) \(KHTML, like Gecko\) Version/([0-9.]+) Mobile Safari/([0-9.]+) $`)
+	uaPlatformRe = regexp.MustCompile(`; ([a-z]{2}-[a-z]{2})(?:;|\)|$)`)
+	uaWebKitRe   = regexp.MustCompile(`AppleWebKit/([0-9.]+)`)
+)
+
+// ParseUserAgent parses a user agent string ending with the Kobo product token
+// (Kobo Touch ID/VERSION). If the string doesn't end with it, an error wrapping
+// ErrNotKoboUserAgent is returned.
+//
+// The user agents used by nickel's sync and store clients aren't parsed
+// specially, and will be UserAgentOther or ErrNotKoboUserAgent depending on
+// whether they end with the product token.
+func ParseUserAgent(ua string) (UserAgent, error) {
+	idx := uaTokenRe.FindStringSubmatchIndex(ua)
+	if idx == nil {
+		return UserAgent{}, ErrNotKoboUserAgent
+	}
+
+	u := UserAgent{Raw: ua}
+
+	id, err := strconv.Atoi(ua[idx[2]:idx[3]])
+	if err != nil {
+		return UserAgent{}, fmt.Errorf("parse device id: %w", err)
+	}
+	u.DeviceID = id
+	u.Device, _ = DeviceByNumericID(id)
+
+	if u.Version, err = ParseVersion(ua[idx[4]:idx[5]]); err != nil {
+		return UserAgent{}, fmt.Errorf("parse version: %w", err)
+	}
+
+	prefix, suffix := ua[:idx[0]], ua[idx[1]:]
+	switch {
+	case strings.TrimSpace(prefix) == "" && strings.TrimSpace(suffix) == "" && ua[idx[0]] != '(':
+		u.Kind = UserAgentBare
+	case suffix == "" && ua[idx[0]] == '(' && uaNickelRe.MatchString(prefix):
+		m := uaNickelRe.FindStringSubmatch(prefix)
+		u.Kind = UserAgentNickel
+		u.Platform = m[1]
+		u.WebKit = m[2]
+		u.Browser = m[3]
+		u.Safari = m[4]
+		if m := uaPlatformRe.FindStringSubmatch("(" + u.Platform + ")"); m != nil {
+			u.Language = m[1]
+		}
+	default:
+		u.Kind = UserAgentOther
+		if m := uaPlatformRe.FindStringSubmatch(prefix); m != nil {
+			u.Language = m[1]
+		}
+		if m := uaWebKitRe.FindStringSubmatch(prefix); m != nil {
+			u.WebKit = m[1]
+		}
+	}
+	return u, nil
+}
+
+// NickelUserAgent returns the user agent nickel sends for the specified device
+// and firmware version (for firmware 4.x and later).
+func NickelUserAgent(d Device, v Version) UserAgent {
+	return UserAgent{
+		Kind:     UserAgentNickel,
+		DeviceID: d.ID(),
+		Device:   d,
+		Version:  v,
+		WebKit:   "538.1",
+		Language: "en-us",
+	}
+}
+
+// ProductToken returns the Kobo product token (e.g., Kobo Touch 0376/4.38.21908).
+func (u UserAgent) ProductToken() string {
+	return fmt.Sprintf("Kobo Touch %04d/%s", u.DeviceID, u.Version)
+}
+
+// IDString returns the full device ID string for the device ID, even if the
+// device is unknown.
+func (u UserAgent) IDString() string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", u.DeviceID)
+}
+
+// String returns the user agent string. For UserAgentOther, it is the original
+// string. For UserAgentNickel, the tokens which aren't set are filled in with
+// the ones used by firmware 4.x.
+func (u UserAgent) String() string {
+	switch u.Kind {
+	case UserAgentNickel:
+		platform, webkit, browser, safari := u.Platform, u.WebKit, u.Browser, u.Safari
+		if platform == "" {
+			platform = "Linux; U; Android 2.0; " + u.Language + ";"
+		}
+		if webkit == "" {
+			webkit = "538.1"
+		}
+		if browser == "" {
+			browser = "4.0"
+		}
+		if safari == "" {
+			safari = webkit
+		}
+		return "Mozilla/5.0 (" + platform + ") AppleWebKit/" + webkit + " (KHTML, like Gecko) Version/" + browser + " Mobile Safari/" + safari + " (" + u.ProductToken() + ")"
+	case UserAgentBare:
+		return u.ProductToken()
+	default:
+		return u.Raw
+	}
+}
@@ -0,0 +1,129 @@
+package kobo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		ua      string
+		kind    UserAgentKind
+		device  Device
+		id      int
+		version string
+		webkit  string
+		err     error
+	}{
+		{
+			"Nickel",
+			"Mozilla/5.0 (Linux; U; Android 2.0; en-us;) AppleWebKit/538.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/538.1 (Kobo Touch 0370/4.20.14622)",
+			UserAgentNickel, DeviceAuraH2O, 370, "4.20.14622", "538.1", nil,
+		},
+		{
+			"NickelColour",
+			"Mozilla/5.0 (Linux; U; Android 2.0; en-us;) AppleWebKit/538.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/538.1 (Kobo Touch 0690/4.41.23145)",
+			UserAgentNickel, DeviceVisionColour, 690, "4.41.23145", "538.1", nil,
+		},
+		{
+			"NickelTokens",
+			"Mozilla/5.0 (Linux; U; Android 4.4; de-de) AppleWebKit/601.1 (KHTML, like Gecko) Version/5.0 Mobile Safari/601.2 (Kobo Touch 0390/4.41.23145)",
+			UserAgentNickel, DeviceLibraColour, 390, "4.41.23145", "601.1", nil,
+		},
+		{
+			"Bare",
+			"Kobo Touch 0388/4.38.21908",
+			UserAgentBare, DeviceLibra2, 388, "4.38.21908", "", nil,
+		},
+		{
+			"OtherPrefix",
+			"Mozilla/5.0 (X11; Linux armv7l; en-gb;) AppleWebKit/601.1 (KHTML, like Gecko) (Kobo Touch 0387/4.38.21908)",
+			UserAgentOther, DeviceElipsa, 387, "4.38.21908", "601.1", nil,
+		},
+		{
+			"UnknownDevice",
+			"Kobo Touch 0999/5.0.1",
+			UserAgentBare, 0, 999, "5.0.1", "", nil,
+		},
+		{
+			"UnescapedDot",
+			"Mozilla/5.0 (Kobo Touch 0370/4.20x14622)",
+			0, 0, 0, "", "", ErrNotKoboUserAgent,
+		},
+		{
+			"ExtraVersionComponent",
+			"Kobo Touch 0373/4.20.14622.5",
+			0, 0, 0, "", "", ErrNotKoboUserAgent,
+		},
+		{
+			"NickelExtraVersionComponent",
+			"Mozilla/5.0 (Linux; U; Android 2.0; en-us;) AppleWebKit/538.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/538.1 (Kobo Touch 0373/4.20.14622.5)",
+			0, 0, 0, "", "", ErrNotKoboUserAgent,
+		},
+		{
+			"TrailingText",
+			"Kobo Touch 0373/4.20.14622 extra",
+			0, 0, 0, "", "", ErrNotKoboUserAgent,
+		},
+		{
+			"Chrome",
+			"Mozilla/5.0 (Linux; Android 8.0.0; SM-G930F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.162 Mobile Safari/537.36",
+			0, 0, 0, "", "", ErrNotKoboUserAgent,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := ParseUserAgent(tc.ua)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if u.Kind != tc.kind {
+				t.Errorf("expected kind %s, got %s", tc.kind, u.Kind)
+			}
+			if u.Device != tc.device || u.DeviceID != tc.id {
+				t.Errorf("expected device %d (%d), got %d (%d)", tc.device, tc.id, u.Device, u.DeviceID)
+			}
+			if u.Version.String() != tc.version {
+				t.Errorf("expected version %s, got %s", tc.version, u.Version)
+			}
+			if u.WebKit != tc.webkit {
+				t.Errorf("expected webkit %q, got %q", tc.webkit, u.WebKit)
+			}
+			if u.String() != tc.ua {
+				t.Errorf("expected string to round-trip, got %q", u.String())
+			}
+		})
+	}
+}
+
+func TestUserAgentTokens(t *testing.T) {
+	u, err := ParseUserAgent("Mozilla/5.0 (Linux; U; Android 4.4; de-de) AppleWebKit/601.1 (KHTML, like Gecko) Version/5.0 Mobile Safari/601.2 (Kobo Touch 0390/4.41.23145)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u.Platform != "Linux; U; Android 4.4; de-de" || u.Language != "de-de" || u.Browser != "5.0" || u.Safari != "601.2" {
+		t.Errorf("unexpected tokens %+v", u)
+	}
+	u.Version = Version{4, 42, 23296}
+	if exp := "Mozilla/5.0 (Linux; U; Android 4.4; de-de) AppleWebKit/601.1 (KHTML, like Gecko) Version/5.0 Mobile Safari/601.2 (Kobo Touch 0390/4.42.23296)"; u.String() != exp {
+		t.Errorf("expected %q, got %q", exp, u.String())
+	}
+}
+
+func TestNickelUserAgent(t *testing.T) {
+	ua := NickelUserAgent(DeviceClaraHD, Version{4, 38, 21908}).String()
+	if exp := "Mozilla/5.0 (Linux; U; Android 2.0; en-us;) AppleWebKit/538.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/538.1 (Kobo Touch 0376/4.38.21908)"; ua != exp {
+		t.Errorf("expected %q, got %q", exp, ua)
+	}
+	for _, d := range Devices() {
+		u, err := ParseUserAgent(NickelUserAgent(d, Version{4, 38, 21908}).String())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", d, err)
+		} else if u.Device != d || u.Kind != UserAgentNickel {
+			t.Errorf("%s: parsed as %s (%s)", d, u.Device, u.Kind)
+		}
+	}
+}
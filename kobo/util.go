@@ -14,6 +14,17 @@ func PathToContentID(relpath string) string {
 	return fmt.Sprintf("file:///mnt/onboard/%s", filepath.ToSlash(relpath))
 }
 
+// ContentIDToPath converts a Kobo ContentId back to a slash-separated path
+// relative to the internal storage root. It returns false if the ContentId
+// isn't for a file on the internal storage (e.g., for store books).
+func ContentIDToPath(contentID string) (string, bool) {
+	rel, ok := strings.CutPrefix(contentID, "file:///mnt/onboard/")
+	if !ok || rel == "" {
+		return "", false
+	}
+	return rel, true
+}
+
 // ContentIDToImageID converts the Kobo ContentId to the ImageId.
 func ContentIDToImageID(contentID string) string {
 	return strings.NewReplacer(
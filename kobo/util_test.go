@@ -37,6 +37,16 @@ func TestPathContentIDImageID(t *testing.T) {
 		if tc.iid != iid {
 			t.Errorf("iid of %#v: expected %#v, got %#v", tc.cid, tc.iid, iid)
 		}
+
+		if p, ok := ContentIDToPath(cid); !ok || p != tc.path {
+			t.Errorf("path of %#v: expected %#v, got %#v", cid, tc.path, p)
+		}
+	}
+
+	for _, cid := range []string{"0a1b2c3d-store-book", "file:///mnt/onboard/", "file:///mnt/sd/Book.epub"} {
+		if p, ok := ContentIDToPath(cid); ok {
+			t.Errorf("path of %#v: expected not ok, got %#v", cid, p)
+		}
 	}
 }
 
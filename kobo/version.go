@@ -1,6 +1,7 @@
 package kobo
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -30,6 +31,85 @@ func VersionCompare(a, b string) int {
 	return 0
 }
 
+// Version represents a firmware version.
+type Version struct {
+	Major int
+	Minor int
+	Patch int // is just the build number on v4 and likely v5 too
+}
+
+// ParseVersion parses s, ensuring it is canonical.
+func ParseVersion(s string) (Version, error) {
+	spl := strings.Split(s, ".")
+	if len(spl) != 3 {
+		return Version{}, fmt.Errorf("more than 3 components in version %q", s)
+	}
+
+	major, err := strconv.ParseInt(spl[0], 10, 0)
+	if err == nil && major <= 0 {
+		err = errors.New("major must be gt 1")
+	}
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid version %q: %w", s, err)
+	}
+
+	minor, err := strconv.ParseInt(spl[1], 10, 0)
+	if err == nil && minor < 0 {
+		err = errors.New("minor must be ge 1")
+	}
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid version %q: %w", s, err)
+	}
+
+	patch, err := strconv.ParseInt(spl[2], 10, 0)
+	if err == nil && patch < 0 {
+		err = errors.New("patch must be ge 1")
+	}
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid version %q: %w", s, err)
+	}
+
+	v := Version{
+		Major: int(major),
+		Minor: int(minor),
+		Patch: int(patch),
+	}
+	if v.String() != s {
+		return Version{}, fmt.Errorf("non-canonical version %q", s)
+	}
+	return v, nil
+}
+
+// IsZero checks if the version is 0.0.0 (i.e., unknown).
+func (v Version) IsZero() bool {
+	return v.Major == 0 && v.Minor == 0 && v.Patch == 0
+}
+
+// String formats the version as major.minor.patch.
+func (v Version) String() string {
+	return strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor) + "." + strconv.Itoa(v.Patch)
+}
+
+// Less checks if v is older than o.
+func (v Version) Less(o Version) bool {
+	return v.Compare(o) < 0
+}
+
+// Compare compares v and o, returning -1 if v is older, 0 if they are the
+// same, or 1 if v is newer.
+func (v Version) Compare(o Version) int {
+	if v.Major != o.Major {
+		return cmp.Compare(v.Major, o.Major)
+	}
+	if v.Minor != o.Minor {
+		return cmp.Compare(v.Minor, o.Minor)
+	}
+	if v.Patch != o.Patch {
+		return cmp.Compare(v.Patch, o.Patch)
+	}
+	return 0
+}
+
 // ParseKoboVersion gets the info from the .kobo/version file.
 func ParseKoboVersion(kpath string) (serial, version, id string, err error) {
 	vbuf, err := ioutil.ReadFile(filepath.Join(kpath, ".kobo", "version"))
@@ -57,14 +137,12 @@ func ParseKoboAffiliate(kpath string) (affiliate string, err error) {
 }
 
 // ParseKoboUAString parses a web browser UA string for Kobo ID and version info.
+//
+// Deprecated: Use ParseUserAgent, which also resolves the device.
 func ParseKoboUAString(ua string) (version, id string, err error) {
-	m := regexp.MustCompile(`.+\(Kobo Touch (\d+)/(\d+\.\d+.\d+)\)`).FindStringSubmatch(ua)
-	if len(m) != 3 {
-		return "", "", errors.New("could not parse UA string")
-	}
-	idInt, err := strconv.Atoi(m[1])
+	u, err := ParseUserAgent(ua)
 	if err != nil {
-		return "", "", errors.New("could not parse device id")
+		return "", "", errors.New("could not parse UA string")
 	}
-	return m[2], fmt.Sprintf("00000000-0000-0000-0000-%012d", idInt), nil
+	return u.Version.String(), u.IDString(), nil
 }
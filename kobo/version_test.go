@@ -39,6 +39,33 @@ func TestVersionCompare(t *testing.T) {
 	}
 }
 
+func TestParseVersion(t *testing.T) {
+	for _, c := range []struct {
+		S   string
+		V   Version
+		Err bool
+	}{
+		{"4.38.21908", Version{4, 38, 21908}, false},
+		{"1.0.0", Version{1, 0, 0}, false},
+		{"0.1.0", Version{}, true},
+		{"4.38", Version{}, true},
+		{"4.38.21908.1", Version{}, true},
+		{"4.038.21908", Version{}, true},
+		{"4.x.21908", Version{}, true},
+	} {
+		v, err := ParseVersion(c.S)
+		if (err != nil) != c.Err {
+			t.Errorf("ParseVersion(%s): unexpected error state %v", c.S, err)
+		}
+		if v != c.V {
+			t.Errorf("ParseVersion(%s) should be %#v, not %#v", c.S, c.V, v)
+		}
+	}
+	if !(Version{4, 9, 11311}).Less(Version{4, 10, 11655}) {
+		t.Errorf("expected 4.9.11311 < 4.10.11655")
+	}
+}
+
 func TestParseKoboVersion(t *testing.T) {
 	if err := fakekobo(func(kpath string) {
 		serial, version, id, err := ParseKoboVersion(kpath)